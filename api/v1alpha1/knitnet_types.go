@@ -56,11 +56,140 @@ type KnitnetStatus struct {
 	// Phase is the knitnet operator running phase.
 	// +optional
 	Phase Phase `json:"phase,omitempty"`
+
+	// ObservedGeneration is the most recent generation of the spec that has been fully applied.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// ClusterID is the cluster ID the cluster has joined the broker with.
+	// +optional
+	ClusterID string `json:"clusterID,omitempty"`
+
+	// GatewayLoadBalancerAddress is the address assigned to the LoadBalancer service in front of the gateways,
+	// it is only set when the LoadBalancer is enabled in the join config.
+	// +optional
+	GatewayLoadBalancerAddress string `json:"gatewayLoadBalancerAddress,omitempty"`
+
+	// CloudResources are the cloud resources created by the cloud preparation, they are removed when the
+	// knitnet is deleted or the cloud preparation is dropped.
+	// +optional
+	CloudResources []CloudResource `json:"cloudResources,omitempty"`
+
+	// AppliedCloudPrepareConfig is the cloud prepare config the cloud resources were created with.
+	// +optional
+	AppliedCloudPrepareConfig *CloudPrepareConfig `json:"appliedCloudPrepareConfig,omitempty"`
+
+	// Discovery reports what was discovered about the cluster environment.
+	// +optional
+	Discovery *DiscoveryStatus `json:"discovery,omitempty"`
+
+	// IPSecPSK reports the IPsec PSK published by the broker and its rollout to the joined clusters.
+	// +optional
+	IPSecPSK *IPSecPSKStatus `json:"ipsecPSK,omitempty"`
+
+	// Conditions represent the latest available observations of each deploy and join stage.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// IPSecPSKStatus reports the generation of the IPsec PSK and which joined clusters run with it.
+type IPSecPSKStatus struct {
+	// Generation is the generation of the PSK published in the broker info, it is increased on each rotation.
+	Generation int64 `json:"generation,omitempty"`
+
+	// LastRotationTime is the time the published PSK was generated.
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	// RotationRequest is the last value of the rotate annotation the PSK was rotated for.
+	// +optional
+	RotationRequest string `json:"rotationRequest,omitempty"`
+
+	// UpdatedClusters are the joined clusters running with the published PSK.
+	// +optional
+	UpdatedClusters []string `json:"updatedClusters,omitempty"`
+
+	// PendingClusters are the joined clusters still running with a previous PSK.
+	// +optional
+	PendingClusters []string `json:"pendingClusters,omitempty"`
 }
 
 const (
 	PhaseRunning Phase = "Running"
 	PhaseFailed  Phase = "Failed"
+	// PhasePending is reported while the spec is being applied, e.g. while cloud resources are provisioned
+	PhasePending Phase = "Pending"
+)
+
+// Condition types reported in the Knitnet status.
+const (
+	// ConditionReady summarizes whether the last reconcile applied the whole spec.
+	ConditionReady = "Ready"
+	// ConditionBrokerRBACReady indicates the broker namespace, service accounts and roles exist.
+	ConditionBrokerRBACReady = "BrokerRBACReady"
+	// ConditionSubmarinerOperatorReady indicates the submariner-operator has been deployed.
+	ConditionSubmarinerOperatorReady = "SubmarinerOperatorReady"
+	// ConditionBrokerDeployed indicates the Submariner Broker CR has been created.
+	ConditionBrokerDeployed = "BrokerDeployed"
+	// ConditionBrokerInfoPublished indicates the broker info has been written for joining clusters.
+	ConditionBrokerInfoPublished = "BrokerInfoPublished"
+	// ConditionBrokerConnected indicates the broker info was synced and the broker cluster is reachable.
+	ConditionBrokerConnected = "BrokerConnected"
+	// ConditionNetworkDiscovered indicates the cluster network details have been discovered.
+	ConditionNetworkDiscovered = "NetworkDiscovered"
+	// ConditionGlobalCIDRAllocated indicates the cluster has been registered and, with globalnet, got a global CIDR.
+	ConditionGlobalCIDRAllocated = "GlobalCIDRAllocated"
+	// ConditionServiceCIDRInferred warns the service CIDR was inferred from the kubernetes service, it may be larger
+	// than the actual one and is not checked for overlaps until it is pinned in the join config.
+	ConditionServiceCIDRInferred = "ServiceCIDRInferred"
+	// ConditionSubmarinerDeployed indicates the Submariner (or ServiceDiscovery) CR has been applied.
+	ConditionSubmarinerDeployed = "SubmarinerDeployed"
+	// ConditionCalicoIPPoolsReady indicates the Calico IPPools for remote clusters are in place.
+	ConditionCalicoIPPoolsReady = "CalicoIPPoolsReady"
+	// ConditionDeletionBlocked indicates the broker can not be deleted while clusters are still joined.
+	ConditionDeletionBlocked = "DeletionBlocked"
+	// ConditionIPSecPSKRolledOut indicates all joined clusters run with the IPsec PSK published by the broker.
+	ConditionIPSecPSKRolledOut = "IPSecPSKRolledOut"
+	// ConditionCloudCredentialsReady indicates the cloud credentials were read and a client for the provider was created.
+	ConditionCloudCredentialsReady = "CloudCredentialsReady"
+	// ConditionCloudPortsOpened indicates the submariner ports are opened in the cloud network of the cluster.
+	ConditionCloudPortsOpened = "CloudPortsOpened"
+	// ConditionCloudGatewaysReady indicates the requested gateway nodes are running and labeled as gateways.
+	ConditionCloudGatewaysReady = "CloudGatewaysReady"
+)
+
+// ClusterIDRenameAnnotation allows changing the cluster ID of a joined cluster, the cluster leaves
+// the broker with the old cluster ID and joins again with the new one. The annotation is removed once
+// the new cluster ID is recorded in the status.
+const ClusterIDRenameAnnotation = "operator.tkestack.io/rename-cluster-id"
+
+// IPSecPSKRotateAnnotation requests a rotation of the IPsec PSK of the broker, the PSK is rotated
+// once for each new value, e.g. the current date.
+const IPSecPSKRotateAnnotation = "operator.tkestack.io/rotate-ipsec-psk"
+
+// NetworkRediscoverAnnotation requests a new discovery of the network of a joined cluster, the network
+// is discovered once for each new value, e.g. the current date.
+const NetworkRediscoverAnnotation = "operator.tkestack.io/rediscover-network"
+
+// ValidImageNames are the names of the component images which can be overridden in the join config.
+var ValidImageNames = []string{"submariner-networkplugin-syncer", "submariner-route-agent", "submariner-gateway",
+	"submariner-globalnet", "lighthouse-agent", "lighthouse-coredns", "submariner-operator"}
+
+// Condition reasons reported in the Knitnet status.
+const (
+	ReasonSucceeded            = "Succeeded"
+	ReasonFailed               = "Failed"
+	ReasonInvalidConfiguration = "InvalidConfiguration"
+	ReasonCIDROverlap          = "CIDROverlap"
+	ReasonNotRequired          = "NotRequired"
+	ReasonServiceDiscoveryOnly = "ServiceDiscoveryOnly"
+	ReasonClustersJoined       = "ClustersJoined"
+	ReasonRolloutInProgress    = "RolloutInProgress"
+	ReasonProvisioning         = "Provisioning"
+	ReasonCleaningUp           = "CleaningUp"
+	ReasonInferred             = "Inferred"
 )
 
 // Phase is the phase of the installation.
@@ -93,17 +222,39 @@ type BrokerConfig struct {
 	// DefaultCustomDomains represents list of domains to use for multicluster service discovery.
 	// +optional
 	DefaultCustomDomains []string `json:"defaultCustomDomains,omitempty"`
+	// IPSecPSKRotationInterval represents the interval to rotate the IPsec PSK automatically (e.g. 720h),
+	// the PSK is only rotated on request through the rotate annotation when not specified.
+	// +optional
+	IPSecPSKRotationInterval *metav1.Duration `json:"ipsecPSKRotationInterval,omitempty"`
+	// TokenExpiration represents the lifetime of the tokens of the joined clusters issued through the TokenRequest API
+	// (default 24h), the tokens are renewed after two thirds of their lifetime. The broker admin token does not expire.
+	// +optional
+	TokenExpiration *metav1.Duration `json:"tokenExpiration,omitempty"`
 }
 
 type JoinConfig struct {
-	// ClusterID used to identify the tunnels.
-	ClusterID string `json:"clusterID"`
-	// ServiceCIDR represents service CIDR.
+	// ClusterID used to identify the tunnels. It is derived from the kube-system namespace UID when not specified.
+	// +optional
+	ClusterID string `json:"clusterID,omitempty"`
+	// ClusterIDPrefix represents the prefix of the derived cluster ID, it is ignored when ClusterID is specified.
+	// +optional
+	ClusterIDPrefix string `json:"clusterIDPrefix,omitempty"`
+	// ServiceCIDR represents service CIDR, the CIDRs of a dual-stack cluster are separated by a comma.
 	// +optional
 	ServiceCIDR string `json:"serviceCIDR,omitempty"`
-	// ClusterCIDR represents cluster CIDR.
+	// ServiceCIDRProbe allows discovering the service CIDR by creating an invalid service in the operator
+	// namespace and parsing the error of the API server, when no other source has the service CIDR.
+	// +optional
+	ServiceCIDRProbe bool `json:"serviceCIDRProbe,omitempty"`
+	// ClusterCIDR represents cluster CIDR, the CIDRs of a dual-stack cluster are separated by a comma.
 	// +optional
 	ClusterCIDR string `json:"clusterCIDR,omitempty"`
+	// NetworkPlugin pins the network plugin of the cluster, the CIDRs are still discovered when not specified.
+	// +optional
+	NetworkPlugin string `json:"networkPlugin,omitempty"`
+	// NetworkPluginSettings pins settings of the network plugin, they override the discovered settings.
+	// +optional
+	NetworkPluginSettings map[string]string `json:"networkPluginSettings,omitempty"`
 	// GlobalCIDR represents global CIDR to be allocated to the cluster.
 	// +optional
 	GlobalnetCIDR string `json:"globalnetCIDR,omitempty"`
@@ -184,22 +335,47 @@ type JoinConfig struct {
 	CorednsCustomConfigMap string `json:"corednsCustomConfigMap,omitempty"`
 }
 
+// CloudProvider is a cloud provider the cluster environment can be prepared on.
+// +kubebuilder:validation:Enum=aws;tencent;gcp;azure
+type CloudProvider string
+
+const (
+	CloudProviderAWS     CloudProvider = "aws"
+	CloudProviderTencent CloudProvider = "tencent"
+	CloudProviderGCP     CloudProvider = "gcp"
+	CloudProviderAzure   CloudProvider = "azure"
+)
+
 type CloudPrepareConfig struct {
 	// CredentialsSecret is a reference to the secret with a certain cloud platform
-	// credentials, the supported platform includes AWS, GCP, Azure, ROKS and OSD.
+	// credentials, the supported platform includes AWS, Tencent Cloud, GCP and Azure.
 	// The knitnet-operator will use these credentials to prepare Submariner cluster
 	// environment. If the submariner cluster environment requires knitnet-operator
 	// preparation, this field should be specified.
 	// +optional
 	CredentialsSecret *corev1.LocalObjectReference `json:"credentialsSecret,omitempty"`
 
-	// Infra ID
+	// Provider is the cloud provider the cluster runs on, it is detected from the nodes and the
+	// OpenShift infrastructure when omitted.
+	// +optional
+	Provider CloudProvider `json:"provider,omitempty"`
+
+	// Infra ID, it is detected on OpenShift when omitted.
 	InfraID string `json:"infraID,omitempty"`
-	// Regio
+	// Region, it is detected from the region label of the nodes when omitted.
 	Region string `json:"region,omitempty"`
 
 	// AWS specific cloud prepare setup
 	AWS `json:"aws,omitempty"`
+
+	// Tencent Cloud specific cloud prepare setup
+	TencentCloud `json:"tencentCloud,omitempty"`
+
+	// GCP specific cloud prepare setup
+	GCP `json:"gcp,omitempty"`
+
+	// Azure specific cloud prepare setup
+	Azure `json:"azure,omitempty"`
 }
 
 type AWS struct {
@@ -215,13 +391,178 @@ type AWS struct {
 	Gateways int `json:"gateways,omitempty"`
 }
 
-//+kubebuilder:object:root=true
-//+kubebuilder:subresource:status
+type TencentCloud struct {
+	// Gateways represents the count of worker nodes used as Submariner gateways, the nodes without
+	// public IP are bound to an elastic IP.
+	// +optional
+	// +kubebuilder:default=1
+	Gateways int `json:"gateways,omitempty"`
+
+	// EIPBandwidth is the maximum outbound bandwidth in Mbps of the allocated elastic IPs, the
+	// account default is used when it is not set.
+	// +optional
+	EIPBandwidth int `json:"eipBandwidth,omitempty"`
+}
+
+type GCP struct {
+	// Gateways represents the count of worker nodes used as Submariner gateways, their instances are
+	// tagged with the network tag the gateway ports are opened for.
+	// +optional
+	// +kubebuilder:default=1
+	Gateways int `json:"gateways,omitempty"`
+}
+
+type Azure struct {
+	// Gateways represents the count of worker nodes used as Submariner gateways, their virtual machines
+	// are tagged as gateways.
+	// +optional
+	// +kubebuilder:default=1
+	Gateways int `json:"gateways,omitempty"`
+}
+
+// CloudResource is a cloud resource created by the cloud preparation, it is recorded so the
+// preparation can be rolled back.
+type CloudResource struct {
+	// Kind is the kind of the resource, e.g. SecurityGroupRule or Address.
+	Kind string `json:"kind"`
+
+	// ID identifies the resource with the cloud provider.
+	ID string `json:"id"`
+
+	// Parent is the resource the resource belongs to, e.g. the security group of a rule.
+	// +optional
+	Parent string `json:"parent,omitempty"`
+}
+
+// DiscoveryStatus reports what was discovered about the cluster environment
+type DiscoveryStatus struct {
+	// CloudPlatform is the detected cloud platform, it is not set when no platform is detected.
+	// +optional
+	CloudPlatform *CloudPlatform `json:"cloudPlatform,omitempty"`
+
+	// Network is the discovered network of the cluster, it is set when the cluster joins the broker.
+	// +optional
+	Network *NetworkDiscovery `json:"network,omitempty"`
+}
+
+// NetworkDiscovery is the network discovered for the cluster, the CIDRs of all the IP families are
+// reported, while Submariner only connects the IPv4 ones
+type NetworkDiscovery struct {
+	// NetworkPlugin is the detected network plugin.
+	// +optional
+	NetworkPlugin string `json:"networkPlugin,omitempty"`
+
+	// PodCIDRs are the pod CIDRs of the cluster.
+	// +optional
+	PodCIDRs []string `json:"podCIDRs,omitempty"`
+
+	// ServiceCIDRs are the service CIDRs of the cluster.
+	// +optional
+	ServiceCIDRs []string `json:"serviceCIDRs,omitempty"`
+
+	// GlobalCIDR is the global CIDR configured in the Submariner of the cluster.
+	// +optional
+	GlobalCIDR string `json:"globalCIDR,omitempty"`
+
+	// PluginSettings are the settings of the network plugin.
+	// +optional
+	PluginSettings map[string]string `json:"pluginSettings,omitempty"`
+
+	// Source is the detector the network was discovered by, generic when no network plugin was detected.
+	// +optional
+	Source string `json:"source,omitempty"`
+
+	// PinnedFields are the fields of the join config which override the discovered values, e.g. networkPlugin.
+	// +optional
+	PinnedFields []string `json:"pinnedFields,omitempty"`
+
+	// ServiceCIDRSource is where the service CIDRs were found, e.g. kube-apiserver, kubeadm-config,
+	// node-annotations, servicecidr-api, kubernetes-service, service-creation or join-config.
+	// +optional
+	ServiceCIDRSource string `json:"serviceCIDRSource,omitempty"`
+
+	// IPFamilies are the IP families of the pod and service CIDRs, a dual-stack cluster has both IPv4 and IPv6.
+	// +optional
+	IPFamilies []corev1.IPFamily `json:"ipFamilies,omitempty"`
+
+	// Detections are all the network plugins detected in the cluster, the selected one first, to debug
+	// misdetections.
+	// +optional
+	Detections []NetworkDetection `json:"detections,omitempty"`
+
+	// DetectorErrors are the errors of the detectors which failed, by network plugin.
+	// +optional
+	DetectorErrors map[string]string `json:"detectorErrors,omitempty"`
+
+	// LastDiscoveryTime is when the network was last discovered, the network is only discovered again when
+	// the network plugin of the cluster changes, the pinned fields change or on request through the
+	// rediscover annotation.
+	// +optional
+	LastDiscoveryTime *metav1.Time `json:"lastDiscoveryTime,omitempty"`
+
+	// Fingerprint identifies the network plugin workloads of the cluster at the last discovery.
+	// +optional
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// RediscoveryRequest is the value of the rediscover annotation of the last requested discovery.
+	// +optional
+	RediscoveryRequest string `json:"rediscoveryRequest,omitempty"`
+}
+
+// NetworkDetection is the evidence of a network plugin found by its detector
+type NetworkDetection struct {
+	// Detector is the name of the detector.
+	Detector string `json:"detector"`
+
+	// NetworkPlugin is the network plugin reported by the detector.
+	// +optional
+	NetworkPlugin string `json:"networkPlugin,omitempty"`
+
+	// Confidence is the confidence in the detection, from 0 to 100.
+	Confidence int `json:"confidence"`
+
+	// Evidence is what the detection is based on.
+	// +optional
+	Evidence []string `json:"evidence,omitempty"`
+
+	// PodCIDRs are the pod CIDRs found by the detector.
+	// +optional
+	PodCIDRs []string `json:"podCIDRs,omitempty"`
+
+	// ServiceCIDRs are the service CIDRs found by the detector.
+	// +optional
+	ServiceCIDRs []string `json:"serviceCIDRs,omitempty"`
+}
+
+// CloudPlatform is the cloud platform detected for the cluster
+type CloudPlatform struct {
+	// Provider is the detected cloud provider.
+	Provider CloudProvider `json:"provider"`
+
+	// Region is the detected region, it is read from the region label of the nodes or the OpenShift
+	// infrastructure.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// InfraID is the infrastructure name of the OpenShift cluster.
+	// +optional
+	InfraID string `json:"infraID,omitempty"`
+
+	// Source is what the provider was detected from, one of openshift-infrastructure, node-provider-id
+	// or node-labels.
+	// +optional
+	Source string `json:"source,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
 // +kubebuilder:resource:path=knitnets,shortName=fb,scope=Namespaced
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=.metadata.creationTimestamp
 // +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=.status.phase,description="Current Cluster Phase"
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
 // +kubebuilder:printcolumn:name="Created At",type=string,JSONPath=.metadata.creationTimestamp
-// Knitnet is the Schema for the knitnets API
+// Knitnet is the Schema for the knitnets API. A Knitnet is not converted to the v1beta1 APIs
+// automatically, it is converted when annotated with operator.tkestack.io/convert-to=v1beta1.
 type Knitnet struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,473 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	actionBroker = "broker"
+	actionJoin   = "join"
+	actionAll    = "all"
+
+	defaultGlobalnetCIDRRange          = "242.0.0.0/8"
+	defaultGlobalnetClusterSize        = 65336
+	defaultNattPort                    = 4500
+	defaultIkePort                     = 500
+	defaultHealthCheckInterval         = 1
+	defaultHealthCheckMaxPacketLossCnt = 5
+
+	// minIPSecPSKRotationInterval leaves the joined clusters time to roll a rotated PSK out
+	minIPSecPSKRotationInterval = time.Hour
+	// minTokenExpiration leaves the joined clusters time to pick up a renewed broker token
+	minTokenExpiration = time.Hour
+)
+
+// log is for logging in this package.
+var knitnetlog = logf.Log.WithName("knitnet-resource")
+
+var clusterIDRegexp = regexp.MustCompile("^[a-z0-9][a-z0-9.-]*[a-z0-9]$")
+
+func (r *Knitnet) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-operator-tkestack-io-v1alpha1-knitnet,mutating=true,failurePolicy=fail,sideEffects=None,groups=operator.tkestack.io,resources=knitnets,verbs=create;update,versions=v1alpha1,name=mknitnet.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &Knitnet{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Knitnet) Default() {
+	knitnetlog.Info("default", "name", r.Name)
+
+	if r.Spec.Action == "" {
+		r.Spec.Action = actionBroker
+	}
+	if r.Spec.BrokerConfig.GlobalnetEnable {
+		if r.Spec.BrokerConfig.GlobalnetCIDRRange == "" {
+			r.Spec.BrokerConfig.GlobalnetCIDRRange = defaultGlobalnetCIDRRange
+		}
+		if r.Spec.BrokerConfig.DefaultGlobalnetClusterSize == 0 {
+			r.Spec.BrokerConfig.DefaultGlobalnetClusterSize = defaultGlobalnetClusterSize
+		}
+	}
+	if r.Spec.JoinConfig.NattPort == 0 {
+		r.Spec.JoinConfig.NattPort = defaultNattPort
+	}
+	if r.Spec.JoinConfig.IkePort == 0 {
+		r.Spec.JoinConfig.IkePort = defaultIkePort
+	}
+	if r.Spec.JoinConfig.HealthCheckInterval == 0 {
+		r.Spec.JoinConfig.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if r.Spec.JoinConfig.HealthCheckMaxPacketLossCount == 0 {
+		r.Spec.JoinConfig.HealthCheckMaxPacketLossCount = defaultHealthCheckMaxPacketLossCnt
+	}
+	r.Spec.JoinConfig.CorednsCustomConfigMap = strings.TrimSpace(r.Spec.JoinConfig.CorednsCustomConfigMap)
+}
+
+//+kubebuilder:webhook:path=/validate-operator-tkestack-io-v1alpha1-knitnet,mutating=false,failurePolicy=fail,sideEffects=None,groups=operator.tkestack.io,resources=knitnets,verbs=create;update,versions=v1alpha1,name=vknitnet.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &Knitnet{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Knitnet) ValidateCreate() error {
+	knitnetlog.Info("validate create", "name", r.Name)
+
+	return r.toAggregateError(r.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Knitnet) ValidateUpdate(old runtime.Object) error {
+	knitnetlog.Info("validate update", "name", r.Name)
+
+	oldKnitnet, ok := old.(*Knitnet)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a Knitnet but got a %T", old))
+	}
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validateImmutableFields(oldKnitnet)...)
+	return r.toAggregateError(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Knitnet) ValidateDelete() error {
+	knitnetlog.Info("validate delete", "name", r.Name)
+
+	// Deletion is guarded by the finalizer, nothing to validate here
+	return nil
+}
+
+// IsJoined returns whether the cluster of the knitnet has successfully joined the broker
+func (r *Knitnet) IsJoined() bool {
+	return meta.IsStatusConditionTrue(r.Status.Conditions, ConditionSubmarinerDeployed)
+}
+
+func (r *Knitnet) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	if r.Spec.Action == actionBroker || r.Spec.Action == actionAll {
+		allErrs = append(allErrs, ValidateBrokerConfig(&r.Spec.BrokerConfig, specPath.Child("brokerConfig"))...)
+	}
+	if r.Spec.Action == actionJoin || r.Spec.Action == actionAll {
+		joinPath := specPath.Child("joinConfig")
+		allErrs = append(allErrs, ValidateJoinConfig(&r.Spec.JoinConfig, joinPath)...)
+		// The broker is deployed in the same cluster, so the global CIDR range is known up front
+		if r.Spec.Action == actionAll && r.Spec.BrokerConfig.GlobalnetEnable {
+			allErrs = append(allErrs, validateJoinGlobalnet(&r.Spec.JoinConfig, r.Spec.BrokerConfig.GlobalnetCIDRRange, joinPath)...)
+		}
+	}
+	if r.Spec.CloudPrepareConfig.CredentialsSecret != nil {
+		allErrs = append(allErrs, ValidateCloudPrepareConfig(&r.Spec.CloudPrepareConfig, specPath.Child("cloudPrepareConfig"))...)
+	}
+	return allErrs
+}
+
+func (r *Knitnet) validateImmutableFields(old *Knitnet) field.ErrorList {
+	return ValidateClusterIDUpdate(&r.Spec.JoinConfig, &old.Spec.JoinConfig, old.JoinedClusterID(), r.GetAnnotations(),
+		field.NewPath("spec", "joinConfig"))
+}
+
+// JoinedClusterID returns the cluster ID the cluster has joined the broker with, if any
+func (r *Knitnet) JoinedClusterID() string {
+	if r.Status.ClusterID != "" {
+		return r.Status.ClusterID
+	}
+	if r.IsJoined() {
+		return r.Spec.JoinConfig.ClusterID
+	}
+	return ""
+}
+
+func (r *Knitnet) toAggregateError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Knitnet"}, r.Name, allErrs)
+}
+
+// ValidateCloudPrepareConfig validates the settings given for the cloud provider, the provider, the region
+// and the infra ID may be omitted as they are detected
+func ValidateCloudPrepareConfig(cloudPrepareConfig *CloudPrepareConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	switch cloudPrepareConfig.Provider {
+	case CloudProviderAWS:
+		if cloudPrepareConfig.AWS.Gateways < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("aws", "gateways"), cloudPrepareConfig.AWS.Gateways, "must not be negative"))
+		}
+	case CloudProviderTencent:
+		if cloudPrepareConfig.TencentCloud.Gateways < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("tencentCloud", "gateways"),
+				cloudPrepareConfig.TencentCloud.Gateways, "must not be negative"))
+		}
+		if cloudPrepareConfig.TencentCloud.EIPBandwidth < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("tencentCloud", "eipBandwidth"),
+				cloudPrepareConfig.TencentCloud.EIPBandwidth, "must not be negative"))
+		}
+	case CloudProviderGCP:
+		if cloudPrepareConfig.GCP.Gateways < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("gcp", "gateways"), cloudPrepareConfig.GCP.Gateways, "must not be negative"))
+		}
+	case CloudProviderAzure:
+		if cloudPrepareConfig.Azure.Gateways < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("azure", "gateways"), cloudPrepareConfig.Azure.Gateways, "must not be negative"))
+		}
+	case "":
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("provider"), cloudPrepareConfig.Provider,
+			[]string{string(CloudProviderAWS), string(CloudProviderTencent), string(CloudProviderGCP), string(CloudProviderAzure)}))
+	}
+	return allErrs
+}
+
+// RequireCloudPrepareConfig checks the settings the cloud provider needs once the omitted ones are
+// filled with the detected values
+func RequireCloudPrepareConfig(cloudPrepareConfig *CloudPrepareConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	switch cloudPrepareConfig.Provider {
+	case CloudProviderAWS:
+		if cloudPrepareConfig.Region == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("region"), "the region is required on AWS and could not be detected"))
+		}
+		if cloudPrepareConfig.InfraID == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("infraID"), "the infra ID is required on AWS and could not be detected"))
+		}
+	case CloudProviderTencent:
+		if cloudPrepareConfig.Region == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("region"), "the region is required on Tencent Cloud and could not be detected"))
+		}
+	case CloudProviderGCP:
+		if cloudPrepareConfig.InfraID == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("infraID"), "the infra ID names the firewall rules on GCP and could not be detected"))
+		}
+	case "":
+		allErrs = append(allErrs, field.Required(fldPath.Child("provider"), "the provider is required to prepare the cloud and could not be detected"))
+	}
+	return allErrs
+}
+
+// ValidateBrokerConfig validates the globalnet settings of the broker
+func ValidateBrokerConfig(brokerConfig *BrokerConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if interval := brokerConfig.IPSecPSKRotationInterval; interval != nil && interval.Duration < minIPSecPSKRotationInterval {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("ipsecPSKRotationInterval"), interval.Duration.String(),
+			fmt.Sprintf("must be at least %s", minIPSecPSKRotationInterval)))
+	}
+	if expiration := brokerConfig.TokenExpiration; expiration != nil && expiration.Duration < minTokenExpiration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("tokenExpiration"), expiration.Duration.String(),
+			fmt.Sprintf("must be at least %s", minTokenExpiration)))
+	}
+	if !brokerConfig.GlobalnetEnable {
+		return allErrs
+	}
+	rangePath := fldPath.Child("globalnetCIDRRange")
+	if err := validateIPv4CIDR(brokerConfig.GlobalnetCIDRRange); err != nil {
+		return append(allErrs, field.Invalid(rangePath, brokerConfig.GlobalnetCIDRRange, err.Error()))
+	}
+	if err := ValidateGlobalnetClusterSize(brokerConfig.GlobalnetCIDRRange, brokerConfig.DefaultGlobalnetClusterSize); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("defaultGlobalnetClusterSize"), brokerConfig.DefaultGlobalnetClusterSize, err.Error()))
+	}
+	return allErrs
+}
+
+// ValidateJoinConfig validates the join settings which do not depend on the broker
+func ValidateJoinConfig(joinConfig *JoinConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if joinConfig.ClusterID != "" {
+		if err := ValidateClusterID(joinConfig.ClusterID); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("clusterID"), joinConfig.ClusterID, err.Error()))
+		}
+	}
+	if joinConfig.ClusterID == "" && joinConfig.ClusterIDPrefix != "" {
+		// The derived cluster ID is <prefix>-<8 characters of the kube-system namespace UID>
+		if err := ValidateClusterID(joinConfig.ClusterIDPrefix + "-00000000"); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("clusterIDPrefix"), joinConfig.ClusterIDPrefix, err.Error()))
+		}
+	}
+	if err := ValidateCustomCoreDNSConfig(joinConfig.CorednsCustomConfigMap); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("corednsCustomConfigMap"), joinConfig.CorednsCustomConfigMap, err.Error()))
+	}
+	for i, imageOverride := range joinConfig.ImageOverrideArr {
+		if _, _, err := ParseImageOverride(imageOverride); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("imageOverrideArr").Index(i), imageOverride, err.Error()))
+		}
+	}
+	if joinConfig.GlobalnetCIDR != "" && joinConfig.GlobalnetClusterSize != 0 {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("globalnetClusterSize"),
+			"both globalnetClusterSize and globalnetCIDR can't be specified, specify either one"))
+	}
+	if joinConfig.GlobalnetCIDR != "" {
+		if err := validateIPv4CIDR(joinConfig.GlobalnetCIDR); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("globalnetCIDR"), joinConfig.GlobalnetCIDR, err.Error()))
+		}
+	}
+	if joinConfig.ClusterCIDR != "" {
+		if err := ValidateClusterCIDRs(strings.Split(joinConfig.ClusterCIDR, ",")); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("clusterCIDR"), joinConfig.ClusterCIDR, err.Error()))
+		}
+	}
+	if joinConfig.ServiceCIDR != "" {
+		if err := ValidateClusterCIDRs(strings.Split(joinConfig.ServiceCIDR, ",")); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("serviceCIDR"), joinConfig.ServiceCIDR, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+func validateJoinGlobalnet(joinConfig *JoinConfig, globalnetCIDRRange string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	_, rangeNet, err := net.ParseCIDR(globalnetCIDRRange)
+	if err != nil {
+		// Reported by ValidateBrokerConfig
+		return allErrs
+	}
+	if joinConfig.GlobalnetClusterSize != 0 {
+		if err := ValidateGlobalnetClusterSize(globalnetCIDRRange, joinConfig.GlobalnetClusterSize); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("globalnetClusterSize"), joinConfig.GlobalnetClusterSize, err.Error()))
+		}
+	}
+	if joinConfig.GlobalnetCIDR != "" {
+		ip, cidrNet, err := net.ParseCIDR(joinConfig.GlobalnetCIDR)
+		if err == nil && !isSubnet(rangeNet, ip, cidrNet) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("globalnetCIDR"), joinConfig.GlobalnetCIDR,
+				fmt.Sprintf("should be a subnet of the globalnet CIDR range %s", globalnetCIDRRange)))
+		}
+	}
+	return allErrs
+}
+
+// ValidateClusterIDUpdate protects the identity of a joined cluster, the cluster ID can only be changed
+// when a rename is requested with the ClusterIDRenameAnnotation
+func ValidateClusterIDUpdate(newConfig, oldConfig *JoinConfig, joinedClusterID string, annotations map[string]string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if joinedClusterID == "" || annotations[ClusterIDRenameAnnotation] == "true" {
+		return allErrs
+	}
+	detail := fmt.Sprintf("cluster has joined the broker with cluster ID %q, set annotation %s=true to rename it",
+		joinedClusterID, ClusterIDRenameAnnotation)
+	if newConfig.ClusterID != "" {
+		if newConfig.ClusterID != joinedClusterID {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("clusterID"), detail))
+		}
+		return allErrs
+	}
+	// The cluster ID would be derived again
+	if oldConfig.ClusterID != "" {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("clusterID"), detail))
+	} else if newConfig.ClusterIDPrefix != oldConfig.ClusterIDPrefix {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("clusterIDPrefix"), detail))
+	}
+	return allErrs
+}
+
+// ValidateClusterID makes sure the cluster ID is a valid DNS-1123 string
+func ValidateClusterID(clusterID string) error {
+	if !clusterIDRegexp.MatchString(clusterID) {
+		return fmt.Errorf("cluster IDs must be valid DNS-1123 names, with only lowercase alphanumerics,\n"+
+			"'.' or '-' (and the first and last characters must be alphanumerics).\n"+
+			"%s doesn't meet these requirements", clusterID)
+	}
+	return nil
+}
+
+// ValidateCustomCoreDNSConfig makes sure the custom CoreDNS configmap is in <namespace>/<name> format
+func ValidateCustomCoreDNSConfig(corednsCustomConfigMap string) error {
+	if corednsCustomConfigMap == "" {
+		return nil
+	}
+	paramList := strings.Split(corednsCustomConfigMap, "/")
+	if len(paramList) > 2 {
+		return fmt.Errorf("coredns-custom-configmap should be in <namespace>/<name> format, namespace is optional")
+	}
+	for _, param := range paramList {
+		if param == "" {
+			return fmt.Errorf("coredns-custom-configmap should be in <namespace>/<name> format, namespace is optional")
+		}
+	}
+	return nil
+}
+
+// ParseImageOverride splits an image override in <component>=<image> format
+func ParseImageOverride(imageOverride string) (component, image string, err error) {
+	paramList := strings.SplitN(imageOverride, "=", 2)
+	if len(paramList) != 2 || paramList[1] == "" {
+		return "", "", fmt.Errorf("image override %q should be in <component>=<image> format", imageOverride)
+	}
+	component, image = paramList[0], paramList[1]
+	for _, name := range ValidImageNames {
+		if component == name {
+			return component, image, nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid image name %s provided. Please choose from %q", component, ValidImageNames)
+}
+
+// ValidateGlobalnetClusterSize makes sure the cluster size, rounded up to a power of 2,
+// is at most half of the global CIDR range
+func ValidateGlobalnetClusterSize(globalnetCIDRRange string, clusterSize uint) error {
+	_, network, err := net.ParseCIDR(globalnetCIDRRange)
+	if err != nil {
+		return err
+	}
+	ones, totalbits := network.Mask.Size()
+	availableSize := uint64(1) << uint(totalbits-ones)
+	roundedSize := uint64(1)
+	for roundedSize < uint64(clusterSize) {
+		roundedSize <<= 1
+	}
+	if clusterSize == 0 || roundedSize > availableSize/2 {
+		return fmt.Errorf("cluster size %d, should be > 0 and <= %d", clusterSize, availableSize/2)
+	}
+	return nil
+}
+
+// SplitIPFamilies splits the CIDRs into the IPv4 and the IPv6 ones
+func SplitIPFamilies(cidrs []string) (ipv4, ipv6 []string, err error) {
+	for _, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, nil, err
+		}
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, strings.TrimSpace(cidr))
+		} else {
+			ipv6 = append(ipv6, strings.TrimSpace(cidr))
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+// IPFamilies returns the IP families of the CIDRs, IPv4 first
+func IPFamilies(cidrs []string) []corev1.IPFamily {
+	ipv4, ipv6, _ := SplitIPFamilies(cidrs)
+	var families []corev1.IPFamily
+	if len(ipv4) > 0 {
+		families = append(families, corev1.IPv4Protocol)
+	}
+	if len(ipv6) > 0 {
+		families = append(families, corev1.IPv6Protocol)
+	}
+	return families
+}
+
+// ValidateClusterCIDRs makes sure the pod or service CIDRs of a cluster are valid and that they have an
+// IPv4 CIDR, Submariner does not connect the IPv6 CIDRs of dual-stack clusters yet
+func ValidateClusterCIDRs(cidrs []string) error {
+	ipv4, _, err := SplitIPFamilies(cidrs)
+	if err != nil {
+		return err
+	}
+	if len(ipv4) == 0 {
+		return fmt.Errorf("no IPv4 CIDR in %s, Submariner does not support IPv6-only clusters yet", strings.Join(cidrs, ","))
+	}
+	return nil
+}
+
+// validateIPv4CIDR makes sure the CIDR is an IPv4 CIDR, as globalnet only allocates IPv4 global CIDRs
+func validateIPv4CIDR(cidr string) error {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("globalnet only supports IPv4 CIDRs")
+	}
+	return nil
+}
+
+func isSubnet(parent *net.IPNet, ip net.IP, child *net.IPNet) bool {
+	parentOnes, parentBits := parent.Mask.Size()
+	childOnes, childBits := child.Mask.Size()
+	return parentBits == childBits && childOnes >= parentOnes && parent.Contains(ip)
+}
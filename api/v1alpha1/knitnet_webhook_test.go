@@ -0,0 +1,281 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func newJoinKnitnet() *Knitnet {
+	return &Knitnet{
+		ObjectMeta: metav1.ObjectMeta{Name: "join-broker-sample"},
+		Spec: KnitnetSpec{
+			Action:     "join",
+			JoinConfig: JoinConfig{ClusterID: "cluster-b"},
+		},
+	}
+}
+
+var _ = Describe("Knitnet webhook", func() {
+	When("Defaulting", func() {
+		It("Should fill the globalnet defaults of the broker", func() {
+			knitnet := &Knitnet{Spec: KnitnetSpec{BrokerConfig: BrokerConfig{GlobalnetEnable: true}}}
+			knitnet.Default()
+			Expect(knitnet.Spec.Action).To(Equal("broker"))
+			Expect(knitnet.Spec.BrokerConfig.GlobalnetCIDRRange).To(Equal("242.0.0.0/8"))
+			Expect(knitnet.Spec.BrokerConfig.DefaultGlobalnetClusterSize).To(Equal(uint(65336)))
+			Expect(knitnet.Spec.JoinConfig.NattPort).To(Equal(4500))
+		})
+	})
+
+	When("Validating a join", func() {
+		It("Should accept a valid spec", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.CorednsCustomConfigMap = "kube-system/coredns-custom"
+			knitnet.Spec.JoinConfig.ImageOverrideArr = []string{"submariner-operator=repo/submariner-operator:dev"}
+			Expect(knitnet.ValidateCreate()).To(Succeed())
+		})
+
+		It("Should reject an invalid cluster ID", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.ClusterID = "Cluster_B"
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.joinConfig.clusterID")))
+		})
+
+		It("Should reject an invalid cluster ID prefix", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.ClusterID = ""
+			knitnet.Spec.JoinConfig.ClusterIDPrefix = "Prod"
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.joinConfig.clusterIDPrefix")))
+		})
+
+		It("Should reject a malformed custom CoreDNS configmap", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.CorednsCustomConfigMap = "a/b/c"
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.joinConfig.corednsCustomConfigMap")))
+		})
+
+		It("Should reject an image override without '='", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.ImageOverrideArr = []string{"submariner-operator"}
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.joinConfig.imageOverrideArr[0]")))
+		})
+
+		It("Should reject an image override of an unknown component", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.ImageOverrideArr = []string{"unknown=repo/unknown:dev"}
+			Expect(knitnet.ValidateCreate()).To(HaveOccurred())
+		})
+
+		It("Should accept dual-stack CIDRs", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.ClusterCIDR = "10.244.0.0/16,fd00:10:244::/56"
+			knitnet.Spec.JoinConfig.ServiceCIDR = "10.96.0.0/12,fd00:10:96::/112"
+			Expect(knitnet.ValidateCreate()).To(Succeed())
+		})
+
+		It("Should reject IPv6-only CIDRs", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.ClusterCIDR = "fd00:10:244::/56"
+			Expect(knitnet.ValidateCreate()).To(MatchError(And(
+				ContainSubstring("spec.joinConfig.clusterCIDR"), ContainSubstring("IPv6-only"))))
+		})
+
+		It("Should reject an IPv6 globalnet CIDR", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.GlobalnetCIDR = "fd00:242::/64"
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.joinConfig.globalnetCIDR")))
+		})
+
+		It("Should reject both globalnet CIDR and cluster size", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.GlobalnetCIDR = "242.0.0.0/16"
+			knitnet.Spec.JoinConfig.GlobalnetClusterSize = 1024
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.joinConfig.globalnetClusterSize")))
+		})
+	})
+
+	When("Validating a broker", func() {
+		It("Should reject a cluster size larger than the globalnet CIDR range allows", func() {
+			knitnet := &Knitnet{Spec: KnitnetSpec{
+				Action:       "broker",
+				BrokerConfig: BrokerConfig{GlobalnetEnable: true, GlobalnetCIDRRange: "242.0.0.0/16", DefaultGlobalnetClusterSize: 65336},
+			}}
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.brokerConfig.defaultGlobalnetClusterSize")))
+		})
+
+		It("Should reject a join cluster size larger than the broker range of the same knitnet", func() {
+			knitnet := &Knitnet{Spec: KnitnetSpec{
+				Action:       "all",
+				BrokerConfig: BrokerConfig{GlobalnetEnable: true, GlobalnetCIDRRange: "242.0.0.0/16", DefaultGlobalnetClusterSize: 1024},
+				JoinConfig:   JoinConfig{ClusterID: "cluster-a", GlobalnetClusterSize: 65536},
+			}}
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.joinConfig.globalnetClusterSize")))
+		})
+
+		It("Should reject a join global CIDR outside of the broker range", func() {
+			knitnet := &Knitnet{Spec: KnitnetSpec{
+				Action:       "all",
+				BrokerConfig: BrokerConfig{GlobalnetEnable: true, GlobalnetCIDRRange: "242.0.0.0/16", DefaultGlobalnetClusterSize: 1024},
+				JoinConfig:   JoinConfig{ClusterID: "cluster-a", GlobalnetCIDR: "243.0.0.0/24"},
+			}}
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.joinConfig.globalnetCIDR")))
+		})
+
+		It("Should reject a too short IPsec PSK rotation interval", func() {
+			knitnet := &Knitnet{Spec: KnitnetSpec{
+				Action:       "broker",
+				BrokerConfig: BrokerConfig{IPSecPSKRotationInterval: &metav1.Duration{Duration: time.Minute}},
+			}}
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.brokerConfig.ipsecPSKRotationInterval")))
+		})
+	})
+
+	When("Updating", func() {
+		It("Should allow changing the cluster ID before joining", func() {
+			old := newJoinKnitnet()
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.JoinConfig.ClusterID = "cluster-c"
+			Expect(knitnet.ValidateUpdate(old)).To(Succeed())
+		})
+
+		It("Should forbid changing the cluster ID after joining", func() {
+			old := newJoinKnitnet()
+			meta.SetStatusCondition(&old.Status.Conditions, metav1.Condition{
+				Type: ConditionSubmarinerDeployed, Status: metav1.ConditionTrue, Reason: ReasonSucceeded,
+			})
+			knitnet := old.DeepCopy()
+			knitnet.Spec.JoinConfig.ClusterID = "cluster-c"
+			Expect(knitnet.ValidateUpdate(old)).To(MatchError(ContainSubstring("spec.joinConfig.clusterID")))
+		})
+
+		It("Should allow pinning the derived cluster ID", func() {
+			old := newJoinKnitnet()
+			old.Spec.JoinConfig.ClusterID = ""
+			old.Status.ClusterID = "3f2a9c1e"
+			knitnet := old.DeepCopy()
+			knitnet.Spec.JoinConfig.ClusterID = "3f2a9c1e"
+			Expect(knitnet.ValidateUpdate(old)).To(Succeed())
+		})
+
+		It("Should forbid changing the cluster ID prefix after joining", func() {
+			old := newJoinKnitnet()
+			old.Spec.JoinConfig.ClusterID = ""
+			old.Status.ClusterID = "3f2a9c1e"
+			knitnet := old.DeepCopy()
+			knitnet.Spec.JoinConfig.ClusterIDPrefix = "prod"
+			Expect(knitnet.ValidateUpdate(old)).To(MatchError(ContainSubstring("spec.joinConfig.clusterIDPrefix")))
+		})
+
+		It("Should allow a rename with the rename annotation", func() {
+			old := newJoinKnitnet()
+			old.Status.ClusterID = "cluster-b"
+			knitnet := old.DeepCopy()
+			knitnet.Annotations = map[string]string{ClusterIDRenameAnnotation: "true"}
+			knitnet.Spec.JoinConfig.ClusterID = "cluster-c"
+			Expect(knitnet.ValidateUpdate(old)).To(Succeed())
+		})
+	})
+
+	When("Validating a cloud preparation", func() {
+		It("Should accept an omitted provider, region and infra ID as they are detected", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.CloudPrepareConfig.CredentialsSecret = &corev1.LocalObjectReference{Name: "aws-creds"}
+			Expect(knitnet.ValidateCreate()).To(Succeed())
+		})
+
+		It("Should reject an unsupported provider", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.CloudPrepareConfig = CloudPrepareConfig{
+				CredentialsSecret: &corev1.LocalObjectReference{Name: "creds"},
+				Provider:          "openstack",
+			}
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.cloudPrepareConfig.provider")))
+		})
+
+		It("Should reject a negative elastic IP bandwidth on Tencent Cloud", func() {
+			knitnet := newJoinKnitnet()
+			knitnet.Spec.CloudPrepareConfig = CloudPrepareConfig{
+				CredentialsSecret: &corev1.LocalObjectReference{Name: "tencent-creds"},
+				Provider:          CloudProviderTencent,
+				TencentCloud:      TencentCloud{Gateways: 1, EIPBandwidth: -1},
+			}
+			Expect(knitnet.ValidateCreate()).To(MatchError(ContainSubstring("spec.cloudPrepareConfig.tencentCloud.eipBandwidth")))
+
+			knitnet.Spec.CloudPrepareConfig.TencentCloud.EIPBandwidth = 100
+			Expect(knitnet.ValidateCreate()).To(Succeed())
+		})
+	})
+
+	When("Splitting the IP families", func() {
+		It("Should split the IPv4 and the IPv6 CIDRs", func() {
+			ipv4, ipv6, err := SplitIPFamilies([]string{"fd00:10:244::/56", "10.244.0.0/16"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipv4).To(Equal([]string{"10.244.0.0/16"}))
+			Expect(ipv6).To(Equal([]string{"fd00:10:244::/56"}))
+			Expect(IPFamilies([]string{"fd00:10:244::/56", "10.244.0.0/16"})).
+				To(Equal([]corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}))
+		})
+
+		It("Should reject an invalid CIDR", func() {
+			_, _, err := SplitIPFamilies([]string{"10.244.0.0/33"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("Requiring the detected cloud settings", func() {
+		fldPath := field.NewPath("cloudPrepareConfig")
+
+		It("Should require the provider", func() {
+			Expect(RequireCloudPrepareConfig(&CloudPrepareConfig{}, fldPath).ToAggregate()).
+				To(MatchError(ContainSubstring("cloudPrepareConfig.provider")))
+		})
+
+		It("Should require the region and infra ID on AWS", func() {
+			config := &CloudPrepareConfig{Provider: CloudProviderAWS}
+			err := RequireCloudPrepareConfig(config, fldPath).ToAggregate()
+			Expect(err).To(MatchError(ContainSubstring("cloudPrepareConfig.region")))
+			Expect(err).To(MatchError(ContainSubstring("cloudPrepareConfig.infraID")))
+
+			config.Region = "us-east-1"
+			config.InfraID = "cluster-b-x7k2p"
+			Expect(RequireCloudPrepareConfig(config, fldPath)).To(BeEmpty())
+		})
+
+		It("Should only require the region on Tencent Cloud", func() {
+			config := &CloudPrepareConfig{Provider: CloudProviderTencent}
+			Expect(RequireCloudPrepareConfig(config, fldPath)).To(HaveLen(1))
+			config.Region = "ap-guangzhou"
+			Expect(RequireCloudPrepareConfig(config, fldPath)).To(BeEmpty())
+		})
+
+		It("Should require the infra ID on GCP", func() {
+			config := &CloudPrepareConfig{Provider: CloudProviderGCP}
+			Expect(RequireCloudPrepareConfig(config, fldPath).ToAggregate()).
+				To(MatchError(ContainSubstring("cloudPrepareConfig.infraID")))
+			config.InfraID = "cluster-c-8fk2w"
+			Expect(RequireCloudPrepareConfig(config, fldPath)).To(BeEmpty())
+		})
+	})
+})
@@ -14,19 +14,16 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package utils
+package v1alpha1
 
 import (
-	"math/rand"
-	"time"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
-func RandStringRunes(n int) string {
-	rand.Seed(time.Now().UnixNano())
-	letterRunes := []rune("abcdefghijklmnopqrstuvwxyz0123456789")
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
-	}
-	return string(b)
+func TestKnitnetWebhook(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Knitnet webhook")
 }
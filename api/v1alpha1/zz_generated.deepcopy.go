@@ -21,7 +21,8 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/api/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -40,6 +41,21 @@ func (in *AWS) DeepCopy() *AWS {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Azure) DeepCopyInto(out *Azure) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Azure.
+func (in *Azure) DeepCopy() *Azure {
+	if in == nil {
+		return nil
+	}
+	out := new(Azure)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BrokerConfig) DeepCopyInto(out *BrokerConfig) {
 	*out = *in
@@ -48,6 +64,16 @@ func (in *BrokerConfig) DeepCopyInto(out *BrokerConfig) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.IPSecPSKRotationInterval != nil {
+		in, out := &in.IPSecPSKRotationInterval, &out.IPSecPSKRotationInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.TokenExpiration != nil {
+		in, out := &in.TokenExpiration, &out.TokenExpiration
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BrokerConfig.
@@ -60,15 +86,33 @@ func (in *BrokerConfig) DeepCopy() *BrokerConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloudPlatform) DeepCopyInto(out *CloudPlatform) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudPlatform.
+func (in *CloudPlatform) DeepCopy() *CloudPlatform {
+	if in == nil {
+		return nil
+	}
+	out := new(CloudPlatform)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CloudPrepareConfig) DeepCopyInto(out *CloudPrepareConfig) {
 	*out = *in
 	if in.CredentialsSecret != nil {
 		in, out := &in.CredentialsSecret, &out.CredentialsSecret
-		*out = new(v1.LocalObjectReference)
+		*out = new(corev1.LocalObjectReference)
 		**out = **in
 	}
 	out.AWS = in.AWS
+	out.TencentCloud = in.TencentCloud
+	out.GCP = in.GCP
+	out.Azure = in.Azure
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudPrepareConfig.
@@ -81,9 +125,100 @@ func (in *CloudPrepareConfig) DeepCopy() *CloudPrepareConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloudResource) DeepCopyInto(out *CloudResource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudResource.
+func (in *CloudResource) DeepCopy() *CloudResource {
+	if in == nil {
+		return nil
+	}
+	out := new(CloudResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscoveryStatus) DeepCopyInto(out *DiscoveryStatus) {
+	*out = *in
+	if in.CloudPlatform != nil {
+		in, out := &in.CloudPlatform, &out.CloudPlatform
+		*out = new(CloudPlatform)
+		**out = **in
+	}
+	if in.Network != nil {
+		in, out := &in.Network, &out.Network
+		*out = new(NetworkDiscovery)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscoveryStatus.
+func (in *DiscoveryStatus) DeepCopy() *DiscoveryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscoveryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCP) DeepCopyInto(out *GCP) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCP.
+func (in *GCP) DeepCopy() *GCP {
+	if in == nil {
+		return nil
+	}
+	out := new(GCP)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPSecPSKStatus) DeepCopyInto(out *IPSecPSKStatus) {
+	*out = *in
+	if in.LastRotationTime != nil {
+		in, out := &in.LastRotationTime, &out.LastRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.UpdatedClusters != nil {
+		in, out := &in.UpdatedClusters, &out.UpdatedClusters
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PendingClusters != nil {
+		in, out := &in.PendingClusters, &out.PendingClusters
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPSecPSKStatus.
+func (in *IPSecPSKStatus) DeepCopy() *IPSecPSKStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IPSecPSKStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *JoinConfig) DeepCopyInto(out *JoinConfig) {
 	*out = *in
+	if in.NetworkPluginSettings != nil {
+		in, out := &in.NetworkPluginSettings, &out.NetworkPluginSettings
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	if in.CustomDomains != nil {
 		in, out := &in.CustomDomains, &out.CustomDomains
 		*out = make([]string, len(*in))
@@ -112,7 +247,7 @@ func (in *Knitnet) DeepCopyInto(out *Knitnet) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Knitnet.
@@ -186,6 +321,33 @@ func (in *KnitnetSpec) DeepCopy() *KnitnetSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KnitnetStatus) DeepCopyInto(out *KnitnetStatus) {
 	*out = *in
+	if in.CloudResources != nil {
+		in, out := &in.CloudResources, &out.CloudResources
+		*out = make([]CloudResource, len(*in))
+		copy(*out, *in)
+	}
+	if in.AppliedCloudPrepareConfig != nil {
+		in, out := &in.AppliedCloudPrepareConfig, &out.AppliedCloudPrepareConfig
+		*out = new(CloudPrepareConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Discovery != nil {
+		in, out := &in.Discovery, &out.Discovery
+		*out = new(DiscoveryStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IPSecPSK != nil {
+		in, out := &in.IPSecPSK, &out.IPSecPSK
+		*out = new(IPSecPSKStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetStatus.
@@ -197,3 +359,108 @@ func (in *KnitnetStatus) DeepCopy() *KnitnetStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkDetection) DeepCopyInto(out *NetworkDetection) {
+	*out = *in
+	if in.Evidence != nil {
+		in, out := &in.Evidence, &out.Evidence
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PodCIDRs != nil {
+		in, out := &in.PodCIDRs, &out.PodCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceCIDRs != nil {
+		in, out := &in.ServiceCIDRs, &out.ServiceCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkDetection.
+func (in *NetworkDetection) DeepCopy() *NetworkDetection {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkDetection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkDiscovery) DeepCopyInto(out *NetworkDiscovery) {
+	*out = *in
+	if in.PodCIDRs != nil {
+		in, out := &in.PodCIDRs, &out.PodCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceCIDRs != nil {
+		in, out := &in.ServiceCIDRs, &out.ServiceCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PluginSettings != nil {
+		in, out := &in.PluginSettings, &out.PluginSettings
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PinnedFields != nil {
+		in, out := &in.PinnedFields, &out.PinnedFields
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.IPFamilies != nil {
+		in, out := &in.IPFamilies, &out.IPFamilies
+		*out = make([]corev1.IPFamily, len(*in))
+		copy(*out, *in)
+	}
+	if in.Detections != nil {
+		in, out := &in.Detections, &out.Detections
+		*out = make([]NetworkDetection, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DetectorErrors != nil {
+		in, out := &in.DetectorErrors, &out.DetectorErrors
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LastDiscoveryTime != nil {
+		in, out := &in.LastDiscoveryTime, &out.LastDiscoveryTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkDiscovery.
+func (in *NetworkDiscovery) DeepCopy() *NetworkDiscovery {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkDiscovery)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TencentCloud) DeepCopyInto(out *TencentCloud) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TencentCloud.
+func (in *TencentCloud) DeepCopy() *TencentCloud {
+	if in == nil {
+		return nil
+	}
+	out := new(TencentCloud)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tkestack/knitnet-operator/api/v1alpha1"
+)
+
+// A CRD conversion webhook can only convert between versions of the same kind, while a v1alpha1
+// Knitnet is split into several v1beta1 kinds here. The conversion is therefore done by the operator,
+// see ConvertFromKnitnet, and the v1beta1 reconcilers reuse the v1alpha1 logic through ToKnitnet.
+// It is never done automatically, a Knitnet is only converted once annotated with
+// operator.tkestack.io/convert-to=v1beta1, so the existing Knitnets keep being served as they are.
+
+var brokerConditionTypes = []string{
+	v1alpha1.ConditionReady,
+	v1alpha1.ConditionBrokerRBACReady,
+	v1alpha1.ConditionSubmarinerOperatorReady,
+	v1alpha1.ConditionBrokerDeployed,
+	v1alpha1.ConditionBrokerInfoPublished,
+	v1alpha1.ConditionDeletionBlocked,
+	v1alpha1.ConditionIPSecPSKRolledOut,
+}
+
+var joinConditionTypes = []string{
+	v1alpha1.ConditionReady,
+	v1alpha1.ConditionSubmarinerOperatorReady,
+	v1alpha1.ConditionBrokerConnected,
+	v1alpha1.ConditionNetworkDiscovered,
+	v1alpha1.ConditionGlobalCIDRAllocated,
+	v1alpha1.ConditionServiceCIDRInferred,
+	v1alpha1.ConditionSubmarinerDeployed,
+	v1alpha1.ConditionCalicoIPPoolsReady,
+}
+
+var cloudPrepareConditionTypes = []string{
+	v1alpha1.ConditionReady,
+	v1alpha1.ConditionCloudCredentialsReady,
+	v1alpha1.ConditionCloudPortsOpened,
+	v1alpha1.ConditionCloudGatewaysReady,
+}
+
+// ConvertFromKnitnet splits a v1alpha1 Knitnet into the v1beta1 objects its action stands for,
+// the objects not required by the action are returned as nil
+func ConvertFromKnitnet(src *v1alpha1.Knitnet) (*KnitnetBroker, *KnitnetJoin, *KnitnetCloudPrepare) {
+	var knitnetBroker *KnitnetBroker
+	var knitnetJoin *KnitnetJoin
+	var knitnetCloudPrepare *KnitnetCloudPrepare
+
+	if src.Spec.Action == "" || src.Spec.Action == "broker" || src.Spec.Action == "all" {
+		knitnetBroker = &KnitnetBroker{
+			ObjectMeta: convertObjectMeta(src),
+			Spec:       KnitnetBrokerSpec{BrokerConfig: *src.Spec.BrokerConfig.DeepCopy()},
+			Status: KnitnetBrokerStatus{
+				ObservedGeneration: src.Status.ObservedGeneration,
+				Conditions:         filterConditions(src.Status.Conditions, brokerConditionTypes),
+				IPSecPSK:           src.Status.IPSecPSK.DeepCopy(),
+			},
+		}
+	}
+	if src.Spec.Action == "join" || src.Spec.Action == "all" {
+		knitnetJoin = &KnitnetJoin{
+			ObjectMeta: convertObjectMeta(src),
+			Spec:       KnitnetJoinSpec{JoinConfig: *src.Spec.JoinConfig.DeepCopy()},
+			Status: KnitnetJoinStatus{
+				ObservedGeneration:         src.Status.ObservedGeneration,
+				ClusterID:                  src.Status.ClusterID,
+				GatewayLoadBalancerAddress: src.Status.GatewayLoadBalancerAddress,
+				Conditions:                 filterConditions(src.Status.Conditions, joinConditionTypes),
+			},
+		}
+		if src.Status.Discovery != nil {
+			knitnetJoin.Status.Network = src.Status.Discovery.Network.DeepCopy()
+		}
+	}
+	cloudPrepareConfig := src.Spec.CloudPrepareConfig
+	if cloudPrepareConfig.CredentialsSecret != nil || cloudPrepareConfig.InfraID != "" || cloudPrepareConfig.Region != "" {
+		knitnetCloudPrepare = &KnitnetCloudPrepare{
+			ObjectMeta: convertObjectMeta(src),
+			Spec:       KnitnetCloudPrepareSpec{CloudPrepareConfig: *cloudPrepareConfig.DeepCopy()},
+			Status: KnitnetCloudPrepareStatus{
+				ObservedGeneration:        src.Status.ObservedGeneration,
+				CloudResources:            append([]v1alpha1.CloudResource(nil), src.Status.CloudResources...),
+				AppliedCloudPrepareConfig: src.Status.AppliedCloudPrepareConfig.DeepCopy(),
+				Conditions:                filterConditions(src.Status.Conditions, cloudPrepareConditionTypes),
+			},
+		}
+		if src.Status.Discovery != nil {
+			knitnetCloudPrepare.Status.CloudPlatform = src.Status.Discovery.CloudPlatform.DeepCopy()
+		}
+	}
+	return knitnetBroker, knitnetJoin, knitnetCloudPrepare
+}
+
+// ToKnitnet returns the equivalent v1alpha1 Knitnet of the broker
+func (r *KnitnetBroker) ToKnitnet() *v1alpha1.Knitnet {
+	return &v1alpha1.Knitnet{
+		ObjectMeta: *r.ObjectMeta.DeepCopy(),
+		Spec: v1alpha1.KnitnetSpec{
+			Action:       "broker",
+			BrokerConfig: *r.Spec.BrokerConfig.DeepCopy(),
+		},
+		Status: v1alpha1.KnitnetStatus{
+			ObservedGeneration: r.Status.ObservedGeneration,
+			IPSecPSK:           r.Status.IPSecPSK.DeepCopy(),
+			Conditions:         filterConditions(r.Status.Conditions, brokerConditionTypes),
+		},
+	}
+}
+
+// ToKnitnet returns the equivalent v1alpha1 Knitnet of the join
+func (r *KnitnetJoin) ToKnitnet() *v1alpha1.Knitnet {
+	knitnet := &v1alpha1.Knitnet{
+		ObjectMeta: *r.ObjectMeta.DeepCopy(),
+		Spec: v1alpha1.KnitnetSpec{
+			Action:     "join",
+			JoinConfig: *r.Spec.JoinConfig.DeepCopy(),
+		},
+		Status: v1alpha1.KnitnetStatus{
+			ObservedGeneration:         r.Status.ObservedGeneration,
+			ClusterID:                  r.Status.ClusterID,
+			GatewayLoadBalancerAddress: r.Status.GatewayLoadBalancerAddress,
+			Conditions:                 filterConditions(r.Status.Conditions, joinConditionTypes),
+		},
+	}
+	if r.Status.Network != nil {
+		knitnet.Status.Discovery = &v1alpha1.DiscoveryStatus{Network: r.Status.Network.DeepCopy()}
+	}
+	return knitnet
+}
+
+// ToKnitnet returns the equivalent v1alpha1 Knitnet of the cloud preparation
+func (r *KnitnetCloudPrepare) ToKnitnet() *v1alpha1.Knitnet {
+	knitnet := &v1alpha1.Knitnet{
+		ObjectMeta: *r.ObjectMeta.DeepCopy(),
+		Spec: v1alpha1.KnitnetSpec{
+			CloudPrepareConfig: *r.Spec.CloudPrepareConfig.DeepCopy(),
+		},
+		Status: v1alpha1.KnitnetStatus{
+			ObservedGeneration:        r.Status.ObservedGeneration,
+			CloudResources:            append([]v1alpha1.CloudResource(nil), r.Status.CloudResources...),
+			AppliedCloudPrepareConfig: r.Status.AppliedCloudPrepareConfig.DeepCopy(),
+			Conditions:                filterConditions(r.Status.Conditions, cloudPrepareConditionTypes),
+		},
+	}
+	if r.Status.CloudPlatform != nil {
+		knitnet.Status.Discovery = &v1alpha1.DiscoveryStatus{CloudPlatform: r.Status.CloudPlatform.DeepCopy()}
+	}
+	return knitnet
+}
+
+// convertObjectMeta keeps the name, namespace, labels and annotations of the source, the
+// finalizer is added back by the reconciler of the new object
+func convertObjectMeta(src *v1alpha1.Knitnet) metav1.ObjectMeta {
+	objectMeta := metav1.ObjectMeta{
+		Name:        src.GetName(),
+		Namespace:   src.GetNamespace(),
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	}
+	for k, v := range src.GetLabels() {
+		objectMeta.Labels[k] = v
+	}
+	for k, v := range src.GetAnnotations() {
+		objectMeta.Annotations[k] = v
+	}
+	return objectMeta
+}
+
+func filterConditions(conditions []metav1.Condition, conditionTypes []string) []metav1.Condition {
+	var filtered []metav1.Condition
+	for _, conditionType := range conditionTypes {
+		if condition := meta.FindStatusCondition(conditions, conditionType); condition != nil {
+			filtered = append(filtered, *condition)
+		}
+	}
+	return filtered
+}
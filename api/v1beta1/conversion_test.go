@@ -0,0 +1,110 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1beta1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tkestack/knitnet-operator/api/v1alpha1"
+)
+
+func newKnitnet(action string) *v1alpha1.Knitnet {
+	knitnet := &v1alpha1.Knitnet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "knitnet-sample",
+			Namespace:   "default",
+			Annotations: map[string]string{"owner": "team-a"},
+		},
+		Spec: v1alpha1.KnitnetSpec{
+			Action:       action,
+			BrokerConfig: v1alpha1.BrokerConfig{GlobalnetEnable: true, GlobalnetCIDRRange: "242.0.0.0/8"},
+			JoinConfig:   v1alpha1.JoinConfig{ClusterID: "cluster-a", NattPort: 4500},
+		},
+	}
+	for _, conditionType := range []string{v1alpha1.ConditionReady, v1alpha1.ConditionBrokerDeployed, v1alpha1.ConditionSubmarinerDeployed} {
+		meta.SetStatusCondition(&knitnet.Status.Conditions, metav1.Condition{
+			Type: conditionType, Status: metav1.ConditionTrue, Reason: v1alpha1.ReasonSucceeded,
+		})
+	}
+	return knitnet
+}
+
+var _ = Describe("Knitnet conversion", func() {
+	When("Converting a broker Knitnet", func() {
+		It("Should only create a KnitnetBroker", func() {
+			knitnetBroker, knitnetJoin, knitnetCloudPrepare := ConvertFromKnitnet(newKnitnet("broker"))
+			Expect(knitnetBroker).NotTo(BeNil())
+			Expect(knitnetJoin).To(BeNil())
+			Expect(knitnetCloudPrepare).To(BeNil())
+			Expect(knitnetBroker.Name).To(Equal("knitnet-sample"))
+			Expect(knitnetBroker.Annotations).To(HaveKeyWithValue("owner", "team-a"))
+			Expect(knitnetBroker.Spec.GlobalnetCIDRRange).To(Equal("242.0.0.0/8"))
+		})
+
+		It("Should only keep the broker conditions", func() {
+			knitnetBroker, _, _ := ConvertFromKnitnet(newKnitnet("broker"))
+			Expect(meta.FindStatusCondition(knitnetBroker.Status.Conditions, v1alpha1.ConditionBrokerDeployed)).NotTo(BeNil())
+			Expect(meta.FindStatusCondition(knitnetBroker.Status.Conditions, v1alpha1.ConditionSubmarinerDeployed)).To(BeNil())
+		})
+	})
+
+	When("Converting an all Knitnet with cloud prepare config", func() {
+		It("Should create all the v1beta1 objects", func() {
+			knitnet := newKnitnet("all")
+			knitnet.Spec.CloudPrepareConfig.Region = "ap-guangzhou"
+			knitnetBroker, knitnetJoin, knitnetCloudPrepare := ConvertFromKnitnet(knitnet)
+			Expect(knitnetBroker).NotTo(BeNil())
+			Expect(knitnetJoin).NotTo(BeNil())
+			Expect(knitnetCloudPrepare).NotTo(BeNil())
+			Expect(knitnetJoin.Spec.ClusterID).To(Equal("cluster-a"))
+			Expect(knitnetJoin.IsJoined()).To(BeTrue())
+			Expect(knitnetCloudPrepare.Spec.Region).To(Equal("ap-guangzhou"))
+		})
+	})
+
+	When("Converting back to a Knitnet", func() {
+		It("Should round trip the join config", func() {
+			_, knitnetJoin, _ := ConvertFromKnitnet(newKnitnet("join"))
+			knitnet := knitnetJoin.ToKnitnet()
+			Expect(knitnet.Spec.Action).To(Equal("join"))
+			Expect(knitnet.Spec.JoinConfig).To(Equal(newKnitnet("join").Spec.JoinConfig))
+			Expect(knitnet.Status.Conditions).To(Equal(knitnetJoin.Status.Conditions))
+		})
+
+		It("Should round trip the dual-stack network of the join", func() {
+			knitnet := newKnitnet("join")
+			knitnet.Status.Discovery = &v1alpha1.DiscoveryStatus{Network: &v1alpha1.NetworkDiscovery{
+				NetworkPlugin: "calico",
+				PodCIDRs:      []string{"10.244.0.0/16", "fd00:10:244::/56"},
+				IPFamilies:    []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol},
+			}}
+			_, knitnetJoin, _ := ConvertFromKnitnet(knitnet)
+			Expect(knitnetJoin.Status.Network).To(Equal(knitnet.Status.Discovery.Network))
+			Expect(knitnetJoin.ToKnitnet().Status.Discovery).To(Equal(knitnet.Status.Discovery))
+		})
+
+		It("Should not share the conditions with the source", func() {
+			knitnetBroker, _, _ := ConvertFromKnitnet(newKnitnet("broker"))
+			knitnet := knitnetBroker.ToKnitnet()
+			knitnet.Status.Conditions[0].Status = metav1.ConditionFalse
+			Expect(knitnetBroker.Status.Conditions[0].Status).To(Equal(metav1.ConditionTrue))
+		})
+	})
+})
@@ -0,0 +1,78 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tkestack/knitnet-operator/api/v1alpha1"
+)
+
+// KnitnetBrokerSpec defines the desired state of KnitnetBroker
+type KnitnetBrokerSpec struct {
+	// BrokerConfig represents the broker cluster configuration of the Submariner.
+	v1alpha1.BrokerConfig `json:",inline"`
+}
+
+// KnitnetBrokerStatus defines the observed state of KnitnetBroker
+type KnitnetBrokerStatus struct {
+	// ObservedGeneration is the most recent generation of the spec that has been fully applied.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of each deploy stage.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// JoinedClusters is the list of the cluster IDs registered in the broker.
+	// +optional
+	JoinedClusters []string `json:"joinedClusters,omitempty"`
+
+	// IPSecPSK reports the IPsec PSK published by the broker and its rollout to the joined clusters.
+	// +optional
+	IPSecPSK *v1alpha1.IPSecPSKStatus `json:"ipsecPSK,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+// +kubebuilder:resource:path=knitnetbrokers,shortName=knb,scope=Namespaced
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Globalnet",type=boolean,JSONPath=.spec.globalnetEnable
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=.metadata.creationTimestamp
+// KnitnetBroker is the Schema for the knitnetbrokers API. It is not created from an existing v1alpha1
+// Knitnet automatically, the Knitnet is converted when annotated with operator.tkestack.io/convert-to=v1beta1.
+type KnitnetBroker struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KnitnetBrokerSpec   `json:"spec,omitempty"`
+	Status KnitnetBrokerStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KnitnetBrokerList contains a list of KnitnetBroker
+type KnitnetBrokerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KnitnetBroker `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KnitnetBroker{}, &KnitnetBrokerList{})
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1beta1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/tkestack/knitnet-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var knitnetbrokerlog = logf.Log.WithName("knitnetbroker-resource")
+
+func (r *KnitnetBroker) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-operator-tkestack-io-v1beta1-knitnetbroker,mutating=false,failurePolicy=fail,sideEffects=None,groups=operator.tkestack.io,resources=knitnetbrokers,verbs=create;update,versions=v1beta1,name=vknitnetbroker.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &KnitnetBroker{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *KnitnetBroker) ValidateCreate() error {
+	knitnetbrokerlog.Info("validate create", "name", r.Name)
+
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *KnitnetBroker) ValidateUpdate(old runtime.Object) error {
+	knitnetbrokerlog.Info("validate update", "name", r.Name)
+
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *KnitnetBroker) ValidateDelete() error {
+	knitnetbrokerlog.Info("validate delete", "name", r.Name)
+
+	// Deletion is guarded by the finalizer, nothing to validate here
+	return nil
+}
+
+func (r *KnitnetBroker) validate() error {
+	allErrs := v1alpha1.ValidateBrokerConfig(&r.Spec.BrokerConfig, field.NewPath("spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("KnitnetBroker").GroupKind(), r.Name, allErrs)
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tkestack/knitnet-operator/api/v1alpha1"
+)
+
+// KnitnetCloudPrepareSpec defines the desired state of KnitnetCloudPrepare
+type KnitnetCloudPrepareSpec struct {
+	// CloudPrepareConfig represents the prepare config for the cloud vendor.
+	v1alpha1.CloudPrepareConfig `json:",inline"`
+}
+
+// KnitnetCloudPrepareStatus defines the observed state of KnitnetCloudPrepare
+type KnitnetCloudPrepareStatus struct {
+	// ObservedGeneration is the most recent generation of the spec that has been fully applied.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// CloudResources are the cloud resources created by the cloud preparation.
+	// +optional
+	CloudResources []v1alpha1.CloudResource `json:"cloudResources,omitempty"`
+
+	// AppliedCloudPrepareConfig is the cloud prepare config the cloud resources were created with.
+	// +optional
+	AppliedCloudPrepareConfig *v1alpha1.CloudPrepareConfig `json:"appliedCloudPrepareConfig,omitempty"`
+
+	// CloudPlatform is the detected cloud platform, it is not set when no platform is detected.
+	// +optional
+	CloudPlatform *v1alpha1.CloudPlatform `json:"cloudPlatform,omitempty"`
+
+	// Conditions represent the latest available observations of the cloud preparation.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+// +kubebuilder:resource:path=knitnetcloudprepares,shortName=kcp,scope=Namespaced
+// +kubebuilder:printcolumn:name="Region",type=string,JSONPath=.spec.region
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=.metadata.creationTimestamp
+// KnitnetCloudPrepare is the Schema for the knitnetcloudprepares API. It is not created from an existing v1alpha1
+// Knitnet automatically, the Knitnet is converted when annotated with operator.tkestack.io/convert-to=v1beta1.
+type KnitnetCloudPrepare struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KnitnetCloudPrepareSpec   `json:"spec,omitempty"`
+	Status KnitnetCloudPrepareStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KnitnetCloudPrepareList contains a list of KnitnetCloudPrepare
+type KnitnetCloudPrepareList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KnitnetCloudPrepare `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KnitnetCloudPrepare{}, &KnitnetCloudPrepareList{})
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tkestack/knitnet-operator/api/v1alpha1"
+)
+
+// KnitnetJoinSpec defines the desired state of KnitnetJoin
+type KnitnetJoinSpec struct {
+	// JoinConfig represents the managed cluster join configuration of the Submariner.
+	v1alpha1.JoinConfig `json:",inline"`
+}
+
+// KnitnetJoinStatus defines the observed state of KnitnetJoin
+type KnitnetJoinStatus struct {
+	// ObservedGeneration is the most recent generation of the spec that has been fully applied.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// ClusterID is the cluster ID the cluster has joined the broker with.
+	// +optional
+	ClusterID string `json:"clusterID,omitempty"`
+
+	// GatewayLoadBalancerAddress is the address assigned to the LoadBalancer service in front of the gateways,
+	// it is only set when the LoadBalancer is enabled in the join config.
+	// +optional
+	GatewayLoadBalancerAddress string `json:"gatewayLoadBalancerAddress,omitempty"`
+
+	// Network is the discovered network of the cluster, including the IPv6 CIDRs of a dual-stack cluster.
+	// +optional
+	Network *v1alpha1.NetworkDiscovery `json:"network,omitempty"`
+
+	// Conditions represent the latest available observations of each join stage.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+// +kubebuilder:resource:path=knitnetjoins,shortName=knj,scope=Namespaced
+// +kubebuilder:printcolumn:name="Cluster ID",type=string,JSONPath=.status.clusterID
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=.metadata.creationTimestamp
+// KnitnetJoin is the Schema for the knitnetjoins API. It is not created from an existing v1alpha1
+// Knitnet automatically, the Knitnet is converted when annotated with operator.tkestack.io/convert-to=v1beta1.
+type KnitnetJoin struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KnitnetJoinSpec   `json:"spec,omitempty"`
+	Status KnitnetJoinStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KnitnetJoinList contains a list of KnitnetJoin
+type KnitnetJoinList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KnitnetJoin `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KnitnetJoin{}, &KnitnetJoinList{})
+}
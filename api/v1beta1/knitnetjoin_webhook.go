@@ -0,0 +1,84 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1beta1
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/tkestack/knitnet-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var knitnetjoinlog = logf.Log.WithName("knitnetjoin-resource")
+
+func (r *KnitnetJoin) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-operator-tkestack-io-v1beta1-knitnetjoin,mutating=false,failurePolicy=fail,sideEffects=None,groups=operator.tkestack.io,resources=knitnetjoins,verbs=create;update,versions=v1beta1,name=vknitnetjoin.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &KnitnetJoin{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *KnitnetJoin) ValidateCreate() error {
+	knitnetjoinlog.Info("validate create", "name", r.Name)
+
+	return r.toAggregateError(v1alpha1.ValidateJoinConfig(&r.Spec.JoinConfig, field.NewPath("spec")))
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *KnitnetJoin) ValidateUpdate(old runtime.Object) error {
+	knitnetjoinlog.Info("validate update", "name", r.Name)
+
+	oldJoin, ok := old.(*KnitnetJoin)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a KnitnetJoin but got a %T", old))
+	}
+	allErrs := v1alpha1.ValidateJoinConfig(&r.Spec.JoinConfig, field.NewPath("spec"))
+	allErrs = append(allErrs, v1alpha1.ValidateClusterIDUpdate(&r.Spec.JoinConfig, &oldJoin.Spec.JoinConfig,
+		oldJoin.ToKnitnet().JoinedClusterID(), r.GetAnnotations(), field.NewPath("spec"))...)
+	return r.toAggregateError(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *KnitnetJoin) ValidateDelete() error {
+	knitnetjoinlog.Info("validate delete", "name", r.Name)
+
+	// Deletion is guarded by the finalizer, nothing to validate here
+	return nil
+}
+
+// IsJoined returns whether the cluster has successfully joined the broker
+func (r *KnitnetJoin) IsJoined() bool {
+	return meta.IsStatusConditionTrue(r.Status.Conditions, v1alpha1.ConditionSubmarinerDeployed)
+}
+
+func (r *KnitnetJoin) toAggregateError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("KnitnetJoin").GroupKind(), r.Name, allErrs)
+}
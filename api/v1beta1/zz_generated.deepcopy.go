@@ -0,0 +1,348 @@
+// +build !ignore_autogenerated
+
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"github.com/tkestack/knitnet-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetBroker) DeepCopyInto(out *KnitnetBroker) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetBroker.
+func (in *KnitnetBroker) DeepCopy() *KnitnetBroker {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetBroker)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KnitnetBroker) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetBrokerList) DeepCopyInto(out *KnitnetBrokerList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KnitnetBroker, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetBrokerList.
+func (in *KnitnetBrokerList) DeepCopy() *KnitnetBrokerList {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetBrokerList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KnitnetBrokerList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetBrokerSpec) DeepCopyInto(out *KnitnetBrokerSpec) {
+	*out = *in
+	in.BrokerConfig.DeepCopyInto(&out.BrokerConfig)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetBrokerSpec.
+func (in *KnitnetBrokerSpec) DeepCopy() *KnitnetBrokerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetBrokerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetBrokerStatus) DeepCopyInto(out *KnitnetBrokerStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.JoinedClusters != nil {
+		in, out := &in.JoinedClusters, &out.JoinedClusters
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.IPSecPSK != nil {
+		in, out := &in.IPSecPSK, &out.IPSecPSK
+		*out = new(v1alpha1.IPSecPSKStatus)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetBrokerStatus.
+func (in *KnitnetBrokerStatus) DeepCopy() *KnitnetBrokerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetBrokerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetCloudPrepare) DeepCopyInto(out *KnitnetCloudPrepare) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetCloudPrepare.
+func (in *KnitnetCloudPrepare) DeepCopy() *KnitnetCloudPrepare {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetCloudPrepare)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KnitnetCloudPrepare) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetCloudPrepareList) DeepCopyInto(out *KnitnetCloudPrepareList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KnitnetCloudPrepare, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetCloudPrepareList.
+func (in *KnitnetCloudPrepareList) DeepCopy() *KnitnetCloudPrepareList {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetCloudPrepareList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KnitnetCloudPrepareList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetCloudPrepareSpec) DeepCopyInto(out *KnitnetCloudPrepareSpec) {
+	*out = *in
+	in.CloudPrepareConfig.DeepCopyInto(&out.CloudPrepareConfig)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetCloudPrepareSpec.
+func (in *KnitnetCloudPrepareSpec) DeepCopy() *KnitnetCloudPrepareSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetCloudPrepareSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetCloudPrepareStatus) DeepCopyInto(out *KnitnetCloudPrepareStatus) {
+	*out = *in
+	if in.CloudResources != nil {
+		in, out := &in.CloudResources, &out.CloudResources
+		*out = make([]v1alpha1.CloudResource, len(*in))
+		copy(*out, *in)
+	}
+	if in.AppliedCloudPrepareConfig != nil {
+		in, out := &in.AppliedCloudPrepareConfig, &out.AppliedCloudPrepareConfig
+		*out = new(v1alpha1.CloudPrepareConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CloudPlatform != nil {
+		in, out := &in.CloudPlatform, &out.CloudPlatform
+		*out = new(v1alpha1.CloudPlatform)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetCloudPrepareStatus.
+func (in *KnitnetCloudPrepareStatus) DeepCopy() *KnitnetCloudPrepareStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetCloudPrepareStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetJoin) DeepCopyInto(out *KnitnetJoin) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetJoin.
+func (in *KnitnetJoin) DeepCopy() *KnitnetJoin {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetJoin)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KnitnetJoin) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetJoinList) DeepCopyInto(out *KnitnetJoinList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KnitnetJoin, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetJoinList.
+func (in *KnitnetJoinList) DeepCopy() *KnitnetJoinList {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetJoinList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KnitnetJoinList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetJoinSpec) DeepCopyInto(out *KnitnetJoinSpec) {
+	*out = *in
+	in.JoinConfig.DeepCopyInto(&out.JoinConfig)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetJoinSpec.
+func (in *KnitnetJoinSpec) DeepCopy() *KnitnetJoinSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetJoinSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KnitnetJoinStatus) DeepCopyInto(out *KnitnetJoinStatus) {
+	*out = *in
+	if in.Network != nil {
+		in, out := &in.Network, &out.Network
+		*out = new(v1alpha1.NetworkDiscovery)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KnitnetJoinStatus.
+func (in *KnitnetJoinStatus) DeepCopy() *KnitnetJoinStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KnitnetJoinStatus)
+	in.DeepCopyInto(out)
+	return out
+}
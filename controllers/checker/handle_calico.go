@@ -22,13 +22,16 @@ import (
 	"text/template"
 
 	submarinerv1 "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
 
 	netconsts "github.com/tkestack/knitnet-operator/controllers/discovery"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
 	"github.com/tkestack/knitnet-operator/controllers/ensures/broker"
 )
 
@@ -77,21 +80,49 @@ apiVersion: crd.projectcalico.org/v1
 kind: IPPool
 metadata:
   name: {{ .NAME }}
+  labels:
+    {{ .LABELKEY }}: {{ .LABELVALUE }}
 spec:
   cidr: {{ .CIDR }}
   natOutgoing: false
   disabled: true
 `
 
+var ippoolGVK = schema.GroupVersionKind{Group: "crd.projectcalico.org", Version: "v1", Kind: "IPPoolList"}
+
 type IPPoolData struct {
-	NAME string
-	CIDR string
+	NAME       string
+	CIDR       string
+	LABELKEY   string
+	LABELVALUE string
+}
+
+// DeleteCalicoIPPools removes the IPPools created by EnsureCalico for the remote clusters
+func DeleteCalicoIPPools(c client.Client) error {
+	ippools := &unstructured.UnstructuredList{}
+	ippools.SetGroupVersionKind(ippoolGVK)
+	if err := c.List(context.TODO(), ippools, client.MatchingLabels{consts.KnitnetManagedByLabel: consts.KnitnetManagedByValue}); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		klog.Errorf("Failed to list IPPool: %v", err)
+		return err
+	}
+	for i := range ippools.Items {
+		klog.Infof("Delete IPPool %s", ippools.Items[i].GetName())
+		if err := c.Delete(context.TODO(), &ippools.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func createOrUpdateIPPools(c client.Client, name, cidr string) error {
 	ippoolData := IPPoolData{
-		NAME: name,
-		CIDR: cidr,
+		NAME:       name,
+		CIDR:       cidr,
+		LABELKEY:   consts.KnitnetManagedByLabel,
+		LABELVALUE: consts.KnitnetManagedByValue,
 	}
 	var ippoolYaml bytes.Buffer
 	t := template.Must(template.New("ippool").Parse(ippool))
@@ -118,7 +149,21 @@ func createUpdateFromYaml(c client.Client, yamlContent []byte) error {
 		return err
 	}
 
+	// CreateOrUpdate replaces the object with the live one, the labels and the spec of the
+	// rendered object are applied in the mutate function
+	desired := obj.DeepCopy()
 	or, err := ctrl.CreateOrUpdate(context.TODO(), c, obj, func() error {
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for key, value := range desired.GetLabels() {
+			labels[key] = value
+		}
+		obj.SetLabels(labels)
+		if spec, ok := desired.Object["spec"]; ok {
+			obj.Object["spec"] = spec
+		}
 		return nil
 	})
 	if err != nil {
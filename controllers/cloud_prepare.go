@@ -0,0 +1,414 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare"
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare/aws"
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare/azure"
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare/gcp"
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare/tencent"
+	clouddiscovery "github.com/tkestack/knitnet-operator/controllers/discovery/cloud"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+const (
+	// cloudPrepareRequeueAfter is the interval to recheck the gateway instances while they are provisioned
+	cloudPrepareRequeueAfter = 30 * time.Second
+
+	masterNodeLabel       = "node-role.kubernetes.io/master"
+	controlPlaneNodeLabel = "node-role.kubernetes.io/control-plane"
+)
+
+// cloudConditionTypes are the conditions reporting the cloud preparation
+var cloudConditionTypes = []string{
+	operatorv1alpha1.ConditionCloudCredentialsReady,
+	operatorv1alpha1.ConditionCloudPortsOpened,
+	operatorv1alpha1.ConditionCloudGatewaysReady,
+}
+
+// isCloudPrepareRequired returns whether the cluster environment is prepared by the operator
+func isCloudPrepareRequired(instance *operatorv1alpha1.Knitnet) bool {
+	return instance.Spec.CloudPrepareConfig.CredentialsSecret != nil
+}
+
+// needsCloudCleanup returns whether cloud resources are recorded which are no longer wanted, because the
+// cloud preparation was dropped or moved to another provider, region or infrastructure
+func needsCloudCleanup(instance *operatorv1alpha1.Knitnet) bool {
+	if len(instance.Status.CloudResources) == 0 {
+		return false
+	}
+	if !isCloudPrepareRequired(instance) {
+		return true
+	}
+	applied, config := instance.Status.AppliedCloudPrepareConfig, &instance.Spec.CloudPrepareConfig
+	if applied == nil {
+		return false
+	}
+	// An empty value is one which could not be detected, rather than a change
+	changed := func(applied, desired string) bool {
+		return desired != "" && applied != desired
+	}
+	return changed(string(applied.Provider), string(config.Provider)) || changed(applied.Region, config.Region) ||
+		changed(applied.InfraID, config.InfraID)
+}
+
+// newCloud returns the cloud preparation of the configured provider, nodes are the nodes of the cluster
+func newCloud(config *operatorv1alpha1.CloudPrepareConfig, credentials map[string][]byte, nodes []cloudprepare.Node) (cloudprepare.Cloud, error) {
+	switch config.Provider {
+	case operatorv1alpha1.CloudProviderAWS:
+		return aws.NewCloudFromCredentials(credentials, config.InfraID, config.Region)
+	case operatorv1alpha1.CloudProviderTencent:
+		return tencent.NewCloudFromCredentials(credentials, config.Region, nodes, config.TencentCloud.EIPBandwidth)
+	case operatorv1alpha1.CloudProviderGCP:
+		return gcp.NewCloudFromCredentials(credentials, config.InfraID, nodes)
+	case operatorv1alpha1.CloudProviderAzure:
+		return azure.NewCloudFromCredentials(credentials, nodes)
+	default:
+		return nil, fmt.Errorf("cloud provider %q is not supported", config.Provider)
+	}
+}
+
+// gatewaySpec returns the gateway instances requested for the provider
+func gatewaySpec(config *operatorv1alpha1.CloudPrepareConfig) cloudprepare.GatewaySpec {
+	switch config.Provider {
+	case operatorv1alpha1.CloudProviderAWS:
+		return cloudprepare.GatewaySpec{InstanceType: config.AWS.GatewayInstance, Count: config.AWS.Gateways}
+	case operatorv1alpha1.CloudProviderTencent:
+		return cloudprepare.GatewaySpec{Count: config.TencentCloud.Gateways}
+	case operatorv1alpha1.CloudProviderGCP:
+		return cloudprepare.GatewaySpec{Count: config.GCP.Gateways}
+	case operatorv1alpha1.CloudProviderAzure:
+		return cloudprepare.GatewaySpec{Count: config.Azure.Gateways}
+	default:
+		return cloudprepare.GatewaySpec{}
+	}
+}
+
+// PrepareCloud opens the submariner ports in the cloud network of the cluster and provisions the gateway
+// instances, each step is reported in a condition. It returns whether the gateways are ready. The status
+// is persisted with persist as soon as new cloud resources are recorded, so that they are not leaked when
+// the status update at the end of the reconcile fails.
+func (r *KnitnetReconciler) PrepareCloud(instance *operatorv1alpha1.Knitnet, persist func() error) (bool, error) {
+	config := &instance.Spec.CloudPrepareConfig
+	fldPath := field.NewPath("cloudPrepareConfig")
+	allErrs := append(operatorv1alpha1.ValidateCloudPrepareConfig(config, fldPath), operatorv1alpha1.RequireCloudPrepareConfig(config, fldPath)...)
+	if len(allErrs) > 0 {
+		err := allErrs.ToAggregate()
+		markConditionFalse(instance, operatorv1alpha1.ConditionCloudCredentialsReady, operatorv1alpha1.ReasonInvalidConfiguration, err)
+		return false, err
+	}
+	credentials, err := cloudprepare.GetCredentials(r.Reader, instance.GetNamespace(), config.CredentialsSecret)
+	if err != nil {
+		markConditionFalse(instance, operatorv1alpha1.ConditionCloudCredentialsReady, operatorv1alpha1.ReasonInvalidConfiguration, err)
+		return false, err
+	}
+	nodes, err := r.cloudNodes()
+	if err != nil {
+		klog.Errorf("List the nodes failed: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionCloudCredentialsReady, operatorv1alpha1.ReasonFailed, err)
+		return false, err
+	}
+	cloud, err := newCloud(config, credentials, nodes)
+	if err != nil {
+		klog.Errorf("Create the cloud client failed: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionCloudCredentialsReady, operatorv1alpha1.ReasonInvalidConfiguration, err)
+		return false, err
+	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionCloudCredentialsReady, operatorv1alpha1.ReasonSucceeded,
+		"Using the %s credentials of secret %s", cloud.Name(), config.CredentialsSecret.Name)
+	instance.Status.AppliedCloudPrepareConfig = config.DeepCopy()
+
+	ports := cloudprepare.GatewayPorts(instance.Spec.JoinConfig.IkePort, instance.Spec.JoinConfig.NattPort)
+	resources, err := cloud.OpenPorts(ports)
+	if persistErr := persistCloudResources(instance, resources, persist); persistErr != nil {
+		markConditionFalse(instance, operatorv1alpha1.ConditionCloudPortsOpened, operatorv1alpha1.ReasonFailed, persistErr)
+		return false, persistErr
+	}
+	if err != nil {
+		klog.Errorf("Open the submariner ports on %s failed: %v", cloud.Name(), err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionCloudPortsOpened, operatorv1alpha1.ReasonFailed, err)
+		return false, err
+	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionCloudPortsOpened, operatorv1alpha1.ReasonSucceeded,
+		"Opened the ports %s on %s", describePorts(ports), cloud.Name())
+
+	spec := gatewaySpec(config)
+	if spec.Count == 0 {
+		markConditionTrue(instance, operatorv1alpha1.ConditionCloudGatewaysReady, operatorv1alpha1.ReasonNotRequired,
+			"No gateway instances are requested")
+		return true, nil
+	}
+	gateways, resources, err := cloud.PrepareGateways(spec)
+	if persistErr := persistCloudResources(instance, resources, persist); persistErr != nil {
+		markConditionFalse(instance, operatorv1alpha1.ConditionCloudGatewaysReady, operatorv1alpha1.ReasonFailed, persistErr)
+		return false, persistErr
+	}
+	if err != nil {
+		klog.Errorf("Prepare the gateways on %s failed: %v", cloud.Name(), err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionCloudGatewaysReady, operatorv1alpha1.ReasonFailed, err)
+		return false, err
+	}
+	labeled, err := r.labelCloudGatewayNodes(gateways)
+	if err != nil {
+		klog.Errorf("Label the gateway nodes failed: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionCloudGatewaysReady, operatorv1alpha1.ReasonFailed, err)
+		return false, err
+	}
+	if labeled < spec.Count {
+		setCondition(instance, operatorv1alpha1.ConditionCloudGatewaysReady, metav1.ConditionFalse, operatorv1alpha1.ReasonProvisioning,
+			fmt.Sprintf("%d of %d gateway nodes are ready on %s", labeled, spec.Count, cloud.Name()))
+		return false, nil
+	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionCloudGatewaysReady, operatorv1alpha1.ReasonSucceeded,
+		"%d gateway nodes are ready on %s", labeled, cloud.Name())
+	return true, nil
+}
+
+// detectCloudPlatform fills the provider, the region and the infra ID omitted in the cloud prepare config
+// with the cloud platform reported in the status. Only the in-memory spec is filled. The platform is
+// detected again while the cloud preparation is configured, otherwise only until it is detected.
+func (r *KnitnetReconciler) detectCloudPlatform(instance *operatorv1alpha1.Knitnet) {
+	if isCloudPrepareRequired(instance) || instance.Status.Discovery == nil || instance.Status.Discovery.CloudPlatform == nil {
+		r.discoverCloudPlatform(instance)
+	}
+	if instance.Status.Discovery == nil || instance.Status.Discovery.CloudPlatform == nil {
+		return
+	}
+	platform := instance.Status.Discovery.CloudPlatform
+
+	config := &instance.Spec.CloudPrepareConfig
+	if config.Provider == "" {
+		klog.Infof("Using cloud provider %s detected from %s", platform.Provider, platform.Source)
+		config.Provider = platform.Provider
+	}
+	// The detected region and infra ID do not apply to another provider
+	if config.Provider != platform.Provider {
+		return
+	}
+	if config.Region == "" {
+		config.Region = platform.Region
+	}
+	if config.InfraID == "" {
+		config.InfraID = platform.InfraID
+	}
+}
+
+// discoverCloudPlatform detects the cloud platform and reports it in the status
+func (r *KnitnetReconciler) discoverCloudPlatform(instance *operatorv1alpha1.Knitnet) {
+	platform, err := clouddiscovery.Discover(r.DynamicClient, r.Client)
+	if err != nil {
+		klog.Warningf("Error trying to discover the cloud platform: %v", err)
+		return
+	}
+	if platform == nil {
+		if instance.Status.Discovery != nil {
+			instance.Status.Discovery.CloudPlatform = nil
+		}
+		return
+	}
+	if instance.Status.Discovery == nil {
+		instance.Status.Discovery = &operatorv1alpha1.DiscoveryStatus{}
+	}
+	instance.Status.Discovery.CloudPlatform = &operatorv1alpha1.CloudPlatform{
+		Provider: operatorv1alpha1.CloudProvider(platform.Provider),
+		Region:   platform.Region,
+		InfraID:  platform.InfraID,
+		Source:   platform.Source,
+	}
+}
+
+// CleanupCloud removes the cloud resources recorded in the status, with the cloud preparation config
+// they were created with. It returns whether all of them are removed, the resources which can not be
+// removed yet are kept in the status.
+func (r *KnitnetReconciler) CleanupCloud(instance *operatorv1alpha1.Knitnet) (bool, error) {
+	if len(instance.Status.CloudResources) > 0 {
+		config := instance.Status.AppliedCloudPrepareConfig
+		if config == nil {
+			config = &instance.Spec.CloudPrepareConfig
+		}
+		left, err := r.removeCloudResources(instance, config)
+		if err != nil {
+			markConditionFalse(instance, operatorv1alpha1.ConditionCloudPortsOpened, operatorv1alpha1.ReasonCleaningUp, err)
+			return false, err
+		}
+		if left > 0 {
+			setCondition(instance, operatorv1alpha1.ConditionCloudPortsOpened, metav1.ConditionFalse, operatorv1alpha1.ReasonCleaningUp,
+				fmt.Sprintf("%d cloud resources are being removed on %s", left, config.Provider))
+			return false, nil
+		}
+	}
+	instance.Status.CloudResources = nil
+	instance.Status.AppliedCloudPrepareConfig = nil
+	for _, conditionType := range cloudConditionTypes {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, conditionType)
+	}
+	return true, nil
+}
+
+// removeCloudResources removes the recorded cloud resources and returns the number of resources left.
+// Nothing can be removed when the credentials secret is gone, the removal is blocked until the secret is
+// restored or the force delete annotation is set, which leaves the resources behind.
+func (r *KnitnetReconciler) removeCloudResources(instance *operatorv1alpha1.Knitnet, config *operatorv1alpha1.CloudPrepareConfig) (int, error) {
+	var credentials map[string][]byte
+	var err error
+	if config.CredentialsSecret != nil {
+		credentials, err = cloudprepare.GetCredentials(r.Reader, instance.GetNamespace(), config.CredentialsSecret)
+	}
+	if config.CredentialsSecret == nil || apierrors.IsNotFound(err) {
+		left := len(instance.Status.CloudResources)
+		if instance.GetAnnotations()[consts.KnitnetForceDeleteAnnotation] != "true" {
+			klog.Warningf("The cloud credentials are gone, annotate %s=true to leave %d cloud resources behind",
+				consts.KnitnetForceDeleteAnnotation, left)
+			return left, fmt.Errorf("the cloud credentials secret is gone, restore it to remove %d cloud resources or set annotation %s=true to leave them behind",
+				left, consts.KnitnetForceDeleteAnnotation)
+		}
+		klog.Warningf("The cloud credentials are gone, leaving %d cloud resources behind: %v", left, instance.Status.CloudResources)
+		instance.Status.CloudResources = nil
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	nodes, err := r.cloudNodes()
+	if err != nil {
+		klog.Errorf("List the nodes failed: %v", err)
+		return 0, err
+	}
+	cloud, err := newCloud(config, credentials, nodes)
+	if err != nil {
+		klog.Errorf("Create the cloud client failed: %v", err)
+		return 0, err
+	}
+
+	resources := make([]cloudprepare.Resource, 0, len(instance.Status.CloudResources))
+	for _, recorded := range instance.Status.CloudResources {
+		resources = append(resources, cloudprepare.Resource{Kind: recorded.Kind, ID: recorded.ID, Parent: recorded.Parent})
+	}
+	klog.Infof("Removing %d cloud resources on %s", len(resources), cloud.Name())
+	left, err := cloud.RemoveResources(resources)
+	instance.Status.CloudResources = nil
+	recordCloudResources(instance, left)
+	if err != nil {
+		klog.Errorf("Remove the cloud resources on %s failed: %v", cloud.Name(), err)
+		return len(left), err
+	}
+	return len(left), nil
+}
+
+// labelCloudGatewayNodes labels the nodes of the running gateway instances as gateways, it returns the
+// number of labeled nodes. The instances which have not registered as nodes yet are skipped.
+func (r *KnitnetReconciler) labelCloudGatewayNodes(gateways []cloudprepare.Gateway) (int, error) {
+	providerIDs := map[string]bool{}
+	for _, gateway := range gateways {
+		if gateway.Running {
+			providerIDs[gateway.ProviderID] = true
+		}
+	}
+	nodes := &v1.NodeList{}
+	if err := r.Client.List(context.TODO(), nodes); err != nil {
+		return 0, err
+	}
+	gatewayLabels := map[string]string{consts.SubmarinerGatewayLabel: "true", consts.KnitnetGatewayLabel: "true"}
+	labeled := 0
+	for _, node := range nodes.Items {
+		if !providerIDs[node.Spec.ProviderID] {
+			continue
+		}
+		if node.Labels[consts.SubmarinerGatewayLabel] != "true" {
+			klog.Infof("Labeling node %s of gateway instance %s", node.GetName(), node.Spec.ProviderID)
+			if err := r.addLabelsToNode(node.GetName(), gatewayLabels); err != nil {
+				return labeled, err
+			}
+		}
+		labeled++
+	}
+	return labeled, nil
+}
+
+// cloudNodes returns the nodes of the cluster for the providers which choose the gateways among them
+func (r *KnitnetReconciler) cloudNodes() ([]cloudprepare.Node, error) {
+	nodes := &v1.NodeList{}
+	if err := r.Client.List(context.TODO(), nodes); err != nil {
+		return nil, err
+	}
+	cloudNodes := make([]cloudprepare.Node, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		_, master := node.Labels[masterNodeLabel]
+		_, controlPlane := node.Labels[controlPlaneNodeLabel]
+		cloudNodes = append(cloudNodes, cloudprepare.Node{
+			Name:         node.GetName(),
+			ProviderID:   node.Spec.ProviderID,
+			Gateway:      node.Labels[consts.SubmarinerGatewayLabel] == "true",
+			ControlPlane: master || controlPlane || node.Spec.Unschedulable,
+		})
+	}
+	return cloudNodes, nil
+}
+
+// recordCloudResources adds the cloud resources created by the preparation to the status, so that they
+// can be rolled back
+func recordCloudResources(instance *operatorv1alpha1.Knitnet, resources []cloudprepare.Resource) {
+	for _, resource := range resources {
+		recorded := operatorv1alpha1.CloudResource{Kind: resource.Kind, ID: resource.ID, Parent: resource.Parent}
+		found := false
+		for _, existing := range instance.Status.CloudResources {
+			if existing == recorded {
+				found = true
+				break
+			}
+		}
+		if !found {
+			instance.Status.CloudResources = append(instance.Status.CloudResources, recorded)
+		}
+	}
+}
+
+// persistCloudResources records the cloud resources and persists the status when new ones are recorded
+func persistCloudResources(instance *operatorv1alpha1.Knitnet, resources []cloudprepare.Resource, persist func() error) error {
+	recorded := len(instance.Status.CloudResources)
+	recordCloudResources(instance, resources)
+	if len(instance.Status.CloudResources) == recorded {
+		return nil
+	}
+	if err := persist(); err != nil {
+		klog.Errorf("Persist the cloud resources failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+func describePorts(ports []cloudprepare.Port) string {
+	described := make([]string, 0, len(ports))
+	for _, port := range ports {
+		described = append(described, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+	}
+	return strings.Join(described, ", ")
+}
@@ -0,0 +1,468 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package aws prepares the clusters running on AWS: the submariner ports are opened in the security
+// groups of the cluster and gateway instances are launched from the template of a worker instance.
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare"
+)
+
+const (
+	// The keys of the credentials secret, they match the cloud credentials secrets of OpenShift
+	AccessKeyIDKey     = "aws_access_key_id"
+	SecretAccessKeyKey = "aws_secret_access_key"
+	SessionTokenKey    = "aws_session_token"
+	// EndpointKey optionally overrides the EC2 endpoint of the region
+	EndpointKey = "ec2_endpoint"
+
+	// DefaultGatewayInstanceType is the instance type of the gateways when it is not configured
+	DefaultGatewayInstanceType = "m5n.large"
+
+	clusterTagPrefix           = "kubernetes.io/cluster/"
+	gatewaySecurityGroupSuffix = "-submariner-gw-sg"
+	gatewayNameSuffix          = "-submariner-gw"
+	nameTag                    = "Name"
+	anywhereCIDR               = "0.0.0.0/0"
+	// espProtocol is the IP protocol number of ESP, used by IPsec when no NAT is in between
+	espProtocol = "50"
+)
+
+var activeInstanceStates = []string{"pending", "running"}
+
+type awsCloud struct {
+	ec2     EC2API
+	infraID string
+}
+
+// NewCloud returns the cloud preparation of the cluster tagged with infraID
+func NewCloud(ec2 EC2API, infraID string) cloudprepare.Cloud {
+	return &awsCloud{ec2: ec2, infraID: infraID}
+}
+
+// NewCloudFromCredentials returns the cloud preparation of the cluster tagged with infraID, the EC2 API
+// of the region is accessed with the credentials of the credentials secret
+func NewCloudFromCredentials(credentials map[string][]byte, infraID, region string) (cloudprepare.Cloud, error) {
+	if err := cloudprepare.RequiredKeys(credentials, AccessKeyIDKey, SecretAccessKeyKey); err != nil {
+		return nil, err
+	}
+	ec2, err := NewEC2Client(string(credentials[EndpointKey]), region, Credentials{
+		AccessKeyID:     string(credentials[AccessKeyIDKey]),
+		SecretAccessKey: string(credentials[SecretAccessKeyKey]),
+		SessionToken:    string(credentials[SessionTokenKey]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewCloud(ec2, infraID), nil
+}
+
+func (a *awsCloud) Name() string {
+	return "AWS"
+}
+
+func (a *awsCloud) clusterTag() string {
+	return clusterTagPrefix + a.infraID
+}
+
+// OpenPorts opens the public ports to anywhere in the gateway security group, and the other ports
+// between all security groups of the cluster instances
+func (a *awsCloud) OpenPorts(ports []cloudprepare.Port) ([]cloudprepare.Resource, error) {
+	nodes, err := a.ec2.DescribeInstances([]Filter{
+		{Name: "tag-key", Values: []string{a.clusterTag()}},
+		{Name: "instance-state-name", Values: activeInstanceStates},
+	})
+	if err != nil {
+		klog.Errorf("Describe the instances of cluster %s failed: %v", a.infraID, err)
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no instances tagged with %s found", a.clusterTag())
+	}
+
+	gatewayGroup, resources, err := a.ensureGatewaySecurityGroup(nodes[0].VpcID)
+	if err != nil {
+		return resources, err
+	}
+	clusterGroupIDs := map[string]bool{}
+	for _, node := range nodes {
+		for _, groupID := range node.SecurityGroupIDs {
+			if groupID != gatewayGroup.ID {
+				clusterGroupIDs[groupID] = true
+			}
+		}
+	}
+	sources := make([]string, 0, len(clusterGroupIDs))
+	for groupID := range clusterGroupIDs {
+		sources = append(sources, groupID)
+	}
+	sort.Strings(sources)
+
+	publicPermissions := []IPPermission{{Protocol: espProtocol, CIDRs: []string{anywhereCIDR}}}
+	var internalPermissions []IPPermission
+	for _, port := range ports {
+		permission := IPPermission{Protocol: string(port.Protocol), FromPort: int(port.Port), ToPort: int(port.Port)}
+		if port.Public {
+			permission.CIDRs = []string{anywhereCIDR}
+			publicPermissions = append(publicPermissions, permission)
+		} else {
+			permission.GroupIDs = sources
+			internalPermissions = append(internalPermissions, permission)
+		}
+	}
+
+	authorized, err := a.authorize(gatewayGroup, publicPermissions)
+	resources = append(resources, authorized...)
+	if err != nil || len(internalPermissions) == 0 {
+		return resources, err
+	}
+	groups, err := a.ec2.DescribeSecurityGroups([]Filter{{Name: "group-id", Values: sources}})
+	if err != nil {
+		klog.Errorf("Describe the security groups of cluster %s failed: %v", a.infraID, err)
+		return resources, err
+	}
+	for i := range groups {
+		authorized, err := a.authorize(&groups[i], internalPermissions)
+		resources = append(resources, authorized...)
+		if err != nil {
+			return resources, err
+		}
+	}
+	return resources, nil
+}
+
+// authorize adds the permissions missing in the security group and returns the rules it added
+func (a *awsCloud) authorize(group *SecurityGroup, permissions []IPPermission) ([]cloudprepare.Resource, error) {
+	missing := missingPermissions(group.Ingress, permissions)
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	klog.Infof("Authorizing %d ingress rules in security group %s", len(missing), group.ID)
+	err := a.ec2.AuthorizeSecurityGroupIngress(group.ID, missing)
+	if err != nil && !IsErrorCode(err, "InvalidPermission.Duplicate") {
+		klog.Errorf("Authorize ingress in security group %s failed: %v", group.ID, err)
+		return nil, err
+	}
+	resources := make([]cloudprepare.Resource, 0, len(missing))
+	for _, permission := range missing {
+		resources = append(resources, cloudprepare.Resource{
+			Kind:   cloudprepare.ResourceSecurityGroupRule,
+			ID:     permissionKey(permission, append(permission.CIDRs, permission.GroupIDs...)[0]),
+			Parent: group.ID,
+		})
+	}
+	return resources, nil
+}
+
+// ensureGatewaySecurityGroup returns the security group of the gateway instances, it is created when
+// missing. An existing group is returned as resource when it carries the tag of the operator, so it is
+// recorded again when the record of its creation is lost. A group created by someone else, like
+// subctl cloud prepare, is reused without being recorded, so it is never deleted by the operator.
+func (a *awsCloud) ensureGatewaySecurityGroup(vpcID string) (*SecurityGroup, []cloudprepare.Resource, error) {
+	name := a.infraID + gatewaySecurityGroupSuffix
+	groups, err := a.ec2.DescribeSecurityGroups([]Filter{
+		{Name: "group-name", Values: []string{name}},
+		{Name: "vpc-id", Values: []string{vpcID}},
+	})
+	if err != nil {
+		klog.Errorf("Describe security group %s failed: %v", name, err)
+		return nil, nil, err
+	}
+	if len(groups) > 0 {
+		if value, _ := groups[0].TagValue(cloudprepare.ManagedByTag); value != cloudprepare.ManagedByValue {
+			klog.Infof("Reusing security group %s, which was not created by the operator", groups[0].ID)
+			return &groups[0], nil, nil
+		}
+		owned := []cloudprepare.Resource{{Kind: cloudprepare.ResourceSecurityGroup, ID: groups[0].ID, Parent: vpcID}}
+		return &groups[0], owned, nil
+	}
+
+	klog.Infof("Creating security group %s in VPC %s", name, vpcID)
+	groupID, err := a.ec2.CreateSecurityGroup(vpcID, name, "Submariner gateways", []Tag{
+		{Key: a.clusterTag(), Value: "owned"},
+		{Key: nameTag, Value: name},
+		{Key: cloudprepare.ManagedByTag, Value: cloudprepare.ManagedByValue},
+	})
+	if err != nil {
+		klog.Errorf("Create security group %s failed: %v", name, err)
+		return nil, nil, err
+	}
+	created := []cloudprepare.Resource{{Kind: cloudprepare.ResourceSecurityGroup, ID: groupID, Parent: vpcID}}
+	return &SecurityGroup{ID: groupID, Name: name, VpcID: vpcID}, created, nil
+}
+
+// PrepareGateways launches the missing gateway instances from the template of a worker instance, they
+// get the security groups of the worker and the gateway security group. The source/destination check
+// is disabled on the gateways, as they forward the traffic of the other nodes.
+func (a *awsCloud) PrepareGateways(spec cloudprepare.GatewaySpec) ([]cloudprepare.Gateway, []cloudprepare.Resource, error) {
+	nodes, err := a.ec2.DescribeInstances([]Filter{
+		{Name: "tag-key", Values: []string{a.clusterTag()}},
+		{Name: "instance-state-name", Values: activeInstanceStates},
+	})
+	if err != nil {
+		klog.Errorf("Describe the instances of cluster %s failed: %v", a.infraID, err)
+		return nil, nil, err
+	}
+	var gateways, workers []Instance
+	var resources []cloudprepare.Resource
+	for _, node := range nodes {
+		if value, ok := node.TagValue(cloudprepare.GatewayTag); ok && value == "true" {
+			gateways = append(gateways, node)
+			// The instances launched before are returned too, in case the record of their launch is lost
+			if value, _ := node.TagValue(cloudprepare.ManagedByTag); value == cloudprepare.ManagedByValue {
+				resources = append(resources, cloudprepare.Resource{Kind: cloudprepare.ResourceInstance, ID: node.ID})
+			}
+		} else {
+			workers = append(workers, node)
+		}
+	}
+
+	if missing := spec.Count - len(gateways); missing > 0 {
+		launched, created, err := a.launchGateways(workers, spec.InstanceType, missing)
+		resources = append(resources, created...)
+		if err != nil {
+			return nil, resources, err
+		}
+		gateways = append(gateways, launched...)
+	} else if missing < 0 {
+		klog.Warningf("Cluster %s has %d gateway instances, more than the %d requested", a.infraID, len(gateways), spec.Count)
+	}
+
+	result := make([]cloudprepare.Gateway, 0, len(gateways))
+	for _, gateway := range gateways {
+		if gateway.SourceDestCheck {
+			if err := a.ec2.DisableSourceDestCheck(gateway.ID); err != nil {
+				klog.Errorf("Disable the source/destination check of instance %s failed: %v", gateway.ID, err)
+				return nil, resources, err
+			}
+		}
+		result = append(result, cloudprepare.Gateway{
+			ID:         gateway.ID,
+			ProviderID: fmt.Sprintf("aws:///%s/%s", gateway.AvailabilityZone, gateway.ID),
+			Running:    gateway.State == "running",
+		})
+	}
+	return result, resources, nil
+}
+
+// RemoveResources terminates the gateway instances, revokes the ingress rules and deletes the gateway
+// security group, which is left while the instances using it are terminating
+func (a *awsCloud) RemoveResources(resources []cloudprepare.Resource) ([]cloudprepare.Resource, error) {
+	var instances, rules, groups []cloudprepare.Resource
+	for _, resource := range resources {
+		switch resource.Kind {
+		case cloudprepare.ResourceInstance:
+			instances = append(instances, resource)
+		case cloudprepare.ResourceSecurityGroupRule:
+			rules = append(rules, resource)
+		case cloudprepare.ResourceSecurityGroup:
+			groups = append(groups, resource)
+		default:
+			klog.Warningf("Skipping unknown AWS resource %s %s", resource.Kind, resource.ID)
+		}
+	}
+
+	for i, instance := range instances {
+		klog.Infof("Terminating gateway instance %s", instance.ID)
+		err := a.ec2.TerminateInstance(instance.ID)
+		if err != nil && !IsErrorCode(err, "InvalidInstanceID.NotFound") {
+			klog.Errorf("Terminate instance %s failed: %v", instance.ID, err)
+			return concatResources(instances[i:], rules, groups), err
+		}
+	}
+	for i, rule := range rules {
+		permission, ok := parsePermissionKey(rule.ID)
+		if !ok {
+			klog.Warningf("Skipping malformed ingress rule %s of security group %s", rule.ID, rule.Parent)
+			continue
+		}
+		klog.Infof("Revoking ingress rule %s of security group %s", rule.ID, rule.Parent)
+		err := a.ec2.RevokeSecurityGroupIngress(rule.Parent, []IPPermission{permission})
+		if err != nil && !IsErrorCode(err, "InvalidPermission.NotFound") && !IsErrorCode(err, "InvalidGroup.NotFound") {
+			klog.Errorf("Revoke ingress rule %s of security group %s failed: %v", rule.ID, rule.Parent, err)
+			return concatResources(rules[i:], groups), err
+		}
+	}
+	var left []cloudprepare.Resource
+	for i, group := range groups {
+		klog.Infof("Deleting security group %s", group.ID)
+		err := a.ec2.DeleteSecurityGroup(group.ID)
+		if IsErrorCode(err, "DependencyViolation") {
+			klog.Infof("Security group %s is still in use", group.ID)
+			left = append(left, group)
+			continue
+		}
+		if err != nil && !IsErrorCode(err, "InvalidGroup.NotFound") {
+			klog.Errorf("Delete security group %s failed: %v", group.ID, err)
+			return concatResources(left, groups[i:]), err
+		}
+	}
+	return left, nil
+}
+
+// launchGateways launches the gateway instances, the instances and the security group created are
+// returned as created resources
+func (a *awsCloud) launchGateways(workers []Instance, instanceType string, count int) ([]Instance, []cloudprepare.Resource, error) {
+	template := workerTemplate(workers)
+	if template == nil {
+		return nil, nil, fmt.Errorf("no worker instance of cluster %s found to launch the gateways from", a.infraID)
+	}
+	gatewayGroup, resources, err := a.ensureGatewaySecurityGroup(template.VpcID)
+	if err != nil {
+		return nil, resources, err
+	}
+	userData, err := a.ec2.DescribeInstanceUserData(template.ID)
+	if err != nil {
+		klog.Errorf("Describe the user data of instance %s failed: %v", template.ID, err)
+		return nil, resources, err
+	}
+	if instanceType == "" {
+		instanceType = DefaultGatewayInstanceType
+	}
+	clusterTagValue, _ := template.TagValue(a.clusterTag())
+	securityGroupIDs := append([]string{gatewayGroup.ID}, template.SecurityGroupIDs...)
+
+	klog.Infof("Launching %d %s gateway instances from instance %s", count, instanceType, template.ID)
+	instances, err := a.ec2.RunInstances(&RunInstancesInput{
+		ImageID:               template.ImageID,
+		InstanceType:          instanceType,
+		SubnetID:              template.SubnetID,
+		IAMInstanceProfileARN: template.IAMInstanceProfileARN,
+		UserData:              userData,
+		SecurityGroupIDs:      uniqueStrings(securityGroupIDs),
+		Count:                 count,
+		Tags: []Tag{
+			{Key: a.clusterTag(), Value: clusterTagValue},
+			{Key: nameTag, Value: a.infraID + gatewayNameSuffix},
+			{Key: cloudprepare.GatewayTag, Value: "true"},
+			{Key: cloudprepare.ManagedByTag, Value: cloudprepare.ManagedByValue},
+		},
+	})
+	if err != nil {
+		klog.Errorf("Launch gateway instances failed: %v", err)
+		return nil, resources, err
+	}
+	for i := range instances {
+		// The check is disabled right away, the instances are not described again
+		instances[i].SourceDestCheck = true
+		resources = append(resources, cloudprepare.Resource{Kind: cloudprepare.ResourceInstance, ID: instances[i].ID})
+	}
+	return instances, resources, nil
+}
+
+// workerTemplate returns the instance the gateways are launched from, the instances named as workers are preferred
+func workerTemplate(instances []Instance) *Instance {
+	var template *Instance
+	for i := range instances {
+		name, _ := instances[i].TagValue(nameTag)
+		if strings.Contains(name, "worker") {
+			return &instances[i]
+		}
+		if template == nil {
+			template = &instances[i]
+		}
+	}
+	return template
+}
+
+// missingPermissions returns the desired permissions not granted yet, split into one permission per source
+func missingPermissions(existing, desired []IPPermission) []IPPermission {
+	granted := map[string]bool{}
+	for _, permission := range existing {
+		for _, cidr := range permission.CIDRs {
+			granted[permissionKey(permission, cidr)] = true
+		}
+		for _, groupID := range permission.GroupIDs {
+			granted[permissionKey(permission, groupID)] = true
+		}
+	}
+	var missing []IPPermission
+	for _, permission := range desired {
+		for _, cidr := range permission.CIDRs {
+			if !granted[permissionKey(permission, cidr)] {
+				missing = append(missing, IPPermission{Protocol: permission.Protocol, FromPort: permission.FromPort,
+					ToPort: permission.ToPort, CIDRs: []string{cidr}})
+			}
+		}
+		for _, groupID := range permission.GroupIDs {
+			if !granted[permissionKey(permission, groupID)] {
+				missing = append(missing, IPPermission{Protocol: permission.Protocol, FromPort: permission.FromPort,
+					ToPort: permission.ToPort, GroupIDs: []string{groupID}})
+			}
+		}
+	}
+	return missing
+}
+
+// parsePermissionKey returns the permission of a single source the key was built for
+func parsePermissionKey(key string) (IPPermission, bool) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return IPPermission{}, false
+	}
+	permission := IPPermission{Protocol: parts[0]}
+	source := parts[1]
+	if hasPorts(permission.Protocol) {
+		parts = strings.SplitN(source, "/", 2)
+		if len(parts) != 2 {
+			return IPPermission{}, false
+		}
+		if _, err := fmt.Sscanf(parts[0], "%d-%d", &permission.FromPort, &permission.ToPort); err != nil {
+			return IPPermission{}, false
+		}
+		source = parts[1]
+	}
+	if strings.Contains(source, "/") {
+		permission.CIDRs = []string{source}
+	} else {
+		permission.GroupIDs = []string{source}
+	}
+	return permission, source != ""
+}
+
+func concatResources(lists ...[]cloudprepare.Resource) []cloudprepare.Resource {
+	var resources []cloudprepare.Resource
+	for _, list := range lists {
+		resources = append(resources, list...)
+	}
+	return resources
+}
+
+func permissionKey(permission IPPermission, source string) string {
+	if !hasPorts(permission.Protocol) {
+		return fmt.Sprintf("%s/%s", permission.Protocol, source)
+	}
+	return fmt.Sprintf("%s/%d-%d/%s", strings.ToLower(permission.Protocol), permission.FromPort, permission.ToPort, source)
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare"
+)
+
+const testInfraID = "cluster-b-x7k2p"
+
+var _ = Describe("AWS cloud preparation", func() {
+	var server *httptest.Server
+	var ec2 *mockEC2
+	var cloud cloudprepare.Cloud
+	BeforeEach(func() {
+		ec2 = newMockEC2()
+		ec2.groups = []*SecurityGroup{
+			{ID: "sg-worker", Name: testInfraID + "-worker-sg", VpcID: "vpc-1"},
+			{ID: "sg-master", Name: testInfraID + "-master-sg", VpcID: "vpc-1"},
+		}
+		clusterTag := Tag{Key: clusterTagPrefix + testInfraID, Value: "owned"}
+		ec2.instances = []*Instance{
+			{ID: "i-master", VpcID: "vpc-1", State: "running", SecurityGroupIDs: []string{"sg-master"},
+				Tags: []Tag{clusterTag, {Key: nameTag, Value: testInfraID + "-master-0"}}},
+			{ID: "i-worker", VpcID: "vpc-1", State: "running", ImageID: "ami-1", SubnetID: "subnet-1",
+				IAMInstanceProfileARN: "arn:aws:iam::1:instance-profile/worker", SecurityGroupIDs: []string{"sg-worker"},
+				Tags: []Tag{clusterTag, {Key: nameTag, Value: testInfraID + "-worker-a"}}},
+			{ID: "i-other", VpcID: "vpc-1", State: "running", SecurityGroupIDs: []string{"sg-worker"}},
+		}
+		ec2.userData["i-worker"] = "aWduaXRpb24="
+		server = httptest.NewServer(ec2)
+		var err error
+		cloud, err = NewCloudFromCredentials(map[string][]byte{
+			AccessKeyIDKey:     []byte("AKIDEXAMPLE"),
+			SecretAccessKeyKey: []byte("secret"),
+			EndpointKey:        []byte(server.URL),
+		}, testInfraID, "us-east-1")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	When("Opening the ports", func() {
+		It("Should open the IPsec ports to anywhere and VXLAN inside the cluster", func() {
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+
+			gatewayGroup := ec2.groups[2]
+			Expect(gatewayGroup.Name).To(Equal(testInfraID + gatewaySecurityGroupSuffix))
+			Expect(gatewayGroup.Ingress).To(ConsistOf(
+				IPPermission{Protocol: espProtocol, CIDRs: []string{anywhereCIDR}},
+				IPPermission{Protocol: "udp", FromPort: 500, ToPort: 500, CIDRs: []string{anywhereCIDR}},
+				IPPermission{Protocol: "udp", FromPort: 4500, ToPort: 4500, CIDRs: []string{anywhereCIDR}},
+			))
+			for _, group := range ec2.groups[:2] {
+				Expect(group.Ingress).To(ConsistOf(
+					IPPermission{Protocol: "udp", FromPort: 4800, ToPort: 4800, GroupIDs: []string{"sg-master"}},
+					IPPermission{Protocol: "udp", FromPort: 4800, ToPort: 4800, GroupIDs: []string{"sg-worker"}},
+				))
+			}
+			Expect(resources).To(HaveLen(8))
+			Expect(resources).To(ContainElements(
+				cloudprepare.Resource{Kind: cloudprepare.ResourceSecurityGroup, ID: gatewayGroup.ID, Parent: "vpc-1"},
+				cloudprepare.Resource{Kind: cloudprepare.ResourceSecurityGroupRule, ID: "udp/4500-4500/0.0.0.0/0", Parent: gatewayGroup.ID},
+			))
+		})
+
+		It("Should not authorize the rules again", func() {
+			_, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			authorized := countAction(ec2.actions(), "AuthorizeSecurityGroupIngress")
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			// The existing gateway security group is returned again, in case its record is lost
+			Expect(resources).To(Equal([]cloudprepare.Resource{
+				{Kind: cloudprepare.ResourceSecurityGroup, ID: "sg-1", Parent: "vpc-1"},
+			}))
+			Expect(countAction(ec2.actions(), "AuthorizeSecurityGroupIngress")).To(Equal(authorized))
+			Expect(countAction(ec2.actions(), "CreateSecurityGroup")).To(Equal(1))
+		})
+
+		It("Should reuse a gateway security group created by someone else without recording it", func() {
+			ec2.groups = append(ec2.groups, &SecurityGroup{ID: "sg-subctl", Name: testInfraID + gatewaySecurityGroupSuffix, VpcID: "vpc-1"})
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(countAction(ec2.actions(), "CreateSecurityGroup")).To(Equal(0))
+			for _, resource := range resources {
+				Expect(resource.Kind).NotTo(Equal(cloudprepare.ResourceSecurityGroup))
+			}
+			Expect(resources).To(ContainElement(
+				cloudprepare.Resource{Kind: cloudprepare.ResourceSecurityGroupRule, ID: "udp/4500-4500/0.0.0.0/0", Parent: "sg-subctl"},
+			))
+		})
+
+		It("Should fail when no instance has the cluster tag", func() {
+			client, err := NewEC2Client(server.URL, "us-east-1", Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			cloud = NewCloud(client, "unknown")
+			_, err = cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).To(MatchError(ContainSubstring("no instances tagged")))
+		})
+	})
+
+	When("Preparing the gateways", func() {
+		It("Should launch the missing gateways from the worker template", func() {
+			gateways, resources, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gateways).To(HaveLen(2))
+			Expect(resources).To(ConsistOf(
+				cloudprepare.Resource{Kind: cloudprepare.ResourceSecurityGroup, ID: "sg-1", Parent: "vpc-1"},
+				cloudprepare.Resource{Kind: cloudprepare.ResourceInstance, ID: gateways[0].ID},
+				cloudprepare.Resource{Kind: cloudprepare.ResourceInstance, ID: gateways[1].ID},
+			))
+			Expect(gateways[0].Running).To(BeFalse())
+			Expect(gateways[0].ProviderID).To(Equal("aws:///us-east-1a/" + gateways[0].ID))
+
+			launched := ec2.instances[3]
+			Expect(launched.ImageID).To(Equal("ami-1"))
+			Expect(launched.InstanceType).To(Equal(DefaultGatewayInstanceType))
+			Expect(launched.SubnetID).To(Equal("subnet-1"))
+			Expect(launched.IAMInstanceProfileARN).To(Equal("arn:aws:iam::1:instance-profile/worker"))
+			Expect(launched.SecurityGroupIDs).To(ConsistOf("sg-1", "sg-worker"))
+			Expect(launched.SourceDestCheck).To(BeFalse())
+			Expect(ec2.userData[launched.ID]).To(Equal("aWduaXRpb24="))
+			Expect(launched.Tags).To(ContainElements(
+				Tag{Key: cloudprepare.GatewayTag, Value: "true"},
+				Tag{Key: clusterTagPrefix + testInfraID, Value: "owned"},
+				Tag{Key: cloudprepare.ManagedByTag, Value: cloudprepare.ManagedByValue},
+			))
+			Expect(ec2.groups[2].Tags).To(ContainElement(Tag{Key: cloudprepare.ManagedByTag, Value: cloudprepare.ManagedByValue}))
+		})
+
+		It("Should keep the existing gateways", func() {
+			_, _, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1, InstanceType: "c5.large"})
+			Expect(err).NotTo(HaveOccurred())
+			ec2.instances[3].State = "running"
+
+			gateways, resources, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1, InstanceType: "c5.large"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(Equal([]cloudprepare.Resource{{Kind: cloudprepare.ResourceInstance, ID: ec2.instances[3].ID}}))
+			Expect(gateways).To(HaveLen(1))
+			Expect(gateways[0].Running).To(BeTrue())
+			Expect(ec2.instances).To(HaveLen(4))
+			Expect(countAction(ec2.actions(), "RunInstances")).To(Equal(1))
+		})
+
+		It("Should not record the gateways launched by someone else", func() {
+			ec2.instances = append(ec2.instances, &Instance{ID: "i-subctl", VpcID: "vpc-1", State: "running",
+				Tags: []Tag{{Key: clusterTagPrefix + testInfraID, Value: "owned"}, {Key: cloudprepare.GatewayTag, Value: "true"},
+					{Key: nameTag, Value: testInfraID + gatewayNameSuffix}}})
+			gateways, resources, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gateways).To(HaveLen(1))
+			Expect(gateways[0].ID).To(Equal("i-subctl"))
+			Expect(resources).To(BeEmpty())
+		})
+	})
+
+	When("Removing the resources", func() {
+		It("Should revoke the rules and delete the security group once the gateways are terminated", func() {
+			opened, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			_, launched, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1})
+			Expect(err).NotTo(HaveOccurred())
+			// The gateway security group is returned again with the launched instance
+			Expect(launched[0]).To(Equal(opened[0]))
+			resources := append(opened, launched[1:]...)
+
+			left, err := cloud.RemoveResources(resources)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(left).To(ConsistOf(opened[0]))
+			Expect(ec2.instances[3].State).To(Equal("shutting-down"))
+			for _, group := range ec2.groups {
+				Expect(group.Ingress).To(BeEmpty())
+			}
+
+			ec2.instances[3].State = "terminated"
+			left, err = cloud.RemoveResources(left)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(left).To(BeEmpty())
+			Expect(ec2.groups).To(HaveLen(2))
+		})
+
+		It("Should skip the resources which are already gone", func() {
+			left, err := cloud.RemoveResources([]cloudprepare.Resource{
+				{Kind: cloudprepare.ResourceInstance, ID: "i-gone"},
+				{Kind: cloudprepare.ResourceSecurityGroupRule, ID: "udp/4800-4800/sg-master", Parent: "sg-worker"},
+				{Kind: cloudprepare.ResourceSecurityGroup, ID: "sg-gone"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(left).To(BeEmpty())
+		})
+
+		It("Should parse the permission keys", func() {
+			for key, expected := range map[string]IPPermission{
+				"udp/4500-4500/0.0.0.0/0": {Protocol: "udp", FromPort: 4500, ToPort: 4500, CIDRs: []string{"0.0.0.0/0"}},
+				"50/0.0.0.0/0":            {Protocol: "50", CIDRs: []string{"0.0.0.0/0"}},
+				"udp/4800-4800/sg-x":      {Protocol: "udp", FromPort: 4800, ToPort: 4800, GroupIDs: []string{"sg-x"}},
+			} {
+				permission, ok := parsePermissionKey(key)
+				Expect(ok).To(BeTrue())
+				Expect(permission).To(Equal(expected))
+			}
+		})
+	})
+
+	When("Calling the API", func() {
+		It("Should return the EC2 error code", func() {
+			client, err := NewEC2Client(server.URL, "us-east-1", Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			err = client.AuthorizeSecurityGroupIngress("sg-missing", []IPPermission{{Protocol: "udp", FromPort: 1, ToPort: 1}})
+			Expect(IsErrorCode(err, "InvalidGroup.NotFound")).To(BeTrue())
+		})
+
+		It("Should page through the instances", func() {
+			ec2.pageSize = 1
+			client, err := NewEC2Client(server.URL, "us-east-1", Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			instances, err := client.DescribeInstances(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(3))
+			Expect(instances[1].IAMInstanceProfileARN).To(Equal("arn:aws:iam::1:instance-profile/worker"))
+			Expect(instances[1].Tags).To(ContainElement(Tag{Key: nameTag, Value: testInfraID + "-worker-a"}))
+		})
+	})
+})
+
+func countAction(actions []string, action string) int {
+	count := 0
+	for _, a := range actions {
+		if a == action {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,393 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awscredentials "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Filter is a filter of an EC2 describe call
+type Filter struct {
+	Name   string
+	Values []string
+}
+
+// Tag is an EC2 resource tag
+type Tag struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+// IPPermission is an ingress rule of a security group, the ports are ignored for the protocols
+// other than tcp and udp
+type IPPermission struct {
+	Protocol string
+	FromPort int
+	ToPort   int
+	CIDRs    []string
+	GroupIDs []string
+}
+
+// SecurityGroup is an EC2 security group
+type SecurityGroup struct {
+	ID      string
+	Name    string
+	VpcID   string
+	Ingress []IPPermission
+	Tags    []Tag
+}
+
+// TagValue returns the value of the tag and whether the security group has it
+func (g *SecurityGroup) TagValue(key string) (string, bool) {
+	return tagValue(g.Tags, key)
+}
+
+// Instance is an EC2 instance
+type Instance struct {
+	ID                    string
+	ImageID               string
+	InstanceType          string
+	SubnetID              string
+	VpcID                 string
+	AvailabilityZone      string
+	State                 string
+	IAMInstanceProfileARN string
+	SecurityGroupIDs      []string
+	SourceDestCheck       bool
+	Tags                  []Tag
+}
+
+// TagValue returns the value of the tag and whether the instance has it
+func (i *Instance) TagValue(key string) (string, bool) {
+	return tagValue(i.Tags, key)
+}
+
+func tagValue(tags []Tag, key string) (string, bool) {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// RunInstancesInput describes the instances to launch
+type RunInstancesInput struct {
+	ImageID               string
+	InstanceType          string
+	SubnetID              string
+	IAMInstanceProfileARN string
+	UserData              string
+	SecurityGroupIDs      []string
+	Count                 int
+	Tags                  []Tag
+}
+
+// EC2API is the part of the EC2 API the cloud preparation uses
+type EC2API interface {
+	DescribeInstances(filters []Filter) ([]Instance, error)
+	DescribeInstanceUserData(instanceID string) (string, error)
+	RunInstances(input *RunInstancesInput) ([]Instance, error)
+	DisableSourceDestCheck(instanceID string) error
+	DescribeSecurityGroups(filters []Filter) ([]SecurityGroup, error)
+	CreateSecurityGroup(vpcID, name, description string, tags []Tag) (string, error)
+	AuthorizeSecurityGroupIngress(groupID string, permissions []IPPermission) error
+	RevokeSecurityGroupIngress(groupID string, permissions []IPPermission) error
+	DeleteSecurityGroup(groupID string) error
+	TerminateInstance(instanceID string) error
+}
+
+// Credentials are the AWS credentials of an IAM user or of a temporary session
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// APIError is an error returned by the EC2 API
+type APIError struct {
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// IsErrorCode reports whether err is an EC2 API error with the code
+func IsErrorCode(err error, code string) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// toAPIError returns the error of the SDK as an APIError when the service returned it
+func toAPIError(err error) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return &APIError{Code: awsErr.Code(), Message: awsErr.Message()}
+	}
+	return err
+}
+
+// ec2Client calls the EC2 API with the AWS SDK
+type ec2Client struct {
+	ec2 *ec2.EC2
+}
+
+var _ EC2API = &ec2Client{}
+
+// NewEC2Client returns a client of the EC2 API of the region, the endpoint of the region is used when
+// endpoint is empty
+func NewEC2Client(endpoint, region string, credentials Credentials) (EC2API, error) {
+	config := aws.NewConfig().
+		WithRegion(region).
+		WithCredentials(awscredentials.NewStaticCredentials(credentials.AccessKeyID, credentials.SecretAccessKey,
+			credentials.SessionToken))
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("create the AWS session: %v", err)
+	}
+	return &ec2Client{ec2: ec2.New(sess)}, nil
+}
+
+func fromEC2Instance(instance *ec2.Instance) Instance {
+	result := Instance{
+		ID:              aws.StringValue(instance.InstanceId),
+		ImageID:         aws.StringValue(instance.ImageId),
+		InstanceType:    aws.StringValue(instance.InstanceType),
+		SubnetID:        aws.StringValue(instance.SubnetId),
+		VpcID:           aws.StringValue(instance.VpcId),
+		SourceDestCheck: aws.BoolValue(instance.SourceDestCheck),
+	}
+	if instance.Placement != nil {
+		result.AvailabilityZone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+	if instance.State != nil {
+		result.State = aws.StringValue(instance.State.Name)
+	}
+	if instance.IamInstanceProfile != nil {
+		result.IAMInstanceProfileARN = aws.StringValue(instance.IamInstanceProfile.Arn)
+	}
+	for _, group := range instance.SecurityGroups {
+		result.SecurityGroupIDs = append(result.SecurityGroupIDs, aws.StringValue(group.GroupId))
+	}
+	for _, tag := range instance.Tags {
+		result.Tags = append(result.Tags, Tag{Key: aws.StringValue(tag.Key), Value: aws.StringValue(tag.Value)})
+	}
+	return result
+}
+
+func (c *ec2Client) DescribeInstances(filters []Filter) ([]Instance, error) {
+	var instances []Instance
+	err := c.ec2.DescribeInstancesPages(&ec2.DescribeInstancesInput{Filters: toEC2Filters(filters)},
+		func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					instances = append(instances, fromEC2Instance(instance))
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	return instances, nil
+}
+
+func (c *ec2Client) DescribeInstanceUserData(instanceID string) (string, error) {
+	output, err := c.ec2.DescribeInstanceAttribute(&ec2.DescribeInstanceAttributeInput{
+		InstanceId: aws.String(instanceID),
+		Attribute:  aws.String(ec2.InstanceAttributeNameUserData),
+	})
+	if err != nil {
+		return "", toAPIError(err)
+	}
+	if output.UserData == nil {
+		return "", nil
+	}
+	return aws.StringValue(output.UserData.Value), nil
+}
+
+func (c *ec2Client) RunInstances(input *RunInstancesInput) ([]Instance, error) {
+	runInput := &ec2.RunInstancesInput{
+		ImageId:           aws.String(input.ImageID),
+		InstanceType:      aws.String(input.InstanceType),
+		MinCount:          aws.Int64(int64(input.Count)),
+		MaxCount:          aws.Int64(int64(input.Count)),
+		SubnetId:          aws.String(input.SubnetID),
+		SecurityGroupIds:  aws.StringSlice(input.SecurityGroupIDs),
+		TagSpecifications: toTagSpecifications(ec2.ResourceTypeInstance, input.Tags),
+	}
+	if input.IAMInstanceProfileARN != "" {
+		runInput.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{Arn: aws.String(input.IAMInstanceProfileARN)}
+	}
+	if input.UserData != "" {
+		runInput.UserData = aws.String(input.UserData)
+	}
+	output, err := c.ec2.RunInstances(runInput)
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	instances := make([]Instance, 0, len(output.Instances))
+	for _, instance := range output.Instances {
+		instances = append(instances, fromEC2Instance(instance))
+	}
+	return instances, nil
+}
+
+func (c *ec2Client) DisableSourceDestCheck(instanceID string) error {
+	_, err := c.ec2.ModifyInstanceAttribute(&ec2.ModifyInstanceAttributeInput{
+		InstanceId:      aws.String(instanceID),
+		SourceDestCheck: &ec2.AttributeBooleanValue{Value: aws.Bool(false)},
+	})
+	return toAPIError(err)
+}
+
+func (c *ec2Client) DescribeSecurityGroups(filters []Filter) ([]SecurityGroup, error) {
+	var groups []SecurityGroup
+	err := c.ec2.DescribeSecurityGroupsPages(&ec2.DescribeSecurityGroupsInput{Filters: toEC2Filters(filters)},
+		func(page *ec2.DescribeSecurityGroupsOutput, _ bool) bool {
+			for _, group := range page.SecurityGroups {
+				securityGroup := SecurityGroup{
+					ID:    aws.StringValue(group.GroupId),
+					Name:  aws.StringValue(group.GroupName),
+					VpcID: aws.StringValue(group.VpcId),
+				}
+				for _, permission := range group.IpPermissions {
+					securityGroup.Ingress = append(securityGroup.Ingress, fromEC2Permission(permission))
+				}
+				for _, tag := range group.Tags {
+					securityGroup.Tags = append(securityGroup.Tags, Tag{Key: aws.StringValue(tag.Key), Value: aws.StringValue(tag.Value)})
+				}
+				groups = append(groups, securityGroup)
+			}
+			return true
+		})
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	return groups, nil
+}
+
+func (c *ec2Client) CreateSecurityGroup(vpcID, name, description string, tags []Tag) (string, error) {
+	output, err := c.ec2.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		VpcId:             aws.String(vpcID),
+		GroupName:         aws.String(name),
+		Description:       aws.String(description),
+		TagSpecifications: toTagSpecifications(ec2.ResourceTypeSecurityGroup, tags),
+	})
+	if err != nil {
+		return "", toAPIError(err)
+	}
+	return aws.StringValue(output.GroupId), nil
+}
+
+func (c *ec2Client) AuthorizeSecurityGroupIngress(groupID string, permissions []IPPermission) error {
+	_, err := c.ec2.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: toEC2Permissions(permissions),
+	})
+	return toAPIError(err)
+}
+
+func (c *ec2Client) RevokeSecurityGroupIngress(groupID string, permissions []IPPermission) error {
+	_, err := c.ec2.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: toEC2Permissions(permissions),
+	})
+	return toAPIError(err)
+}
+
+func (c *ec2Client) DeleteSecurityGroup(groupID string) error {
+	_, err := c.ec2.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(groupID)})
+	return toAPIError(err)
+}
+
+func (c *ec2Client) TerminateInstance(instanceID string) error {
+	_, err := c.ec2.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+	return toAPIError(err)
+}
+
+func hasPorts(protocol string) bool {
+	protocol = strings.ToLower(protocol)
+	return protocol == "tcp" || protocol == "udp"
+}
+
+func toEC2Permissions(permissions []IPPermission) []*ec2.IpPermission {
+	ec2Permissions := make([]*ec2.IpPermission, 0, len(permissions))
+	for _, permission := range permissions {
+		ec2Permission := &ec2.IpPermission{IpProtocol: aws.String(permission.Protocol)}
+		if hasPorts(permission.Protocol) {
+			ec2Permission.FromPort = aws.Int64(int64(permission.FromPort))
+			ec2Permission.ToPort = aws.Int64(int64(permission.ToPort))
+		}
+		for _, cidr := range permission.CIDRs {
+			ec2Permission.IpRanges = append(ec2Permission.IpRanges, &ec2.IpRange{CidrIp: aws.String(cidr)})
+		}
+		for _, sourceGroupID := range permission.GroupIDs {
+			ec2Permission.UserIdGroupPairs = append(ec2Permission.UserIdGroupPairs,
+				&ec2.UserIdGroupPair{GroupId: aws.String(sourceGroupID)})
+		}
+		ec2Permissions = append(ec2Permissions, ec2Permission)
+	}
+	return ec2Permissions
+}
+
+func fromEC2Permission(ec2Permission *ec2.IpPermission) IPPermission {
+	permission := IPPermission{
+		Protocol: aws.StringValue(ec2Permission.IpProtocol),
+		FromPort: int(aws.Int64Value(ec2Permission.FromPort)),
+		ToPort:   int(aws.Int64Value(ec2Permission.ToPort)),
+	}
+	for _, ipRange := range ec2Permission.IpRanges {
+		permission.CIDRs = append(permission.CIDRs, aws.StringValue(ipRange.CidrIp))
+	}
+	for _, pair := range ec2Permission.UserIdGroupPairs {
+		permission.GroupIDs = append(permission.GroupIDs, aws.StringValue(pair.GroupId))
+	}
+	return permission
+}
+
+func toEC2Filters(filters []Filter) []*ec2.Filter {
+	ec2Filters := make([]*ec2.Filter, 0, len(filters))
+	for _, filter := range filters {
+		ec2Filters = append(ec2Filters, &ec2.Filter{Name: aws.String(filter.Name), Values: aws.StringSlice(filter.Values)})
+	}
+	return ec2Filters
+}
+
+func toTagSpecifications(resourceType string, tags []Tag) []*ec2.TagSpecification {
+	if len(tags) == 0 {
+		return nil
+	}
+	specification := &ec2.TagSpecification{ResourceType: aws.String(resourceType)}
+	for _, tag := range tags {
+		specification.Tags = append(specification.Tags, &ec2.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
+	}
+	return []*ec2.TagSpecification{specification}
+}
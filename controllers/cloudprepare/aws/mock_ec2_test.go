@@ -0,0 +1,372 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mockEC2 is an in-memory EC2 query API serving the actions used by the cloud preparation
+type mockEC2 struct {
+	sync.Mutex
+	instances []*Instance
+	groups    []*SecurityGroup
+	userData  map[string]string
+	requests  []url.Values
+	nextID    int
+	// pageSize limits the instances of a DescribeInstances page when it is set
+	pageSize int
+}
+
+func newMockEC2() *mockEC2 {
+	return &mockEC2{userData: map[string]string{}}
+}
+
+func (m *mockEC2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		m.writeError(w, "AuthFailure", "request is not signed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		m.writeError(w, "MalformedQueryString", err.Error())
+		return
+	}
+	params := r.PostForm
+	m.requests = append(m.requests, params)
+	switch params.Get("Action") {
+	case "DescribeInstances":
+		m.describeInstances(w, params)
+	case "DescribeInstanceAttribute":
+		fmt.Fprintf(w, "<DescribeInstanceAttributeResponse><userData><value>%s</value></userData></DescribeInstanceAttributeResponse>",
+			m.userData[params.Get("InstanceId")])
+	case "RunInstances":
+		m.runInstances(w, params)
+	case "ModifyInstanceAttribute":
+		for _, instance := range m.instances {
+			if instance.ID == params.Get("InstanceId") {
+				instance.SourceDestCheck = params.Get("SourceDestCheck.Value") != "false"
+			}
+		}
+		fmt.Fprint(w, "<ModifyInstanceAttributeResponse><return>true</return></ModifyInstanceAttributeResponse>")
+	case "DescribeSecurityGroups":
+		m.describeSecurityGroups(w, params)
+	case "CreateSecurityGroup":
+		m.nextID++
+		group := &SecurityGroup{ID: fmt.Sprintf("sg-%d", m.nextID), Name: params.Get("GroupName"), VpcID: params.Get("VpcId"),
+			Tags: tagSpecification(params)}
+		m.groups = append(m.groups, group)
+		fmt.Fprintf(w, "<CreateSecurityGroupResponse><groupId>%s</groupId></CreateSecurityGroupResponse>", group.ID)
+	case "AuthorizeSecurityGroupIngress":
+		m.authorizeIngress(w, params)
+	case "RevokeSecurityGroupIngress":
+		m.revokeIngress(w, params)
+	case "DeleteSecurityGroup":
+		m.deleteSecurityGroup(w, params)
+	case "TerminateInstances":
+		m.terminateInstances(w, params)
+	default:
+		m.writeError(w, "InvalidAction", params.Get("Action"))
+	}
+}
+
+func (m *mockEC2) writeError(w http.ResponseWriter, code, message string) {
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, "<Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors></Response>", code, message)
+}
+
+// xmlInstance is an instance in the EC2 query API responses
+type xmlInstance struct {
+	ID                    string   `xml:"instanceId"`
+	ImageID               string   `xml:"imageId"`
+	InstanceType          string   `xml:"instanceType"`
+	SubnetID              string   `xml:"subnetId"`
+	VpcID                 string   `xml:"vpcId"`
+	AvailabilityZone      string   `xml:"placement>availabilityZone"`
+	State                 string   `xml:"instanceState>name"`
+	IAMInstanceProfileARN string   `xml:"iamInstanceProfile>arn"`
+	SecurityGroupIDs      []string `xml:"groupSet>item>groupId"`
+	SourceDestCheck       bool     `xml:"sourceDestCheck"`
+	Tags                  []Tag    `xml:"tagSet>item"`
+}
+
+// xmlIPPermission is an ingress rule in the EC2 query API responses
+type xmlIPPermission struct {
+	Protocol string   `xml:"ipProtocol"`
+	FromPort int      `xml:"fromPort"`
+	ToPort   int      `xml:"toPort"`
+	CIDRs    []string `xml:"ipRanges>item>cidrIp"`
+	GroupIDs []string `xml:"groups>item>groupId"`
+}
+
+// tagSpecification returns the tags of the first tag specification of the request
+func tagSpecification(params url.Values) []Tag {
+	var tags []Tag
+	for i := 1; params.Get(fmt.Sprintf("TagSpecification.1.Tag.%d.Key", i)) != ""; i++ {
+		tags = append(tags, Tag{
+			Key:   params.Get(fmt.Sprintf("TagSpecification.1.Tag.%d.Key", i)),
+			Value: params.Get(fmt.Sprintf("TagSpecification.1.Tag.%d.Value", i)),
+		})
+	}
+	return tags
+}
+
+// filterValues returns the values of the filter of the request
+func filterValues(params url.Values, name string) ([]string, bool) {
+	for i := 1; params.Get(fmt.Sprintf("Filter.%d.Name", i)) != ""; i++ {
+		if params.Get(fmt.Sprintf("Filter.%d.Name", i)) != name {
+			continue
+		}
+		var values []string
+		for j := 1; params.Get(fmt.Sprintf("Filter.%d.Value.%d", i, j)) != ""; j++ {
+			values = append(values, params.Get(fmt.Sprintf("Filter.%d.Value.%d", i, j)))
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockEC2) describeInstances(w http.ResponseWriter, params url.Values) {
+	var items []xmlInstance
+	for _, instance := range m.instances {
+		if keys, ok := filterValues(params, "tag-key"); ok {
+			if _, found := instance.TagValue(keys[0]); !found {
+				continue
+			}
+		}
+		if states, ok := filterValues(params, "instance-state-name"); ok && !contains(states, instance.State) {
+			continue
+		}
+		items = append(items, xmlInstance(*instance))
+	}
+	// Each instance is returned in a reservation of its own, as a real response may do
+	resp := struct {
+		XMLName      xml.Name `xml:"DescribeInstancesResponse"`
+		Reservations []struct {
+			Instances []xmlInstance `xml:"instancesSet>item"`
+		} `xml:"reservationSet>item"`
+		NextToken string `xml:"nextToken,omitempty"`
+	}{}
+	if m.pageSize > 0 {
+		start, _ := strconv.Atoi(params.Get("NextToken"))
+		end := start + m.pageSize
+		if end < len(items) {
+			resp.NextToken = strconv.Itoa(end)
+		} else {
+			end = len(items)
+		}
+		items = items[start:end]
+	}
+	for _, item := range items {
+		resp.Reservations = append(resp.Reservations, struct {
+			Instances []xmlInstance `xml:"instancesSet>item"`
+		}{Instances: []xmlInstance{item}})
+	}
+	_ = xml.NewEncoder(w).Encode(resp)
+}
+
+func (m *mockEC2) runInstances(w http.ResponseWriter, params url.Values) {
+	count, _ := strconv.Atoi(params.Get("MaxCount"))
+	var groupIDs []string
+	for i := 1; params.Get(fmt.Sprintf("SecurityGroupId.%d", i)) != ""; i++ {
+		groupIDs = append(groupIDs, params.Get(fmt.Sprintf("SecurityGroupId.%d", i)))
+	}
+	tags := tagSpecification(params)
+	resp := struct {
+		XMLName   xml.Name      `xml:"RunInstancesResponse"`
+		Instances []xmlInstance `xml:"instancesSet>item"`
+	}{}
+	for i := 0; i < count; i++ {
+		m.nextID++
+		instance := &Instance{
+			ID:                    fmt.Sprintf("i-%d", m.nextID),
+			ImageID:               params.Get("ImageId"),
+			InstanceType:          params.Get("InstanceType"),
+			SubnetID:              params.Get("SubnetId"),
+			IAMInstanceProfileARN: params.Get("IamInstanceProfile.Arn"),
+			VpcID:                 "vpc-1",
+			AvailabilityZone:      "us-east-1a",
+			State:                 "pending",
+			SecurityGroupIDs:      groupIDs,
+			SourceDestCheck:       true,
+			Tags:                  tags,
+		}
+		m.userData[instance.ID] = params.Get("UserData")
+		m.instances = append(m.instances, instance)
+		resp.Instances = append(resp.Instances, xmlInstance(*instance))
+	}
+	_ = xml.NewEncoder(w).Encode(resp)
+}
+
+func (m *mockEC2) describeSecurityGroups(w http.ResponseWriter, params url.Values) {
+	type xmlGroup struct {
+		ID      string            `xml:"groupId"`
+		Name    string            `xml:"groupName"`
+		VpcID   string            `xml:"vpcId"`
+		Ingress []xmlIPPermission `xml:"ipPermissions>item"`
+		Tags    []Tag             `xml:"tagSet>item"`
+	}
+	resp := struct {
+		XMLName xml.Name   `xml:"DescribeSecurityGroupsResponse"`
+		Groups  []xmlGroup `xml:"securityGroupInfo>item"`
+	}{}
+	for _, group := range m.groups {
+		if names, ok := filterValues(params, "group-name"); ok && !contains(names, group.Name) {
+			continue
+		}
+		if ids, ok := filterValues(params, "group-id"); ok && !contains(ids, group.ID) {
+			continue
+		}
+		if vpcs, ok := filterValues(params, "vpc-id"); ok && !contains(vpcs, group.VpcID) {
+			continue
+		}
+		item := xmlGroup{ID: group.ID, Name: group.Name, VpcID: group.VpcID, Tags: group.Tags}
+		for _, permission := range group.Ingress {
+			item.Ingress = append(item.Ingress, xmlIPPermission(permission))
+		}
+		resp.Groups = append(resp.Groups, item)
+	}
+	_ = xml.NewEncoder(w).Encode(resp)
+}
+
+func (m *mockEC2) group(id string) *SecurityGroup {
+	for _, group := range m.groups {
+		if group.ID == id {
+			return group
+		}
+	}
+	return nil
+}
+
+// permissions returns the permissions of the request
+func permissions(params url.Values) []IPPermission {
+	var permissions []IPPermission
+	for i := 1; params.Get(fmt.Sprintf("IpPermissions.%d.IpProtocol", i)) != ""; i++ {
+		prefix := fmt.Sprintf("IpPermissions.%d.", i)
+		permission := IPPermission{Protocol: params.Get(prefix + "IpProtocol")}
+		permission.FromPort, _ = strconv.Atoi(params.Get(prefix + "FromPort"))
+		permission.ToPort, _ = strconv.Atoi(params.Get(prefix + "ToPort"))
+		for j := 1; params.Get(fmt.Sprintf("%sIpRanges.%d.CidrIp", prefix, j)) != ""; j++ {
+			permission.CIDRs = append(permission.CIDRs, params.Get(fmt.Sprintf("%sIpRanges.%d.CidrIp", prefix, j)))
+		}
+		for j := 1; params.Get(fmt.Sprintf("%sGroups.%d.GroupId", prefix, j)) != ""; j++ {
+			permission.GroupIDs = append(permission.GroupIDs, params.Get(fmt.Sprintf("%sGroups.%d.GroupId", prefix, j)))
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions
+}
+
+func (m *mockEC2) authorizeIngress(w http.ResponseWriter, params url.Values) {
+	group := m.group(params.Get("GroupId"))
+	if group == nil {
+		m.writeError(w, "InvalidGroup.NotFound", params.Get("GroupId"))
+		return
+	}
+	for _, permission := range permissions(params) {
+		for _, existing := range group.Ingress {
+			if len(missingPermissions([]IPPermission{existing}, []IPPermission{permission})) == 0 {
+				m.writeError(w, "InvalidPermission.Duplicate", "the rule already exists")
+				return
+			}
+		}
+		group.Ingress = append(group.Ingress, permission)
+	}
+	fmt.Fprint(w, "<AuthorizeSecurityGroupIngressResponse><return>true</return></AuthorizeSecurityGroupIngressResponse>")
+}
+
+func (m *mockEC2) revokeIngress(w http.ResponseWriter, params url.Values) {
+	group := m.group(params.Get("GroupId"))
+	if group == nil {
+		m.writeError(w, "InvalidGroup.NotFound", params.Get("GroupId"))
+		return
+	}
+	for _, permission := range permissions(params) {
+		found := false
+		for i, existing := range group.Ingress {
+			if reflect.DeepEqual(existing, permission) {
+				group.Ingress = append(group.Ingress[:i], group.Ingress[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			m.writeError(w, "InvalidPermission.NotFound", "the rule does not exist")
+			return
+		}
+	}
+	fmt.Fprint(w, "<RevokeSecurityGroupIngressResponse><return>true</return></RevokeSecurityGroupIngressResponse>")
+}
+
+// deleteSecurityGroup deletes the group unless an instance which is not terminated uses it
+func (m *mockEC2) deleteSecurityGroup(w http.ResponseWriter, params url.Values) {
+	groupID := params.Get("GroupId")
+	for _, instance := range m.instances {
+		if instance.State != "terminated" && contains(instance.SecurityGroupIDs, groupID) {
+			m.writeError(w, "DependencyViolation", "the group is in use")
+			return
+		}
+	}
+	for i, group := range m.groups {
+		if group.ID == groupID {
+			m.groups = append(m.groups[:i], m.groups[i+1:]...)
+			fmt.Fprint(w, "<DeleteSecurityGroupResponse><return>true</return></DeleteSecurityGroupResponse>")
+			return
+		}
+	}
+	m.writeError(w, "InvalidGroup.NotFound", groupID)
+}
+
+// terminateInstances moves the instances to shutting-down, the tests terminate them
+func (m *mockEC2) terminateInstances(w http.ResponseWriter, params url.Values) {
+	for _, instance := range m.instances {
+		if instance.ID == params.Get("InstanceId.1") {
+			instance.State = "shutting-down"
+			fmt.Fprint(w, "<TerminateInstancesResponse></TerminateInstancesResponse>")
+			return
+		}
+	}
+	m.writeError(w, "InvalidInstanceID.NotFound", params.Get("InstanceId.1"))
+}
+
+// actions returns the actions of the requests received so far
+func (m *mockEC2) actions() []string {
+	m.Lock()
+	defer m.Unlock()
+	var actions []string
+	for _, params := range m.requests {
+		actions = append(actions, params.Get("Action"))
+	}
+	return actions
+}
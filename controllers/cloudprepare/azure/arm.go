@@ -0,0 +1,379 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-12-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Reference is a reference to another resource
+type Reference struct {
+	ID string
+}
+
+// VirtualMachine is a virtual machine
+type VirtualMachine struct {
+	ID         string
+	Name       string
+	Tags       map[string]string
+	Properties struct {
+		ProvisioningState string
+		NetworkProfile    struct {
+			NetworkInterfaces []Reference
+		}
+	}
+}
+
+// NetworkInterface is a network interface of a virtual machine, it and its subnet may have a network security group
+type NetworkInterface struct {
+	ID         string
+	Properties struct {
+		NetworkSecurityGroup *Reference
+		IPConfigurations     []IPConfiguration
+	}
+}
+
+// IPConfiguration is an IP configuration of a network interface
+type IPConfiguration struct {
+	Properties struct {
+		Subnet *Reference
+	}
+}
+
+// Subnet is a subnet of a virtual network
+type Subnet struct {
+	ID         string
+	Properties struct {
+		NetworkSecurityGroup *Reference
+	}
+}
+
+// SecurityRuleProperties are the properties of a security rule
+type SecurityRuleProperties struct {
+	Description              string
+	Protocol                 string
+	SourcePortRange          string
+	DestinationPortRange     string
+	SourceAddressPrefix      string
+	DestinationAddressPrefix string
+	Access                   string
+	Priority                 int
+	Direction                string
+}
+
+// SecurityRule is a rule of a network security group
+type SecurityRule struct {
+	Name       string
+	Properties SecurityRuleProperties
+}
+
+// SecurityGroup is a network security group
+type SecurityGroup struct {
+	ID         string
+	Name       string
+	Properties struct {
+		SecurityRules []SecurityRule
+	}
+}
+
+// ResourceManagerAPI is the part of the Azure Resource Manager API the cloud preparation uses, the
+// resources are addressed by their IDs
+type ResourceManagerAPI interface {
+	GetVirtualMachine(id string) (*VirtualMachine, error)
+	UpdateVirtualMachineTags(id string, tags map[string]string) error
+	GetNetworkInterface(id string) (*NetworkInterface, error)
+	GetSubnet(id string) (*Subnet, error)
+	GetSecurityGroup(id string) (*SecurityGroup, error)
+	CreateSecurityRule(groupID string, rule *SecurityRule) error
+	DeleteSecurityRule(groupID, name string) error
+}
+
+// APIError is an error returned by the Azure Resource Manager API
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a Resource Manager API error for a missing resource
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// toAPIError returns the error of the SDK as an APIError when the service responded
+func toAPIError(err error) error {
+	var detailed autorest.DetailedError
+	if !errors.As(err, &detailed) {
+		return err
+	}
+	statusCode, ok := detailed.StatusCode.(int)
+	if !ok || statusCode == autorest.UndefinedStatusCode {
+		return err
+	}
+	apiErr := &APIError{StatusCode: statusCode, Code: fmt.Sprint(statusCode), Message: detailed.Error()}
+	var requestErr *azure.RequestError
+	if errors.As(detailed.Original, &requestErr) && requestErr.ServiceError != nil {
+		apiErr.Code = requestErr.ServiceError.Code
+		apiErr.Message = requestErr.ServiceError.Message
+	}
+	return apiErr
+}
+
+// resourceID is a parsed resource ID, /subscriptions/<subscription>/resourceGroups/<group>/providers/
+// <namespace>/<type>/<name>[/<child type>/<child name>]
+type resourceID struct {
+	subscription string
+	group        string
+	names        []string
+}
+
+func parseResourceID(id string) (*resourceID, error) {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	if len(parts) < 8 || len(parts)%2 != 0 || !strings.EqualFold(parts[0], "subscriptions") ||
+		!strings.EqualFold(parts[2], "resourceGroups") || !strings.EqualFold(parts[4], "providers") {
+		return nil, fmt.Errorf("invalid resource ID %q", id)
+	}
+	parsed := &resourceID{subscription: parts[1], group: parts[3]}
+	for i := 7; i < len(parts); i += 2 {
+		parsed.names = append(parsed.names, parts[i])
+	}
+	return parsed, nil
+}
+
+// resourceManagerClient calls the Azure Resource Manager API with the Azure SDK
+type resourceManagerClient struct {
+	baseURI    string
+	authorizer autorest.Authorizer
+}
+
+var _ ResourceManagerAPI = &resourceManagerClient{}
+
+// NewResourceManagerClient returns a client of the Resource Manager API at baseURI, the requests are
+// authorized with authorizer
+func NewResourceManagerClient(baseURI string, authorizer autorest.Authorizer) ResourceManagerAPI {
+	return &resourceManagerClient{baseURI: baseURI, authorizer: authorizer}
+}
+
+// NewResourceManagerClientFromSecret returns a client of the Resource Manager API of the cloud environment,
+// the requests are authorized with the client secret of a service principal of the tenant. The environment
+// is a name like AzureChinaCloud, the public cloud is used when it is empty.
+func NewResourceManagerClientFromSecret(environmentName, tenantID, clientID, clientSecret string) (ResourceManagerAPI, error) {
+	environment := azure.PublicCloud
+	if environmentName != "" {
+		var err error
+		if environment, err = azure.EnvironmentFromName(environmentName); err != nil {
+			return nil, err
+		}
+	}
+	config := auth.NewClientCredentialsConfig(clientID, clientSecret, tenantID)
+	config.AADEndpoint = environment.ActiveDirectoryEndpoint
+	config.Resource = environment.ResourceManagerEndpoint
+	authorizer, err := config.Authorizer()
+	if err != nil {
+		return nil, err
+	}
+	return NewResourceManagerClient(environment.ResourceManagerEndpoint, authorizer), nil
+}
+
+func (c *resourceManagerClient) prepare(client *autorest.Client) (context.Context, context.CancelFunc) {
+	client.Authorizer = c.authorizer
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
+
+func (c *resourceManagerClient) GetVirtualMachine(id string) (*VirtualMachine, error) {
+	parsed, err := parseResourceID(id)
+	if err != nil {
+		return nil, err
+	}
+	client := compute.NewVirtualMachinesClientWithBaseURI(c.baseURI, parsed.subscription)
+	ctx, cancel := c.prepare(&client.Client)
+	defer cancel()
+	result, err := client.Get(ctx, parsed.group, parsed.names[0], "")
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	vm := &VirtualMachine{ID: to.String(result.ID), Name: to.String(result.Name), Tags: to.StringMap(result.Tags)}
+	if properties := result.VirtualMachineProperties; properties != nil {
+		vm.Properties.ProvisioningState = to.String(properties.ProvisioningState)
+		if properties.NetworkProfile != nil && properties.NetworkProfile.NetworkInterfaces != nil {
+			for _, nic := range *properties.NetworkProfile.NetworkInterfaces {
+				vm.Properties.NetworkProfile.NetworkInterfaces = append(vm.Properties.NetworkProfile.NetworkInterfaces,
+					Reference{ID: to.String(nic.ID)})
+			}
+		}
+	}
+	return vm, nil
+}
+
+func (c *resourceManagerClient) UpdateVirtualMachineTags(id string, tags map[string]string) error {
+	parsed, err := parseResourceID(id)
+	if err != nil {
+		return err
+	}
+	client := compute.NewVirtualMachinesClientWithBaseURI(c.baseURI, parsed.subscription)
+	ctx, cancel := c.prepare(&client.Client)
+	defer cancel()
+	_, err = client.Update(ctx, parsed.group, parsed.names[0], compute.VirtualMachineUpdate{Tags: *to.StringMapPtr(tags)})
+	return toAPIError(err)
+}
+
+func (c *resourceManagerClient) GetNetworkInterface(id string) (*NetworkInterface, error) {
+	parsed, err := parseResourceID(id)
+	if err != nil {
+		return nil, err
+	}
+	client := network.NewInterfacesClientWithBaseURI(c.baseURI, parsed.subscription)
+	ctx, cancel := c.prepare(&client.Client)
+	defer cancel()
+	result, err := client.Get(ctx, parsed.group, parsed.names[0], "")
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	nic := &NetworkInterface{ID: to.String(result.ID)}
+	if properties := result.InterfacePropertiesFormat; properties != nil {
+		nic.Properties.NetworkSecurityGroup = securityGroupReference(properties.NetworkSecurityGroup)
+		if properties.IPConfigurations != nil {
+			for _, ipConfig := range *properties.IPConfigurations {
+				ipConfiguration := IPConfiguration{}
+				if ipConfig.InterfaceIPConfigurationPropertiesFormat != nil && ipConfig.Subnet != nil {
+					ipConfiguration.Properties.Subnet = &Reference{ID: to.String(ipConfig.Subnet.ID)}
+				}
+				nic.Properties.IPConfigurations = append(nic.Properties.IPConfigurations, ipConfiguration)
+			}
+		}
+	}
+	return nic, nil
+}
+
+func (c *resourceManagerClient) GetSubnet(id string) (*Subnet, error) {
+	parsed, err := parseResourceID(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed.names) != 2 {
+		return nil, fmt.Errorf("invalid subnet ID %q", id)
+	}
+	client := network.NewSubnetsClientWithBaseURI(c.baseURI, parsed.subscription)
+	ctx, cancel := c.prepare(&client.Client)
+	defer cancel()
+	result, err := client.Get(ctx, parsed.group, parsed.names[0], parsed.names[1], "")
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	subnet := &Subnet{ID: to.String(result.ID)}
+	if result.SubnetPropertiesFormat != nil {
+		subnet.Properties.NetworkSecurityGroup = securityGroupReference(result.NetworkSecurityGroup)
+	}
+	return subnet, nil
+}
+
+func securityGroupReference(group *network.SecurityGroup) *Reference {
+	if group == nil {
+		return nil
+	}
+	return &Reference{ID: to.String(group.ID)}
+}
+
+func (c *resourceManagerClient) GetSecurityGroup(id string) (*SecurityGroup, error) {
+	parsed, err := parseResourceID(id)
+	if err != nil {
+		return nil, err
+	}
+	client := network.NewSecurityGroupsClientWithBaseURI(c.baseURI, parsed.subscription)
+	ctx, cancel := c.prepare(&client.Client)
+	defer cancel()
+	result, err := client.Get(ctx, parsed.group, parsed.names[0], "")
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	group := &SecurityGroup{ID: to.String(result.ID), Name: to.String(result.Name)}
+	if result.SecurityGroupPropertiesFormat != nil && result.SecurityRules != nil {
+		for _, rule := range *result.SecurityRules {
+			securityRule := SecurityRule{Name: to.String(rule.Name)}
+			if properties := rule.SecurityRulePropertiesFormat; properties != nil {
+				securityRule.Properties = SecurityRuleProperties{
+					Description:              to.String(properties.Description),
+					Protocol:                 string(properties.Protocol),
+					SourcePortRange:          to.String(properties.SourcePortRange),
+					DestinationPortRange:     to.String(properties.DestinationPortRange),
+					SourceAddressPrefix:      to.String(properties.SourceAddressPrefix),
+					DestinationAddressPrefix: to.String(properties.DestinationAddressPrefix),
+					Access:                   string(properties.Access),
+					Priority:                 int(to.Int32(properties.Priority)),
+					Direction:                string(properties.Direction),
+				}
+			}
+			group.Properties.SecurityRules = append(group.Properties.SecurityRules, securityRule)
+		}
+	}
+	return group, nil
+}
+
+func (c *resourceManagerClient) CreateSecurityRule(groupID string, rule *SecurityRule) error {
+	parsed, err := parseResourceID(groupID)
+	if err != nil {
+		return err
+	}
+	client := network.NewSecurityRulesClientWithBaseURI(c.baseURI, parsed.subscription)
+	ctx, cancel := c.prepare(&client.Client)
+	defer cancel()
+	_, err = client.CreateOrUpdate(ctx, parsed.group, parsed.names[0], rule.Name, network.SecurityRule{
+		SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+			Description:              to.StringPtr(rule.Properties.Description),
+			Protocol:                 network.SecurityRuleProtocol(rule.Properties.Protocol),
+			SourcePortRange:          to.StringPtr(rule.Properties.SourcePortRange),
+			DestinationPortRange:     to.StringPtr(rule.Properties.DestinationPortRange),
+			SourceAddressPrefix:      to.StringPtr(rule.Properties.SourceAddressPrefix),
+			DestinationAddressPrefix: to.StringPtr(rule.Properties.DestinationAddressPrefix),
+			Access:                   network.SecurityRuleAccess(rule.Properties.Access),
+			Priority:                 to.Int32Ptr(int32(rule.Properties.Priority)),
+			Direction:                network.SecurityRuleDirection(rule.Properties.Direction),
+		},
+	})
+	return toAPIError(err)
+}
+
+func (c *resourceManagerClient) DeleteSecurityRule(groupID, name string) error {
+	parsed, err := parseResourceID(groupID)
+	if err != nil {
+		return err
+	}
+	client := network.NewSecurityRulesClientWithBaseURI(c.baseURI, parsed.subscription)
+	ctx, cancel := c.prepare(&client.Client)
+	defer cancel()
+	_, err = client.Delete(ctx, parsed.group, parsed.names[0], name)
+	return toAPIError(err)
+}
@@ -0,0 +1,309 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package azure prepares the clusters running on Azure: security rules open the submariner ports in the
+// network security groups of the nodes and their subnets, and the virtual machines of the gateway nodes
+// are tagged. The gateways are chosen among the existing worker nodes.
+package azure
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare"
+)
+
+const (
+	// The keys of the credentials secret, they match the cloud credentials secrets of OpenShift
+	ClientIDKey     = "azure_client_id"
+	ClientSecretKey = "azure_client_secret"
+	TenantIDKey     = "azure_tenant_id"
+	// EnvironmentKey optionally names the Azure cloud, like AzureChinaCloud, the public cloud is the default
+	EnvironmentKey = "azure_environment"
+
+	// GatewayTag marks the virtual machines of the gateway nodes, the tag names can not contain a slash
+	GatewayTag = "submariner-io-gateway"
+
+	providerIDPrefix      = "azure://"
+	virtualMachinesPath   = "/providers/Microsoft.Compute/virtualMachines/"
+	provisioningSucceeded = "Succeeded"
+	ruleNamePrefix        = "submariner-"
+	wildcard              = "*"
+	virtualNetworkAddress = "VirtualNetwork"
+	minRulePriority       = 1000
+	maxRulePriority       = 4096
+	accessAllow           = "Allow"
+	directionInbound      = "Inbound"
+	ruleDescription       = "Submariner"
+)
+
+type azureCloud struct {
+	arm   ResourceManagerAPI
+	nodes []cloudprepare.Node
+}
+
+// NewCloud returns the cloud preparation of the cluster of the nodes
+func NewCloud(arm ResourceManagerAPI, nodes []cloudprepare.Node) cloudprepare.Cloud {
+	return &azureCloud{arm: arm, nodes: nodes}
+}
+
+// NewCloudFromCredentials returns the cloud preparation of the cluster of the nodes, the Resource Manager
+// API is accessed with the service principal of the credentials secret
+func NewCloudFromCredentials(credentials map[string][]byte, nodes []cloudprepare.Node) (cloudprepare.Cloud, error) {
+	if err := cloudprepare.RequiredKeys(credentials, TenantIDKey, ClientIDKey, ClientSecretKey); err != nil {
+		return nil, err
+	}
+	arm, err := NewResourceManagerClientFromSecret(string(credentials[EnvironmentKey]), string(credentials[TenantIDKey]),
+		string(credentials[ClientIDKey]), string(credentials[ClientSecretKey]))
+	if err != nil {
+		return nil, err
+	}
+	return NewCloud(arm, nodes), nil
+}
+
+// VirtualMachineID returns the ID of the virtual machine of the provider ID of a node, which is
+// azure://<virtual machine ID>. The instances of scale sets are not supported.
+func VirtualMachineID(providerID string) (string, bool) {
+	if !strings.HasPrefix(providerID, providerIDPrefix) {
+		return "", false
+	}
+	id := strings.TrimPrefix(providerID, providerIDPrefix)
+	return id, strings.Contains(id, virtualMachinesPath) && !strings.Contains(id, "/virtualMachineScaleSets/")
+}
+
+func (a *azureCloud) Name() string {
+	return "Azure"
+}
+
+// OpenPorts opens the public ports to anywhere, and the other ports inside the virtual network, in the
+// network security groups of the network interfaces of the nodes and of their subnets
+func (a *azureCloud) OpenPorts(ports []cloudprepare.Port) ([]cloudprepare.Resource, error) {
+	groupIDs, err := a.securityGroupIDs()
+	if err != nil {
+		return nil, err
+	}
+	if len(groupIDs) == 0 {
+		return nil, fmt.Errorf("the nodes of the cluster have no network security group")
+	}
+
+	var resources []cloudprepare.Resource
+	for _, groupID := range groupIDs {
+		group, err := a.arm.GetSecurityGroup(groupID)
+		if err != nil {
+			klog.Errorf("Get network security group %s failed: %v", groupID, err)
+			return resources, err
+		}
+		existing := map[string]bool{}
+		usedPriorities := map[int]bool{}
+		for _, rule := range group.Properties.SecurityRules {
+			existing[rule.Name] = true
+			usedPriorities[rule.Properties.Priority] = true
+		}
+		priority := minRulePriority
+		for _, port := range ports {
+			rule := securityRule(port)
+			if existing[rule.Name] {
+				continue
+			}
+			for usedPriorities[priority] {
+				priority++
+			}
+			if priority > maxRulePriority {
+				return resources, fmt.Errorf("no free priority left in network security group %s", groupID)
+			}
+			rule.Properties.Priority = priority
+			usedPriorities[priority] = true
+			klog.Infof("Creating security rule %s in network security group %s", rule.Name, groupID)
+			if err := a.arm.CreateSecurityRule(groupID, rule); err != nil {
+				klog.Errorf("Create security rule %s in network security group %s failed: %v", rule.Name, groupID, err)
+				return resources, err
+			}
+			resources = append(resources, cloudprepare.Resource{
+				Kind:   cloudprepare.ResourceSecurityGroupRule,
+				ID:     rule.Name,
+				Parent: groupID,
+			})
+		}
+	}
+	return resources, nil
+}
+
+// securityGroupIDs returns the network security groups of the network interfaces of the nodes and of their subnets
+func (a *azureCloud) securityGroupIDs() ([]string, error) {
+	groupSet := map[string]bool{}
+	subnetSet := map[string]bool{}
+	for _, node := range a.nodes {
+		vmID, ok := VirtualMachineID(node.ProviderID)
+		if !ok {
+			continue
+		}
+		vm, err := a.arm.GetVirtualMachine(vmID)
+		if err != nil {
+			klog.Errorf("Get virtual machine of node %s failed: %v", node.Name, err)
+			return nil, err
+		}
+		for _, nicRef := range vm.Properties.NetworkProfile.NetworkInterfaces {
+			nic, err := a.arm.GetNetworkInterface(nicRef.ID)
+			if err != nil {
+				klog.Errorf("Get network interface %s failed: %v", nicRef.ID, err)
+				return nil, err
+			}
+			if nic.Properties.NetworkSecurityGroup != nil {
+				groupSet[nic.Properties.NetworkSecurityGroup.ID] = true
+			}
+			for _, ipConfig := range nic.Properties.IPConfigurations {
+				if ipConfig.Properties.Subnet != nil {
+					subnetSet[ipConfig.Properties.Subnet.ID] = true
+				}
+			}
+		}
+	}
+	for subnetID := range subnetSet {
+		subnet, err := a.arm.GetSubnet(subnetID)
+		if err != nil {
+			klog.Errorf("Get subnet %s failed: %v", subnetID, err)
+			return nil, err
+		}
+		if subnet.Properties.NetworkSecurityGroup != nil {
+			groupSet[subnet.Properties.NetworkSecurityGroup.ID] = true
+		}
+	}
+	groupIDs := make([]string, 0, len(groupSet))
+	for groupID := range groupSet {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+	return groupIDs, nil
+}
+
+// PrepareGateways chooses the gateway nodes among the worker nodes and tags their virtual machines
+func (a *azureCloud) PrepareGateways(spec cloudprepare.GatewaySpec) ([]cloudprepare.Gateway, []cloudprepare.Resource, error) {
+	candidates := cloudprepare.GatewayCandidates(a.nodes, func(node cloudprepare.Node) bool {
+		_, ok := VirtualMachineID(node.ProviderID)
+		return ok
+	})
+	if len(candidates) > spec.Count {
+		candidates = candidates[:spec.Count]
+	} else if len(candidates) < spec.Count {
+		klog.Warningf("Only %d worker nodes can be used as gateways, %d are requested", len(candidates), spec.Count)
+	}
+
+	var gateways []cloudprepare.Gateway
+	var resources []cloudprepare.Resource
+	for _, node := range candidates {
+		vmID, _ := VirtualMachineID(node.ProviderID)
+		vm, err := a.arm.GetVirtualMachine(vmID)
+		if err != nil {
+			klog.Errorf("Get virtual machine of node %s failed: %v", node.Name, err)
+			return nil, resources, err
+		}
+		if vm.Tags[GatewayTag] != "true" {
+			tags := map[string]string{GatewayTag: "true"}
+			for key, value := range vm.Tags {
+				if key != GatewayTag {
+					tags[key] = value
+				}
+			}
+			klog.Infof("Tagging virtual machine %s with %s", vm.Name, GatewayTag)
+			if err := a.arm.UpdateVirtualMachineTags(vmID, tags); err != nil {
+				klog.Errorf("Tag virtual machine %s failed: %v", vm.Name, err)
+				return nil, resources, err
+			}
+			resources = append(resources, cloudprepare.Resource{Kind: cloudprepare.ResourceInstanceTag, ID: GatewayTag, Parent: vmID})
+		}
+		gateways = append(gateways, cloudprepare.Gateway{
+			ID:         vm.Name,
+			ProviderID: node.ProviderID,
+			Running:    vm.Properties.ProvisioningState == provisioningSucceeded,
+		})
+	}
+	return gateways, resources, nil
+}
+
+// RemoveResources deletes the security rules and removes the gateway tag from the virtual machines
+func (a *azureCloud) RemoveResources(resources []cloudprepare.Resource) ([]cloudprepare.Resource, error) {
+	for i, resource := range resources {
+		var err error
+		switch resource.Kind {
+		case cloudprepare.ResourceSecurityGroupRule:
+			klog.Infof("Deleting security rule %s of %s", resource.ID, resource.Parent)
+			if err = a.arm.DeleteSecurityRule(resource.Parent, resource.ID); IsNotFound(err) {
+				err = nil
+			} else if err != nil {
+				klog.Errorf("Delete security rule %s of %s failed: %v", resource.ID, resource.Parent, err)
+			}
+		case cloudprepare.ResourceInstanceTag:
+			err = a.untagVirtualMachine(resource.Parent, resource.ID)
+		default:
+			klog.Warningf("Skipping unknown Azure resource %s %s", resource.Kind, resource.ID)
+		}
+		if err != nil {
+			return resources[i:], err
+		}
+	}
+	return nil, nil
+}
+
+func (a *azureCloud) untagVirtualMachine(vmID, tag string) error {
+	vm, err := a.arm.GetVirtualMachine(vmID)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		klog.Errorf("Get virtual machine %s failed: %v", vmID, err)
+		return err
+	}
+	if _, ok := vm.Tags[tag]; !ok {
+		return nil
+	}
+	tags := map[string]string{}
+	for key, value := range vm.Tags {
+		if key != tag {
+			tags[key] = value
+		}
+	}
+	klog.Infof("Removing tag %s from virtual machine %s", tag, vm.Name)
+	if err := a.arm.UpdateVirtualMachineTags(vmID, tags); err != nil {
+		klog.Errorf("Remove tag %s from virtual machine %s failed: %v", tag, vm.Name, err)
+		return err
+	}
+	return nil
+}
+
+// securityRule returns the inbound rule of the port, the public ports are opened to anywhere and the
+// other ports inside the virtual network
+func securityRule(port cloudprepare.Port) *SecurityRule {
+	rule := &SecurityRule{
+		Name: fmt.Sprintf("%s%s-%d-in", ruleNamePrefix, port.Protocol, port.Port),
+		Properties: SecurityRuleProperties{
+			Description:              ruleDescription,
+			Protocol:                 strings.Title(string(port.Protocol)),
+			SourcePortRange:          wildcard,
+			DestinationPortRange:     fmt.Sprintf("%d", port.Port),
+			SourceAddressPrefix:      virtualNetworkAddress,
+			DestinationAddressPrefix: wildcard,
+			Access:                   accessAllow,
+			Direction:                directionInbound,
+		},
+	}
+	if port.Public {
+		rule.Properties.SourceAddressPrefix = wildcard
+	}
+	return rule
+}
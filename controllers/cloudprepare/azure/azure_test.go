@@ -0,0 +1,183 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/Azure/go-autorest/autorest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare"
+)
+
+const (
+	testResourceGroup = "/subscriptions/sub/resourceGroups/cluster-d-rg"
+	testNodeNSG       = testResourceGroup + "/providers/Microsoft.Network/networkSecurityGroups/cluster-d-nsg"
+	testSubnetNSG     = testResourceGroup + "/providers/Microsoft.Network/networkSecurityGroups/cluster-d-subnet-nsg"
+	testSubnet        = testResourceGroup + "/providers/Microsoft.Network/virtualNetworks/cluster-d-vnet/subnets/worker"
+)
+
+func testVirtualMachineID(name string) string {
+	return testResourceGroup + virtualMachinesPath + name
+}
+
+var _ = Describe("Azure cloud preparation", func() {
+	var arm *fakeResourceManager
+	var cloud cloudprepare.Cloud
+	BeforeEach(func() {
+		arm = newFakeResourceManager()
+		var nodes []cloudprepare.Node
+		for _, name := range []string{"worker-a", "worker-b"} {
+			vm := &VirtualMachine{ID: testVirtualMachineID(name), Name: name, Tags: map[string]string{"owner": "knitnet"}}
+			vm.Properties.ProvisioningState = provisioningSucceeded
+			nicID := testResourceGroup + "/providers/Microsoft.Network/networkInterfaces/" + name + "-nic"
+			vm.Properties.NetworkProfile.NetworkInterfaces = []Reference{{ID: nicID}}
+			arm.vms[vm.ID] = vm
+
+			nic := &NetworkInterface{ID: nicID}
+			nic.Properties.NetworkSecurityGroup = &Reference{ID: testNodeNSG}
+			nic.Properties.IPConfigurations = make([]IPConfiguration, 1)
+			nic.Properties.IPConfigurations[0].Properties.Subnet = &Reference{ID: testSubnet}
+			arm.nics[nicID] = nic
+			nodes = append(nodes, cloudprepare.Node{Name: name, ProviderID: providerIDPrefix + vm.ID})
+		}
+		subnet := &Subnet{ID: testSubnet}
+		subnet.Properties.NetworkSecurityGroup = &Reference{ID: testSubnetNSG}
+		arm.subnets[testSubnet] = subnet
+
+		arm.groups[testNodeNSG] = &SecurityGroup{ID: testNodeNSG}
+		arm.groups[testNodeNSG].Properties.SecurityRules = []SecurityRule{
+			{Name: "api", Properties: SecurityRuleProperties{Priority: minRulePriority}},
+		}
+		arm.groups[testSubnetNSG] = &SecurityGroup{ID: testSubnetNSG}
+		cloud = NewCloud(arm, nodes)
+	})
+
+	When("Opening the ports", func() {
+		It("Should create the rules in the security groups of the nodes and the subnets", func() {
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(HaveLen(6))
+			Expect(resources).To(ContainElement(cloudprepare.Resource{
+				Kind: cloudprepare.ResourceSecurityGroupRule, ID: "submariner-udp-4500-in", Parent: testSubnetNSG}))
+
+			rules := arm.groups[testNodeNSG].Properties.SecurityRules
+			Expect(rules).To(HaveLen(4))
+			Expect(rules[1].Name).To(Equal("submariner-udp-500-in"))
+			Expect(rules[1].Properties.Priority).To(Equal(minRulePriority + 1))
+			Expect(rules[1].Properties.Protocol).To(Equal("Udp"))
+			Expect(rules[1].Properties.SourceAddressPrefix).To(Equal(wildcard))
+			Expect(rules[3].Name).To(Equal("submariner-udp-4800-in"))
+			Expect(rules[3].Properties.Priority).To(Equal(minRulePriority + 3))
+			Expect(rules[3].Properties.SourceAddressPrefix).To(Equal(virtualNetworkAddress))
+		})
+
+		It("Should not create the rules again", func() {
+			_, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(BeEmpty())
+			Expect(arm.count("CreateSecurityRule")).To(Equal(6))
+		})
+	})
+
+	When("Preparing the gateways", func() {
+		It("Should tag the virtual machines of the gateways and keep their tags", func() {
+			gateways, resources, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gateways).To(Equal([]cloudprepare.Gateway{
+				{ID: "worker-a", ProviderID: providerIDPrefix + testVirtualMachineID("worker-a"), Running: true},
+			}))
+			Expect(resources).To(Equal([]cloudprepare.Resource{
+				{Kind: cloudprepare.ResourceInstanceTag, ID: GatewayTag, Parent: testVirtualMachineID("worker-a")},
+			}))
+			Expect(arm.vms[testVirtualMachineID("worker-a")].Tags).To(Equal(map[string]string{"owner": "knitnet", GatewayTag: "true"}))
+
+			_, resources, err = cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(BeEmpty())
+			Expect(arm.count("UpdateVirtualMachineTags")).To(Equal(1))
+		})
+
+		It("Should skip the scale set instances", func() {
+			_, ok := VirtualMachineID("azure://" + testResourceGroup + "/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/0")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	When("Removing the resources", func() {
+		It("Should delete the security rules and untag the gateways", func() {
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			_, tagged, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1})
+			Expect(err).NotTo(HaveOccurred())
+			resources = append(resources, tagged...)
+
+			left, err := cloud.RemoveResources(resources)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(left).To(BeEmpty())
+			Expect(arm.groups[testNodeNSG].Properties.SecurityRules).To(HaveLen(1))
+			Expect(arm.groups[testSubnetNSG].Properties.SecurityRules).To(BeEmpty())
+			Expect(arm.vms[testVirtualMachineID("worker-a")].Tags).To(Equal(map[string]string{"owner": "knitnet"}))
+		})
+
+		It("Should skip the security groups which are gone", func() {
+			left, err := cloud.RemoveResources([]cloudprepare.Resource{
+				{Kind: cloudprepare.ResourceSecurityGroupRule, ID: "submariner-udp-4500-in", Parent: "gone"},
+				{Kind: cloudprepare.ResourceInstanceTag, ID: GatewayTag, Parent: "gone"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(left).To(BeEmpty())
+		})
+	})
+
+	When("Calling the API", func() {
+		It("Should address the resources by their IDs and return the not found errors", func() {
+			var paths []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.Method+" "+r.URL.Path)
+				switch r.URL.Path {
+				case testSubnet:
+					_, _ = w.Write([]byte(`{"id":"` + testSubnet + `","properties":{"networkSecurityGroup":{"id":"` + testSubnetNSG + `"}}}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"error":{"code":"NotFound","message":"not found"}}`))
+				}
+			}))
+			defer server.Close()
+			client := NewResourceManagerClient(server.URL, autorest.NullAuthorizer{})
+
+			subnet, err := client.GetSubnet(testSubnet)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subnet.Properties.NetworkSecurityGroup).To(Equal(&Reference{ID: testSubnetNSG}))
+			err = client.DeleteSecurityRule(testNodeNSG, "submariner-udp-4500-in")
+			Expect(IsNotFound(err)).To(BeTrue())
+			_, err = client.GetSecurityGroup(testNodeNSG)
+			Expect(IsNotFound(err)).To(BeTrue())
+			Expect(paths).To(ContainElement("DELETE " + testNodeNSG + "/securityRules/submariner-udp-4500-in"))
+		})
+
+		It("Should reject an unknown cloud environment", func() {
+			_, err := NewResourceManagerClientFromSecret("AzureMoonCloud", "tenant", "client", "secret")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,126 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"net/http"
+)
+
+// fakeResourceManager is an in-memory Resource Manager API
+type fakeResourceManager struct {
+	vms     map[string]*VirtualMachine
+	nics    map[string]*NetworkInterface
+	subnets map[string]*Subnet
+	groups  map[string]*SecurityGroup
+	calls   []string
+}
+
+var _ ResourceManagerAPI = &fakeResourceManager{}
+
+func newFakeResourceManager() *fakeResourceManager {
+	return &fakeResourceManager{
+		vms:     map[string]*VirtualMachine{},
+		nics:    map[string]*NetworkInterface{},
+		subnets: map[string]*Subnet{},
+		groups:  map[string]*SecurityGroup{},
+	}
+}
+
+func notFound(id string) error {
+	return &APIError{StatusCode: http.StatusNotFound, Code: "ResourceNotFound", Message: id}
+}
+
+func (f *fakeResourceManager) GetVirtualMachine(id string) (*VirtualMachine, error) {
+	vm, ok := f.vms[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	copied := *vm
+	return &copied, nil
+}
+
+func (f *fakeResourceManager) UpdateVirtualMachineTags(id string, tags map[string]string) error {
+	f.calls = append(f.calls, "UpdateVirtualMachineTags")
+	vm, ok := f.vms[id]
+	if !ok {
+		return notFound(id)
+	}
+	vm.Tags = tags
+	return nil
+}
+
+func (f *fakeResourceManager) GetNetworkInterface(id string) (*NetworkInterface, error) {
+	nic, ok := f.nics[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	return nic, nil
+}
+
+func (f *fakeResourceManager) GetSubnet(id string) (*Subnet, error) {
+	subnet, ok := f.subnets[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	return subnet, nil
+}
+
+func (f *fakeResourceManager) GetSecurityGroup(id string) (*SecurityGroup, error) {
+	group, ok := f.groups[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	copied := *group
+	copied.Properties.SecurityRules = append([]SecurityRule(nil), group.Properties.SecurityRules...)
+	return &copied, nil
+}
+
+func (f *fakeResourceManager) CreateSecurityRule(groupID string, rule *SecurityRule) error {
+	f.calls = append(f.calls, "CreateSecurityRule")
+	group, ok := f.groups[groupID]
+	if !ok {
+		return notFound(groupID)
+	}
+	group.Properties.SecurityRules = append(group.Properties.SecurityRules, *rule)
+	return nil
+}
+
+func (f *fakeResourceManager) DeleteSecurityRule(groupID, name string) error {
+	f.calls = append(f.calls, "DeleteSecurityRule")
+	group, ok := f.groups[groupID]
+	if !ok {
+		return notFound(groupID)
+	}
+	var kept []SecurityRule
+	for _, rule := range group.Properties.SecurityRules {
+		if rule.Name != name {
+			kept = append(kept, rule)
+		}
+	}
+	group.Properties.SecurityRules = kept
+	return nil
+}
+
+func (f *fakeResourceManager) count(call string) int {
+	count := 0
+	for _, c := range f.calls {
+		if c == call {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cloudprepare prepares the cloud infrastructure of a cluster for submariner: the ports
+// submariner uses are opened in the cloud network and dedicated gateway nodes are provisioned.
+package cloudprepare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultIKEPort and DefaultNATTPort are the IPsec ports used when the join config does not set them
+	DefaultIKEPort  = 500
+	DefaultNATTPort = 4500
+	// VXLANPort is the port of the VXLAN tunnel between the gateway and the other nodes of the cluster
+	VXLANPort = 4800
+
+	// GatewayTag is the cloud instance tag of the instances provisioned as submariner gateways. It is only
+	// used for the tags of the cloud instances, the gateway nodes are labeled with consts.SubmarinerGatewayLabel.
+	GatewayTag = "submariner.io/gateway"
+	// ManagedByTag marks the cloud resources created by the operator with ManagedByValue, the existing resources
+	// are only recorded as created by the operator when they carry it
+	ManagedByTag   = "app.kubernetes.io/managed-by"
+	ManagedByValue = "knitnet-operator"
+)
+
+// Protocol is the IP protocol of a port
+type Protocol string
+
+const (
+	ProtocolUDP Protocol = "udp"
+	ProtocolTCP Protocol = "tcp"
+)
+
+// Port is a port submariner needs to be reachable in the cloud network
+type Port struct {
+	Port     uint16
+	Protocol Protocol
+	// Public is set for the ports the gateways of the other clusters connect to, the other ports are
+	// only opened between the nodes of the cluster
+	Public bool
+}
+
+// GatewayPorts returns the ports submariner uses, with the IPsec ports of the join config
+func GatewayPorts(ikePort, nattPort int) []Port {
+	if ikePort == 0 {
+		ikePort = DefaultIKEPort
+	}
+	if nattPort == 0 {
+		nattPort = DefaultNATTPort
+	}
+	return []Port{
+		{Port: uint16(ikePort), Protocol: ProtocolUDP, Public: true},
+		{Port: uint16(nattPort), Protocol: ProtocolUDP, Public: true},
+		{Port: VXLANPort, Protocol: ProtocolUDP},
+	}
+}
+
+// GatewaySpec describes the gateway nodes to provision
+type GatewaySpec struct {
+	// InstanceType is the machine type of the gateway instances, the provider default is used when empty
+	InstanceType string
+	// Count is the number of gateway instances
+	Count int
+}
+
+// Gateway is a gateway instance provisioned in the cloud
+type Gateway struct {
+	// ID is the instance ID of the cloud provider
+	ID string
+	// ProviderID is the provider ID of the node the instance registers as, when it is known
+	ProviderID string
+	// Running is set once the instance is running
+	Running bool
+}
+
+// Node is a node of the cluster the gateways can be chosen from, by the providers which turn existing
+// nodes into gateways
+type Node struct {
+	Name string
+	// ProviderID is the provider ID of the node, it references the cloud instance
+	ProviderID string
+	// Gateway is set when the node is labeled as gateway already
+	Gateway bool
+	// ControlPlane is set for the master nodes, they are not chosen as gateways
+	ControlPlane bool
+}
+
+// The kinds of the cloud resources created by the preparation
+const (
+	ResourceSecurityGroup      = "SecurityGroup"
+	ResourceSecurityGroupRule  = "SecurityGroupRule"
+	ResourceInstance           = "Instance"
+	ResourceAddress            = "Address"
+	ResourceAddressAssociation = "AddressAssociation"
+	ResourceFirewallRule       = "FirewallRule"
+	ResourceInstanceTag        = "InstanceTag"
+)
+
+// GatewayCandidates returns the worker nodes accepted by the provider in the order they are chosen as
+// gateways, the nodes labeled as gateway first and then by name
+func GatewayCandidates(nodes []Node, accept func(Node) bool) []Node {
+	var candidates []Node
+	for _, node := range nodes {
+		if !node.ControlPlane && accept(node) {
+			candidates = append(candidates, node)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Gateway != candidates[j].Gateway {
+			return candidates[i].Gateway
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates
+}
+
+// Resource is a cloud resource created by the preparation, the resources are recorded so the
+// preparation can be rolled back. The format of the ID is up to the provider.
+type Resource struct {
+	Kind   string
+	ID     string
+	Parent string
+}
+
+// Cloud prepares the infrastructure of a cloud provider for submariner. The resources created are
+// returned on failure as well, so they are recorded in any case.
+type Cloud interface {
+	// Name returns the name of the cloud provider
+	Name() string
+	// OpenPorts opens the ports in the cloud network of the cluster, it must be idempotent
+	OpenPorts(ports []Port) ([]Resource, error)
+	// PrepareGateways provisions the missing gateway instances and returns all gateway instances
+	PrepareGateways(gateways GatewaySpec) ([]Gateway, []Resource, error)
+	// RemoveResources removes the resources created by the preparation, the resources already gone are
+	// skipped. It returns the resources left, which can not be removed yet, e.g. while the instances
+	// using them terminate.
+	RemoveResources(resources []Resource) ([]Resource, error)
+}
+
+// GetCredentials returns the data of the credentials secret
+func GetCredentials(reader client.Reader, namespace string, ref *v1.LocalObjectReference) (map[string][]byte, error) {
+	if ref == nil || ref.Name == "" {
+		return nil, fmt.Errorf("no credentials secret is configured")
+	}
+	secret := &v1.Secret{}
+	if err := reader.Get(context.TODO(), types.NamespacedName{Name: ref.Name, Namespace: namespace}, secret); err != nil {
+		klog.Errorf("Get credentials secret %s/%s failed: %v", namespace, ref.Name, err)
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+// RequiredKeys returns an error naming the keys missing in the credentials
+func RequiredKeys(credentials map[string][]byte, keys ...string) error {
+	var missing []string
+	for _, key := range keys {
+		if len(credentials[key]) == 0 {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the credentials secret misses the keys %v", missing)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Allowed is a protocol and its ports allowed by a firewall rule, the ports are empty for all ports
+type Allowed struct {
+	IPProtocol string
+	Ports      []string
+}
+
+// Firewall is a firewall rule of a VPC network
+type Firewall struct {
+	Name         string
+	Description  string
+	Network      string
+	Direction    string
+	Allowed      []Allowed
+	SourceRanges []string
+	SourceTags   []string
+	TargetTags   []string
+}
+
+// Tags are the network tags of an instance, the fingerprint of the current tags is required to set them
+type Tags struct {
+	Items       []string
+	Fingerprint string
+}
+
+// NetworkInterface is a network interface of an instance
+type NetworkInterface struct {
+	Network   string
+	NetworkIP string
+}
+
+// Instance is a compute engine instance
+type Instance struct {
+	Name              string
+	Status            string
+	Tags              Tags
+	NetworkInterfaces []NetworkInterface
+}
+
+// ComputeAPI is the part of the compute engine API of a project the cloud preparation uses
+type ComputeAPI interface {
+	GetInstance(zone, name string) (*Instance, error)
+	SetInstanceTags(zone, name string, tags Tags) error
+	GetFirewall(name string) (*Firewall, error)
+	InsertFirewall(firewall *Firewall) error
+	PatchFirewall(firewall *Firewall) error
+	DeleteFirewall(name string) error
+}
+
+// APIError is an error returned by the compute engine API
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a compute engine API error for a missing resource
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// toAPIError returns the error of the SDK as an APIError when the service returned it
+func toAPIError(err error) error {
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return &APIError{Code: googleErr.Code, Message: googleErr.Message}
+	}
+	return err
+}
+
+// computeClient calls the compute engine API of a project with the Google API client
+type computeClient struct {
+	service *compute.Service
+	project string
+}
+
+var _ ComputeAPI = &computeClient{}
+
+// NewComputeClient returns a client of the compute engine API of the project, the options must
+// authorize the requests
+func NewComputeClient(project string, opts ...option.ClientOption) (ComputeAPI, error) {
+	service, err := compute.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create the compute engine client: %v", err)
+	}
+	return &computeClient{service: service, project: project}, nil
+}
+
+// NewComputeClientFromKey returns a client of the compute engine API of the project of the service account
+// key, the requests are authorized with the service account
+func NewComputeClientFromKey(key []byte) (ComputeAPI, error) {
+	account := &struct {
+		ProjectID string `json:"project_id"`
+	}{}
+	if err := json.Unmarshal(key, account); err != nil {
+		return nil, fmt.Errorf("parse the service account key: %v", err)
+	}
+	if account.ProjectID == "" {
+		return nil, fmt.Errorf("the service account key misses the project ID")
+	}
+	return NewComputeClient(account.ProjectID, option.WithCredentialsJSON(key), option.WithScopes(compute.ComputeScope))
+}
+
+func (c *computeClient) GetInstance(zone, name string) (*Instance, error) {
+	computeInstance, err := c.service.Instances.Get(c.project, zone, name).Do()
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	instance := &Instance{Name: computeInstance.Name, Status: computeInstance.Status}
+	if computeInstance.Tags != nil {
+		instance.Tags = Tags{Items: computeInstance.Tags.Items, Fingerprint: computeInstance.Tags.Fingerprint}
+	}
+	for _, networkInterface := range computeInstance.NetworkInterfaces {
+		instance.NetworkInterfaces = append(instance.NetworkInterfaces, NetworkInterface{
+			Network:   networkInterface.Network,
+			NetworkIP: networkInterface.NetworkIP,
+		})
+	}
+	return instance, nil
+}
+
+func (c *computeClient) SetInstanceTags(zone, name string, tags Tags) error {
+	_, err := c.service.Instances.SetTags(c.project, zone, name,
+		&compute.Tags{Items: tags.Items, Fingerprint: tags.Fingerprint}).Do()
+	return toAPIError(err)
+}
+
+func (c *computeClient) GetFirewall(name string) (*Firewall, error) {
+	computeFirewall, err := c.service.Firewalls.Get(c.project, name).Do()
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	firewall := &Firewall{
+		Name:         computeFirewall.Name,
+		Description:  computeFirewall.Description,
+		Network:      computeFirewall.Network,
+		Direction:    computeFirewall.Direction,
+		SourceRanges: computeFirewall.SourceRanges,
+		SourceTags:   computeFirewall.SourceTags,
+		TargetTags:   computeFirewall.TargetTags,
+	}
+	for _, allowed := range computeFirewall.Allowed {
+		firewall.Allowed = append(firewall.Allowed, Allowed{IPProtocol: allowed.IPProtocol, Ports: allowed.Ports})
+	}
+	return firewall, nil
+}
+
+func toComputeFirewall(firewall *Firewall) *compute.Firewall {
+	computeFirewall := &compute.Firewall{
+		Name:         firewall.Name,
+		Description:  firewall.Description,
+		Network:      firewall.Network,
+		Direction:    firewall.Direction,
+		SourceRanges: firewall.SourceRanges,
+		SourceTags:   firewall.SourceTags,
+		TargetTags:   firewall.TargetTags,
+	}
+	for _, allowed := range firewall.Allowed {
+		computeFirewall.Allowed = append(computeFirewall.Allowed,
+			&compute.FirewallAllowed{IPProtocol: allowed.IPProtocol, Ports: allowed.Ports})
+	}
+	return computeFirewall
+}
+
+func (c *computeClient) InsertFirewall(firewall *Firewall) error {
+	_, err := c.service.Firewalls.Insert(c.project, toComputeFirewall(firewall)).Do()
+	return toAPIError(err)
+}
+
+func (c *computeClient) PatchFirewall(firewall *Firewall) error {
+	_, err := c.service.Firewalls.Patch(c.project, firewall.Name, toComputeFirewall(firewall)).Do()
+	return toAPIError(err)
+}
+
+func (c *computeClient) DeleteFirewall(name string) error {
+	_, err := c.service.Firewalls.Delete(c.project, name).Do()
+	return toAPIError(err)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// fakeCompute is an in-memory compute engine API of a project
+type fakeCompute struct {
+	instances map[string]*Instance
+	firewalls map[string]*Firewall
+	calls     []string
+}
+
+var _ ComputeAPI = &fakeCompute{}
+
+func newFakeCompute() *fakeCompute {
+	return &fakeCompute{instances: map[string]*Instance{}, firewalls: map[string]*Firewall{}}
+}
+
+func (f *fakeCompute) GetInstance(zone, name string) (*Instance, error) {
+	instance, ok := f.instances[zone+"/"+name]
+	if !ok {
+		return nil, &APIError{Code: http.StatusNotFound, Message: fmt.Sprintf("instance %s not found", name)}
+	}
+	copied := *instance
+	copied.Tags.Items = append([]string(nil), instance.Tags.Items...)
+	return &copied, nil
+}
+
+func (f *fakeCompute) SetInstanceTags(zone, name string, tags Tags) error {
+	f.calls = append(f.calls, "SetInstanceTags")
+	instance, ok := f.instances[zone+"/"+name]
+	if !ok {
+		return &APIError{Code: http.StatusNotFound, Message: fmt.Sprintf("instance %s not found", name)}
+	}
+	if tags.Fingerprint != instance.Tags.Fingerprint {
+		return &APIError{Code: http.StatusPreconditionFailed, Message: "fingerprint mismatch"}
+	}
+	instance.Tags = Tags{Items: tags.Items, Fingerprint: tags.Fingerprint + "+"}
+	return nil
+}
+
+func (f *fakeCompute) GetFirewall(name string) (*Firewall, error) {
+	firewall, ok := f.firewalls[name]
+	if !ok {
+		return nil, &APIError{Code: http.StatusNotFound, Message: fmt.Sprintf("firewall %s not found", name)}
+	}
+	copied := *firewall
+	return &copied, nil
+}
+
+func (f *fakeCompute) InsertFirewall(firewall *Firewall) error {
+	f.calls = append(f.calls, "InsertFirewall")
+	if _, ok := f.firewalls[firewall.Name]; ok {
+		return &APIError{Code: http.StatusConflict, Message: "already exists"}
+	}
+	copied := *firewall
+	f.firewalls[firewall.Name] = &copied
+	return nil
+}
+
+func (f *fakeCompute) PatchFirewall(firewall *Firewall) error {
+	f.calls = append(f.calls, "PatchFirewall")
+	copied := *firewall
+	f.firewalls[firewall.Name] = &copied
+	return nil
+}
+
+func (f *fakeCompute) DeleteFirewall(name string) error {
+	f.calls = append(f.calls, "DeleteFirewall")
+	if _, ok := f.firewalls[name]; !ok {
+		return &APIError{Code: http.StatusNotFound, Message: fmt.Sprintf("firewall %s not found", name)}
+	}
+	delete(f.firewalls, name)
+	return nil
+}
+
+func (f *fakeCompute) count(call string) int {
+	count := 0
+	for _, c := range f.calls {
+		if c == call {
+			count++
+		}
+	}
+	return count
+}
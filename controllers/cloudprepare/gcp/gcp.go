@@ -0,0 +1,332 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcp prepares the clusters running on GCP: firewall rules open the submariner ports in the
+// VPC network of the cluster, and the gateway nodes are tagged with the network tag the public ports
+// are opened for. The gateways are chosen among the existing worker nodes.
+package gcp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare"
+)
+
+const (
+	// ServiceAccountKey is the key of the credentials secret holding the JSON key of the service account,
+	// it matches the cloud credentials secrets of OpenShift
+	ServiceAccountKey = "service_account.json"
+
+	providerIDPrefix       = "gce://"
+	instanceRunning        = "RUNNING"
+	gatewayTagSuffix       = "-submariner-gw"
+	publicFirewallSuffix   = "-submariner-gw-ingress"
+	internalFirewallSuffix = "-submariner-internal-ingress"
+	anywhereCIDR           = "0.0.0.0/0"
+	ingressDirection       = "INGRESS"
+	espProtocol            = "esp"
+)
+
+type gcpCloud struct {
+	compute ComputeAPI
+	infraID string
+	nodes   []cloudprepare.Node
+}
+
+// NewCloud returns the cloud preparation of the cluster of the nodes, the firewall rules and the gateway
+// network tag are named after infraID
+func NewCloud(compute ComputeAPI, infraID string, nodes []cloudprepare.Node) cloudprepare.Cloud {
+	return &gcpCloud{compute: compute, infraID: infraID, nodes: nodes}
+}
+
+// NewCloudFromCredentials returns the cloud preparation of the cluster of the nodes, the compute engine
+// API is accessed with the service account key of the credentials secret
+func NewCloudFromCredentials(credentials map[string][]byte, infraID string, nodes []cloudprepare.Node) (cloudprepare.Cloud, error) {
+	if err := cloudprepare.RequiredKeys(credentials, ServiceAccountKey); err != nil {
+		return nil, err
+	}
+	compute, err := NewComputeClientFromKey(credentials[ServiceAccountKey])
+	if err != nil {
+		return nil, err
+	}
+	return NewCloud(compute, infraID, nodes), nil
+}
+
+// InstanceOf returns the zone and the name of the instance of the provider ID of a node, which is
+// gce://<project>/<zone>/<name>
+func InstanceOf(providerID string) (string, string, bool) {
+	if !strings.HasPrefix(providerID, providerIDPrefix) {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(providerID, providerIDPrefix), "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func (g *gcpCloud) Name() string {
+	return "GCP"
+}
+
+func (g *gcpCloud) gatewayTag() string {
+	return g.infraID + gatewayTagSuffix
+}
+
+// OpenPorts opens the public ports to anywhere for the instances with the gateway tag, and the other
+// ports between the instances of the nodes, identified by their network tags or their IPs
+func (g *gcpCloud) OpenPorts(ports []cloudprepare.Port) ([]cloudprepare.Resource, error) {
+	var network string
+	tagSet := map[string]bool{}
+	var nodeIPs []string
+	for _, node := range g.nodes {
+		zone, name, ok := InstanceOf(node.ProviderID)
+		if !ok {
+			continue
+		}
+		instance, err := g.compute.GetInstance(zone, name)
+		if err != nil {
+			klog.Errorf("Get instance %s of node %s failed: %v", name, node.Name, err)
+			return nil, err
+		}
+		for _, tag := range instance.Tags.Items {
+			if tag != g.gatewayTag() {
+				tagSet[tag] = true
+			}
+		}
+		if len(instance.NetworkInterfaces) > 0 {
+			network = instance.NetworkInterfaces[0].Network
+			nodeIPs = append(nodeIPs, instance.NetworkInterfaces[0].NetworkIP+"/32")
+		}
+	}
+	if network == "" {
+		return nil, fmt.Errorf("no node of the cluster runs on a compute engine instance")
+	}
+
+	var public, internal []cloudprepare.Port
+	for _, port := range ports {
+		if port.Public {
+			public = append(public, port)
+		} else {
+			internal = append(internal, port)
+		}
+	}
+	publicFirewall := &Firewall{
+		Name:         g.infraID + publicFirewallSuffix,
+		Description:  "Submariner gateway ports",
+		Network:      network,
+		Direction:    ingressDirection,
+		Allowed:      append(allowedPorts(public), Allowed{IPProtocol: espProtocol}),
+		SourceRanges: []string{anywhereCIDR},
+		TargetTags:   []string{g.gatewayTag()},
+	}
+	internalFirewall := &Firewall{
+		Name:        g.infraID + internalFirewallSuffix,
+		Description: "Submariner ports between the cluster nodes",
+		Network:     network,
+		Direction:   ingressDirection,
+		Allowed:     allowedPorts(internal),
+	}
+	if len(tagSet) > 0 {
+		tags := make([]string, 0, len(tagSet))
+		for tag := range tagSet {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		internalFirewall.SourceTags = tags
+		internalFirewall.TargetTags = tags
+	} else {
+		sort.Strings(nodeIPs)
+		internalFirewall.SourceRanges = nodeIPs
+	}
+
+	var resources []cloudprepare.Resource
+	for _, firewall := range []*Firewall{publicFirewall, internalFirewall} {
+		if len(firewall.Allowed) == 0 {
+			continue
+		}
+		created, err := g.ensureFirewall(firewall)
+		resources = append(resources, created...)
+		if err != nil {
+			return resources, err
+		}
+	}
+	return resources, nil
+}
+
+// ensureFirewall creates the firewall rule, or updates it when it differs
+func (g *gcpCloud) ensureFirewall(desired *Firewall) ([]cloudprepare.Resource, error) {
+	current, err := g.compute.GetFirewall(desired.Name)
+	if IsNotFound(err) {
+		klog.Infof("Creating firewall rule %s", desired.Name)
+		if err := g.compute.InsertFirewall(desired); err != nil {
+			klog.Errorf("Create firewall rule %s failed: %v", desired.Name, err)
+			return nil, err
+		}
+		return []cloudprepare.Resource{{Kind: cloudprepare.ResourceFirewallRule, ID: desired.Name}}, nil
+	}
+	if err != nil {
+		klog.Errorf("Get firewall rule %s failed: %v", desired.Name, err)
+		return nil, err
+	}
+	if reflect.DeepEqual(current.Allowed, desired.Allowed) && reflect.DeepEqual(current.SourceRanges, desired.SourceRanges) &&
+		reflect.DeepEqual(current.SourceTags, desired.SourceTags) && reflect.DeepEqual(current.TargetTags, desired.TargetTags) {
+		return nil, nil
+	}
+	klog.Infof("Updating firewall rule %s", desired.Name)
+	if err := g.compute.PatchFirewall(desired); err != nil {
+		klog.Errorf("Update firewall rule %s failed: %v", desired.Name, err)
+		return nil, err
+	}
+	return nil, nil
+}
+
+// PrepareGateways chooses the gateway nodes among the worker nodes and adds the gateway tag to their
+// instances, so the public ports are opened for them
+func (g *gcpCloud) PrepareGateways(spec cloudprepare.GatewaySpec) ([]cloudprepare.Gateway, []cloudprepare.Resource, error) {
+	candidates := cloudprepare.GatewayCandidates(g.nodes, func(node cloudprepare.Node) bool {
+		_, _, ok := InstanceOf(node.ProviderID)
+		return ok
+	})
+	if len(candidates) > spec.Count {
+		candidates = candidates[:spec.Count]
+	} else if len(candidates) < spec.Count {
+		klog.Warningf("Only %d worker nodes can be used as gateways, %d are requested", len(candidates), spec.Count)
+	}
+
+	var gateways []cloudprepare.Gateway
+	var resources []cloudprepare.Resource
+	for _, node := range candidates {
+		zone, name, _ := InstanceOf(node.ProviderID)
+		instance, err := g.compute.GetInstance(zone, name)
+		if err != nil {
+			klog.Errorf("Get instance %s of node %s failed: %v", name, node.Name, err)
+			return nil, resources, err
+		}
+		if !containsString(instance.Tags.Items, g.gatewayTag()) {
+			klog.Infof("Tagging instance %s with %s", name, g.gatewayTag())
+			tags := Tags{Items: append(instance.Tags.Items, g.gatewayTag()), Fingerprint: instance.Tags.Fingerprint}
+			if err := g.compute.SetInstanceTags(zone, name, tags); err != nil {
+				klog.Errorf("Tag instance %s failed: %v", name, err)
+				return nil, resources, err
+			}
+			resources = append(resources, cloudprepare.Resource{
+				Kind:   cloudprepare.ResourceInstanceTag,
+				ID:     g.gatewayTag(),
+				Parent: zone + "/" + name,
+			})
+		}
+		gateways = append(gateways, cloudprepare.Gateway{
+			ID:         name,
+			ProviderID: node.ProviderID,
+			Running:    instance.Status == instanceRunning,
+		})
+	}
+	return gateways, resources, nil
+}
+
+// RemoveResources deletes the firewall rules and removes the gateway tag from the instances
+func (g *gcpCloud) RemoveResources(resources []cloudprepare.Resource) ([]cloudprepare.Resource, error) {
+	for i, resource := range resources {
+		var err error
+		switch resource.Kind {
+		case cloudprepare.ResourceFirewallRule:
+			err = g.deleteFirewall(resource.ID)
+		case cloudprepare.ResourceInstanceTag:
+			err = g.untagInstance(resource.Parent, resource.ID)
+		default:
+			klog.Warningf("Skipping unknown GCP resource %s %s", resource.Kind, resource.ID)
+		}
+		if err != nil {
+			return resources[i:], err
+		}
+	}
+	return nil, nil
+}
+
+func (g *gcpCloud) deleteFirewall(name string) error {
+	klog.Infof("Deleting firewall rule %s", name)
+	if err := g.compute.DeleteFirewall(name); err != nil && !IsNotFound(err) {
+		klog.Errorf("Delete firewall rule %s failed: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+// untagInstance removes the tag from the instance, zoneName is the zone and the name of the instance
+// separated by a slash
+func (g *gcpCloud) untagInstance(zoneName, tag string) error {
+	parts := strings.SplitN(zoneName, "/", 2)
+	if len(parts) != 2 {
+		klog.Warningf("Skipping tag %s of malformed instance %s", tag, zoneName)
+		return nil
+	}
+	zone, name := parts[0], parts[1]
+	instance, err := g.compute.GetInstance(zone, name)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		klog.Errorf("Get instance %s failed: %v", name, err)
+		return err
+	}
+	if !containsString(instance.Tags.Items, tag) {
+		return nil
+	}
+	var items []string
+	for _, item := range instance.Tags.Items {
+		if item != tag {
+			items = append(items, item)
+		}
+	}
+	klog.Infof("Removing tag %s from instance %s", tag, name)
+	if err := g.compute.SetInstanceTags(zone, name, Tags{Items: items, Fingerprint: instance.Tags.Fingerprint}); err != nil {
+		klog.Errorf("Remove tag %s from instance %s failed: %v", tag, name, err)
+		return err
+	}
+	return nil
+}
+
+// allowedPorts returns the ports grouped by protocol
+func allowedPorts(ports []cloudprepare.Port) []Allowed {
+	var allowed []Allowed
+	indexes := map[cloudprepare.Protocol]int{}
+	for _, port := range ports {
+		index, ok := indexes[port.Protocol]
+		if !ok {
+			index = len(allowed)
+			indexes[port.Protocol] = index
+			allowed = append(allowed, Allowed{IPProtocol: string(port.Protocol)})
+		}
+		allowed[index].Ports = append(allowed[index].Ports, strconv.Itoa(int(port.Port)))
+	}
+	return allowed
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
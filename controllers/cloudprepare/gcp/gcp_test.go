@@ -0,0 +1,182 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"google.golang.org/api/option"
+
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare"
+)
+
+const (
+	testInfraID = "cluster-c-8fk2w"
+	testNetwork = "https://www.googleapis.com/compute/v1/projects/project/global/networks/cluster-c"
+)
+
+var _ = Describe("GCP cloud preparation", func() {
+	var compute *fakeCompute
+	var nodes []cloudprepare.Node
+	var cloud cloudprepare.Cloud
+	BeforeEach(func() {
+		compute = newFakeCompute()
+		for _, name := range []string{"master-0", "worker-a", "worker-b"} {
+			compute.instances["us-central1-a/"+name] = &Instance{
+				Name:              name,
+				Status:            instanceRunning,
+				Tags:              Tags{Items: []string{testInfraID + "-" + name[:6]}, Fingerprint: "fp"},
+				NetworkInterfaces: []NetworkInterface{{Network: testNetwork, NetworkIP: "10.0.0.1"}},
+			}
+		}
+		nodes = []cloudprepare.Node{
+			{Name: "master-0", ProviderID: "gce://project/us-central1-a/master-0", ControlPlane: true},
+			{Name: "worker-a", ProviderID: "gce://project/us-central1-a/worker-a"},
+			{Name: "worker-b", ProviderID: "gce://project/us-central1-a/worker-b", Gateway: true},
+		}
+		cloud = NewCloud(compute, testInfraID, nodes)
+	})
+
+	When("Opening the ports", func() {
+		It("Should open the IPsec ports for the gateways and VXLAN between the nodes", func() {
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(ConsistOf(
+				cloudprepare.Resource{Kind: cloudprepare.ResourceFirewallRule, ID: testInfraID + publicFirewallSuffix},
+				cloudprepare.Resource{Kind: cloudprepare.ResourceFirewallRule, ID: testInfraID + internalFirewallSuffix},
+			))
+
+			public := compute.firewalls[testInfraID+publicFirewallSuffix]
+			Expect(public.Network).To(Equal(testNetwork))
+			Expect(public.Allowed).To(Equal([]Allowed{{IPProtocol: "udp", Ports: []string{"500", "4500"}}, {IPProtocol: espProtocol}}))
+			Expect(public.SourceRanges).To(Equal([]string{anywhereCIDR}))
+			Expect(public.TargetTags).To(Equal([]string{testInfraID + gatewayTagSuffix}))
+
+			internal := compute.firewalls[testInfraID+internalFirewallSuffix]
+			Expect(internal.Allowed).To(Equal([]Allowed{{IPProtocol: "udp", Ports: []string{"4800"}}}))
+			Expect(internal.SourceTags).To(Equal([]string{testInfraID + "-master", testInfraID + "-worker"}))
+			Expect(internal.TargetTags).To(Equal(internal.SourceTags))
+		})
+
+		It("Should only update the rules when the ports change", func() {
+			_, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(BeEmpty())
+			Expect(compute.count("PatchFirewall")).To(Equal(0))
+
+			_, err = cloud.OpenPorts(cloudprepare.GatewayPorts(0, 4501))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compute.count("PatchFirewall")).To(Equal(1))
+			Expect(compute.count("InsertFirewall")).To(Equal(2))
+			Expect(compute.firewalls[testInfraID+publicFirewallSuffix].Allowed[0].Ports).To(Equal([]string{"500", "4501"}))
+		})
+
+		It("Should fail without compute engine nodes", func() {
+			cloud = NewCloud(compute, testInfraID, []cloudprepare.Node{{Name: "kind", ProviderID: "kind://docker/kind"}})
+			_, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("Preparing the gateways", func() {
+		It("Should tag the labeled gateway nodes first", func() {
+			gateways, resources, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gateways).To(Equal([]cloudprepare.Gateway{
+				{ID: "worker-b", ProviderID: "gce://project/us-central1-a/worker-b", Running: true},
+			}))
+			Expect(resources).To(Equal([]cloudprepare.Resource{
+				{Kind: cloudprepare.ResourceInstanceTag, ID: testInfraID + gatewayTagSuffix, Parent: "us-central1-a/worker-b"},
+			}))
+			Expect(compute.instances["us-central1-a/worker-b"].Tags.Items).To(ContainElement(testInfraID + gatewayTagSuffix))
+		})
+
+		It("Should not tag the gateways again", func() {
+			spec := cloudprepare.GatewaySpec{Count: 3}
+			_, _, err := cloud.PrepareGateways(spec)
+			Expect(err).NotTo(HaveOccurred())
+			gateways, resources, err := cloud.PrepareGateways(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(BeEmpty())
+			Expect(gateways).To(HaveLen(2))
+			Expect(compute.count("SetInstanceTags")).To(Equal(2))
+		})
+	})
+
+	When("Removing the resources", func() {
+		It("Should delete the firewall rules and untag the gateways", func() {
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			_, tagged, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1})
+			Expect(err).NotTo(HaveOccurred())
+			resources = append(resources, tagged...)
+
+			left, err := cloud.RemoveResources(resources)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(left).To(BeEmpty())
+			Expect(compute.firewalls).To(BeEmpty())
+			Expect(compute.instances["us-central1-a/worker-b"].Tags.Items).To(Equal([]string{testInfraID + "-worker"}))
+
+			left, err = cloud.RemoveResources(resources)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(left).To(BeEmpty())
+		})
+	})
+
+	When("Parsing the provider ID", func() {
+		It("Should return the zone and the name of the instance", func() {
+			zone, name, ok := InstanceOf("gce://project/europe-west1-b/worker-a")
+			Expect(ok).To(BeTrue())
+			Expect(zone).To(Equal("europe-west1-b"))
+			Expect(name).To(Equal("worker-a"))
+			_, _, ok = InstanceOf("gce://project/worker-a")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	When("Calling the API", func() {
+		It("Should send the firewall rules and return the not found errors", func() {
+			var inserted map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/compute/v1/projects/project/global/firewalls":
+					_ = json.NewDecoder(r.Body).Decode(&inserted)
+					_, _ = w.Write([]byte(`{"name":"operation-1"}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"error":{"code":404,"message":"not found"}}`))
+				}
+			}))
+			defer server.Close()
+			client, err := NewComputeClient("project", option.WithEndpoint(server.URL+"/compute/v1/projects/"), option.WithoutAuthentication())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(client.InsertFirewall(&Firewall{Name: "rule", Allowed: []Allowed{{IPProtocol: "esp"}}})).To(Succeed())
+			Expect(inserted).To(HaveKeyWithValue("name", "rule"))
+			Expect(inserted).To(HaveKeyWithValue("allowed", ConsistOf(HaveKeyWithValue("IPProtocol", "esp"))))
+			_, err = client.GetFirewall("missing")
+			Expect(IsNotFound(err)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,340 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tencent
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	sdkerrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+const (
+	// maxPageSize is the maximum number of items a describe call returns, and of IDs it accepts
+	maxPageSize = 100
+
+	requestTimeoutSeconds = 30
+)
+
+// Filter is a filter of a describe call
+type Filter struct {
+	Name   string   `json:"Name"`
+	Values []string `json:"Values"`
+}
+
+// Instance is a CVM instance
+type Instance struct {
+	InstanceID          string   `json:"InstanceId"`
+	InstanceState       string   `json:"InstanceState"`
+	SecurityGroupIDs    []string `json:"SecurityGroupIds"`
+	PublicIPAddresses   []string `json:"PublicIpAddresses"`
+	VirtualPrivateCloud struct {
+		VpcID    string `json:"VpcId"`
+		SubnetID string `json:"SubnetId"`
+	} `json:"VirtualPrivateCloud"`
+}
+
+// SecurityGroupPolicy is a rule of a security group, the source is either a CIDR block or a security group
+type SecurityGroupPolicy struct {
+	Protocol          string `json:"Protocol,omitempty"`
+	Port              string `json:"Port,omitempty"`
+	CidrBlock         string `json:"CidrBlock,omitempty"`
+	SecurityGroupID   string `json:"SecurityGroupId,omitempty"`
+	Action            string `json:"Action,omitempty"`
+	PolicyDescription string `json:"PolicyDescription,omitempty"`
+}
+
+// Address is an elastic IP
+type Address struct {
+	AddressID     string `json:"AddressId"`
+	AddressName   string `json:"AddressName"`
+	AddressStatus string `json:"AddressStatus"`
+	AddressIP     string `json:"AddressIp"`
+	InstanceID    string `json:"InstanceId"`
+}
+
+// API is the part of the CVM and VPC APIs the cloud preparation uses
+type API interface {
+	DescribeInstances(instanceIDs []string) ([]Instance, error)
+	DescribeSecurityGroupPolicies(groupID string) ([]SecurityGroupPolicy, error)
+	CreateSecurityGroupPolicies(groupID string, ingress []SecurityGroupPolicy) error
+	DeleteSecurityGroupPolicies(groupID string, ingress []SecurityGroupPolicy) error
+	DescribeAddresses(filters []Filter) ([]Address, error)
+	AllocateAddress(name string, bandwidth int) (string, error)
+	AssociateAddress(addressID, instanceID string) error
+	DisassociateAddress(addressID string) error
+	ReleaseAddress(addressID string) error
+}
+
+// Credentials are the API keys of a Tencent Cloud account or of a temporary session
+type Credentials struct {
+	SecretID  string
+	SecretKey string
+	Token     string
+}
+
+// APIError is an error returned by the Tencent Cloud API
+type APIError struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// IsErrorCode reports whether err is a Tencent Cloud API error with the code
+func IsErrorCode(err error, code string) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// toAPIError returns the error of the SDK as an APIError
+func toAPIError(err error) error {
+	var sdkErr *sdkerrors.TencentCloudSDKError
+	if errors.As(err, &sdkErr) {
+		return &APIError{Code: sdkErr.Code, Message: sdkErr.Message}
+	}
+	return err
+}
+
+// client calls the CVM and VPC APIs with the Tencent Cloud SDK
+type client struct {
+	cvm *cvm.Client
+	vpc *vpc.Client
+}
+
+var _ API = &client{}
+
+// NewClient returns a client of the APIs of the region, endpoint overrides the endpoint of all services
+// and the public endpoint of each service is used when it is empty
+func NewClient(endpoint, region string, credentials Credentials) (API, error) {
+	clientProfile := profile.NewClientProfile()
+	clientProfile.HttpProfile.ReqTimeout = requestTimeoutSeconds
+	if endpoint != "" {
+		endpointURL, err := url.Parse(endpoint)
+		if err != nil || endpointURL.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+		}
+		clientProfile.HttpProfile.Endpoint = endpointURL.Host
+		clientProfile.HttpProfile.Scheme = strings.ToUpper(endpointURL.Scheme)
+	}
+	credential := common.NewTokenCredential(credentials.SecretID, credentials.SecretKey, credentials.Token)
+	cvmClient, err := cvm.NewClient(credential, region, clientProfile)
+	if err != nil {
+		return nil, err
+	}
+	vpcClient, err := vpc.NewClient(credential, region, clientProfile)
+	if err != nil {
+		return nil, err
+	}
+	return &client{cvm: cvmClient, vpc: vpcClient}, nil
+}
+
+func (c *client) DescribeInstances(instanceIDs []string) ([]Instance, error) {
+	var instances []Instance
+	for start := 0; start < len(instanceIDs); start += maxPageSize {
+		end := start + maxPageSize
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+		request := cvm.NewDescribeInstancesRequest()
+		request.InstanceIds = common.StringPtrs(instanceIDs[start:end])
+		request.Limit = common.Int64Ptr(maxPageSize)
+		response, err := c.cvm.DescribeInstances(request)
+		if err != nil {
+			return nil, toAPIError(err)
+		}
+		for _, instance := range response.Response.InstanceSet {
+			instances = append(instances, fromCVMInstance(instance))
+		}
+	}
+	return instances, nil
+}
+
+func fromCVMInstance(cvmInstance *cvm.Instance) Instance {
+	instance := Instance{
+		InstanceID:        stringValue(cvmInstance.InstanceId),
+		InstanceState:     stringValue(cvmInstance.InstanceState),
+		SecurityGroupIDs:  stringValues(cvmInstance.SecurityGroupIds),
+		PublicIPAddresses: stringValues(cvmInstance.PublicIpAddresses),
+	}
+	if cvmInstance.VirtualPrivateCloud != nil {
+		instance.VirtualPrivateCloud.VpcID = stringValue(cvmInstance.VirtualPrivateCloud.VpcId)
+		instance.VirtualPrivateCloud.SubnetID = stringValue(cvmInstance.VirtualPrivateCloud.SubnetId)
+	}
+	return instance
+}
+
+func (c *client) DescribeSecurityGroupPolicies(groupID string) ([]SecurityGroupPolicy, error) {
+	request := vpc.NewDescribeSecurityGroupPoliciesRequest()
+	request.SecurityGroupId = common.StringPtr(groupID)
+	response, err := c.vpc.DescribeSecurityGroupPolicies(request)
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	if response.Response.SecurityGroupPolicySet == nil {
+		return nil, nil
+	}
+	var policies []SecurityGroupPolicy
+	for _, policy := range response.Response.SecurityGroupPolicySet.Ingress {
+		policies = append(policies, SecurityGroupPolicy{
+			Protocol:          stringValue(policy.Protocol),
+			Port:              stringValue(policy.Port),
+			CidrBlock:         stringValue(policy.CidrBlock),
+			SecurityGroupID:   stringValue(policy.SecurityGroupId),
+			Action:            stringValue(policy.Action),
+			PolicyDescription: stringValue(policy.PolicyDescription),
+		})
+	}
+	return policies, nil
+}
+
+// toPolicySet returns the ingress policies as a policy set of the SDK, the empty fields are omitted
+func toPolicySet(ingress []SecurityGroupPolicy) *vpc.SecurityGroupPolicySet {
+	policySet := &vpc.SecurityGroupPolicySet{}
+	for _, policy := range ingress {
+		policySet.Ingress = append(policySet.Ingress, &vpc.SecurityGroupPolicy{
+			Protocol:          optionalString(policy.Protocol),
+			Port:              optionalString(policy.Port),
+			CidrBlock:         optionalString(policy.CidrBlock),
+			SecurityGroupId:   optionalString(policy.SecurityGroupID),
+			Action:            optionalString(policy.Action),
+			PolicyDescription: optionalString(policy.PolicyDescription),
+		})
+	}
+	return policySet
+}
+
+func (c *client) CreateSecurityGroupPolicies(groupID string, ingress []SecurityGroupPolicy) error {
+	request := vpc.NewCreateSecurityGroupPoliciesRequest()
+	request.SecurityGroupId = common.StringPtr(groupID)
+	request.SecurityGroupPolicySet = toPolicySet(ingress)
+	_, err := c.vpc.CreateSecurityGroupPolicies(request)
+	return toAPIError(err)
+}
+
+func (c *client) DeleteSecurityGroupPolicies(groupID string, ingress []SecurityGroupPolicy) error {
+	request := vpc.NewDeleteSecurityGroupPoliciesRequest()
+	request.SecurityGroupId = common.StringPtr(groupID)
+	request.SecurityGroupPolicySet = toPolicySet(ingress)
+	_, err := c.vpc.DeleteSecurityGroupPolicies(request)
+	return toAPIError(err)
+}
+
+func (c *client) DescribeAddresses(filters []Filter) ([]Address, error) {
+	var addresses []Address
+	for offset := 0; ; offset += maxPageSize {
+		request := vpc.NewDescribeAddressesRequest()
+		for _, filter := range filters {
+			request.Filters = append(request.Filters, &vpc.Filter{
+				Name:   common.StringPtr(filter.Name),
+				Values: common.StringPtrs(filter.Values),
+			})
+		}
+		request.Offset = common.Int64Ptr(int64(offset))
+		request.Limit = common.Int64Ptr(maxPageSize)
+		response, err := c.vpc.DescribeAddresses(request)
+		if err != nil {
+			return nil, toAPIError(err)
+		}
+		for _, address := range response.Response.AddressSet {
+			addresses = append(addresses, Address{
+				AddressID:     stringValue(address.AddressId),
+				AddressName:   stringValue(address.AddressName),
+				AddressStatus: stringValue(address.AddressStatus),
+				AddressIP:     stringValue(address.AddressIp),
+				InstanceID:    stringValue(address.InstanceId),
+			})
+		}
+		totalCount := 0
+		if response.Response.TotalCount != nil {
+			totalCount = int(*response.Response.TotalCount)
+		}
+		if len(response.Response.AddressSet) < maxPageSize || len(addresses) >= totalCount {
+			return addresses, nil
+		}
+	}
+}
+
+// AllocateAddress allocates an elastic IP named name, the account default bandwidth is used when bandwidth is zero
+func (c *client) AllocateAddress(name string, bandwidth int) (string, error) {
+	request := vpc.NewAllocateAddressesRequest()
+	request.AddressCount = common.Int64Ptr(1)
+	request.AddressName = common.StringPtr(name)
+	if bandwidth > 0 {
+		request.InternetMaxBandwidthOut = common.Int64Ptr(int64(bandwidth))
+	}
+	response, err := c.vpc.AllocateAddresses(request)
+	if err != nil {
+		return "", toAPIError(err)
+	}
+	if len(response.Response.AddressSet) == 0 {
+		return "", fmt.Errorf("no elastic IP was allocated")
+	}
+	return stringValue(response.Response.AddressSet[0]), nil
+}
+
+func (c *client) AssociateAddress(addressID, instanceID string) error {
+	request := vpc.NewAssociateAddressRequest()
+	request.AddressId = common.StringPtr(addressID)
+	request.InstanceId = common.StringPtr(instanceID)
+	_, err := c.vpc.AssociateAddress(request)
+	return toAPIError(err)
+}
+
+func (c *client) DisassociateAddress(addressID string) error {
+	request := vpc.NewDisassociateAddressRequest()
+	request.AddressId = common.StringPtr(addressID)
+	_, err := c.vpc.DisassociateAddress(request)
+	return toAPIError(err)
+}
+
+func (c *client) ReleaseAddress(addressID string) error {
+	request := vpc.NewReleaseAddressesRequest()
+	request.AddressIds = common.StringPtrs([]string{addressID})
+	_, err := c.vpc.ReleaseAddresses(request)
+	return toAPIError(err)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func stringValues(ptrs []*string) []string {
+	var values []string
+	for _, s := range ptrs {
+		values = append(values, stringValue(s))
+	}
+	return values
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return common.StringPtr(s)
+}
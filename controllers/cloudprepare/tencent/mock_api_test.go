@@ -0,0 +1,224 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tencent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// mockAPI is an in-memory CVM and VPC API serving the actions used by the cloud preparation, the
+// elastic IPs are allocated and bound synchronously
+type mockAPI struct {
+	sync.Mutex
+	instances []*Instance
+	policies  map[string][]SecurityGroupPolicy
+	addresses []*Address
+	actions   []string
+	nextID    int
+}
+
+func newMockAPI() *mockAPI {
+	return &mockAPI{policies: map[string][]SecurityGroupPolicy{}}
+}
+
+const (
+	cvmService = "cvm"
+	vpcService = "vpc"
+)
+
+var mockServices = map[string]string{
+	"DescribeInstances":             cvmService,
+	"DescribeSecurityGroupPolicies": vpcService,
+	"CreateSecurityGroupPolicies":   vpcService,
+	"DescribeAddresses":             vpcService,
+	"AllocateAddresses":             vpcService,
+	"AssociateAddress":              vpcService,
+	"DeleteSecurityGroupPolicies":   vpcService,
+	"DisassociateAddress":           vpcService,
+	"ReleaseAddresses":              vpcService,
+}
+
+func (m *mockAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+	action := r.Header.Get("X-TC-Action")
+	service, ok := mockServices[action]
+	if !ok {
+		m.writeError(w, "InvalidAction", action)
+		return
+	}
+	scope := fmt.Sprintf("/%s/tc3_request,", service)
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "TC3-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(authorization, scope) {
+		m.writeError(w, "AuthFailure.SignatureFailure", "request is not signed")
+		return
+	}
+	request := map[string]json.RawMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		m.writeError(w, "InvalidParameter", err.Error())
+		return
+	}
+	m.actions = append(m.actions, action)
+	switch action {
+	case "DescribeInstances":
+		var ids []string
+		_ = json.Unmarshal(request["InstanceIds"], &ids)
+		var instances []*Instance
+		for _, instance := range m.instances {
+			for _, id := range ids {
+				if instance.InstanceID == id {
+					instances = append(instances, instance)
+				}
+			}
+		}
+		m.write(w, map[string]interface{}{"TotalCount": len(instances), "InstanceSet": instances})
+	case "DescribeSecurityGroupPolicies":
+		var groupID string
+		_ = json.Unmarshal(request["SecurityGroupId"], &groupID)
+		m.write(w, map[string]interface{}{"SecurityGroupPolicySet": map[string]interface{}{"Ingress": m.policies[groupID]}})
+	case "CreateSecurityGroupPolicies":
+		var groupID string
+		policySet := struct{ Ingress []SecurityGroupPolicy }{}
+		_ = json.Unmarshal(request["SecurityGroupId"], &groupID)
+		_ = json.Unmarshal(request["SecurityGroupPolicySet"], &policySet)
+		m.policies[groupID] = append(policySet.Ingress, m.policies[groupID]...)
+		m.write(w, map[string]interface{}{})
+	case "DescribeAddresses":
+		var filters []Filter
+		_ = json.Unmarshal(request["Filters"], &filters)
+		var addresses []*Address
+		for _, address := range m.addresses {
+			if len(filters) == 0 || filters[0].Name == "address-name" && address.AddressName == filters[0].Values[0] ||
+				filters[0].Name == "address-id" && address.AddressID == filters[0].Values[0] {
+				addresses = append(addresses, address)
+			}
+		}
+		m.write(w, map[string]interface{}{"TotalCount": len(addresses), "AddressSet": addresses})
+	case "AllocateAddresses":
+		var name string
+		_ = json.Unmarshal(request["AddressName"], &name)
+		m.nextID++
+		address := &Address{AddressID: fmt.Sprintf("eip-%d", m.nextID), AddressName: name, AddressStatus: addressUnbound,
+			AddressIP: fmt.Sprintf("203.0.113.%d", m.nextID)}
+		m.addresses = append(m.addresses, address)
+		m.write(w, map[string]interface{}{"AddressSet": []string{address.AddressID}})
+	case "AssociateAddress":
+		m.associateAddress(w, request)
+	case "DeleteSecurityGroupPolicies":
+		m.deletePolicies(w, request)
+	case "DisassociateAddress":
+		m.disassociateAddress(w, request)
+	case "ReleaseAddresses":
+		m.releaseAddresses(w, request)
+	}
+}
+
+func (m *mockAPI) deletePolicies(w http.ResponseWriter, request map[string]json.RawMessage) {
+	var groupID string
+	policySet := struct{ Ingress []SecurityGroupPolicy }{}
+	_ = json.Unmarshal(request["SecurityGroupId"], &groupID)
+	_ = json.Unmarshal(request["SecurityGroupPolicySet"], &policySet)
+	var kept []SecurityGroupPolicy
+	for _, policy := range m.policies[groupID] {
+		deleted := false
+		for _, p := range policySet.Ingress {
+			deleted = deleted || p == policy
+		}
+		if !deleted {
+			kept = append(kept, policy)
+		}
+	}
+	m.policies[groupID] = kept
+	m.write(w, map[string]interface{}{})
+}
+
+func (m *mockAPI) disassociateAddress(w http.ResponseWriter, request map[string]json.RawMessage) {
+	var addressID string
+	_ = json.Unmarshal(request["AddressId"], &addressID)
+	for _, address := range m.addresses {
+		if address.AddressID != addressID {
+			continue
+		}
+		for _, instance := range m.instances {
+			if instance.InstanceID == address.InstanceID {
+				instance.PublicIPAddresses = nil
+			}
+		}
+		address.AddressStatus = addressUnbound
+		address.InstanceID = ""
+		m.write(w, map[string]interface{}{"TaskId": "2"})
+		return
+	}
+	m.writeError(w, "InvalidAddressId.NotFound", addressID)
+}
+
+func (m *mockAPI) releaseAddresses(w http.ResponseWriter, request map[string]json.RawMessage) {
+	var addressIDs []string
+	_ = json.Unmarshal(request["AddressIds"], &addressIDs)
+	for i, address := range m.addresses {
+		if address.AddressID == addressIDs[0] {
+			m.addresses = append(m.addresses[:i], m.addresses[i+1:]...)
+			m.write(w, map[string]interface{}{"TaskId": "3"})
+			return
+		}
+	}
+	m.writeError(w, "InvalidAddressId.NotFound", addressIDs[0])
+}
+
+func (m *mockAPI) associateAddress(w http.ResponseWriter, request map[string]json.RawMessage) {
+	var addressID, instanceID string
+	_ = json.Unmarshal(request["AddressId"], &addressID)
+	_ = json.Unmarshal(request["InstanceId"], &instanceID)
+	for _, address := range m.addresses {
+		if address.AddressID != addressID {
+			continue
+		}
+		address.AddressStatus = addressBound
+		address.InstanceID = instanceID
+		for _, instance := range m.instances {
+			if instance.InstanceID == instanceID {
+				instance.PublicIPAddresses = []string{address.AddressIP}
+			}
+		}
+		m.write(w, map[string]interface{}{"TaskId": "1"})
+		return
+	}
+	m.writeError(w, "InvalidAddressId.NotFound", addressID)
+}
+
+func (m *mockAPI) write(w http.ResponseWriter, response map[string]interface{}) {
+	response["RequestId"] = "request"
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"Response": response})
+}
+
+func (m *mockAPI) writeError(w http.ResponseWriter, code, message string) {
+	m.write(w, map[string]interface{}{"Error": APIError{Code: code, Message: message}})
+}
+
+func (m *mockAPI) count(action string) int {
+	count := 0
+	for _, a := range m.actions {
+		if a == action {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,469 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tencent prepares the clusters running on Tencent Cloud, like TKE: the submariner ports are
+// opened in the security groups of the nodes, and the gateway nodes without public IP are bound to an
+// elastic IP. The gateways are chosen among the existing worker nodes.
+package tencent
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare"
+)
+
+const (
+	// The keys of the credentials secret
+	SecretIDKey  = "tencentcloud_secret_id"
+	SecretKeyKey = "tencentcloud_secret_key"
+	TokenKey     = "tencentcloud_token"
+	// EndpointKey optionally overrides the endpoint of the CVM and VPC APIs
+	EndpointKey = "tencentcloud_endpoint"
+
+	providerIDPrefix     = "qcloud://"
+	instanceIDPrefix     = "ins-"
+	instanceRunning      = "RUNNING"
+	addressBound         = "BIND"
+	addressUnbound       = "UNBIND"
+	gatewayAddressPrefix = "submariner-gw-"
+	anywhereCIDR         = "0.0.0.0/0"
+	policyAccept         = "ACCEPT"
+	policyAll            = "ALL"
+	policyDescription    = "submariner"
+	resourceNotFound     = "ResourceNotFound"
+)
+
+type tencentCloud struct {
+	api          API
+	nodes        []cloudprepare.Node
+	eipBandwidth int
+}
+
+// NewCloud returns the cloud preparation of the cluster of the nodes, the elastic IPs are allocated
+// with eipBandwidth, or the account default when it is zero
+func NewCloud(api API, nodes []cloudprepare.Node, eipBandwidth int) cloudprepare.Cloud {
+	return &tencentCloud{api: api, nodes: nodes, eipBandwidth: eipBandwidth}
+}
+
+// NewCloudFromCredentials returns the cloud preparation of the cluster of the nodes, the APIs of the
+// region are accessed with the credentials of the credentials secret
+func NewCloudFromCredentials(credentials map[string][]byte, region string, nodes []cloudprepare.Node, eipBandwidth int) (cloudprepare.Cloud, error) {
+	if err := cloudprepare.RequiredKeys(credentials, SecretIDKey, SecretKeyKey); err != nil {
+		return nil, err
+	}
+	api, err := NewClient(string(credentials[EndpointKey]), region, Credentials{
+		SecretID:  string(credentials[SecretIDKey]),
+		SecretKey: string(credentials[SecretKeyKey]),
+		Token:     string(credentials[TokenKey]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewCloud(api, nodes, eipBandwidth), nil
+}
+
+// InstanceID returns the CVM instance ID of the provider ID of a node, which is qcloud:///<zone>/<instance ID>
+func InstanceID(providerID string) (string, bool) {
+	if !strings.HasPrefix(providerID, providerIDPrefix) {
+		return "", false
+	}
+	instanceID := providerID[strings.LastIndex(providerID, "/")+1:]
+	return instanceID, strings.HasPrefix(instanceID, instanceIDPrefix)
+}
+
+func (t *tencentCloud) Name() string {
+	return "Tencent Cloud"
+}
+
+// OpenPorts opens the public ports to anywhere, and the other ports between the security groups of
+// the nodes, in each security group of the nodes
+func (t *tencentCloud) OpenPorts(ports []cloudprepare.Port) ([]cloudprepare.Resource, error) {
+	var instanceIDs []string
+	for _, node := range t.nodes {
+		if instanceID, ok := InstanceID(node.ProviderID); ok {
+			instanceIDs = append(instanceIDs, instanceID)
+		}
+	}
+	if len(instanceIDs) == 0 {
+		return nil, fmt.Errorf("no node of the cluster runs on a CVM instance")
+	}
+	instances, err := t.api.DescribeInstances(instanceIDs)
+	if err != nil {
+		klog.Errorf("Describe the instances of the nodes failed: %v", err)
+		return nil, err
+	}
+	groupSet := map[string]bool{}
+	for _, instance := range instances {
+		for _, groupID := range instance.SecurityGroupIDs {
+			groupSet[groupID] = true
+		}
+	}
+	groupIDs := make([]string, 0, len(groupSet))
+	for groupID := range groupSet {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+	if len(groupIDs) == 0 {
+		return nil, fmt.Errorf("the instances of the nodes have no security group")
+	}
+
+	var desired []SecurityGroupPolicy
+	for _, port := range ports {
+		policy := SecurityGroupPolicy{
+			Protocol:          strings.ToUpper(string(port.Protocol)),
+			Port:              strconv.Itoa(int(port.Port)),
+			Action:            policyAccept,
+			PolicyDescription: policyDescription,
+		}
+		if port.Public {
+			policy.CidrBlock = anywhereCIDR
+			desired = append(desired, policy)
+			continue
+		}
+		for _, groupID := range groupIDs {
+			policy.SecurityGroupID = groupID
+			desired = append(desired, policy)
+		}
+	}
+
+	var resources []cloudprepare.Resource
+	for _, groupID := range groupIDs {
+		existing, err := t.api.DescribeSecurityGroupPolicies(groupID)
+		if err != nil {
+			klog.Errorf("Describe the policies of security group %s failed: %v", groupID, err)
+			return resources, err
+		}
+		missing := missingPolicies(existing, desired)
+		if len(missing) == 0 {
+			continue
+		}
+		klog.Infof("Creating %d ingress policies in security group %s", len(missing), groupID)
+		if err := t.api.CreateSecurityGroupPolicies(groupID, missing); err != nil {
+			klog.Errorf("Create ingress policies in security group %s failed: %v", groupID, err)
+			return resources, err
+		}
+		for _, policy := range missing {
+			resources = append(resources, cloudprepare.Resource{
+				Kind:   cloudprepare.ResourceSecurityGroupRule,
+				ID:     policyKey(policy),
+				Parent: groupID,
+			})
+		}
+	}
+	return resources, nil
+}
+
+// PrepareGateways chooses the gateway nodes among the worker nodes, the nodes labeled as gateway and
+// then the nodes with a public IP are preferred. The gateways without public IP are bound to an elastic
+// IP, a gateway is running once it has a public IP.
+func (t *tencentCloud) PrepareGateways(spec cloudprepare.GatewaySpec) ([]cloudprepare.Gateway, []cloudprepare.Resource, error) {
+	candidates, err := t.gatewayCandidates()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(candidates) > spec.Count {
+		candidates = candidates[:spec.Count]
+	} else if len(candidates) < spec.Count {
+		klog.Warningf("Only %d worker nodes can be used as gateways, %d are requested", len(candidates), spec.Count)
+	}
+
+	var gateways []cloudprepare.Gateway
+	var resources []cloudprepare.Resource
+	for _, candidate := range candidates {
+		public := len(candidate.instance.PublicIPAddresses) > 0
+		if !public {
+			bound, created, err := t.ensureAddress(candidate.instance.InstanceID)
+			resources = append(resources, created...)
+			if err != nil {
+				return nil, resources, err
+			}
+			public = bound
+		}
+		gateways = append(gateways, cloudprepare.Gateway{
+			ID:         candidate.instance.InstanceID,
+			ProviderID: candidate.node.ProviderID,
+			Running:    candidate.instance.InstanceState == instanceRunning && public,
+		})
+	}
+	return gateways, resources, nil
+}
+
+// RemoveResources unbinds and releases the elastic IPs of the gateways and deletes the ingress policies.
+// The elastic IPs are left until the asynchronous unbinding is done.
+func (t *tencentCloud) RemoveResources(resources []cloudprepare.Resource) ([]cloudprepare.Resource, error) {
+	var associations, addresses, rules []cloudprepare.Resource
+	for _, resource := range resources {
+		switch resource.Kind {
+		case cloudprepare.ResourceAddressAssociation:
+			associations = append(associations, resource)
+		case cloudprepare.ResourceAddress:
+			addresses = append(addresses, resource)
+		case cloudprepare.ResourceSecurityGroupRule:
+			rules = append(rules, resource)
+		default:
+			klog.Warningf("Skipping unknown Tencent Cloud resource %s %s", resource.Kind, resource.ID)
+		}
+	}
+
+	for i, association := range associations {
+		address, err := t.address(association.ID)
+		if err != nil {
+			return concatResources(associations[i:], addresses, rules), err
+		}
+		if address == nil || address.AddressStatus != addressBound || address.InstanceID != association.Parent {
+			continue
+		}
+		if err := t.unbindAddress(address); err != nil {
+			return concatResources(associations[i:], addresses, rules), err
+		}
+	}
+
+	var left []cloudprepare.Resource
+	for i, resource := range addresses {
+		address, err := t.address(resource.ID)
+		if err != nil {
+			return concatResources(left, addresses[i:], rules), err
+		}
+		if address == nil {
+			continue
+		}
+		switch address.AddressStatus {
+		case addressUnbound:
+			klog.Infof("Releasing elastic IP %s", address.AddressID)
+			if err := t.api.ReleaseAddress(address.AddressID); err != nil {
+				klog.Errorf("Release elastic IP %s failed: %v", address.AddressID, err)
+				return concatResources(left, addresses[i:], rules), err
+			}
+			continue
+		case addressBound:
+			if err := t.unbindAddress(address); err != nil {
+				return concatResources(left, addresses[i:], rules), err
+			}
+		}
+		// The address is released once it is unbound
+		left = append(left, resource)
+	}
+
+	var groupIDs []string
+	rulesByGroup := map[string]map[string]bool{}
+	for _, rule := range rules {
+		if rulesByGroup[rule.Parent] == nil {
+			rulesByGroup[rule.Parent] = map[string]bool{}
+			groupIDs = append(groupIDs, rule.Parent)
+		}
+		rulesByGroup[rule.Parent][rule.ID] = true
+	}
+	for i, groupID := range groupIDs {
+		if err := t.deletePolicies(groupID, rulesByGroup[groupID]); err != nil {
+			for _, rule := range rules {
+				if indexOf(groupIDs[i:], rule.Parent) >= 0 {
+					left = append(left, rule)
+				}
+			}
+			return left, err
+		}
+	}
+	return left, nil
+}
+
+// deletePolicies deletes the ingress policies of the security group with the keys, the policies
+// deleted already are skipped
+func (t *tencentCloud) deletePolicies(groupID string, keys map[string]bool) error {
+	existing, err := t.api.DescribeSecurityGroupPolicies(groupID)
+	if IsErrorCode(err, resourceNotFound) {
+		return nil
+	}
+	if err != nil {
+		klog.Errorf("Describe the policies of security group %s failed: %v", groupID, err)
+		return err
+	}
+	var policies []SecurityGroupPolicy
+	for _, policy := range existing {
+		if keys[policyKey(policy)] {
+			policies = append(policies, policy)
+		}
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+	klog.Infof("Deleting %d ingress policies in security group %s", len(policies), groupID)
+	if err := t.api.DeleteSecurityGroupPolicies(groupID, policies); err != nil {
+		klog.Errorf("Delete ingress policies in security group %s failed: %v", groupID, err)
+		return err
+	}
+	return nil
+}
+
+// address returns the elastic IP with the ID, or nil when it does not exist
+func (t *tencentCloud) address(addressID string) (*Address, error) {
+	addresses, err := t.api.DescribeAddresses([]Filter{{Name: "address-id", Values: []string{addressID}}})
+	if err != nil {
+		klog.Errorf("Describe elastic IP %s failed: %v", addressID, err)
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	return &addresses[0], nil
+}
+
+func (t *tencentCloud) unbindAddress(address *Address) error {
+	klog.Infof("Unbinding elastic IP %s from instance %s", address.AddressID, address.InstanceID)
+	if err := t.api.DisassociateAddress(address.AddressID); err != nil {
+		klog.Errorf("Unbind elastic IP %s from instance %s failed: %v", address.AddressID, address.InstanceID, err)
+		return err
+	}
+	return nil
+}
+
+type gatewayCandidate struct {
+	node     cloudprepare.Node
+	instance Instance
+}
+
+// gatewayCandidates returns the worker nodes running on CVM instances in the order they are chosen as gateways
+func (t *tencentCloud) gatewayCandidates() ([]gatewayCandidate, error) {
+	nodesByInstance := map[string]cloudprepare.Node{}
+	var instanceIDs []string
+	for _, node := range t.nodes {
+		if instanceID, ok := InstanceID(node.ProviderID); ok && !node.ControlPlane {
+			nodesByInstance[instanceID] = node
+			instanceIDs = append(instanceIDs, instanceID)
+		}
+	}
+	if len(instanceIDs) == 0 {
+		return nil, nil
+	}
+	instances, err := t.api.DescribeInstances(instanceIDs)
+	if err != nil {
+		klog.Errorf("Describe the instances of the worker nodes failed: %v", err)
+		return nil, err
+	}
+	candidates := make([]gatewayCandidate, 0, len(instances))
+	for _, instance := range instances {
+		candidates = append(candidates, gatewayCandidate{node: nodesByInstance[instance.InstanceID], instance: instance})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.node.Gateway != b.node.Gateway {
+			return a.node.Gateway
+		}
+		if aPublic, bPublic := len(a.instance.PublicIPAddresses) > 0, len(b.instance.PublicIPAddresses) > 0; aPublic != bPublic {
+			return aPublic
+		}
+		return a.node.Name < b.node.Name
+	})
+	return candidates, nil
+}
+
+// ensureAddress allocates an elastic IP for the instance and binds it, it returns whether the address
+// is bound to the instance. The address is named after the instance, so it is found again while the
+// asynchronous allocation and binding are in progress. An existing address is returned as resource
+// too, so it is recorded again when the record of its allocation is lost.
+func (t *tencentCloud) ensureAddress(instanceID string) (bool, []cloudprepare.Resource, error) {
+	name := gatewayAddressPrefix + instanceID
+	addresses, err := t.api.DescribeAddresses([]Filter{{Name: "address-name", Values: []string{name}}})
+	if err != nil {
+		klog.Errorf("Describe elastic IP %s failed: %v", name, err)
+		return false, nil, err
+	}
+	if len(addresses) == 0 {
+		klog.Infof("Allocating elastic IP %s", name)
+		addressID, err := t.api.AllocateAddress(name, t.eipBandwidth)
+		if err != nil {
+			klog.Errorf("Allocate elastic IP %s failed: %v", name, err)
+			return false, nil, err
+		}
+		return false, []cloudprepare.Resource{{Kind: cloudprepare.ResourceAddress, ID: addressID}}, nil
+	}
+
+	address := addresses[0]
+	owned := []cloudprepare.Resource{{Kind: cloudprepare.ResourceAddress, ID: address.AddressID}}
+	switch address.AddressStatus {
+	case addressBound:
+		if address.InstanceID != instanceID {
+			return false, owned, fmt.Errorf("elastic IP %s is bound to instance %s", address.AddressID, address.InstanceID)
+		}
+		return true, owned, nil
+	case addressUnbound:
+		klog.Infof("Binding elastic IP %s to instance %s", address.AddressID, instanceID)
+		if err := t.api.AssociateAddress(address.AddressID, instanceID); err != nil {
+			klog.Errorf("Bind elastic IP %s to instance %s failed: %v", address.AddressID, instanceID, err)
+			return false, owned, err
+		}
+		return false, append(owned, cloudprepare.Resource{
+			Kind:   cloudprepare.ResourceAddressAssociation,
+			ID:     address.AddressID,
+			Parent: instanceID,
+		}), nil
+	default:
+		// The address is being created or bound
+		return false, owned, nil
+	}
+}
+
+// missingPolicies returns the desired policies not accepted yet, a policy accepting all protocols from
+// the same source covers any port
+func missingPolicies(existing, desired []SecurityGroupPolicy) []SecurityGroupPolicy {
+	accepted := map[string]bool{}
+	for _, policy := range existing {
+		if strings.EqualFold(policy.Action, policyAccept) {
+			accepted[policyKey(policy)] = true
+		}
+	}
+	var missing []SecurityGroupPolicy
+	for _, policy := range desired {
+		all := SecurityGroupPolicy{Protocol: policyAll, Port: policyAll, CidrBlock: policy.CidrBlock, SecurityGroupID: policy.SecurityGroupID}
+		if !accepted[policyKey(policy)] && !accepted[policyKey(all)] {
+			missing = append(missing, policy)
+		}
+	}
+	return missing
+}
+
+func policyKey(policy SecurityGroupPolicy) string {
+	source := policy.CidrBlock
+	if source == "" {
+		source = policy.SecurityGroupID
+	}
+	port := policy.Port
+	if port == "" {
+		port = policyAll
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.ToLower(policy.Protocol), strings.ToLower(port), source)
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func concatResources(lists ...[]cloudprepare.Resource) []cloudprepare.Resource {
+	var resources []cloudprepare.Resource
+	for _, list := range lists {
+		resources = append(resources, list...)
+	}
+	return resources
+}
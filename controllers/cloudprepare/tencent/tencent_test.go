@@ -0,0 +1,189 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tencent
+
+import (
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/tkestack/knitnet-operator/controllers/cloudprepare"
+)
+
+var _ = Describe("Tencent Cloud cloud preparation", func() {
+	var server *httptest.Server
+	var api *mockAPI
+	var cloud cloudprepare.Cloud
+	BeforeEach(func() {
+		api = newMockAPI()
+		api.instances = []*Instance{
+			{InstanceID: "ins-master", InstanceState: instanceRunning, SecurityGroupIDs: []string{"sg-master"}},
+			{InstanceID: "ins-worker1", InstanceState: instanceRunning, SecurityGroupIDs: []string{"sg-node"}},
+			{InstanceID: "ins-worker2", InstanceState: instanceRunning, SecurityGroupIDs: []string{"sg-node"},
+				PublicIPAddresses: []string{"198.51.100.7"}},
+		}
+		api.policies["sg-node"] = []SecurityGroupPolicy{
+			{Protocol: policyAll, Port: policyAll, SecurityGroupID: "sg-node", Action: policyAccept},
+		}
+		nodes := []cloudprepare.Node{
+			{Name: "master", ProviderID: "qcloud:///100003/ins-master", ControlPlane: true},
+			{Name: "worker1", ProviderID: "qcloud:///100003/ins-worker1"},
+			{Name: "worker2", ProviderID: "qcloud:///100003/ins-worker2"},
+			{Name: "virtual", ProviderID: "eklet://eklet-node"},
+		}
+		server = httptest.NewServer(api)
+		var err error
+		cloud, err = NewCloudFromCredentials(map[string][]byte{
+			SecretIDKey:  []byte("AKIDEXAMPLE"),
+			SecretKeyKey: []byte("secret"),
+			EndpointKey:  []byte(server.URL),
+		}, "ap-guangzhou", nodes, 100)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	When("Opening the ports", func() {
+		It("Should open the IPsec ports to anywhere and VXLAN between the node security groups", func() {
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 4501))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(api.policies["sg-master"]).To(ConsistOf(
+				SecurityGroupPolicy{Protocol: "UDP", Port: "500", CidrBlock: anywhereCIDR, Action: policyAccept, PolicyDescription: policyDescription},
+				SecurityGroupPolicy{Protocol: "UDP", Port: "4501", CidrBlock: anywhereCIDR, Action: policyAccept, PolicyDescription: policyDescription},
+				SecurityGroupPolicy{Protocol: "UDP", Port: "4800", SecurityGroupID: "sg-master", Action: policyAccept, PolicyDescription: policyDescription},
+				SecurityGroupPolicy{Protocol: "UDP", Port: "4800", SecurityGroupID: "sg-node", Action: policyAccept, PolicyDescription: policyDescription},
+			))
+			// The node security group accepts all traffic of its own nodes already
+			Expect(api.policies["sg-node"]).To(HaveLen(4))
+			Expect(resources).To(HaveLen(7))
+			Expect(resources).To(ContainElement(cloudprepare.Resource{
+				Kind: cloudprepare.ResourceSecurityGroupRule, ID: "udp/4501/0.0.0.0/0", Parent: "sg-node"}))
+		})
+
+		It("Should not create the policies again", func() {
+			_, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(BeEmpty())
+			Expect(api.count("CreateSecurityGroupPolicies")).To(Equal(2))
+		})
+	})
+
+	When("Preparing the gateways", func() {
+		It("Should prefer the nodes with a public IP", func() {
+			gateways, resources, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(BeEmpty())
+			Expect(gateways).To(Equal([]cloudprepare.Gateway{
+				{ID: "ins-worker2", ProviderID: "qcloud:///100003/ins-worker2", Running: true},
+			}))
+		})
+
+		It("Should bind an elastic IP to the gateways without public IP", func() {
+			spec := cloudprepare.GatewaySpec{Count: 2}
+			gateways, resources, err := cloud.PrepareGateways(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gateways).To(HaveLen(2))
+			Expect(gateways[0].ID).To(Equal("ins-worker2"))
+			Expect(gateways[1].Running).To(BeFalse())
+			Expect(resources).To(Equal([]cloudprepare.Resource{{Kind: cloudprepare.ResourceAddress, ID: "eip-1"}}))
+			Expect(api.addresses[0].AddressName).To(Equal(gatewayAddressPrefix + "ins-worker1"))
+
+			gateways, resources, err = cloud.PrepareGateways(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(Equal([]cloudprepare.Resource{
+				{Kind: cloudprepare.ResourceAddress, ID: "eip-1"},
+				{Kind: cloudprepare.ResourceAddressAssociation, ID: "eip-1", Parent: "ins-worker1"},
+			}))
+
+			gateways, resources, err = cloud.PrepareGateways(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(BeEmpty())
+			Expect(gateways[1].Running).To(BeTrue())
+			Expect(api.count("AllocateAddresses")).To(Equal(1))
+		})
+
+		It("Should keep the nodes labeled as gateway", func() {
+			client, err := NewClient(server.URL, "ap-guangzhou", Credentials{SecretID: "AKIDEXAMPLE", SecretKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			cloud = NewCloud(client, []cloudprepare.Node{
+				{Name: "worker1", ProviderID: "qcloud:///100003/ins-worker1", Gateway: true},
+				{Name: "worker2", ProviderID: "qcloud:///100003/ins-worker2"},
+			}, 0)
+			gateways, _, err := cloud.PrepareGateways(cloudprepare.GatewaySpec{Count: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gateways).To(HaveLen(1))
+			Expect(gateways[0].ID).To(Equal("ins-worker1"))
+		})
+	})
+
+	When("Removing the resources", func() {
+		It("Should delete the created policies and release the elastic IPs", func() {
+			resources, err := cloud.OpenPorts(cloudprepare.GatewayPorts(0, 0))
+			Expect(err).NotTo(HaveOccurred())
+			spec := cloudprepare.GatewaySpec{Count: 2}
+			for i := 0; i < 2; i++ {
+				_, created, err := cloud.PrepareGateways(spec)
+				Expect(err).NotTo(HaveOccurred())
+				resources = append(resources, created...)
+			}
+			Expect(api.instances[1].PublicIPAddresses).NotTo(BeEmpty())
+
+			left, err := cloud.RemoveResources(resources)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(left).To(BeEmpty())
+			Expect(api.addresses).To(BeEmpty())
+			Expect(api.instances[1].PublicIPAddresses).To(BeEmpty())
+			Expect(api.policies["sg-master"]).To(BeEmpty())
+			// The policy which existed before is kept
+			Expect(api.policies["sg-node"]).To(HaveLen(1))
+		})
+
+		It("Should keep an elastic IP until it is unbound", func() {
+			api.addresses = []*Address{{AddressID: "eip-9", AddressStatus: "UNBINDING"}}
+			resources := []cloudprepare.Resource{
+				{Kind: cloudprepare.ResourceAddress, ID: "eip-9"},
+				{Kind: cloudprepare.ResourceAddress, ID: "eip-gone"},
+			}
+			left, err := cloud.RemoveResources(resources)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(left).To(Equal(resources[:1]))
+		})
+	})
+
+	When("Calling the API", func() {
+		It("Should return the API error code", func() {
+			client, err := NewClient(server.URL, "ap-guangzhou", Credentials{SecretID: "unknown", SecretKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.DescribeInstances([]string{"ins-worker1"})
+			Expect(IsErrorCode(err, "AuthFailure.SignatureFailure")).To(BeTrue())
+		})
+
+		It("Should parse the instance ID of the provider ID", func() {
+			instanceID, ok := InstanceID("qcloud:///100003/ins-worker1")
+			Expect(ok).To(BeTrue())
+			Expect(instanceID).To(Equal("ins-worker1"))
+			_, ok = InstanceID("eklet://eklet-node")
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
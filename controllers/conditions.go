@@ -0,0 +1,66 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+)
+
+// markConditionTrue records that a reconcile stage has finished successfully.
+func markConditionTrue(instance *operatorv1alpha1.Knitnet, conditionType, reason, messageFmt string, args ...interface{}) {
+	setCondition(instance, conditionType, metav1.ConditionTrue, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// markConditionFalse records that a reconcile stage has failed with err.
+func markConditionFalse(instance *operatorv1alpha1.Knitnet, conditionType, reason string, err error) {
+	setCondition(instance, conditionType, metav1.ConditionFalse, reason, err.Error())
+}
+
+func setCondition(instance *operatorv1alpha1.Knitnet, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: instance.GetGeneration(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// markReconcileResult summarizes the result of a reconcile in the phase and the Ready condition.
+func markReconcileResult(instance *operatorv1alpha1.Knitnet, err error) {
+	if err != nil {
+		instance.Status.Phase = operatorv1alpha1.PhaseFailed
+		markConditionFalse(instance, operatorv1alpha1.ConditionReady, operatorv1alpha1.ReasonFailed, err)
+		return
+	}
+	instance.Status.Phase = operatorv1alpha1.PhaseRunning
+	instance.Status.ObservedGeneration = instance.GetGeneration()
+	markConditionTrue(instance, operatorv1alpha1.ConditionReady, operatorv1alpha1.ReasonSucceeded, "Knitnet %s has been applied", instance.Spec.Action)
+}
+
+// markReconcilePending records that the spec is still being applied, e.g. while cloud resources are
+// provisioned or removed. The observed generation is only updated once the spec is fully applied.
+func markReconcilePending(instance *operatorv1alpha1.Knitnet, messageFmt string, args ...interface{}) {
+	instance.Status.Phase = operatorv1alpha1.PhasePending
+	setCondition(instance, operatorv1alpha1.ConditionReady, metav1.ConditionFalse, operatorv1alpha1.ReasonProvisioning,
+		fmt.Sprintf(messageFmt, args...))
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+)
+
+var _ = Describe("Reconcile result", func() {
+	var instance *operatorv1alpha1.Knitnet
+
+	BeforeEach(func() {
+		instance = &operatorv1alpha1.Knitnet{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+		instance.Status.ObservedGeneration = 1
+	})
+
+	It("Should observe the generation once it is applied", func() {
+		markReconcileResult(instance, nil)
+		Expect(instance.Status.Phase).To(Equal(operatorv1alpha1.PhaseRunning))
+		Expect(instance.Status.ObservedGeneration).To(Equal(int64(2)))
+		Expect(meta.IsStatusConditionTrue(instance.Status.Conditions, operatorv1alpha1.ConditionReady)).To(BeTrue())
+	})
+
+	It("Should not observe the generation while it is pending", func() {
+		markReconcilePending(instance, "Removing %d cloud resources", 2)
+		Expect(instance.Status.Phase).To(Equal(operatorv1alpha1.PhasePending))
+		Expect(instance.Status.ObservedGeneration).To(Equal(int64(1)))
+		ready := meta.FindStatusCondition(instance.Status.Conditions, operatorv1alpha1.ConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(operatorv1alpha1.ReasonProvisioning))
+	})
+
+	It("Should not observe the generation when it failed", func() {
+		markReconcileResult(instance, errors.New("failed"))
+		Expect(instance.Status.Phase).To(Equal(operatorv1alpha1.PhaseFailed))
+		Expect(instance.Status.ObservedGeneration).To(Equal(int64(1)))
+	})
+})
@@ -0,0 +1,105 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	operatorv1beta1 "github.com/tkestack/knitnet-operator/api/v1beta1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+// convertToV1beta1 replaces a v1alpha1 Knitnet with the equivalent v1beta1 objects. The deployed
+// resources are kept running and adopted by the v1beta1 reconcilers, so the finalizer of the
+// Knitnet is removed without cleaning anything up.
+func (r *KnitnetReconciler) convertToV1beta1(ctx context.Context, instance *operatorv1alpha1.Knitnet) error {
+	klog.Infof("Converting Knitnet %s/%s to %s", instance.GetNamespace(), instance.GetName(), operatorv1beta1.GroupVersion)
+	source := instance.DeepCopy()
+	delete(source.Annotations, consts.KnitnetConvertAnnotation)
+	if source.Spec.Action == JoinAction || source.Spec.Action == AllAction {
+		// Keep the generated cluster ID, the cluster would join the broker again with a new identity otherwise
+		clusterID, err := r.getJoinedClusterID(source)
+		if err != nil {
+			return err
+		}
+		source.Spec.JoinConfig.ClusterID = clusterID
+	}
+
+	knitnetBroker, knitnetJoin, knitnetCloudPrepare := operatorv1beta1.ConvertFromKnitnet(source)
+	if knitnetBroker != nil {
+		status := knitnetBroker.Status
+		if err := r.createConverted(ctx, knitnetBroker, func() error {
+			knitnetBroker.Status = status
+			return r.Status().Update(ctx, knitnetBroker)
+		}); err != nil {
+			return err
+		}
+	}
+	if knitnetJoin != nil {
+		status := knitnetJoin.Status
+		if err := r.createConverted(ctx, knitnetJoin, func() error {
+			knitnetJoin.Status = status
+			return r.Status().Update(ctx, knitnetJoin)
+		}); err != nil {
+			return err
+		}
+	}
+	if knitnetCloudPrepare != nil {
+		status := knitnetCloudPrepare.Status
+		if err := r.createConverted(ctx, knitnetCloudPrepare, func() error {
+			knitnetCloudPrepare.Status = status
+			return r.Status().Update(ctx, knitnetCloudPrepare)
+		}); err != nil {
+			return err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(instance, consts.KnitnetFinalizer)
+	if err := r.Client.Update(ctx, instance); err != nil {
+		klog.Errorf("Remove finalizer failed: %v", err)
+		return err
+	}
+	if err := r.Client.Delete(ctx, instance); err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Delete converted Knitnet failed: %v", err)
+		return err
+	}
+	klog.Infof("Knitnet %s/%s is converted", instance.GetNamespace(), instance.GetName())
+	return nil
+}
+
+// createConverted creates the converted object and restores its status, which is dropped on create.
+// An existing object is left untouched as it was created by a previous conversion attempt.
+func (r *KnitnetReconciler) createConverted(ctx context.Context, obj client.Object, restoreStatus func() error) error {
+	if err := r.Client.Create(ctx, obj); err != nil {
+		if errors.IsAlreadyExists(err) {
+			klog.Infof("%T %s/%s already exists", obj, obj.GetNamespace(), obj.GetName())
+			return nil
+		}
+		klog.Errorf("Create %T failed: %v", obj, err)
+		return err
+	}
+	if err := restoreStatus(); err != nil {
+		klog.Errorf("Restore status of %T failed: %v", obj, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	operatorv1beta1 "github.com/tkestack/knitnet-operator/api/v1beta1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+// statusDroppingClient drops the status of the created objects, as the API server does for the
+// resources with a status subresource
+type statusDroppingClient struct {
+	client.Client
+}
+
+func (c statusDroppingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	switch o := obj.(type) {
+	case *operatorv1beta1.KnitnetBroker:
+		o.Status = operatorv1beta1.KnitnetBrokerStatus{}
+	case *operatorv1beta1.KnitnetJoin:
+		o.Status = operatorv1beta1.KnitnetJoinStatus{}
+	case *operatorv1beta1.KnitnetCloudPrepare:
+		o.Status = operatorv1beta1.KnitnetCloudPrepareStatus{}
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+var _ = Describe("Converting a Knitnet to v1beta1", func() {
+	var (
+		c       client.Client
+		r       *KnitnetReconciler
+		knitnet *operatorv1alpha1.Knitnet
+		key     types.NamespacedName
+	)
+
+	condition := func(conditionType string) metav1.Condition {
+		return metav1.Condition{Type: conditionType, Status: metav1.ConditionTrue, Reason: operatorv1alpha1.ReasonSucceeded,
+			Message: conditionType, ObservedGeneration: 3, LastTransitionTime: metav1.NewTime(time.Unix(1622505600, 0))}
+	}
+
+	BeforeEach(func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		Expect(operatorv1alpha1.AddToScheme(s)).To(Succeed())
+		Expect(operatorv1beta1.AddToScheme(s)).To(Succeed())
+
+		knitnet = &operatorv1alpha1.Knitnet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "knitnet",
+				Namespace:   "default",
+				Finalizers:  []string{consts.KnitnetFinalizer},
+				Annotations: map[string]string{consts.KnitnetConvertAnnotation: operatorv1beta1.GroupVersion.Version},
+			},
+			Spec: operatorv1alpha1.KnitnetSpec{
+				Action: AllAction,
+				CloudPrepareConfig: operatorv1alpha1.CloudPrepareConfig{
+					Provider:          operatorv1alpha1.CloudProviderAWS,
+					Region:            "us-east-1",
+					CredentialsSecret: &v1.LocalObjectReference{Name: "aws-credentials"},
+				},
+			},
+			Status: operatorv1alpha1.KnitnetStatus{
+				ObservedGeneration:         3,
+				ClusterID:                  "cluster-a",
+				GatewayLoadBalancerAddress: "203.0.113.10",
+				IPSecPSK: &operatorv1alpha1.IPSecPSKStatus{
+					Generation:       2,
+					LastRotationTime: &metav1.Time{Time: time.Unix(1622505600, 0)},
+					RotationRequest:  "2021-06-01",
+					UpdatedClusters:  []string{"cluster-a"},
+					PendingClusters:  []string{"cluster-b"},
+				},
+				Discovery: &operatorv1alpha1.DiscoveryStatus{
+					CloudPlatform: &operatorv1alpha1.CloudPlatform{Provider: operatorv1alpha1.CloudProviderAWS, Region: "us-east-1", InfraID: "cluster-a-x7k2p"},
+					Network: &operatorv1alpha1.NetworkDiscovery{NetworkPlugin: "calico", PodCIDRs: []string{"10.244.0.0/16"},
+						ServiceCIDRs: []string{"10.96.0.0/12"}, GlobalCIDR: "242.0.0.0/16", Source: "calico"},
+				},
+				Conditions: []metav1.Condition{
+					condition(operatorv1alpha1.ConditionReady),
+					condition(operatorv1alpha1.ConditionBrokerDeployed),
+					condition(operatorv1alpha1.ConditionBrokerConnected),
+					condition(operatorv1alpha1.ConditionCloudPortsOpened),
+				},
+				CloudResources: []operatorv1alpha1.CloudResource{
+					{Kind: "SecurityGroup", ID: "sg-1", Parent: "vpc-1"},
+					{Kind: "Instance", ID: "i-1"},
+				},
+				AppliedCloudPrepareConfig: &operatorv1alpha1.CloudPrepareConfig{
+					Provider:          operatorv1alpha1.CloudProviderAWS,
+					Region:            "us-east-1",
+					CredentialsSecret: &v1.LocalObjectReference{Name: "aws-credentials"},
+				},
+			},
+		}
+		key = types.NamespacedName{Name: knitnet.Name, Namespace: knitnet.Namespace}
+		c = statusDroppingClient{fake.NewClientBuilder().WithScheme(s).WithObjects(knitnet).Build()}
+		r = &KnitnetReconciler{Client: c, Reader: c, Scheme: s}
+	})
+
+	It("Should keep the recorded cloud resources", func() {
+		Expect(r.convertToV1beta1(context.TODO(), knitnet)).To(Succeed())
+
+		cloudPrepare := &operatorv1beta1.KnitnetCloudPrepare{}
+		Expect(c.Get(context.TODO(), key, cloudPrepare)).To(Succeed())
+		Expect(cloudPrepare.Status.CloudResources).To(Equal(knitnet.Status.CloudResources))
+		Expect(cloudPrepare.Status.AppliedCloudPrepareConfig).To(Equal(knitnet.Status.AppliedCloudPrepareConfig))
+	})
+
+	It("Should keep every status field", func() {
+		Expect(r.convertToV1beta1(context.TODO(), knitnet)).To(Succeed())
+
+		broker := &operatorv1beta1.KnitnetBroker{}
+		Expect(c.Get(context.TODO(), key, broker)).To(Succeed())
+		Expect(broker.Status.ObservedGeneration).To(Equal(knitnet.Status.ObservedGeneration))
+		Expect(broker.Status.IPSecPSK).To(Equal(knitnet.Status.IPSecPSK))
+		Expect(broker.Status.Conditions).To(Equal([]metav1.Condition{
+			condition(operatorv1alpha1.ConditionReady),
+			condition(operatorv1alpha1.ConditionBrokerDeployed),
+		}))
+
+		join := &operatorv1beta1.KnitnetJoin{}
+		Expect(c.Get(context.TODO(), key, join)).To(Succeed())
+		Expect(join.Spec.ClusterID).To(Equal(knitnet.Status.ClusterID))
+		Expect(join.Status.ObservedGeneration).To(Equal(knitnet.Status.ObservedGeneration))
+		Expect(join.Status.ClusterID).To(Equal(knitnet.Status.ClusterID))
+		Expect(join.Status.GatewayLoadBalancerAddress).To(Equal(knitnet.Status.GatewayLoadBalancerAddress))
+		Expect(join.Status.Network).To(Equal(knitnet.Status.Discovery.Network))
+		Expect(join.Status.Conditions).To(Equal([]metav1.Condition{
+			condition(operatorv1alpha1.ConditionReady),
+			condition(operatorv1alpha1.ConditionBrokerConnected),
+		}))
+
+		cloudPrepare := &operatorv1beta1.KnitnetCloudPrepare{}
+		Expect(c.Get(context.TODO(), key, cloudPrepare)).To(Succeed())
+		Expect(cloudPrepare.Status.ObservedGeneration).To(Equal(knitnet.Status.ObservedGeneration))
+		Expect(cloudPrepare.Status.CloudPlatform).To(Equal(knitnet.Status.Discovery.CloudPlatform))
+		Expect(cloudPrepare.Status.Conditions).To(Equal([]metav1.Condition{
+			condition(operatorv1alpha1.ConditionReady),
+			condition(operatorv1alpha1.ConditionCloudPortsOpened),
+		}))
+
+		err := c.Get(context.TODO(), key, &operatorv1alpha1.Knitnet{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -18,6 +18,7 @@ package controllers
 
 import (
 	submarinerv1a1 "github.com/submariner-io/submariner-operator/apis/submariner/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/klog/v2"
 
 	"github.com/tkestack/knitnet-operator/controllers/checker"
@@ -44,58 +45,68 @@ func (r *KnitnetReconciler) DeploySubmerinerBroker(instance *operatorv1alpha1.Kn
 	}
 
 	brokerConfig := &instance.Spec.BrokerConfig
-	if valid, err := isValidGlobalnetConfig(instance); !valid {
+	if err := operatorv1alpha1.ValidateBrokerConfig(brokerConfig, field.NewPath("spec", "brokerConfig")).ToAggregate(); err != nil {
 		klog.Errorf("Invalid GlobalCIDR configuration: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerDeployed, operatorv1alpha1.ReasonInvalidConfiguration, err)
 		return err
 	}
 
 	klog.Info("Setting up broker RBAC")
 	if err := broker.Ensure(r.Client, r.Config, brokerConfig.ServiceDiscoveryEnabled, brokerConfig.GlobalnetEnable, false); err != nil {
 		klog.Errorf("Error setting up broker RBAC: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerRBACReady, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionBrokerRBACReady, operatorv1alpha1.ReasonSucceeded,
+		"Broker namespace %s, service accounts and roles are ready", consts.SubmarinerBrokerNamespace)
+
 	klog.Info("Deploying the Submariner operator")
 	if err := submarinerop.Ensure(r.Client, r.Config, true); err != nil {
 		klog.Errorf("Error deploying the operator: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerOperatorReady, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionSubmarinerOperatorReady, operatorv1alpha1.ReasonSucceeded,
+		"Submariner operator is deployed in namespace %s", consts.SubmarinerOperatorNamespace)
+
 	klog.Info("Deploying the broker")
 	if err := brokercr.Ensure(r.Client, populateBrokerSpec(instance)); err != nil {
 		klog.Errorf("Broker deployment failed: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerDeployed, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
 
 	if err := broker.CreateGlobalnetConfigMap(r.Client, brokerConfig.GlobalnetEnable, brokerConfig.GlobalnetCIDRRange,
 		brokerConfig.DefaultGlobalnetClusterSize, consts.SubmarinerBrokerNamespace); err != nil {
 		klog.Errorf("Error creating globalCIDR configmap on Broker: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerDeployed, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
 
 	if brokerConfig.GlobalnetEnable {
 		if err := globalnet.ValidateExistingGlobalNetworks(r.Reader, consts.SubmarinerBrokerNamespace); err != nil {
 			klog.Errorf("Error validating existing globalCIDR configmap: %v", err)
+			markConditionFalse(instance, operatorv1alpha1.ConditionBrokerDeployed, operatorv1alpha1.ReasonInvalidConfiguration, err)
 			return err
 		}
 	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionBrokerDeployed, operatorv1alpha1.ReasonSucceeded,
+		"Broker %s is deployed", consts.SubmarinerBrokerName)
 
-	if err := broker.CreateBrokerInfoConfigMap(r.Client, r.Config, instance); err != nil {
-		klog.Errorf("Error writing the broker information: %v", err)
+	ipsecPSK, err := r.ensureIPSecPSK(instance)
+	if err != nil {
+		klog.Errorf("Error ensuring the IPsec PSK: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerInfoPublished, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
-	return nil
-}
-
-func isValidGlobalnetConfig(instance *operatorv1alpha1.Knitnet) (bool, error) {
-	brokerConfig := &instance.Spec.BrokerConfig
-	var err error
-	if !brokerConfig.GlobalnetEnable {
-		return true, nil
-	}
-	defaultGlobalnetClusterSize, err := globalnet.GetValidClusterSize(brokerConfig.GlobalnetCIDRRange, brokerConfig.DefaultGlobalnetClusterSize)
-	if err != nil || defaultGlobalnetClusterSize == 0 {
-		return false, err
+	if err := broker.CreateBrokerInfoSecret(r.Client, r.Reader, r.Config, instance, ipsecPSK); err != nil {
+		klog.Errorf("Error writing the broker information: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerInfoPublished, operatorv1alpha1.ReasonFailed, err)
+		return err
 	}
-	return true, err
+	markConditionTrue(instance, operatorv1alpha1.ConditionBrokerInfoPublished, operatorv1alpha1.ReasonSucceeded,
+		"Broker info is published in %s/%s", consts.SubmarinerBrokerNamespace, consts.SubmarinerBrokerInfo)
+	return r.updateIPSecPSKStatus(instance, ipsecPSK)
 }
 
 func populateBrokerSpec(instance *operatorv1alpha1.Knitnet) submarinerv1a1.BrokerSpec {
@@ -0,0 +1,238 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+const (
+	// SourceOpenShift reports a platform detected from the OpenShift infrastructure
+	SourceOpenShift = "openshift-infrastructure"
+	// SourceProviderID reports a platform detected from the provider IDs of the nodes
+	SourceProviderID = "node-provider-id"
+	// SourceNodeLabels reports a platform detected from the labels of the nodes
+	SourceNodeLabels = "node-labels"
+
+	regionLabel           = "topology.kubernetes.io/region"
+	deprecatedRegionLabel = "failure-domain.beta.kubernetes.io/region"
+)
+
+var (
+	openshift4InfrastructureGVR = schema.GroupVersionResource{
+		Group:    "config.openshift.io",
+		Version:  "v1",
+		Resource: "infrastructures",
+	}
+
+	// openshift4PlatformTypes maps the supported OpenShift platform types to the providers
+	openshift4PlatformTypes = map[string]string{
+		"AWS":   discovery.CloudProviderAWS,
+		"GCP":   discovery.CloudProviderGCP,
+		"Azure": discovery.CloudProviderAzure,
+	}
+
+	providerIDPrefixes = map[string]string{
+		"aws://":    discovery.CloudProviderAWS,
+		"gce://":    discovery.CloudProviderGCP,
+		"azure://":  discovery.CloudProviderAzure,
+		"qcloud://": discovery.CloudProviderTencent,
+	}
+
+	// providerLabelDomains are the domains of the node labels set by the managed clusters of the providers
+	providerLabelDomains = map[string]string{
+		"eks.amazonaws.com":     discovery.CloudProviderAWS,
+		"cloud.google.com":      discovery.CloudProviderGCP,
+		"kubernetes.azure.com":  discovery.CloudProviderAzure,
+		"cloud.tencent.com":     discovery.CloudProviderTencent,
+		"tke.cloud.tencent.com": discovery.CloudProviderTencent,
+	}
+
+	// tencentRegions maps the short region names TKE sets in the region label to the API regions
+	tencentRegions = map[string]string{
+		"bj": "ap-beijing",
+		"cd": "ap-chengdu",
+		"cq": "ap-chongqing",
+		"gz": "ap-guangzhou",
+		"nj": "ap-nanjing",
+		"sh": "ap-shanghai",
+		"hk": "ap-hongkong",
+		"sg": "ap-singapore",
+		"th": "ap-bangkok",
+		"in": "ap-mumbai",
+		"kr": "ap-seoul",
+		"jp": "ap-tokyo",
+		"de": "eu-frankfurt",
+	}
+)
+
+// Platform is the cloud platform the cluster runs on
+type Platform struct {
+	Provider string
+	Region   string
+	// InfraID is the infrastructure name of the cluster, it is only known on OpenShift
+	InfraID string
+	// Source is what the provider was detected from
+	Source string
+}
+
+// Discover detects the cloud platform from the OpenShift infrastructure, then from the provider IDs and
+// the labels of the nodes. It returns nil when the platform is not detected.
+func Discover(dynClient dynamic.Interface, c client.Client) (*Platform, error) {
+	platform, err := discoverOpenShift4Platform(dynClient)
+	if err != nil {
+		return nil, err
+	}
+	nodes := &v1.NodeList{}
+	if err := c.List(context.TODO(), nodes); err != nil {
+		return nil, errors.WithMessage(err, "error listing the nodes")
+	}
+	nodePlatform := discoverNodePlatform(nodes.Items)
+	if platform == nil {
+		return nodePlatform, nil
+	}
+	// The infrastructure has no region on some platforms, e.g. on Azure
+	if platform.Region == "" && nodePlatform != nil && nodePlatform.Provider == platform.Provider {
+		platform.Region = nodePlatform.Region
+	}
+	return platform, nil
+}
+
+func discoverOpenShift4Platform(dynClient dynamic.Interface) (*Platform, error) {
+	if dynClient == nil {
+		return nil, nil
+	}
+	cr, err := dynClient.Resource(openshift4InfrastructureGVR).Get(context.TODO(), "cluster", metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.WithMessage(err, "error obtaining the 'cluster' OpenShift4 Infrastructure resource")
+	}
+	return parseOS4Infrastructure(cr), nil
+}
+
+// parseOS4Infrastructure returns the platform of the infrastructure, or nil when the platform type is not
+// supported. The platform type is read from the deprecated status.platform on older versions.
+func parseOS4Infrastructure(cr *unstructured.Unstructured) *Platform {
+	platformType, _, _ := unstructured.NestedString(cr.Object, "status", "platformStatus", "type")
+	if platformType == "" {
+		platformType, _, _ = unstructured.NestedString(cr.Object, "status", "platform")
+	}
+	provider, ok := openshift4PlatformTypes[platformType]
+	if !ok {
+		return nil
+	}
+	platform := &Platform{Provider: provider, Source: SourceOpenShift}
+	platform.InfraID, _, _ = unstructured.NestedString(cr.Object, "status", "infrastructureName")
+	platform.Region, _, _ = unstructured.NestedString(cr.Object, "status", "platformStatus", strings.ToLower(platformType), "region")
+	return platform
+}
+
+// discoverNodePlatform returns the provider most nodes run on, virtual nodes or nodes of other
+// providers are outvoted. The provider IDs are preferred over the labels.
+func discoverNodePlatform(nodes []v1.Node) *Platform {
+	for _, source := range []string{SourceProviderID, SourceNodeLabels} {
+		votes := map[string]int{}
+		for i := range nodes {
+			if provider := nodeProvider(&nodes[i], source); provider != "" {
+				votes[provider]++
+			}
+		}
+		if len(votes) == 0 {
+			continue
+		}
+		providers := make([]string, 0, len(votes))
+		for provider := range votes {
+			providers = append(providers, provider)
+		}
+		sort.Slice(providers, func(i, j int) bool {
+			if votes[providers[i]] != votes[providers[j]] {
+				return votes[providers[i]] > votes[providers[j]]
+			}
+			return providers[i] < providers[j]
+		})
+		platform := &Platform{Provider: providers[0], Source: source}
+		for i := range nodes {
+			if nodeProvider(&nodes[i], source) == platform.Provider {
+				if platform.Region = nodeRegion(&nodes[i], platform.Provider); platform.Region != "" {
+					break
+				}
+			}
+		}
+		return platform
+	}
+	return nil
+}
+
+func nodeProvider(node *v1.Node, source string) string {
+	if source == SourceProviderID {
+		for prefix, provider := range providerIDPrefixes {
+			if strings.HasPrefix(node.Spec.ProviderID, prefix) {
+				return provider
+			}
+		}
+		return ""
+	}
+	for key := range node.Labels {
+		if i := strings.Index(key, "/"); i > 0 {
+			if provider, ok := providerLabelDomains[key[:i]]; ok {
+				return provider
+			}
+		}
+	}
+	return ""
+}
+
+// nodeRegion returns the API region of the node from the region label, falling back to the zone in
+// the provider ID on AWS
+func nodeRegion(node *v1.Node, provider string) string {
+	region := node.Labels[regionLabel]
+	if region == "" {
+		region = node.Labels[deprecatedRegionLabel]
+	}
+	switch provider {
+	case discovery.CloudProviderTencent:
+		if longRegion, ok := tencentRegions[region]; ok {
+			return longRegion
+		}
+		if !strings.Contains(region, "-") {
+			// An unknown short name
+			return ""
+		}
+	case discovery.CloudProviderAWS:
+		// aws:///us-east-1a/i-0123456789abcdef0
+		parts := strings.Split(strings.TrimPrefix(node.Spec.ProviderID, "aws://"), "/")
+		if region == "" && len(parts) == 3 && len(parts[1]) > 1 {
+			return parts[1][:len(parts[1])-1]
+		}
+	}
+	return region
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+func fakeNode(name, providerID string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: v1meta.ObjectMeta{Name: name, Labels: labels},
+		Spec:       v1.NodeSpec{ProviderID: providerID},
+	}
+}
+
+func newTestClient(nodes ...client.Object) client.Client {
+	return fake.NewClientBuilder().WithObjects(nodes...).Build()
+}
+
+var _ = Describe("Discover", func() {
+	When("The nodes have provider IDs", func() {
+		It("Should detect the provider and the region of AWS", func() {
+			c := newTestClient(
+				fakeNode("worker-a", "aws:///us-east-1a/i-0123", nil),
+				fakeNode("worker-b", "aws:///us-east-1b/i-4567", map[string]string{regionLabel: "us-east-1"}),
+			)
+			platform, err := Discover(nil, c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform).To(Equal(&Platform{Provider: discovery.CloudProviderAWS, Region: "us-east-1", Source: SourceProviderID}))
+		})
+
+		It("Should map the short region names of TKE", func() {
+			c := newTestClient(
+				fakeNode("10.0.0.1", "qcloud:///100003/ins-1", map[string]string{deprecatedRegionLabel: "gz"}),
+				fakeNode("eklet", "eklet://eklet-node", nil),
+			)
+			platform, err := Discover(nil, c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform.Provider).To(Equal(discovery.CloudProviderTencent))
+			Expect(platform.Region).To(Equal("ap-guangzhou"))
+		})
+
+		It("Should pick the provider of most nodes", func() {
+			platform := discoverNodePlatform([]v1.Node{
+				*fakeNode("a", "azure:///subscriptions/s/resourceGroups/g/providers/Microsoft.Compute/virtualMachines/a", nil),
+				*fakeNode("b", "azure:///subscriptions/s/resourceGroups/g/providers/Microsoft.Compute/virtualMachines/b", nil),
+				*fakeNode("c", "gce://project/us-central1-a/c", nil),
+			})
+			Expect(platform.Provider).To(Equal(discovery.CloudProviderAzure))
+		})
+	})
+
+	When("The nodes have only the labels of a managed cluster", func() {
+		It("Should detect the provider from the labels", func() {
+			c := newTestClient(fakeNode("gke-node", "", map[string]string{
+				"cloud.google.com/gke-nodepool": "default-pool",
+				regionLabel:                     "us-central1",
+			}))
+			platform, err := Discover(nil, c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform).To(Equal(&Platform{Provider: discovery.CloudProviderGCP, Region: "us-central1", Source: SourceNodeLabels}))
+		})
+	})
+
+	When("Nothing identifies the provider", func() {
+		It("Should return nil", func() {
+			platform, err := Discover(nil, newTestClient(fakeNode("kind-control-plane", "kind://docker/kind/kind-control-plane", nil)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform).To(BeNil())
+		})
+	})
+
+	When("The cluster runs OpenShift", func() {
+		It("Should read the infra ID and the region of the infrastructure", func() {
+			dynClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), unstructuredParse(getInfrastructureJSON("AWS")))
+			platform, err := Discover(dynClient, newTestClient(fakeNode("worker", "aws:///us-east-2a/i-1", nil)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform).To(Equal(&Platform{
+				Provider: discovery.CloudProviderAWS,
+				Region:   "us-east-2",
+				InfraID:  "cluster-b-x7k2p",
+				Source:   SourceOpenShift,
+			}))
+		})
+
+		It("Should take the region of the nodes when the infrastructure has none", func() {
+			dynClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), unstructuredParse(getInfrastructureJSON("Azure")))
+			platform, err := Discover(dynClient, newTestClient(fakeNode("worker", "azure:///subscriptions/s/resourceGroups/g/providers/Microsoft.Compute/virtualMachines/w",
+				map[string]string{regionLabel: "eastus"})))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform.Provider).To(Equal(discovery.CloudProviderAzure))
+			Expect(platform.Region).To(Equal("eastus"))
+			Expect(platform.InfraID).To(Equal("cluster-b-x7k2p"))
+		})
+
+		It("Should skip the unsupported platforms", func() {
+			Expect(parseOS4Infrastructure(unstructuredParse(getInfrastructureJSON("BareMetal")))).To(BeNil())
+		})
+	})
+})
+
+func unstructuredParse(json []byte) *unstructured.Unstructured {
+	cr := &unstructured.Unstructured{}
+	err := cr.UnmarshalJSON(json)
+	Expect(err).NotTo(HaveOccurred())
+	return cr
+}
+
+func getInfrastructureJSON(platformType string) []byte {
+	return []byte(`
+        {
+            "apiVersion": "config.openshift.io/v1",
+            "kind": "Infrastructure",
+            "metadata": {
+                "name": "cluster"
+            },
+            "status": {
+                "infrastructureName": "cluster-b-x7k2p",
+                "platform": "` + platformType + `",
+                "platformStatus": {
+                    "type": "` + platformType + `",
+                    "aws": {
+                        "region": "us-east-2"
+                    }
+                }
+            }
+        }`)
+}
@@ -9,4 +9,61 @@ const (
 	NetworkPluginOpenShiftSDN  = "OpenShiftSDN"
 	NetworkPluginOVNKubernetes = "OVNKubernetes"
 	NetworkPluginCalico        = "calico"
+	NetworkPluginCilium        = "cilium"
+	NetworkPluginAntrea        = "antrea"
+	NetworkPluginKubeRouter    = "kube-router"
+	NetworkPluginTKE           = "tke"
+)
+
+const (
+	// Network plugin settings discovered
+	CiliumIPAMMode             = "ipam"
+	CiliumKubeProxyReplacement = "kube-proxy-replacement"
+	CiliumTunnel               = "tunnel"
+	CiliumNativeRoutingCIDR    = "ipv4-native-routing-cidr"
+	AntreaTrafficEncapMode     = "trafficEncapMode"
+	AntreaTunnelType           = "tunnelType"
+	AntreaNoSNAT               = "noSNAT"
+	KubeRouterServiceProxy     = "run-service-proxy"
+	KubeRouterRouter           = "run-router"
+	KubeRouterFirewall         = "run-firewall"
+	KubeRouterEnableOverlay    = "enable-overlay"
+	KubeRouterOverlayType      = "overlay-type"
+	TKENetworkMode             = "networkMode"
+	TKEOverlapLikely           = "overlapLikely"
+	Distribution               = "distribution"
+	FlannelBackend             = "flannelBackend"
+)
+
+const (
+	// TKE networking modes
+	TKENetworkModeGlobalRouter = "GlobalRouter"
+	TKENetworkModeVPCCNI       = "VPC-CNI"
+	TKENetworkModeGalaxy       = "Galaxy"
+)
+
+const (
+	// Kubernetes distributions running an embedded network plugin
+	DistributionK3s  = "k3s"
+	DistributionRKE2 = "rke2"
+)
+
+const (
+	// Sources of the service CIDRs
+	ServiceCIDRSourceNetworkPlugin     = "network-plugin"
+	ServiceCIDRSourceAPIServer         = "kube-apiserver"
+	ServiceCIDRSourceKubeadmConfig     = "kubeadm-config"
+	ServiceCIDRSourceNodeAnnotations   = "node-annotations"
+	ServiceCIDRSourceServiceCIDRAPI    = "servicecidr-api"
+	ServiceCIDRSourceKubernetesService = "kubernetes-service"
+	ServiceCIDRSourceServiceCreation   = "service-creation"
+	ServiceCIDRSourceJoinConfig        = "join-config"
+)
+
+const (
+	// Cloud providers detected
+	CloudProviderAWS     = "aws"
+	CloudProviderTencent = "tencent"
+	CloudProviderGCP     = "gcp"
+	CloudProviderAzure   = "azure"
 )
@@ -0,0 +1,105 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package globalnet
+
+import (
+	"fmt"
+	"math/bits"
+	"net"
+	"sort"
+)
+
+// Allocator allocates the global CIDRs of the clusters out of the globalnet CIDR range. It is built from
+// the global CIDRs already allocated and never changes, so it is safe for concurrent use and the same
+// input always gives the same allocation.
+type Allocator struct {
+	network     *net.IPNet
+	clusterSize uint
+	// allocated are the global CIDRs of the clusters, ordered by their first IP
+	allocated []CIDR
+}
+
+// NewAllocator returns an allocator of the globalnet CIDR range with the global CIDRs of the clusters
+func NewAllocator(globalnetInfo *GlobalnetInfo) (*Allocator, error) {
+	_, network, err := net.ParseCIDR(globalnetInfo.GlobalnetCidrRange)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GlobalCIDR %s configured", globalnetInfo.GlobalnetCidrRange)
+	}
+	if network.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid GlobalCIDR %s configured, globalnet only supports IPv4", globalnetInfo.GlobalnetCidrRange)
+	}
+
+	allocator := &Allocator{network: network, clusterSize: globalnetInfo.GlobalnetClusterSize}
+	for _, globalNetwork := range globalnetInfo.GlobalCidrInfo {
+		for _, globalCIDR := range globalNetwork.GlobalCIDRs {
+			cidr, err := NewCIDR(globalCIDR)
+			if err != nil {
+				return nil, err
+			}
+			allocator.allocated = append(allocator.allocated, cidr)
+		}
+	}
+	sort.Slice(allocator.allocated, func(i, j int) bool {
+		first, other := ipToUint(allocator.allocated[i].network.IP), ipToUint(allocator.allocated[j].network.IP)
+		return first < other || (first == other && allocator.allocated[i].lastIP < allocator.allocated[j].lastIP)
+	})
+	return allocator, nil
+}
+
+// Allocate returns the first free CIDR of the cluster size of the globalnet info
+func (a *Allocator) Allocate() (string, error) {
+	return a.AllocateSize(a.clusterSize)
+}
+
+// AllocateSize returns the first free CIDR of the range with at least size IPs, it does not overlap with
+// any of the allocated CIDRs and is aligned on its size
+func (a *Allocator) AllocateSize(size uint) (string, error) {
+	if size == 0 {
+		return "", fmt.Errorf("invalid cluster size 0")
+	}
+	ones, totalBits := a.network.Mask.Size()
+	sizeBits := bits.Len(size - 1)
+	if sizeBits > totalBits-ones {
+		return "", fmt.Errorf("cluster size %d does not fit in GlobalCIDR %s", size, a.network)
+	}
+	blockSize := uint(1) << uint(sizeBits)
+
+	first := ipToUint(a.network.IP)
+	last := LastIP(a.network)
+	for candidate := first; candidate <= last && last-candidate >= blockSize-1; {
+		candidateLast := candidate + blockSize - 1
+		overlapping := a.overlapping(candidate, candidateLast)
+		if overlapping == nil {
+			block := net.IPNet{IP: uintToIP(candidate), Mask: net.CIDRMask(totalBits-sizeBits, totalBits)}
+			return block.String(), nil
+		}
+		// The blocks up to the end of the overlapping CIDR overlap as well, skip to the next aligned block
+		candidate = (overlapping.lastIP/blockSize + 1) * blockSize
+	}
+	return "", fmt.Errorf("allocation not available")
+}
+
+// overlapping returns the first allocated CIDR overlapping with the IPs from first to last
+func (a *Allocator) overlapping(first, last uint) *CIDR {
+	for i := range a.allocated {
+		allocated := &a.allocated[i]
+		if ipToUint(allocated.network.IP) <= last && allocated.lastIP >= first {
+			return allocated
+		}
+	}
+	return nil
+}
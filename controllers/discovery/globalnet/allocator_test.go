@@ -0,0 +1,211 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package globalnet
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/quick"
+)
+
+// allocatorInput is a random globalnet configuration, the ranges are small enough to check the
+// allocations against all the blocks of the range
+type allocatorInput struct {
+	globalnetInfo *GlobalnetInfo
+}
+
+func (allocatorInput) Generate(r *rand.Rand, _ int) reflect.Value {
+	prefix := 16 + r.Intn(9)
+	hostMask := uint(1)<<uint(32-prefix) - 1
+	base := uint(r.Uint32()) &^ hostMask
+	network := net.IPNet{IP: uintToIP(base), Mask: net.CIDRMask(prefix, 32)}
+
+	globalnetInfo := &GlobalnetInfo{
+		GlobalnetEnabled:     true,
+		GlobalnetCidrRange:   network.String(),
+		GlobalnetClusterSize: uint(1 + r.Intn(int(hostMask+1)/2)),
+		GlobalCidrInfo:       map[string]*GlobalNetwork{},
+	}
+	for i := r.Intn(8); i > 0; i-- {
+		allocatedPrefix := prefix + 1 + r.Intn(32-prefix)
+		ip := base + uint(r.Intn(int(hostMask)+1))
+		if r.Intn(4) == 0 {
+			// The CIDRs out of the range never overlap with an allocation
+			ip = uint(r.Uint32())
+		}
+		ip &^= uint(1)<<uint(32-allocatedPrefix) - 1
+		clusterID := fmt.Sprintf("cluster%d", i)
+		globalnetInfo.GlobalCidrInfo[clusterID] = &GlobalNetwork{
+			ClusterID:   clusterID,
+			GlobalCIDRs: []string{fmt.Sprintf("%s/%d", uintToIP(ip), allocatedPrefix)},
+		}
+	}
+	return reflect.ValueOf(allocatorInput{globalnetInfo: globalnetInfo})
+}
+
+func (in allocatorInput) String() string {
+	return fmt.Sprintf("range %s, cluster size %d, allocated %v", in.globalnetInfo.GlobalnetCidrRange,
+		in.globalnetInfo.GlobalnetClusterSize, in.allocatedCIDRs())
+}
+
+func (in allocatorInput) allocatedCIDRs() []string {
+	cidrs := []string{}
+	for _, globalNetwork := range in.globalnetInfo.GlobalCidrInfo {
+		cidrs = append(cidrs, globalNetwork.GlobalCIDRs...)
+	}
+	return cidrs
+}
+
+func allocate(t *testing.T, globalnetInfo *GlobalnetInfo) (string, error) {
+	allocator, err := NewAllocator(globalnetInfo)
+	if err != nil {
+		t.Fatalf("error creating the allocator of %s: %v", globalnetInfo.GlobalnetCidrRange, err)
+	}
+	return allocator.Allocate()
+}
+
+func checkProperty(t *testing.T, property func(allocatorInput) bool) {
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAllocatorStaysInsideTheRangeWithoutOverlaps(t *testing.T) {
+	checkProperty(t, func(in allocatorInput) bool {
+		cidr, err := allocate(t, in.globalnetInfo)
+		if err != nil {
+			return true
+		}
+		_, network, _ := net.ParseCIDR(in.globalnetInfo.GlobalnetCidrRange)
+		_, allocated, err := net.ParseCIDR(cidr)
+		if err != nil || allocated.String() != cidr {
+			t.Logf("%s: allocated %q is not an aligned CIDR", in, cidr)
+			return false
+		}
+		if !network.Contains(allocated.IP) || !network.Contains(uintToIP(LastIP(allocated))) {
+			t.Logf("%s: allocated %s is out of the range", in, cidr)
+			return false
+		}
+		if ones, _ := allocated.Mask.Size(); uint(1)<<uint(32-ones) < in.globalnetInfo.GlobalnetClusterSize {
+			t.Logf("%s: allocated %s is smaller than the cluster size", in, cidr)
+			return false
+		}
+		overlapping, err := isOverlappingCIDR(in.allocatedCIDRs(), cidr)
+		if err != nil || overlapping {
+			t.Logf("%s: allocated %s overlaps with an allocated CIDR", in, cidr)
+			return false
+		}
+		return true
+	})
+}
+
+func TestAllocatorAllocatesTheFirstFreeBlock(t *testing.T) {
+	checkProperty(t, func(in allocatorInput) bool {
+		cidr, err := allocate(t, in.globalnetInfo)
+
+		// Check all the blocks of the cluster size in the order of the range
+		_, network, _ := net.ParseCIDR(in.globalnetInfo.GlobalnetCidrRange)
+		sizeBits := 0
+		for uint(1)<<uint(sizeBits) < in.globalnetInfo.GlobalnetClusterSize {
+			sizeBits++
+		}
+		expected := ""
+		for ip := ipToUint(network.IP); ip+uint(1)<<uint(sizeBits)-1 <= LastIP(network); ip += uint(1) << uint(sizeBits) {
+			block := fmt.Sprintf("%s/%d", uintToIP(ip), 32-sizeBits)
+			if overlapping, _ := isOverlappingCIDR(in.allocatedCIDRs(), block); !overlapping {
+				expected = block
+				break
+			}
+		}
+
+		if expected == "" {
+			return err != nil
+		}
+		if err != nil || cidr != expected {
+			t.Logf("%s: allocated %q (%v), expected %s", in, cidr, err, expected)
+			return false
+		}
+		return true
+	})
+}
+
+func TestAllocatorIsDeterministic(t *testing.T) {
+	checkProperty(t, func(in allocatorInput) bool {
+		cidr, err := allocate(t, in.globalnetInfo)
+
+		// The clusters are read in another order from a copy of the info
+		reordered := *in.globalnetInfo
+		reordered.GlobalCidrInfo = map[string]*GlobalNetwork{}
+		for clusterID, globalNetwork := range in.globalnetInfo.GlobalCidrInfo {
+			reordered.GlobalCidrInfo[clusterID] = globalNetwork
+		}
+		again, againErr := allocate(t, &reordered)
+		return cidr == again && (err == nil) == (againErr == nil)
+	})
+}
+
+func TestAllocatorIsSafeForConcurrentUse(t *testing.T) {
+	checkProperty(t, func(in allocatorInput) bool {
+		allocator, err := NewAllocator(in.globalnetInfo)
+		if err != nil {
+			return false
+		}
+		expected, _ := allocator.Allocate()
+
+		results := make([]string, 8)
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], _ = allocator.Allocate()
+			}(i)
+		}
+		wg.Wait()
+		for _, result := range results {
+			if result != expected {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestAllocatorAllocationsDoNotOverlap(t *testing.T) {
+	checkProperty(t, func(in allocatorInput) bool {
+		allocated := in.allocatedCIDRs()
+		// Register the allocations one after the other until the range is exhausted
+		for i := 0; i < 64; i++ {
+			cidr, err := allocate(t, in.globalnetInfo)
+			if err != nil {
+				return true
+			}
+			if overlapping, _ := isOverlappingCIDR(allocated, cidr); overlapping {
+				t.Logf("%s: allocation %d %s overlaps with %v", in, i, cidr, allocated)
+				return false
+			}
+			allocated = append(allocated, cidr)
+			clusterID := fmt.Sprintf("new%d", i)
+			in.globalnetInfo.GlobalCidrInfo[clusterID] = &GlobalNetwork{ClusterID: clusterID, GlobalCIDRs: []string{cidr}}
+		}
+		return true
+	})
+}
@@ -20,7 +20,6 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"math/bits"
 	"net"
 
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -44,31 +43,34 @@ type GlobalNetwork struct {
 	ClusterID   string
 }
 
-type GlobalCIDR struct {
-	cidr              string
-	net               *net.IPNet
-	allocatedClusters []*CIDR
-	allocatedCount    int
-}
-
 type CIDR struct {
 	network *net.IPNet
 	size    int
 	lastIP  uint
 }
 
+// Config is the network config of a joining cluster. ClusterCIDR and ServiceCIDR are the IPv4 CIDRs
+// Submariner connects, the IPv6 CIDRs of a dual-stack cluster are carried along separately.
 type Config struct {
 	NetworkPlugin           string
 	ClusterCIDR             string
 	ClusterID               string
 	GlobalnetCIDR           string
 	ServiceCIDR             string
+	IPv6ClusterCIDRs        []string
+	IPv6ServiceCIDRs        []string
 	GlobalnetClusterSize    uint
 	ClusterCIDRAutoDetected bool
 	ServiceCIDRAutoDetected bool
+	// ServiceCIDRInferred is set when the service CIDR was inferred from the kubernetes service, it contains
+	// the actual service CIDR but can be much larger
+	ServiceCIDRInferred bool
 }
 
-var globalCidr = GlobalCIDR{allocatedCount: 0}
+// IsDualStack reports whether the cluster has IPv6 CIDRs next to the IPv4 ones
+func (c *Config) IsDualStack() bool {
+	return len(c.IPv6ClusterCIDRs) > 0 || len(c.IPv6ServiceCIDRs) > 0
+}
 
 func isOverlappingCIDR(cidrList []string, cidr string) (bool, error) {
 	_, newNet, err := net.ParseCIDR(cidr)
@@ -92,6 +94,9 @@ func NewCIDR(cidr string) (CIDR, error) {
 	if err != nil {
 		return CIDR{}, fmt.Errorf("invalid cidr %q passed as input", cidr)
 	}
+	if network.IP.To4() == nil {
+		return CIDR{}, fmt.Errorf("invalid cidr %q passed as input, globalnet only supports IPv4", cidr)
+	}
 	ones, total := network.Mask.Size()
 	size := total - ones
 	lastIP := LastIP(network)
@@ -107,88 +112,18 @@ func LastIP(network *net.IPNet) uint {
 	return lastIPUint
 }
 
-func allocateByCidr(cidr string) (uint, error) {
-	requestedIP, requestedNetwork, err := net.ParseCIDR(cidr)
-	if err != nil || !globalCidr.net.Contains(requestedIP) {
-		return 0, fmt.Errorf("%s not a valid subnet of %v", cidr, globalCidr.net)
-	}
-
-	var clusterCidr CIDR
-	if clusterCidr, err = NewCIDR(cidr); err != nil {
-		return 0, err
-	}
-
-	if !globalCidr.net.Contains(uintToIP(clusterCidr.lastIP)) {
-		return 0, fmt.Errorf("%s not a valid subnet of %v", cidr, globalCidr.net)
-	}
-	for i := 0; i < globalCidr.allocatedCount; i++ {
-		allocated := globalCidr.allocatedClusters[i]
-		if allocated.network.Contains(requestedIP) {
-			// subset of already allocated, try next
-			return allocated.lastIP, fmt.Errorf("%s subset of already allocated globalCidr %v", cidr, allocated.network)
-		}
-		if requestedNetwork.Contains(allocated.network.IP) {
-			// already allocated is subset of requested, no valid lastIP
-			return clusterCidr.lastIP, fmt.Errorf("%s overlaps with already allocated globalCidr %s", cidr, allocated.network)
-		}
-	}
-	globalCidr.allocatedClusters = append(globalCidr.allocatedClusters, &clusterCidr)
-	globalCidr.allocatedCount++
-	return 0, nil
-}
-
-func allocateByClusterSize(numSize uint) (string, error) {
-	bitSize := bits.LeadingZeros(0) - bits.LeadingZeros(numSize-1)
-	_, totalbits := globalCidr.net.Mask.Size()
-	clusterPrefix := totalbits - bitSize
-	mask := net.CIDRMask(clusterPrefix, totalbits)
-
-	cidr := fmt.Sprintf("%s/%d", globalCidr.net.IP, clusterPrefix)
-
-	last, err := allocateByCidr(cidr)
-	if err != nil && last == 0 {
-		return "", err
-	}
-	for err != nil {
-		nextNet := net.IPNet{
-			IP:   uintToIP(last + 1),
-			Mask: mask,
-		}
-		cidr = nextNet.String()
-		last, err = allocateByCidr(cidr)
-		if err != nil && last == 0 {
-			return "", fmt.Errorf("allocation not available")
-		}
-	}
-	return cidr, nil
-}
-
+// AllocateGlobalCIDR allocates a global CIDR of the cluster size of the globalnet info, see Allocator
 func AllocateGlobalCIDR(globalnetInfo *GlobalnetInfo) (string, error) {
-	globalCidr = GlobalCIDR{allocatedCount: 0, cidr: globalnetInfo.GlobalnetCidrRange}
-	_, network, err := net.ParseCIDR(globalCidr.cidr)
+	allocator, err := NewAllocator(globalnetInfo)
 	if err != nil {
-		return "", fmt.Errorf("invalid GlobalCIDR %s configured", globalCidr.cidr)
-	}
-	globalCidr.net = network
-	for _, globalNetwork := range globalnetInfo.GlobalCidrInfo {
-		for _, otherCluster := range globalNetwork.GlobalCIDRs {
-			otherClusterCIDR, err := NewCIDR(otherCluster)
-			if err != nil {
-				return "", err
-			}
-			globalCidr.allocatedClusters = append(globalCidr.allocatedClusters, &otherClusterCIDR)
-			globalCidr.allocatedCount++
-		}
+		return "", err
 	}
-	return allocateByClusterSize(globalnetInfo.GlobalnetClusterSize)
+	return allocator.Allocate()
 }
 
+// ipToUint converts an IPv4 address, the callers make sure the addresses are IPv4
 func ipToUint(ip net.IP) uint {
-	intIP := ip
-	if len(ip) == 16 {
-		intIP = ip[12:16]
-	}
-	return uint(binary.BigEndian.Uint32(intIP))
+	return uint(binary.BigEndian.Uint32(ip.To4()))
 }
 
 func uintToIP(ip uint) net.IP {
@@ -212,7 +147,7 @@ func GetValidClusterSize(cidrRange string, clusterSize uint) (uint, error) {
 	return clusterSize, nil
 }
 
-//Refer: https://graphics.stanford.edu/~seander/bithacks.html#RoundUpPowerOf2
+// Refer: https://graphics.stanford.edu/~seander/bithacks.html#RoundUpPowerOf2
 func nextPowerOf2(n uint32) uint {
 	n--
 	n |= n >> 1
@@ -267,10 +202,13 @@ func ValidateGlobalnetConfiguration(globalnetInfo *GlobalnetInfo, netconfig Conf
 	}
 
 	if globalnetCIDR != "" {
-		_, _, err := net.ParseCIDR(globalnetCIDR)
+		ip, _, err := net.ParseCIDR(globalnetCIDR)
 		if err != nil {
 			return "", fmt.Errorf("specified globalnet-cidr is invalid: %s", err)
 		}
+		if ip.To4() == nil {
+			return "", fmt.Errorf("specified globalnet-cidr %s is invalid, globalnet only supports IPv4", globalnetCIDR)
+		}
 	}
 
 	if !globalnetInfo.GlobalnetEnabled {
@@ -212,4 +212,23 @@ var _ = Describe("AllocateGlobalCIDR: Fail", func() {
 			Expect(result).To(Equal(""))
 		})
 	})
+
+	When("The globalnet CIDR range is IPv6", func() {
+		result, err := AllocateGlobalCIDR(&GlobalnetInfo{GlobalnetCidrRange: "fd00:242::/48", GlobalnetClusterSize: 8192})
+		It("Should return error", func() {
+			Expect(err).To(MatchError(ContainSubstring("only supports IPv4")))
+		})
+		It("Should not allocate any CIDR", func() {
+			Expect(result).To(Equal(""))
+		})
+	})
+})
+
+var _ = Describe("ValidateGlobalnetConfiguration", func() {
+	When("The globalnet CIDR is IPv6", func() {
+		_, err := ValidateGlobalnetConfiguration(&GlobalnetInfo{GlobalnetEnabled: true}, Config{GlobalnetCIDR: "fd00:242::/64"})
+		It("Should return error", func() {
+			Expect(err).To(MatchError(ContainSubstring("only supports IPv4")))
+		})
+	})
 })
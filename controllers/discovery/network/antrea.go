@@ -0,0 +1,159 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+const (
+	antreaConfigMapName = "antrea-config"
+	antreaAgentName     = "antrea-agent"
+	antreaAgentConfKey  = "antrea-agent.conf"
+
+	antreaDefaultEncapMode  = "encap"
+	antreaDefaultTunnelType = "geneve"
+)
+
+// antreaAgentConfig holds the fields of antrea-agent.conf used by the discovery
+type antreaAgentConfig struct {
+	TrafficEncapMode string `json:"trafficEncapMode"`
+	TunnelType       string `json:"tunnelType"`
+	NoSNAT           bool   `json:"noSNAT"`
+	ServiceCIDR      string `json:"serviceCIDR"`
+	ServiceCIDRv6    string `json:"serviceCIDRv6"`
+}
+
+func discoverAntreaNetwork(c client.Client) (*ClusterNetwork, error) {
+	cm, err := findAntreaConfigMap(c)
+	if err != nil || cm == nil {
+		return nil, err
+	}
+
+	agentConfig := &antreaAgentConfig{}
+	if err := yaml.Unmarshal([]byte(cm.Data[antreaAgentConfKey]), agentConfig); err != nil {
+		klog.Errorf("error parsing %q of the %q ConfigMap: %v", antreaAgentConfKey, cm.Name, err)
+		return nil, err
+	}
+
+	clusterNetwork := &ClusterNetwork{
+		NetworkPlugin:  constants.NetworkPluginAntrea,
+		PluginSettings: map[string]string{},
+	}
+
+	encapMode := agentConfig.TrafficEncapMode
+	if encapMode == "" {
+		encapMode = antreaDefaultEncapMode
+	}
+	clusterNetwork.PluginSettings[constants.AntreaTrafficEncapMode] = encapMode
+	// The tunnel is only used in the encap and hybrid modes
+	if encapMode == antreaDefaultEncapMode || encapMode == "hybrid" {
+		tunnelType := agentConfig.TunnelType
+		if tunnelType == "" {
+			tunnelType = antreaDefaultTunnelType
+		}
+		clusterNetwork.PluginSettings[constants.AntreaTunnelType] = tunnelType
+	}
+	clusterNetwork.PluginSettings[constants.AntreaNoSNAT] = strconv.FormatBool(agentConfig.NoSNAT)
+
+	// Antrea allocates the pod IPs from the pod CIDRs of the nodes
+	podIPRange, err := findPodIPRange(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if podIPRange != "" {
+		clusterNetwork.PodCIDRs = splitCIDRs(podIPRange)
+	}
+
+	for _, serviceCIDR := range []string{agentConfig.ServiceCIDR, agentConfig.ServiceCIDRv6} {
+		if serviceCIDR != "" {
+			clusterNetwork.ServiceCIDRs = append(clusterNetwork.ServiceCIDRs, serviceCIDR)
+		}
+	}
+
+	// The service CIDR is optional in the agent configuration, try to detect it using the generic functions
+	if len(clusterNetwork.ServiceCIDRs) == 0 {
+		clusterIPRange, source, err := findClusterIPRange(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if clusterIPRange != "" {
+			clusterNetwork.ServiceCIDRs = splitCIDRs(clusterIPRange)
+			clusterNetwork.ServiceCIDRSource = source
+		}
+	}
+
+	return clusterNetwork, nil
+}
+
+// findAntreaConfigMap returns the configmap of the antrea agent, the older releases name it with a hash
+// suffix so it is looked up through the volumes of the antrea-agent DaemonSet
+func findAntreaConfigMap(c client.Client) (*v1.ConfigMap, error) {
+	cm := &v1.ConfigMap{}
+	cmKey := types.NamespacedName{Name: antreaConfigMapName, Namespace: "kube-system"}
+	err := c.Get(context.TODO(), cmKey, cm)
+	if err == nil {
+		return cm, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		klog.Errorf("error obtaining the %q ConfigMap: %v", antreaConfigMapName, err)
+		return nil, err
+	}
+
+	ds := &appsv1.DaemonSet{}
+	dsKey := types.NamespacedName{Name: antreaAgentName, Namespace: "kube-system"}
+	if err := c.Get(context.TODO(), dsKey, ds); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		klog.Errorf("error obtaining the %q DaemonSet: %v", antreaAgentName, err)
+		return nil, err
+	}
+
+	for _, volume := range ds.Spec.Template.Spec.Volumes {
+		if volume.ConfigMap == nil {
+			continue
+		}
+		cmKey.Name = volume.ConfigMap.Name
+		if err := c.Get(context.TODO(), cmKey, cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			klog.Errorf("error obtaining the %q ConfigMap: %v", cmKey.Name, err)
+			return nil, err
+		}
+		if _, ok := cm.Data[antreaAgentConfKey]; ok {
+			return cm, nil
+		}
+	}
+
+	// The DaemonSet identifies Antrea even when its configuration can not be read, the defaults apply
+	return &v1.ConfigMap{}, nil
+}
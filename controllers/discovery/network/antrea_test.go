@@ -0,0 +1,110 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+func antreaFakeConfigMap(name, agentConf string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      name,
+			Namespace: "kube-system",
+		},
+		Data: map[string]string{"antrea-agent.conf": agentConf},
+	}
+}
+
+func antreaFakeDaemonSet(configMapName string) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      "antrea-agent",
+			Namespace: "kube-system",
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Volumes: []v1.Volume{{
+						Name: "antrea-config",
+						VolumeSource: v1.VolumeSource{
+							ConfigMap: &v1.ConfigMapVolumeSource{
+								LocalObjectReference: v1.LocalObjectReference{Name: configMapName},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func testAntreaDiscoveryWith(objects ...client.Object) (*ClusterNetwork, error) {
+	objects = append(objects,
+		fakePod("kube-apiserver", []string{"kube-apiserver", "--service-cluster-ip-range=" + testServiceCIDR}, []v1.EnvVar{}),
+		fakeNode("node1", "10.244.1.0/24"))
+	return discoverAntreaNetwork(newTestClient(objects...))
+}
+
+var _ = Describe("discoverAntreaNetwork", func() {
+	When("Antrea can't be found", func() {
+		It("Should return nil cluster network", func() {
+			clusterNet, err := testAntreaDiscoveryWith()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).To(BeNil())
+		})
+	})
+
+	When("the antrea-config ConfigMap sets the service CIDR and the encapsulation mode", func() {
+		It("Should return them with the pod CIDR of the nodes", func() {
+			clusterNet, err := testAntreaDiscoveryWith(antreaFakeConfigMap("antrea-config",
+				"trafficEncapMode: noEncap\nnoSNAT: true\nserviceCIDR: 10.100.0.0/16\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).NotTo(BeNil())
+			Expect(clusterNet.NetworkPlugin).To(Equal("antrea"))
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.244.1.0/24"}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{"10.100.0.0/16"}))
+			Expect(clusterNet.PluginSettings).To(Equal(map[string]string{
+				constants.AntreaTrafficEncapMode: "noEncap",
+				constants.AntreaNoSNAT:           "true",
+			}))
+		})
+	})
+
+	When("the ConfigMap of the antrea-agent DaemonSet has a hash suffix", func() {
+		It("Should apply the defaults and detect the service CIDR", func() {
+			clusterNet, err := testAntreaDiscoveryWith(
+				antreaFakeDaemonSet("antrea-config-5ct9ktb7c6"),
+				antreaFakeConfigMap("antrea-config-5ct9ktb7c6", "# serviceCIDR: 10.96.0.0/12\n"),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{testServiceCIDR}))
+			Expect(clusterNet.PluginSettings).To(Equal(map[string]string{
+				constants.AntreaTrafficEncapMode: "encap",
+				constants.AntreaTunnelType:       "geneve",
+				constants.AntreaNoSNAT:           "false",
+			}))
+		})
+	})
+})
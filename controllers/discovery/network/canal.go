@@ -47,41 +47,49 @@ func discoverCanalFlannelNetwork(c client.Client) (*ClusterNetwork, error) {
 		return nil, errors.WithMessage(err, "error obtaining the \"canal-config\" ConfigMap")
 	}
 
-	podCIDR := extractPodCIDRFromNetConfigJSON(cm)
+	podCIDRs := extractPodCIDRsFromNetConfigJSON(cm)
 
-	if podCIDR == nil {
+	if podCIDRs == nil {
 		return nil, nil
 	}
 
 	clusterNetwork := &ClusterNetwork{
 		NetworkPlugin: constants.NetworkPluginCanalFlannel,
-		PodCIDRs:      []string{*podCIDR},
+		PodCIDRs:      podCIDRs,
 	}
 
 	// Try to detect the service CIDRs using the generic functions
-	clusterIPRange, err := findClusterIPRange(c)
+	clusterIPRange, source, err := findClusterIPRange(c)
 	if err != nil {
 		return nil, err
 	}
 
 	if clusterIPRange != "" {
-		clusterNetwork.ServiceCIDRs = []string{clusterIPRange}
+		clusterNetwork.ServiceCIDRs = splitCIDRs(clusterIPRange)
+		clusterNetwork.ServiceCIDRSource = source
 	}
 
 	return clusterNetwork, nil
 }
 
-func extractPodCIDRFromNetConfigJSON(cm *v1.ConfigMap) *string {
+func extractPodCIDRsFromNetConfigJSON(cm *v1.ConfigMap) []string {
 	netConfJSON := cm.Data["net-conf.json"]
 	if netConfJSON == "" {
 		return nil
 	}
 	var netConf struct {
-		Network string `json:"Network"`
+		Network     string `json:"Network"`
+		IPv6Network string `json:"IPv6Network"`
 		// All the other fields are ignored by Unmarshal
 	}
-	if err := json.Unmarshal([]byte(netConfJSON), &netConf); err == nil {
-		return &netConf.Network
+	if err := json.Unmarshal([]byte(netConfJSON), &netConf); err != nil {
+		return nil
+	}
+	podCIDRs := []string{}
+	for _, network := range []string{netConf.Network, netConf.IPv6Network} {
+		if network != "" {
+			podCIDRs = append(podCIDRs, network)
+		}
 	}
-	return nil
+	return podCIDRs
 }
@@ -0,0 +1,99 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+const (
+	ciliumConfigMapName = "cilium-config"
+
+	ciliumIPAMClusterPool = "cluster-pool"
+)
+
+func discoverCiliumNetwork(c client.Client) (*ClusterNetwork, error) {
+	cm := &v1.ConfigMap{}
+	cmKey := types.NamespacedName{Name: ciliumConfigMapName, Namespace: "kube-system"}
+	err := c.Get(context.TODO(), cmKey, cm)
+
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		klog.Errorf("error obtaining the %q ConfigMap: %v", ciliumConfigMapName, err)
+		return nil, err
+	}
+
+	clusterNetwork := &ClusterNetwork{
+		NetworkPlugin:  constants.NetworkPluginCilium,
+		PluginSettings: map[string]string{},
+	}
+
+	// cluster-pool is the default IPAM mode of Cilium
+	ipamMode := cm.Data["ipam"]
+	if ipamMode == "" {
+		ipamMode = ciliumIPAMClusterPool
+	}
+	clusterNetwork.PluginSettings[constants.CiliumIPAMMode] = ipamMode
+	for _, key := range []string{constants.CiliumKubeProxyReplacement, constants.CiliumTunnel,
+		constants.CiliumNativeRoutingCIDR} {
+		if value := cm.Data[key]; value != "" {
+			clusterNetwork.PluginSettings[key] = value
+		}
+	}
+
+	// The cluster pool CIDRs are only used by the cluster-pool IPAM, in the other modes the pod
+	// IPs come from the node pod CIDRs or from the cloud network
+	if strings.HasPrefix(ipamMode, ciliumIPAMClusterPool) {
+		clusterNetwork.PodCIDRs = append(strings.Fields(cm.Data["cluster-pool-ipv4-cidr"]),
+			strings.Fields(cm.Data["cluster-pool-ipv6-cidr"])...)
+	}
+
+	if len(clusterNetwork.PodCIDRs) == 0 {
+		podIPRange, err := findPodIPRange(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if podIPRange != "" {
+			clusterNetwork.PodCIDRs = splitCIDRs(podIPRange)
+		}
+	}
+
+	// Try to detect the service CIDRs using the generic functions
+	clusterIPRange, source, err := findClusterIPRange(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if clusterIPRange != "" {
+		clusterNetwork.ServiceCIDRs = splitCIDRs(clusterIPRange)
+		clusterNetwork.ServiceCIDRSource = source
+	}
+
+	return clusterNetwork, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+const testServiceCIDR = "10.96.0.0/12"
+
+func ciliumFakeConfigMap(data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      "cilium-config",
+			Namespace: "kube-system",
+		},
+		Data: data,
+	}
+}
+
+func testCiliumDiscoveryWith(objects ...client.Object) (*ClusterNetwork, error) {
+	objects = append(objects, fakePod("kube-apiserver", []string{"kube-apiserver", "--service-cluster-ip-range=" + testServiceCIDR}, []v1.EnvVar{}))
+	return discoverCiliumNetwork(newTestClient(objects...))
+}
+
+var _ = Describe("discoverCiliumNetwork", func() {
+	When("the cilium-config ConfigMap can't be found", func() {
+		It("Should return nil cluster network", func() {
+			clusterNet, err := testCiliumDiscoveryWith()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).To(BeNil())
+		})
+	})
+
+	When("Cilium uses the cluster-pool IPAM", func() {
+		It("Should return the cluster pool CIDRs of both families", func() {
+			clusterNet, err := testCiliumDiscoveryWith(ciliumFakeConfigMap(map[string]string{
+				"ipam":                   "cluster-pool",
+				"cluster-pool-ipv4-cidr": "10.0.0.0/8",
+				"cluster-pool-ipv6-cidr": "fd00::/104",
+				"kube-proxy-replacement": "strict",
+				"tunnel":                 "vxlan",
+			}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).NotTo(BeNil())
+			Expect(clusterNet.NetworkPlugin).To(Equal("cilium"))
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.0.0.0/8", "fd00::/104"}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{testServiceCIDR}))
+			Expect(clusterNet.PluginSettings).To(Equal(map[string]string{
+				constants.CiliumIPAMMode:             "cluster-pool",
+				constants.CiliumKubeProxyReplacement: "strict",
+				constants.CiliumTunnel:               "vxlan",
+			}))
+		})
+
+		It("Should default the IPAM mode and split multiple CIDRs", func() {
+			clusterNet, err := testCiliumDiscoveryWith(ciliumFakeConfigMap(map[string]string{
+				"cluster-pool-ipv4-cidr": "10.0.0.0/16 10.1.0.0/16",
+			}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.0.0.0/16", "10.1.0.0/16"}))
+			Expect(clusterNet.PluginSettings[constants.CiliumIPAMMode]).To(Equal("cluster-pool"))
+		})
+	})
+
+	When("Cilium uses the kubernetes IPAM", func() {
+		It("Should return the pod CIDR of the nodes", func() {
+			clusterNet, err := testCiliumDiscoveryWith(
+				ciliumFakeConfigMap(map[string]string{
+					"ipam":                   "kubernetes",
+					"cluster-pool-ipv4-cidr": "10.0.0.0/8",
+				}),
+				fakeNode("node1", "10.244.1.0/24"),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.244.1.0/24"}))
+			Expect(clusterNet.PluginSettings[constants.CiliumIPAMMode]).To(Equal("kubernetes"))
+		})
+
+		It("Should return the pod CIDRs of both families of a dual-stack node", func() {
+			node := fakeNode("node1", "10.244.1.0/24")
+			node.Spec.PodCIDRs = []string{"10.244.1.0/24", "fd00:10:244:1::/64"}
+			clusterNet, err := testCiliumDiscoveryWith(ciliumFakeConfigMap(map[string]string{"ipam": "kubernetes"}), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.244.1.0/24", "fd00:10:244:1::/64"}))
+		})
+	})
+})
@@ -0,0 +1,295 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+// The names of the detectors which are not named after the network plugin they detect
+const (
+	// openShift4Detector detects the network plugin configured in OpenShift
+	openShift4Detector = "openshift4"
+	// k3sDetector detects the network plugins embedded in k3s and RKE2
+	k3sDetector = "k3s"
+)
+
+// The confidence of a detection is the sum of the scores of its evidence, up to ConfidenceMax
+const (
+	// ConfidenceConfiguration is the score of the configuration of a network plugin, which may be
+	// left over by a previous installation or shared by several plugins
+	ConfidenceConfiguration = 50
+	// ConfidenceRunning is the score of the workloads of a network plugin running in the cluster
+	ConfidenceRunning = 40
+	// ConfidenceComplete is the score of a detection which found both the pod and service CIDRs
+	ConfidenceComplete = 10
+	// ConfidenceMax is the confidence of a network reported by the cluster itself
+	ConfidenceMax = 100
+)
+
+// Detector detects a network plugin and discovers the network of the cluster
+type Detector interface {
+	// Name is the name of the detected network plugin
+	Name() string
+	// Detect returns the evidence of the network plugin found in the cluster, nil when there is none
+	Detect(dynClient dynamic.Interface, c client.Client) (*Detection, error)
+}
+
+// Detection is the network discovered by a detector, with the evidence it was found from
+type Detection struct {
+	Detector string
+	Network  *ClusterNetwork
+	// Confidence is the confidence in the detection, from 0 to ConfidenceMax
+	Confidence int
+	Evidence   []string
+}
+
+// Report is the result of running all the detectors of a registry
+type Report struct {
+	// Detections are the detections of the network plugins, the best match first
+	Detections []Detection
+	// Errors are the errors of the detectors which failed, by detector name
+	Errors map[string]string
+}
+
+// Best returns the best match, nil when no network plugin was detected
+func (r *Report) Best() *Detection {
+	if r == nil || len(r.Detections) == 0 {
+		return nil
+	}
+	return &r.Detections[0]
+}
+
+// RunnerUp returns the second best match, nil when there is none
+func (r *Report) RunnerUp() *Detection {
+	if r == nil || len(r.Detections) < 2 {
+		return nil
+	}
+	return &r.Detections[1]
+}
+
+// Registry runs the detectors of the network plugins
+type Registry struct {
+	detectors []Detector
+}
+
+// NewRegistry returns a registry with the given detectors
+func NewRegistry(detectors ...Detector) *Registry {
+	return &Registry{detectors: detectors}
+}
+
+// NewDefaultRegistry returns a registry with the detectors of all the supported network plugins
+func NewDefaultRegistry() *Registry {
+	return NewRegistry(DefaultDetectors()...)
+}
+
+// Register adds detectors to the registry, the detections with the same confidence are ordered as
+// their detectors were registered
+func (r *Registry) Register(detectors ...Detector) {
+	r.detectors = append(r.detectors, detectors...)
+}
+
+// Detect runs all the detectors and scores their detections. A failing detector does not prevent the
+// others from detecting their plugin, the errors are only returned when nothing was detected.
+func (r *Registry) Detect(dynClient dynamic.Interface, c client.Client) (*Report, error) {
+	report := &Report{}
+	errs := []error{}
+	for _, detector := range r.detectors {
+		detection, err := detector.Detect(dynClient, c)
+		if err != nil {
+			klog.Errorf("error detecting the %s network plugin: %v", detector.Name(), err)
+			if report.Errors == nil {
+				report.Errors = map[string]string{}
+			}
+			report.Errors[detector.Name()] = err.Error()
+			errs = append(errs, err)
+			continue
+		}
+		if detection == nil || detection.Network == nil {
+			continue
+		}
+		if detection.Detector == "" {
+			detection.Detector = detector.Name()
+		}
+		if detection.Confidence > ConfidenceMax {
+			detection.Confidence = ConfidenceMax
+		}
+		report.Detections = append(report.Detections, *detection)
+	}
+
+	sort.SliceStable(report.Detections, func(i, j int) bool {
+		return report.Detections[i].Confidence > report.Detections[j].Confidence
+	})
+
+	if len(report.Detections) == 0 && len(errs) > 0 {
+		return report, utilerrors.NewAggregate(errs)
+	}
+	return report, nil
+}
+
+// DefaultDetectors returns the detectors of all the supported network plugins, ordered by priority
+func DefaultDetectors() []Detector {
+	return []Detector{
+		&pluginDetector{
+			name:       openShift4Detector,
+			confidence: ConfidenceMax,
+			evidence:   "the OpenShift Network config \"cluster\"",
+			discover: func(dynClient dynamic.Interface, _ client.Client) (*ClusterNetwork, error) {
+				return discoverOpenShift4Network(dynClient)
+			},
+		},
+		&pluginDetector{
+			name:       constants.NetworkPluginTKE,
+			confidence: ConfidenceConfiguration + ConfidenceRunning,
+			evidence:   "the TKE network mode",
+			discover:   withClient(discoverTKENetwork),
+		},
+		&pluginDetector{
+			name:       k3sDetector,
+			confidence: ConfidenceConfiguration + ConfidenceRunning,
+			evidence:   "the k3s or RKE2 node arguments",
+			discover:   withClient(discoverK3sNetwork),
+		},
+		&pluginDetector{
+			name:       constants.NetworkPluginWeaveNet,
+			confidence: ConfidenceConfiguration + ConfidenceRunning,
+			evidence:   "the IPALLOC_RANGE of a weave-net pod",
+			discover:   withClient(discoverWeaveNetwork),
+		},
+		&pluginDetector{
+			name:       constants.NetworkPluginCanalFlannel,
+			confidence: ConfidenceConfiguration,
+			evidence:   "the kube-system/canal-config ConfigMap",
+			daemonSets: []types.NamespacedName{{Namespace: "kube-system", Name: "canal"}},
+			discover:   withClient(discoverCanalFlannelNetwork),
+		},
+		&pluginDetector{
+			name:       constants.NetworkPluginFlannel,
+			confidence: ConfidenceConfiguration,
+			evidence:   "the kube-system/kube-flannel-cfg ConfigMap",
+			daemonSets: []types.NamespacedName{
+				{Namespace: "kube-system", Name: "kube-flannel-ds"},
+				{Namespace: "kube-flannel", Name: "kube-flannel-ds"},
+			},
+			discover: withClient(discoverFlannelNetwork),
+		},
+		&pluginDetector{
+			name:       constants.NetworkPluginOVNKubernetes,
+			confidence: ConfidenceConfiguration + ConfidenceRunning,
+			evidence:   "an ovnkube-db pod and Service",
+			discover:   withClient(discoverOvnKubernetesNetwork),
+		},
+		&pluginDetector{
+			name:       constants.NetworkPluginCilium,
+			confidence: ConfidenceConfiguration,
+			evidence:   "the kube-system/cilium-config ConfigMap",
+			daemonSets: []types.NamespacedName{{Namespace: "kube-system", Name: "cilium"}},
+			discover:   withClient(discoverCiliumNetwork),
+		},
+		&pluginDetector{
+			name:       constants.NetworkPluginAntrea,
+			confidence: ConfidenceConfiguration,
+			evidence:   "the antrea-agent configuration",
+			daemonSets: []types.NamespacedName{{Namespace: "kube-system", Name: antreaAgentName}},
+			discover:   withClient(discoverAntreaNetwork),
+		},
+		&pluginDetector{
+			name:       constants.NetworkPluginKubeRouter,
+			confidence: ConfidenceConfiguration + ConfidenceRunning,
+			evidence:   "the kube-system/kube-router DaemonSet",
+			discover:   withClient(discoverKubeRouterNetwork),
+		},
+		&pluginDetector{
+			name:       constants.NetworkPluginCalico,
+			confidence: ConfidenceConfiguration,
+			evidence:   "a calico-config ConfigMap",
+			daemonSets: []types.NamespacedName{
+				{Namespace: "kube-system", Name: "calico-node"},
+				{Namespace: "calico-system", Name: "calico-node"},
+			},
+			discover: withClient(discoverCalicoNetwork),
+		},
+	}
+}
+
+// pluginDetector scores the network found by the discovery function of a network plugin, its
+// confidence is raised when the DaemonSets of the plugin are found
+type pluginDetector struct {
+	name string
+	// confidence is the score of the evidence the discovery function relies on
+	confidence int
+	evidence   string
+	daemonSets []types.NamespacedName
+	discover   func(dynClient dynamic.Interface, c client.Client) (*ClusterNetwork, error)
+}
+
+func withClient(discover func(c client.Client) (*ClusterNetwork, error)) func(dynamic.Interface, client.Client) (*ClusterNetwork, error) {
+	return func(_ dynamic.Interface, c client.Client) (*ClusterNetwork, error) {
+		return discover(c)
+	}
+}
+
+func (d *pluginDetector) Name() string {
+	return d.name
+}
+
+func (d *pluginDetector) Detect(dynClient dynamic.Interface, c client.Client) (*Detection, error) {
+	clusterNetwork, err := d.discover(dynClient, c)
+	if err != nil || clusterNetwork == nil {
+		return nil, err
+	}
+
+	detection := &Detection{
+		Detector:   d.name,
+		Network:    clusterNetwork,
+		Confidence: d.confidence,
+		Evidence:   []string{d.evidence},
+	}
+
+	for _, key := range d.daemonSets {
+		ds := &appsv1.DaemonSet{}
+		if err := c.Get(context.TODO(), key, ds); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			klog.Errorf("error obtaining the %q DaemonSet: %v", key.Name, err)
+			return nil, err
+		}
+		detection.Confidence += ConfidenceRunning
+		detection.Evidence = append(detection.Evidence, fmt.Sprintf("the %s DaemonSet", key))
+		break
+	}
+
+	if clusterNetwork.IsComplete() {
+		detection.Confidence += ConfidenceComplete
+		detection.Evidence = append(detection.Evidence, "the pod and service CIDRs")
+	}
+
+	return detection, nil
+}
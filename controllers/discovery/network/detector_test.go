@@ -0,0 +1,148 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+func fakeConfigMap(namespace, name string, data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: data,
+	}
+}
+
+func fakeDaemonSet(namespace, name string) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+}
+
+// testDetector is a detector returning a fixed detection
+type testDetector struct {
+	name       string
+	confidence int
+	err        error
+}
+
+func (d *testDetector) Name() string {
+	return d.name
+}
+
+func (d *testDetector) Detect(_ dynamic.Interface, _ client.Client) (*Detection, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &Detection{
+		Network:    &ClusterNetwork{NetworkPlugin: d.name, PodCIDRs: []string{"10.0.0.0/16"}, ServiceCIDRs: []string{"10.1.0.0/16"}},
+		Confidence: d.confidence,
+		Evidence:   []string{"a test"},
+	}, nil
+}
+
+var _ = Describe("Registry", func() {
+	When("a Calico cluster also has the canal-config ConfigMap", func() {
+		var (
+			clusterNet *ClusterNetwork
+			report     *Report
+		)
+
+		BeforeEach(func() {
+			var err error
+			clusterNet, report, err = Discover(nil, newTestClient(
+				fakeConfigMap("kube-system", "canal-config", map[string]string{"net-conf.json": `{"Network": "10.244.0.0/16"}`}),
+				fakeConfigMap("kube-system", "calico-config", map[string]string{}),
+				fakeDaemonSet("kube-system", "calico-node"),
+				fakePod("kube-apiserver", []string{"kube-apiserver", "--service-cluster-ip-range=" + testServiceCIDR}, []v1.EnvVar{}),
+				fakeNode("node1", "10.244.1.0/24")), "default", false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should select Calico, which is running", func() {
+			Expect(clusterNet).NotTo(BeNil())
+			Expect(clusterNet.NetworkPlugin).To(Equal(constants.NetworkPluginCalico))
+			Expect(report.Best().Confidence).To(Equal(ConfidenceMax))
+			Expect(report.Best().Evidence).To(ContainElement("the kube-system/calico-node DaemonSet"))
+		})
+
+		It("Should keep the evidence of Canal as the runner-up", func() {
+			runnerUp := report.RunnerUp()
+			Expect(runnerUp).NotTo(BeNil())
+			Expect(runnerUp.Detector).To(Equal(constants.NetworkPluginCanalFlannel))
+			Expect(runnerUp.Confidence).To(Equal(ConfidenceConfiguration + ConfidenceComplete))
+			Expect(runnerUp.Evidence).To(ContainElement("the kube-system/canal-config ConfigMap"))
+		})
+	})
+
+	When("detections have the same confidence", func() {
+		It("Should prefer the detector registered first", func() {
+			registry := NewRegistry(&testDetector{name: "first", confidence: 50})
+			registry.Register(&testDetector{name: "second", confidence: 50}, &testDetector{name: "third", confidence: 60})
+			report, err := registry.Detect(nil, newTestClient())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Detections).To(HaveLen(3))
+			Expect(report.Best().Detector).To(Equal("third"))
+			Expect(report.RunnerUp().Detector).To(Equal("first"))
+		})
+	})
+
+	When("a detector fails", func() {
+		It("Should report the error and select the detection of another detector", func() {
+			registry := NewRegistry(&testDetector{name: "broken", err: errors.New("forbidden")},
+				&testDetector{name: "custom", confidence: 150})
+			clusterNet, report, err := registry.Discover(nil, newTestClient(), "default", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet.NetworkPlugin).To(Equal("custom"))
+			Expect(report.Best().Confidence).To(Equal(ConfidenceMax))
+			Expect(report.Errors).To(Equal(map[string]string{"broken": "forbidden"}))
+		})
+
+		It("Should return the error when nothing was detected", func() {
+			registry := NewRegistry(&testDetector{name: "broken", err: errors.New("forbidden")})
+			_, _, err := registry.Discover(nil, newTestClient(), "default", false)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("no detector finds its network plugin", func() {
+		It("Should fall back to the generic discovery", func() {
+			clusterNet, report, err := NewRegistry().Discover(nil, newTestClient(
+				fakePod("kube-apiserver", []string{"kube-apiserver", "--service-cluster-ip-range=" + testServiceCIDR}, []v1.EnvVar{}),
+				fakeNode("node1", "10.244.1.0/24")), "default", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Best()).To(BeNil())
+			Expect(clusterNet.NetworkPlugin).To(Equal(constants.NetworkPluginGeneric))
+		})
+	})
+})
@@ -41,25 +41,26 @@ func discoverFlannelNetwork(c client.Client) (*ClusterNetwork, error) {
 		return nil, err
 	}
 
-	podCIDR := extractPodCIDRFromNetConfigJSON(cm)
+	podCIDRs := extractPodCIDRsFromNetConfigJSON(cm)
 
-	if podCIDR == nil {
+	if podCIDRs == nil {
 		return nil, nil
 	}
 
 	clusterNetwork := &ClusterNetwork{
 		NetworkPlugin: constants.NetworkPluginFlannel,
-		PodCIDRs:      []string{*podCIDR},
+		PodCIDRs:      podCIDRs,
 	}
 
 	// Try to detect the service CIDRs using the generic functions
-	clusterIPRange, err := findClusterIPRange(c)
+	clusterIPRange, source, err := findClusterIPRange(c)
 	if err != nil {
 		return nil, err
 	}
 
 	if clusterIPRange != "" {
-		clusterNetwork.ServiceCIDRs = []string{clusterIPRange}
+		clusterNetwork.ServiceCIDRs = splitCIDRs(clusterIPRange)
+		clusterNetwork.ServiceCIDRSource = source
 	}
 
 	return clusterNetwork, nil
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strings"
 
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
@@ -54,16 +55,17 @@ func discoverNetwork(c client.Client) (*ClusterNetwork, error) {
 	}
 
 	if podIPRange != "" {
-		clusterNetwork.PodCIDRs = []string{podIPRange}
+		clusterNetwork.PodCIDRs = splitCIDRs(podIPRange)
 	}
 
-	clusterIPRange, err := findClusterIPRange(c)
+	clusterIPRange, source, err := findClusterIPRange(c)
 	if err != nil {
 		return nil, err
 	}
 
 	if clusterIPRange != "" {
-		clusterNetwork.ServiceCIDRs = []string{clusterIPRange}
+		clusterNetwork.ServiceCIDRs = splitCIDRs(clusterIPRange)
+		clusterNetwork.ServiceCIDRSource = source
 	}
 
 	if len(clusterNetwork.PodCIDRs) > 0 || len(clusterNetwork.ServiceCIDRs) > 0 {
@@ -73,18 +75,27 @@ func discoverNetwork(c client.Client) (*ClusterNetwork, error) {
 	return nil, nil
 }
 
-func findClusterIPRange(c client.Client) (string, error) {
-	clusterIPRange, err := findClusterIPRangeFromApiserver(c)
-	if err != nil || clusterIPRange != "" {
-		return clusterIPRange, err
-	}
-
-	clusterIPRange, err = findClusterIPRangeFromServiceCreation(c)
-	if err != nil || clusterIPRange != "" {
-		return clusterIPRange, err
+// findClusterIPRange returns the service CIDRs and the source they were found in. The probe creating an
+// invalid service is not tried here, it is an opt-in of Discover.
+func findClusterIPRange(c client.Client) (string, string, error) {
+	sources := []struct {
+		name string
+		find func(client.Client) (string, error)
+	}{
+		{constants.ServiceCIDRSourceAPIServer, findClusterIPRangeFromApiserver},
+		{constants.ServiceCIDRSourceKubeadmConfig, findClusterIPRangeFromKubeadmConfig},
+		{constants.ServiceCIDRSourceNodeAnnotations, findClusterIPRangeFromNodeAnnotations},
+		{constants.ServiceCIDRSourceServiceCIDRAPI, findClusterIPRangeFromServiceCIDRAPI},
+		{constants.ServiceCIDRSourceKubernetesService, findClusterIPRangeFromKubernetesService},
+	}
+	for _, source := range sources {
+		clusterIPRange, err := source.find(c)
+		if err != nil || clusterIPRange != "" {
+			return clusterIPRange, source.name, err
+		}
 	}
 
-	return "", nil
+	return "", "", nil
 }
 
 func findClusterIPRangeFromApiserver(c client.Client) (string, error) {
@@ -186,6 +197,10 @@ func findPodIPRangeFromNodeSpec(c client.Client) (string, error) {
 
 func parseToPodCidr(nodes []v1.Node) (string, error) {
 	for _, node := range nodes {
+		// PodCIDRs holds the CIDR of each family on dual-stack clusters
+		if len(node.Spec.PodCIDRs) > 0 {
+			return strings.Join(node.Spec.PodCIDRs, ","), nil
+		}
 		if node.Spec.PodCIDR != "" {
 			return node.Spec.PodCIDR, nil
 		}
@@ -193,3 +208,10 @@ func parseToPodCidr(nodes []v1.Node) (string, error) {
 
 	return "", nil
 }
+
+// splitCIDRs splits a list of CIDRs separated by commas or spaces
+func splitCIDRs(cidrs string) []string {
+	return strings.FieldsFunc(cidrs, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+const (
+	flannelBackendTypeAnnotation = "flannel.alpha.coreos.com/backend-type"
+	flannelPublicIPAnnotation    = "flannel.alpha.coreos.com/public-ip"
+	flannelDefaultBackend        = "vxlan"
+
+	// The CIDRs k3s and RKE2 use when the server arguments don't set them
+	k3sDefaultClusterCIDR = "10.42.0.0/16"
+	k3sDefaultServiceCIDR = "10.43.0.0/16"
+
+	rke2DefaultCNI = "canal"
+)
+
+// k3sNodes are the arguments and flannel annotations of the nodes of a k3s or RKE2 cluster
+type k3sNodes struct {
+	distribution string
+	// serverArgs are the arguments of the first server node, nil when only the agents run as nodes
+	serverArgs []string
+	// flannelBackend is the backend annotated by flannel on the nodes it runs on
+	flannelBackend string
+	nodes          []v1.Node
+}
+
+// discoverK3sNetwork discovers the flannel network embedded in k3s, or the canal network of RKE2.
+// The controller manager and flannel run in the k3s or RKE2 process, the network is read from the
+// arguments and flannel annotations of the nodes.
+func discoverK3sNetwork(c client.Client) (*ClusterNetwork, error) {
+	k3s, err := findK3sNodes(c)
+	if err != nil || k3s == nil {
+		return nil, err
+	}
+
+	clusterNetwork := &ClusterNetwork{
+		PluginSettings: map[string]string{constants.Distribution: k3s.distribution},
+	}
+
+	backend := k3s.flannelBackend
+	switch k3s.distribution {
+	case constants.DistributionK3s:
+		flannelBackend := argValue(k3s.serverArgs, "--flannel-backend")
+		if flannelBackend == "none" {
+			// Another network plugin is installed, it is found by its own detector
+			return nil, nil
+		}
+		if backend == "" {
+			backend = flannelBackend
+		}
+		clusterNetwork.NetworkPlugin = constants.NetworkPluginFlannel
+	case constants.DistributionRKE2:
+		cni := argValue(k3s.serverArgs, "--cni")
+		if cni == "" {
+			cni = rke2DefaultCNI
+		}
+		if !containsString(splitCIDRs(cni), rke2DefaultCNI) {
+			return nil, nil
+		}
+		clusterNetwork.NetworkPlugin = constants.NetworkPluginCanalFlannel
+	}
+	if backend == "" {
+		backend = flannelDefaultBackend
+	}
+	clusterNetwork.PluginSettings[constants.FlannelBackend] = backend
+
+	if k3s.serverArgs != nil {
+		clusterNetwork.PodCIDRs = splitCIDRs(argValueOrDefault(k3s.serverArgs, k3sDefaultClusterCIDR, "--cluster-cidr"))
+		clusterNetwork.ServiceCIDRs = splitCIDRs(argValueOrDefault(k3s.serverArgs, k3sDefaultServiceCIDR, "--service-cidr"))
+		clusterNetwork.ServiceCIDRSource = constants.ServiceCIDRSourceNodeAnnotations
+		return clusterNetwork, nil
+	}
+
+	// The servers don't run as nodes, only the pod CIDRs of the agents and the generic sources are left
+	podIPRange, err := parseToPodCidr(k3s.nodes)
+	if err != nil {
+		return nil, err
+	}
+	clusterNetwork.PodCIDRs = splitCIDRs(podIPRange)
+
+	clusterIPRange, source, err := findClusterIPRange(c)
+	if err != nil {
+		return nil, err
+	}
+	if clusterIPRange != "" {
+		clusterNetwork.ServiceCIDRs = splitCIDRs(clusterIPRange)
+		clusterNetwork.ServiceCIDRSource = source
+	}
+
+	return clusterNetwork, nil
+}
+
+// findK3sNodes returns the k3s or RKE2 nodes, nil when the cluster is not a k3s or RKE2 cluster
+func findK3sNodes(c client.Client) (*k3sNodes, error) {
+	nodes := &v1.NodeList{}
+	if err := c.List(context.TODO(), nodes); err != nil {
+		klog.Errorf("error listing nodes: %v", err)
+		return nil, err
+	}
+
+	k3s := &k3sNodes{nodes: nodes.Items}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		for _, annotation := range nodeArgsAnnotations {
+			value, found := node.Annotations[annotation]
+			if !found {
+				continue
+			}
+			// The annotations are named after the distributions, e.g. k3s.io/node-args
+			k3s.distribution = strings.SplitN(annotation, ".", 2)[0]
+			if args := parseNodeArgs(value); k3s.serverArgs == nil && len(args) > 0 && args[0] == "server" {
+				k3s.serverArgs = args
+			}
+		}
+
+		// flannel annotates the nodes it registered with their backend and public IP
+		if k3s.flannelBackend == "" && node.Annotations[flannelPublicIPAnnotation] != "" {
+			k3s.flannelBackend = node.Annotations[flannelBackendTypeAnnotation]
+		}
+	}
+
+	if k3s.distribution == "" {
+		return nil, nil
+	}
+	return k3s, nil
+}
+
+// argValueOrDefault returns the value of the first of the flags in the arguments, or the default value
+func argValueOrDefault(args []string, defaultValue string, flags ...string) string {
+	if value := argValue(args, flags...); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
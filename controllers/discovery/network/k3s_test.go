@@ -0,0 +1,113 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+func k3sFakeNode(name, argsAnnotation, args string, podCIDRs ...string) *v1.Node {
+	node := fakeNodeWithAnnotations(name, map[string]string{
+		argsAnnotation:               args,
+		flannelBackendTypeAnnotation: "host-gw",
+		flannelPublicIPAnnotation:    "192.168.0.10",
+	})
+	node.Spec.PodCIDRs = podCIDRs
+	return node
+}
+
+func testK3sDiscoveryWith(objects ...client.Object) (*ClusterNetwork, error) {
+	return discoverK3sNetwork(newTestClient(objects...))
+}
+
+var _ = Describe("discoverK3sNetwork", func() {
+	When("the nodes are not k3s or RKE2 nodes", func() {
+		It("Should return nil cluster network", func() {
+			clusterNet, err := testK3sDiscoveryWith(fakeNode("node1", "10.244.1.0/24"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).To(BeNil())
+		})
+	})
+
+	When("a k3s server runs as a node", func() {
+		It("Should return the embedded flannel network of the server arguments", func() {
+			clusterNet, err := testK3sDiscoveryWith(
+				k3sFakeNode("agent", "k3s.io/node-args", `["agent","--server","https://server:6443"]`, "10.52.1.0/24"),
+				k3sFakeNode("server", "k3s.io/node-args",
+					`["server","--cluster-cidr","10.52.0.0/16,fd00:52::/56","--flannel-backend","wireguard"]`, "10.52.0.0/24"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).NotTo(BeNil())
+			Expect(clusterNet.NetworkPlugin).To(Equal(constants.NetworkPluginFlannel))
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.52.0.0/16", "fd00:52::/56"}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{k3sDefaultServiceCIDR}))
+			Expect(clusterNet.ServiceCIDRSource).To(Equal(constants.ServiceCIDRSourceNodeAnnotations))
+			Expect(clusterNet.PluginSettings).To(Equal(map[string]string{
+				constants.Distribution:   constants.DistributionK3s,
+				constants.FlannelBackend: "host-gw",
+			}))
+		})
+	})
+
+	When("k3s runs without flannel", func() {
+		It("Should return nil cluster network", func() {
+			clusterNet, err := testK3sDiscoveryWith(
+				k3sFakeNode("server", "k3s.io/node-args", `["server","--flannel-backend=none"]`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).To(BeNil())
+		})
+	})
+
+	When("only the k3s agents run as nodes", func() {
+		It("Should return the pod CIDRs of the nodes and the inferred service CIDR", func() {
+			clusterNet, err := testK3sDiscoveryWith(
+				k3sFakeNode("agent", "k3s.io/node-args", `["agent"]`, "10.42.1.0/24"),
+				fakeKubernetesService("10.43.0.1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).NotTo(BeNil())
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.42.1.0/24"}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{k3sDefaultServiceCIDR}))
+			Expect(clusterNet.ServiceCIDRSource).To(Equal(constants.ServiceCIDRSourceKubernetesService))
+		})
+	})
+
+	When("an RKE2 server runs the default canal network", func() {
+		It("Should return the canal network with the default CIDRs", func() {
+			clusterNet, err := testK3sDiscoveryWith(
+				k3sFakeNode("server", "rke2.io/node-args", `["server","--service-cidr=10.100.0.0/16"]`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).NotTo(BeNil())
+			Expect(clusterNet.NetworkPlugin).To(Equal(constants.NetworkPluginCanalFlannel))
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{k3sDefaultClusterCIDR}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{"10.100.0.0/16"}))
+			Expect(clusterNet.PluginSettings[constants.Distribution]).To(Equal(constants.DistributionRKE2))
+		})
+	})
+
+	When("an RKE2 server runs another CNI", func() {
+		It("Should return nil cluster network", func() {
+			clusterNet, err := testK3sDiscoveryWith(
+				k3sFakeNode("server", "rke2.io/node-args", `["server","--cni","cilium"]`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,125 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+const kubeRouterName = "kube-router"
+
+// kubeRouterDefaults are the values of the kube-router flags reported as plugin settings when they are not set
+var kubeRouterDefaults = map[string]string{
+	constants.KubeRouterRouter:        "true",
+	constants.KubeRouterFirewall:      "true",
+	constants.KubeRouterServiceProxy:  "false",
+	constants.KubeRouterEnableOverlay: "true",
+	constants.KubeRouterOverlayType:   "subnet",
+}
+
+func discoverKubeRouterNetwork(c client.Client) (*ClusterNetwork, error) {
+	ds := &appsv1.DaemonSet{}
+	dsKey := types.NamespacedName{Name: kubeRouterName, Namespace: "kube-system"}
+	err := c.Get(context.TODO(), dsKey, ds)
+
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		klog.Errorf("error obtaining the %q DaemonSet: %v", kubeRouterName, err)
+		return nil, err
+	}
+
+	args := parseKubeRouterArgs(ds)
+	clusterNetwork := &ClusterNetwork{
+		NetworkPlugin:  constants.NetworkPluginKubeRouter,
+		PluginSettings: map[string]string{},
+	}
+	for setting, defaultValue := range kubeRouterDefaults {
+		clusterNetwork.PluginSettings[setting] = defaultValue
+		if value, ok := args[setting]; ok {
+			clusterNetwork.PluginSettings[setting] = value
+		}
+	}
+
+	if clusterCIDR := args["cluster-cidr"]; clusterCIDR != "" {
+		clusterNetwork.PodCIDRs = splitCIDRs(clusterCIDR)
+	} else {
+		podIPRange, err := findPodIPRange(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if podIPRange != "" {
+			clusterNetwork.PodCIDRs = splitCIDRs(podIPRange)
+		}
+	}
+
+	if serviceIPRange := args["service-cluster-ip-range"]; serviceIPRange != "" {
+		clusterNetwork.ServiceCIDRs = splitCIDRs(serviceIPRange)
+	} else {
+		clusterIPRange, source, err := findClusterIPRange(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if clusterIPRange != "" {
+			clusterNetwork.ServiceCIDRs = splitCIDRs(clusterIPRange)
+			clusterNetwork.ServiceCIDRSource = source
+		}
+	}
+
+	return clusterNetwork, nil
+}
+
+// parseKubeRouterArgs returns the flags of the kube-router container by name without the dashes, a
+// boolean flag given without value is "true"
+func parseKubeRouterArgs(ds *appsv1.DaemonSet) map[string]string {
+	args := map[string]string{}
+	for _, container := range ds.Spec.Template.Spec.Containers {
+		if container.Name != kubeRouterName && len(ds.Spec.Template.Spec.Containers) > 1 {
+			continue
+		}
+		words := []string{}
+		for _, arg := range append(container.Command, container.Args...) {
+			words = append(words, strings.Fields(arg)...)
+		}
+		for i, word := range words {
+			if !strings.HasPrefix(word, "--") {
+				continue
+			}
+			name := strings.TrimPrefix(word, "--")
+			if parts := strings.SplitN(name, "=", 2); len(parts) == 2 {
+				args[parts[0]] = parts[1]
+			} else if i+1 < len(words) && !strings.HasPrefix(words[i+1], "-") {
+				args[name] = words[i+1]
+			} else {
+				args[name] = "true"
+			}
+		}
+	}
+	return args
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+func kubeRouterFakeDaemonSet(args ...string) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      "kube-router",
+			Namespace: "kube-system",
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{
+						Name: "kube-router",
+						Args: args,
+					}},
+				},
+			},
+		},
+	}
+}
+
+func testKubeRouterDiscoveryWith(objects ...client.Object) (*ClusterNetwork, error) {
+	objects = append(objects,
+		fakePod("kube-apiserver", []string{"kube-apiserver", "--service-cluster-ip-range=" + testServiceCIDR}, []v1.EnvVar{}),
+		fakeNode("node1", "10.244.1.0/24"))
+	return discoverKubeRouterNetwork(newTestClient(objects...))
+}
+
+var _ = Describe("discoverKubeRouterNetwork", func() {
+	When("kube-router can't be found", func() {
+		It("Should return nil cluster network", func() {
+			clusterNet, err := testKubeRouterDiscoveryWith()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).To(BeNil())
+		})
+	})
+
+	When("the DaemonSet args set the CIDRs", func() {
+		It("Should return the CIDRs and the modes of the args", func() {
+			clusterNet, err := testKubeRouterDiscoveryWith(kubeRouterFakeDaemonSet(
+				"--run-router=true", "--run-firewall=false", "--run-service-proxy",
+				"--cluster-cidr", "10.32.0.0/12,fd00:10:32::/56", "--service-cluster-ip-range=10.100.0.0/16",
+				"--enable-overlay=false"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).NotTo(BeNil())
+			Expect(clusterNet.NetworkPlugin).To(Equal("kube-router"))
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.32.0.0/12", "fd00:10:32::/56"}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{"10.100.0.0/16"}))
+			Expect(clusterNet.PluginSettings).To(Equal(map[string]string{
+				constants.KubeRouterRouter:        "true",
+				constants.KubeRouterFirewall:      "false",
+				constants.KubeRouterServiceProxy:  "true",
+				constants.KubeRouterEnableOverlay: "false",
+				constants.KubeRouterOverlayType:   "subnet",
+			}))
+		})
+	})
+
+	When("the DaemonSet args don't set the CIDRs", func() {
+		It("Should detect them with the generic functions", func() {
+			clusterNet, err := testKubeRouterDiscoveryWith(kubeRouterFakeDaemonSet("--run-router=true"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.244.1.0/24"}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{testServiceCIDR}))
+			Expect(clusterNet.PluginSettings[constants.KubeRouterServiceProxy]).To(Equal("false"))
+		})
+	})
+})
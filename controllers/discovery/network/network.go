@@ -18,14 +18,21 @@ package network
 
 import (
 	"context"
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
 
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	submariner "github.com/submariner-io/submariner-operator/apis/submariner/v1alpha1"
 
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
 	"github.com/tkestack/knitnet-operator/controllers/ensures/operator/submarinercr"
 )
 
@@ -35,19 +42,19 @@ type ClusterNetwork struct {
 	NetworkPlugin  string
 	GlobalCIDR     string
 	PluginSettings map[string]string
+	// ServiceCIDRSource is where the service CIDRs were found, one of the ServiceCIDRSource constants
+	ServiceCIDRSource string
 }
 
 func (cn *ClusterNetwork) Show() {
 	if cn == nil {
-		fmt.Println("    No network details discovered")
-	} else {
-		fmt.Printf("    Discovered network details:\n")
-		fmt.Printf("        Network plugin:  %s\n", cn.NetworkPlugin)
-		fmt.Printf("        Service CIDRs:   %v\n", cn.ServiceCIDRs)
-		fmt.Printf("        Cluster CIDRs:   %v\n", cn.PodCIDRs)
-		if cn.GlobalCIDR != "" {
-			fmt.Printf("        Global CIDR:     %v\n", cn.GlobalCIDR)
-		}
+		klog.Info("No network details discovered")
+		return
+	}
+	klog.Infof("Discovered network details: network plugin %s, service CIDRs %v (%s), cluster CIDRs %v",
+		cn.NetworkPlugin, cn.ServiceCIDRs, cn.ServiceCIDRSource, cn.PodCIDRs)
+	if cn.GlobalCIDR != "" {
+		klog.Infof("Discovered global CIDR %s", cn.GlobalCIDR)
 	}
 }
 
@@ -55,75 +62,80 @@ func (cn *ClusterNetwork) IsComplete() bool {
 	return cn != nil && len(cn.ServiceCIDRs) > 0 && len(cn.PodCIDRs) > 0
 }
 
-func Discover(dynClient dynamic.Interface, c client.Client, operatorNamespace string) (*ClusterNetwork, error) {
-	discovery, err := networkPluginsDiscovery(dynClient, c)
-	if err != nil {
-		return nil, err
-	}
-
-	if discovery != nil {
-		// TODO: The other branch of this if will not try to find the globalCIDRs
-		globalCIDR, _ := getGlobalCIDRs(c, operatorNamespace)
-		discovery.GlobalCIDR = globalCIDR
-		if discovery.IsComplete() {
-			return discovery, nil
-		}
-
-		// If the info we got from the non-generic plugins is incomplete
-		// try to complete with the generic discovery mechanisms
-		if len(discovery.ServiceCIDRs) == 0 || len(discovery.PodCIDRs) == 0 {
-			genericNet, err := discoverGenericNetwork(c)
-			if err != nil {
-				return nil, err
-			}
-
-			if genericNet != nil {
-				if len(discovery.ServiceCIDRs) == 0 {
-					discovery.ServiceCIDRs = genericNet.ServiceCIDRs
-				}
-				if len(discovery.PodCIDRs) == 0 {
-					discovery.PodCIDRs = genericNet.PodCIDRs
-				}
-			}
-		}
+// Discover returns the network of the cluster found by the default detectors, with the report of all the
+// detections. The service CIDRs are only probed by creating an invalid service when serviceCIDRProbe is set
+// and no other source has them.
+func Discover(dynClient dynamic.Interface, c client.Client, operatorNamespace string, serviceCIDRProbe bool) (*ClusterNetwork, *Report, error) {
+	return NewDefaultRegistry().Discover(dynClient, c, operatorNamespace, serviceCIDRProbe)
+}
 
-		return discovery, nil
+// Discover returns the network of the best match of the detectors of the registry, see Discover
+func (r *Registry) Discover(dynClient dynamic.Interface, c client.Client, operatorNamespace string, serviceCIDRProbe bool) (*ClusterNetwork, *Report, error) {
+	discovery, report, err := r.discover(dynClient, c, operatorNamespace)
+	if err != nil || !serviceCIDRProbe || (discovery != nil && len(discovery.ServiceCIDRs) > 0) {
+		return discovery, report, err
 	}
 
-	// If nothing specific was discovered, use the generic discovery
-	return discoverGenericNetwork(c)
+	clusterIPRange, err := findClusterIPRangeFromServiceCreation(c)
+	if err != nil {
+		return nil, report, err
+	}
+	if discovery == nil {
+		discovery = &ClusterNetwork{NetworkPlugin: constants.NetworkPluginGeneric}
+	}
+	discovery.ServiceCIDRs = splitCIDRs(clusterIPRange)
+	discovery.ServiceCIDRSource = constants.ServiceCIDRSourceServiceCreation
+	return discovery, report, nil
 }
 
-func networkPluginsDiscovery(dynClient dynamic.Interface, c client.Client) (*ClusterNetwork, error) {
-	osClusterNet, err := discoverOpenShift4Network(dynClient)
-	if err != nil || osClusterNet != nil {
-		return osClusterNet, err
+func (r *Registry) discover(dynClient dynamic.Interface, c client.Client, operatorNamespace string) (*ClusterNetwork, *Report, error) {
+	report, err := r.Detect(dynClient, c)
+	if err != nil {
+		return nil, report, err
 	}
 
-	weaveClusterNet, err := discoverWeaveNetwork(c)
-	if err != nil || weaveClusterNet != nil {
-		return weaveClusterNet, err
+	best := report.Best()
+	if best == nil {
+		// If nothing specific was discovered, use the generic discovery
+		discovery, err := discoverGenericNetwork(c)
+		return discovery, report, err
+	}
+	if runnerUp := report.RunnerUp(); runnerUp != nil {
+		klog.Infof("Detected the %s network plugin with confidence %d, the runner-up %s has confidence %d",
+			best.Detector, best.Confidence, runnerUp.Detector, runnerUp.Confidence)
 	}
 
-	canalClusterNet, err := discoverCanalFlannelNetwork(c)
-	if err != nil || canalClusterNet != nil {
-		return canalClusterNet, err
+	discovery := best.Network
+	// The plugins reading the service CIDRs from their own configuration do not set a source
+	if len(discovery.ServiceCIDRs) > 0 && discovery.ServiceCIDRSource == "" {
+		discovery.ServiceCIDRSource = constants.ServiceCIDRSourceNetworkPlugin
 	}
 
-	flannelClusterNet, err := discoverFlannelNetwork(c)
-	if err != nil || flannelClusterNet != nil {
-		return flannelClusterNet, err
+	// TODO: The generic discovery will not try to find the globalCIDRs
+	globalCIDR, _ := getGlobalCIDRs(c, operatorNamespace)
+	discovery.GlobalCIDR = globalCIDR
+	if discovery.IsComplete() {
+		return discovery, report, nil
 	}
 
-	ovnClusterNet, err := discoverOvnKubernetesNetwork(c)
-	if err != nil || ovnClusterNet != nil {
-		return ovnClusterNet, err
+	// If the info we got from the non-generic plugins is incomplete
+	// try to complete with the generic discovery mechanisms
+	genericNet, err := discoverGenericNetwork(c)
+	if err != nil {
+		return nil, report, err
 	}
-	calicoClusterNet, err := discoverCalicoNetwork(c)
-	if err != nil || calicoClusterNet != nil {
-		return calicoClusterNet, err
+
+	if genericNet != nil {
+		if len(discovery.ServiceCIDRs) == 0 {
+			discovery.ServiceCIDRs = genericNet.ServiceCIDRs
+			discovery.ServiceCIDRSource = genericNet.ServiceCIDRSource
+		}
+		if len(discovery.PodCIDRs) == 0 {
+			discovery.PodCIDRs = genericNet.PodCIDRs
+		}
 	}
-	return nil, nil
+
+	return discovery, report, nil
 }
 
 func getGlobalCIDRs(c client.Client, operatorNamespace string) (string, error) {
@@ -136,3 +148,37 @@ func getGlobalCIDRs(c client.Client, operatorNamespace string) (string, error) {
 	globalCIDR := s.Spec.GlobalCIDR
 	return globalCIDR, nil
 }
+
+// Fingerprint identifies the workloads of the network plugins, the DaemonSets out of the ignored namespaces
+// and the k3s or RKE2 node arguments: the network is discovered again when it changes
+func Fingerprint(c client.Client, ignoredNamespaces ...string) (string, error) {
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := c.List(context.TODO(), daemonSets); err != nil {
+		klog.Errorf("error listing DaemonSets: %v", err)
+		return "", err
+	}
+	nodes := &v1.NodeList{}
+	if err := c.List(context.TODO(), nodes); err != nil {
+		klog.Errorf("error listing nodes: %v", err)
+		return "", err
+	}
+
+	keys := []string{}
+	for i := range daemonSets.Items {
+		if containsString(ignoredNamespaces, daemonSets.Items[i].Namespace) {
+			continue
+		}
+		keys = append(keys, daemonSets.Items[i].Namespace+"/"+daemonSets.Items[i].Name)
+	}
+	for i := range nodes.Items {
+		for _, annotation := range nodeArgsAnnotations {
+			if args, found := nodes.Items[i].Annotations[annotation]; found && !containsString(keys, args) {
+				keys = append(keys, args)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	hash := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(hash[:8]), nil
+}
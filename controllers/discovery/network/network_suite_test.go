@@ -21,6 +21,10 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestOpenShift4NetworkDiscovery(t *testing.T) {
@@ -28,32 +32,36 @@ func TestOpenShift4NetworkDiscovery(t *testing.T) {
 	RunSpecs(t, "Network discovery")
 }
 
-// func fakePod(component string, command []string, env []v1.EnvVar) *v1.Pod {
-// 	return fakePodWithName(component, component, command, env)
-// }
+func newTestClient(objects ...client.Object) client.Client {
+	return fake.NewClientBuilder().WithObjects(objects...).Build()
+}
 
-// func fakePodWithName(name, component string, command []string, env []v1.EnvVar) *v1.Pod {
-// 	return fakePodWithNamespace("default", name, component, command, env)
-// }
+func fakePod(component string, command []string, env []v1.EnvVar) *v1.Pod {
+	return fakePodWithName(component, component, command, env)
+}
 
-// func fakePodWithNamespace(namespace, name, component string, command []string, env []v1.EnvVar) *v1.Pod {
-// 	return &v1.Pod{
-// 		ObjectMeta: v1meta.ObjectMeta{
-// 			Namespace: namespace,
-// 			Name:      name,
-// 			Labels:    map[string]string{"component": component, "name": component},
-// 		},
+func fakePodWithName(name, component string, command []string, env []v1.EnvVar) *v1.Pod {
+	return fakePodWithNamespace("default", name, component, command, env)
+}
 
-// 		Spec: v1.PodSpec{
-// 			Containers: []v1.Container{
-// 				{
-// 					Command: command,
-// 					Env:     env,
-// 				},
-// 			},
-// 		},
-// 	}
-// }
+func fakePodWithNamespace(namespace, name, component string, command []string, env []v1.EnvVar) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: v1meta.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{"component": component, "name": component},
+		},
+
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Command: command,
+					Env:     env,
+				},
+			},
+		},
+	}
+}
 
 // func fakeService(namespace, name, component string) *v1.Service {
 // 	return &v1.Service{
@@ -66,13 +74,13 @@ func TestOpenShift4NetworkDiscovery(t *testing.T) {
 // 	}
 // }
 
-// func fakeNode(name, podCIDR string) *v1.Node {
-// 	return &v1.Node{
-// 		ObjectMeta: v1meta.ObjectMeta{
-// 			Name: name,
-// 		},
-// 		Spec: v1.NodeSpec{
-// 			PodCIDR: podCIDR,
-// 		},
-// 	}
-// }
+func fakeNode(name, podCIDR string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1.NodeSpec{
+			PodCIDR: podCIDR,
+		},
+	}
+}
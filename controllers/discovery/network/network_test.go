@@ -0,0 +1,58 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func testFingerprintWith(objects ...client.Object) string {
+	fingerprint, err := Fingerprint(newTestClient(objects...), "submariner-operator")
+	Expect(err).NotTo(HaveOccurred())
+	return fingerprint
+}
+
+var _ = Describe("Fingerprint", func() {
+	var fingerprint string
+
+	BeforeEach(func() {
+		fingerprint = testFingerprintWith(fakeDaemonSet("kube-system", "calico-node"), fakeNode("node1", "10.244.1.0/24"))
+	})
+
+	It("Should be stable", func() {
+		Expect(testFingerprintWith(fakeDaemonSet("kube-system", "calico-node"), fakeNode("node1", "10.244.1.0/24"))).
+			To(Equal(fingerprint))
+	})
+
+	It("Should change with the network plugin DaemonSets", func() {
+		Expect(testFingerprintWith(fakeDaemonSet("kube-system", "cilium"), fakeNode("node1", "10.244.1.0/24"))).
+			NotTo(Equal(fingerprint))
+	})
+
+	It("Should change with the k3s node arguments", func() {
+		Expect(testFingerprintWith(fakeDaemonSet("kube-system", "calico-node"),
+			fakeNodeWithAnnotations("node1", map[string]string{"k3s.io/node-args": `["server"]`}))).NotTo(Equal(fingerprint))
+	})
+
+	It("Should ignore the DaemonSets of the ignored namespaces and the nodes joining", func() {
+		Expect(testFingerprintWith(fakeDaemonSet("kube-system", "calico-node"),
+			fakeDaemonSet("submariner-operator", "submariner-routeagent"),
+			fakeNode("node1", "10.244.1.0/24"), fakeNode("node2", "10.244.2.0/24"))).To(Equal(fingerprint))
+	})
+})
@@ -74,11 +74,11 @@ func discoverOvnKubernetesNetwork(c client.Client) (*ClusterNetwork, error) {
 	cmKey := types.NamespacedName{Name: "ovn-config", Namespace: ovnDBPod.Namespace}
 	if err := c.Get(context.TODO(), cmKey, cm); err == nil {
 		if netCidr, ok := cm.Data["net_cidr"]; ok {
-			clusterNetwork.PodCIDRs = []string{netCidr}
+			clusterNetwork.PodCIDRs = splitCIDRs(netCidr)
 		}
 
 		if svcCidr, ok := cm.Data["svc_cidr"]; ok {
-			clusterNetwork.ServiceCIDRs = []string{svcCidr}
+			clusterNetwork.ServiceCIDRs = splitCIDRs(svcCidr)
 		}
 	}
 
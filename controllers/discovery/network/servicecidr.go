@@ -0,0 +1,199 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	kubeadmConfigMapName        = "kubeadm-config"
+	kubeadmClusterConfiguration = "ClusterConfiguration"
+
+	// serviceCIDRName is the default ServiceCIDR, created by the API server from its flags
+	serviceCIDRName = "kubernetes"
+)
+
+// nodeArgsAnnotations are the node annotations the k3s and RKE2 servers publish their arguments in,
+// which include the arguments of the embedded API server
+var nodeArgsAnnotations = []string{"k3s.io/node-args", "rke2.io/node-args"}
+
+// serviceCIDRVersions are the versions of the ServiceCIDR API, the newest first
+var serviceCIDRVersions = []string{"v1", "v1beta1", "v1alpha1"}
+
+// kubeadmClusterConfig holds the fields of the kubeadm ClusterConfiguration used by the discovery
+type kubeadmClusterConfig struct {
+	Networking struct {
+		ServiceSubnet string `json:"serviceSubnet"`
+	} `json:"networking"`
+}
+
+func findClusterIPRangeFromKubeadmConfig(c client.Client) (string, error) {
+	cm, err := getKubeSystemConfigMap(c, kubeadmConfigMapName)
+	if err != nil || cm == nil {
+		return "", err
+	}
+
+	clusterConfig := &kubeadmClusterConfig{}
+	if err := yaml.Unmarshal([]byte(cm.Data[kubeadmClusterConfiguration]), clusterConfig); err != nil {
+		klog.Errorf("error parsing %q of the %q ConfigMap: %v", kubeadmClusterConfiguration, kubeadmConfigMapName, err)
+		return "", err
+	}
+
+	return clusterConfig.Networking.ServiceSubnet, nil
+}
+
+func findClusterIPRangeFromNodeAnnotations(c client.Client) (string, error) {
+	nodes := &v1.NodeList{}
+	if err := c.List(context.TODO(), nodes); err != nil {
+		klog.Errorf("error listing nodes: %v", err)
+		return "", err
+	}
+
+	for _, node := range nodes.Items {
+		for _, annotation := range nodeArgsAnnotations {
+			args := parseNodeArgs(node.Annotations[annotation])
+			if serviceCIDR := argValue(args, "--service-cidr", "--service-cluster-ip-range"); serviceCIDR != "" {
+				return serviceCIDR, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func findClusterIPRangeFromServiceCIDRAPI(c client.Client) (string, error) {
+	for _, version := range serviceCIDRVersions {
+		serviceCIDR := &unstructured.Unstructured{}
+		serviceCIDR.SetGroupVersionKind(schema.GroupVersionKind{Group: "networking.k8s.io", Version: version, Kind: "ServiceCIDR"})
+		err := c.Get(context.TODO(), types.NamespacedName{Name: serviceCIDRName}, serviceCIDR)
+		if err != nil {
+			// The API is not served by the clusters older than 1.29, or only with the feature gate
+			if meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) || apierrors.IsNotFound(err) {
+				continue
+			}
+			klog.Errorf("error obtaining the %q ServiceCIDR: %v", serviceCIDRName, err)
+			return "", err
+		}
+
+		cidrs, _, err := unstructured.NestedStringSlice(serviceCIDR.Object, "spec", "cidrs")
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(cidrs, ","), nil
+	}
+
+	return "", nil
+}
+
+func findClusterIPRangeFromKubernetesService(c client.Client) (string, error) {
+	service := &v1.Service{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "kubernetes", Namespace: "default"}, service); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		klog.Errorf("error obtaining the \"kubernetes\" Service: %v", err)
+		return "", err
+	}
+
+	clusterIPs := service.Spec.ClusterIPs
+	if len(clusterIPs) == 0 && service.Spec.ClusterIP != "" {
+		clusterIPs = []string{service.Spec.ClusterIP}
+	}
+
+	cidrs := []string{}
+	for _, clusterIP := range clusterIPs {
+		if cidr := inferServiceCIDR(clusterIP); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return strings.Join(cidrs, ","), nil
+}
+
+// inferServiceCIDR infers the service CIDR from the IP of the kubernetes service, which is the first IP
+// of the service CIDR. The largest CIDR the API server accepts starting right before the IP is returned,
+// it contains the actual service CIDR.
+func inferServiceCIDR(clusterIP string) string {
+	ip := net.ParseIP(clusterIP)
+	if ip == nil {
+		return ""
+	}
+	bits, minPrefix := 128, 108
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits, minPrefix = ip4, 32, 12
+	}
+
+	network := make(net.IP, len(ip))
+	copy(network, ip)
+	for i := len(network) - 1; i >= 0; i-- {
+		network[i]--
+		if network[i] != 0xff {
+			break
+		}
+	}
+
+	prefix := bits
+	for prefix > minPrefix && network[(prefix-1)/8]&(0x80>>uint((prefix-1)%8)) == 0 {
+		prefix--
+	}
+	// A service CIDR has more than a handful of IPs, the IP is not the first one of the service CIDR
+	if prefix > bits-4 {
+		return ""
+	}
+	return (&net.IPNet{IP: network, Mask: net.CIDRMask(prefix, bits)}).String()
+}
+
+// parseNodeArgs parses the arguments annotated on a node, a JSON list of strings
+func parseNodeArgs(annotation string) []string {
+	args := []string{}
+	if annotation == "" {
+		return args
+	}
+	if err := json.Unmarshal([]byte(annotation), &args); err != nil {
+		klog.Warningf("error parsing the node arguments %q: %v", annotation, err)
+	}
+	return args
+}
+
+// argValue returns the value of the first of the flags in the arguments, given as --flag=value or --flag value
+func argValue(args []string, flags ...string) string {
+	for i, arg := range args {
+		for _, flag := range flags {
+			if strings.HasPrefix(arg, flag+"=") {
+				return strings.TrimPrefix(arg, flag+"=")
+			}
+			if arg == flag && i+1 < len(args) {
+				return args[i+1]
+			}
+		}
+	}
+	return ""
+}
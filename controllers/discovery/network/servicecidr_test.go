@@ -0,0 +1,147 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+func fakeKubeadmConfigMap(serviceSubnet string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      "kubeadm-config",
+			Namespace: "kube-system",
+		},
+		Data: map[string]string{
+			"ClusterConfiguration": "apiVersion: kubeadm.k8s.io/v1beta2\nkind: ClusterConfiguration\n" +
+				"networking:\n  dnsDomain: cluster.local\n  podSubnet: 10.244.0.0/16\n  serviceSubnet: " + serviceSubnet + "\n",
+		},
+	}
+}
+
+func fakeNodeWithAnnotations(name string, annotations map[string]string) *v1.Node {
+	node := fakeNode(name, "")
+	node.Annotations = annotations
+	return node
+}
+
+func fakeKubernetesService(clusterIPs ...string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      "kubernetes",
+			Namespace: "default",
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP:  clusterIPs[0],
+			ClusterIPs: clusterIPs,
+		},
+	}
+}
+
+func testFindClusterIPRangeWith(objects ...client.Object) (string, string) {
+	clusterIPRange, source, err := findClusterIPRange(newTestClient(objects...))
+	Expect(err).NotTo(HaveOccurred())
+	return clusterIPRange, source
+}
+
+var _ = Describe("findClusterIPRange", func() {
+	When("no source has the service CIDR", func() {
+		It("Should return an empty service CIDR", func() {
+			clusterIPRange, source := testFindClusterIPRangeWith()
+			Expect(clusterIPRange).To(BeEmpty())
+			Expect(source).To(BeEmpty())
+		})
+	})
+
+	When("the kube-apiserver pod has the service CIDR", func() {
+		It("Should prefer it to the other sources", func() {
+			clusterIPRange, source := testFindClusterIPRangeWith(
+				fakePod("kube-apiserver", []string{"kube-apiserver", "--service-cluster-ip-range=" + testServiceCIDR}, []v1.EnvVar{}),
+				fakeKubeadmConfigMap("10.100.0.0/16"),
+				fakeKubernetesService("10.100.0.1"))
+			Expect(clusterIPRange).To(Equal(testServiceCIDR))
+			Expect(source).To(Equal(constants.ServiceCIDRSourceAPIServer))
+		})
+	})
+
+	When("the kubeadm-config ConfigMap has the service CIDR", func() {
+		It("Should return the service subnet of the cluster configuration", func() {
+			clusterIPRange, source := testFindClusterIPRangeWith(
+				fakeKubeadmConfigMap("10.100.0.0/16"),
+				fakeKubernetesService("10.100.0.1"))
+			Expect(clusterIPRange).To(Equal("10.100.0.0/16"))
+			Expect(source).To(Equal(constants.ServiceCIDRSourceKubeadmConfig))
+		})
+	})
+
+	When("the k3s or RKE2 node arguments have the service CIDR", func() {
+		It("Should return the service CIDR of the arguments", func() {
+			clusterIPRange, source := testFindClusterIPRangeWith(
+				fakeNodeWithAnnotations("agent", map[string]string{"k3s.io/node-args": `["agent"]`}),
+				fakeNodeWithAnnotations("server", map[string]string{
+					"rke2.io/node-args": `["server","--cluster-cidr","10.42.0.0/16","--service-cidr","10.43.0.0/16,fd00:43::/112"]`,
+				}))
+			Expect(clusterIPRange).To(Equal("10.43.0.0/16,fd00:43::/112"))
+			Expect(source).To(Equal(constants.ServiceCIDRSourceNodeAnnotations))
+		})
+	})
+
+	When("only the kubernetes Service is available", func() {
+		It("Should infer the service CIDRs from its cluster IPs", func() {
+			clusterIPRange, source := testFindClusterIPRangeWith(fakeKubernetesService("10.96.0.1", "fd00:10:96::1"))
+			Expect(clusterIPRange).To(Equal("10.96.0.0/12,fd00:10:96::/108"))
+			Expect(source).To(Equal(constants.ServiceCIDRSourceKubernetesService))
+		})
+	})
+})
+
+var _ = Describe("inferServiceCIDR", func() {
+	It("Should return the largest CIDR starting right before the cluster IP", func() {
+		Expect(inferServiceCIDR("10.96.0.1")).To(Equal("10.96.0.0/12"))
+		Expect(inferServiceCIDR("172.17.252.1")).To(Equal("172.17.252.0/22"))
+		Expect(inferServiceCIDR("10.100.0.1")).To(Equal("10.100.0.0/14"))
+	})
+
+	It("Should not infer a CIDR from an IP which can't be the first of a service CIDR", func() {
+		Expect(inferServiceCIDR("10.96.0.10")).To(BeEmpty())
+		Expect(inferServiceCIDR("not-an-ip")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Discover", func() {
+	When("no service CIDR is found and the probe is not enabled", func() {
+		It("Should not create a service to find the service CIDR", func() {
+			c := newTestClient(fakeNode("node1", "10.244.1.0/24"))
+			clusterNet, _, err := Discover(nil, c, "default", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).NotTo(BeNil())
+			Expect(clusterNet.ServiceCIDRs).To(BeEmpty())
+
+			services := &v1.ServiceList{}
+			Expect(c.List(context.TODO(), services)).To(Succeed())
+			Expect(services.Items).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,237 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+const (
+	// tkeClusterInfoConfigMap holds the CIDRs and the network type TKE configured the cluster with
+	tkeClusterInfoConfigMap = "tke-cluster-info"
+	tkeClusterCIDRKey       = "clusterCIDR"
+	tkeServiceCIDRKey       = "serviceCIDR"
+	tkeNetworkTypeKey       = "networkType"
+
+	// tkeBridgeAgent programs the routes of the Global Router mode on the nodes
+	tkeBridgeAgent = "tke-bridge-agent"
+	// tkeENIAgent attaches the elastic network interfaces of the VPC-CNI mode to the nodes
+	tkeENIAgent = "tke-eni-agent"
+	// tkeENISubnetsAnnotation lists the VPC subnets the pods of a node get their IPs from in VPC-CNI mode
+	tkeENISubnetsAnnotation = "tke.cloud.tencent.com/eni-subnet-cidrs"
+
+	// galaxyConfigMap is the configmap of the Galaxy daemon, the floating IPs are in galaxyFloatingIPConfigMap
+	galaxyConfigMap           = "galaxy-etc"
+	galaxyFloatingIPConfigMap = "floatingip-config"
+	galaxyFloatingIPsKey      = "floatingips"
+)
+
+// tkeNetworkTypes maps the network types of the cluster info configmap to the networking modes
+var tkeNetworkTypes = map[string]string{
+	"gr":           constants.TKENetworkModeGlobalRouter,
+	"globalrouter": constants.TKENetworkModeGlobalRouter,
+	"vpc-cni":      constants.TKENetworkModeVPCCNI,
+	"galaxy":       constants.TKENetworkModeGalaxy,
+}
+
+// galaxyFloatingIPPool is an entry of the floating IP configuration of Galaxy
+type galaxyFloatingIPPool struct {
+	Subnet string `json:"subnet"`
+}
+
+func discoverTKENetwork(c client.Client) (*ClusterNetwork, error) {
+	clusterInfo, err := getKubeSystemConfigMap(c, tkeClusterInfoConfigMap)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := findTKENetworkMode(c, clusterInfo)
+	if err != nil || mode == "" {
+		return nil, err
+	}
+
+	clusterNetwork := &ClusterNetwork{
+		NetworkPlugin:  constants.NetworkPluginTKE,
+		PluginSettings: map[string]string{constants.TKENetworkMode: mode},
+	}
+	if clusterInfo != nil {
+		clusterNetwork.PodCIDRs = splitCIDRs(clusterInfo.Data[tkeClusterCIDRKey])
+		clusterNetwork.ServiceCIDRs = splitCIDRs(clusterInfo.Data[tkeServiceCIDRKey])
+	}
+
+	switch mode {
+	case constants.TKENetworkModeVPCCNI:
+		// The pods use the IPs of the VPC subnets, which are usually shared with, or overlap the
+		// subnets of the other clusters of the VPC
+		subnets, err := findTKEENISubnets(c)
+		if err != nil {
+			return nil, err
+		}
+		clusterNetwork.PodCIDRs = appendMissing(clusterNetwork.PodCIDRs, subnets...)
+		clusterNetwork.PluginSettings[constants.TKEOverlapLikely] = "true"
+		klog.Warningf("TKE cluster uses VPC-CNI, the pod CIDRs %v are likely to overlap with the other clusters",
+			clusterNetwork.PodCIDRs)
+	case constants.TKENetworkModeGalaxy:
+		subnets, err := findGalaxyFloatingIPSubnets(c)
+		if err != nil {
+			return nil, err
+		}
+		clusterNetwork.PodCIDRs = appendMissing(clusterNetwork.PodCIDRs, subnets...)
+	}
+
+	if len(clusterNetwork.PodCIDRs) == 0 {
+		podIPRange, err := findPodIPRange(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if podIPRange != "" {
+			clusterNetwork.PodCIDRs = splitCIDRs(podIPRange)
+		}
+	}
+
+	if len(clusterNetwork.ServiceCIDRs) == 0 {
+		clusterIPRange, source, err := findClusterIPRange(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if clusterIPRange != "" {
+			clusterNetwork.ServiceCIDRs = splitCIDRs(clusterIPRange)
+			clusterNetwork.ServiceCIDRSource = source
+		}
+	}
+
+	return clusterNetwork, nil
+}
+
+// findTKENetworkMode returns the networking mode of the cluster info configmap, falling back to the
+// TKE network components deployed. An empty mode means the cluster does not use a TKE network.
+func findTKENetworkMode(c client.Client, clusterInfo *v1.ConfigMap) (string, error) {
+	if clusterInfo != nil {
+		if mode, ok := tkeNetworkTypes[strings.ToLower(clusterInfo.Data[tkeNetworkTypeKey])]; ok {
+			return mode, nil
+		}
+	}
+
+	// VPC-CNI can be enabled on top of the Global Router, the pods using it decide about the overlaps
+	for _, component := range []struct{ name, mode string }{
+		{tkeENIAgent, constants.TKENetworkModeVPCCNI},
+		{tkeBridgeAgent, constants.TKENetworkModeGlobalRouter},
+	} {
+		ds := &appsv1.DaemonSet{}
+		dsKey := types.NamespacedName{Name: component.name, Namespace: "kube-system"}
+		if err := c.Get(context.TODO(), dsKey, ds); err == nil {
+			return component.mode, nil
+		} else if !apierrors.IsNotFound(err) {
+			klog.Errorf("error obtaining the %q DaemonSet: %v", component.name, err)
+			return "", err
+		}
+	}
+
+	galaxy, err := getKubeSystemConfigMap(c, galaxyConfigMap)
+	if err != nil {
+		return "", err
+	}
+	if galaxy != nil {
+		return constants.TKENetworkModeGalaxy, nil
+	}
+
+	// A cluster info configmap with an unknown network type still identifies a TKE cluster
+	if clusterInfo != nil {
+		return constants.TKENetworkModeGlobalRouter, nil
+	}
+	return "", nil
+}
+
+// findTKEENISubnets returns the VPC subnets of the VPC-CNI mode annotated on the nodes
+func findTKEENISubnets(c client.Client) ([]string, error) {
+	nodes := &v1.NodeList{}
+	if err := c.List(context.TODO(), nodes); err != nil {
+		klog.Errorf("error listing nodes: %v", err)
+		return nil, err
+	}
+
+	subnets := []string{}
+	for _, node := range nodes.Items {
+		subnets = appendMissing(subnets, splitCIDRs(node.Annotations[tkeENISubnetsAnnotation])...)
+	}
+	return subnets, nil
+}
+
+// findGalaxyFloatingIPSubnets returns the subnets of the floating IP pools of Galaxy
+func findGalaxyFloatingIPSubnets(c client.Client) ([]string, error) {
+	cm, err := getKubeSystemConfigMap(c, galaxyFloatingIPConfigMap)
+	if err != nil || cm == nil {
+		return nil, err
+	}
+
+	pools := []galaxyFloatingIPPool{}
+	if err := json.Unmarshal([]byte(cm.Data[galaxyFloatingIPsKey]), &pools); err != nil {
+		klog.Errorf("error parsing %q of the %q ConfigMap: %v", galaxyFloatingIPsKey, galaxyFloatingIPConfigMap, err)
+		return nil, err
+	}
+
+	subnets := []string{}
+	for _, pool := range pools {
+		if pool.Subnet != "" {
+			subnets = appendMissing(subnets, pool.Subnet)
+		}
+	}
+	return subnets, nil
+}
+
+// getKubeSystemConfigMap returns the configmap of the kube-system namespace, or nil when it does not exist
+func getKubeSystemConfigMap(c client.Client, name string) (*v1.ConfigMap, error) {
+	cm := &v1.ConfigMap{}
+	cmKey := types.NamespacedName{Name: name, Namespace: "kube-system"}
+	if err := c.Get(context.TODO(), cmKey, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		klog.Errorf("error obtaining the %q ConfigMap: %v", name, err)
+		return nil, err
+	}
+	return cm, nil
+}
+
+func appendMissing(cidrs []string, more ...string) []string {
+	for _, cidr := range more {
+		found := false
+		for _, existing := range cidrs {
+			if existing == cidr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	constants "github.com/tkestack/knitnet-operator/controllers/discovery"
+)
+
+func tkeFakeConfigMap(name string, data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      name,
+			Namespace: "kube-system",
+		},
+		Data: data,
+	}
+}
+
+func tkeFakeDaemonSet(name string) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: v1meta.ObjectMeta{
+			Name:      name,
+			Namespace: "kube-system",
+		},
+	}
+}
+
+func tkeFakeNode(name, podCIDR, eniSubnets string) *v1.Node {
+	node := fakeNode(name, podCIDR)
+	node.Annotations = map[string]string{"tke.cloud.tencent.com/eni-subnet-cidrs": eniSubnets}
+	return node
+}
+
+func testTKEDiscoveryWith(objects ...client.Object) (*ClusterNetwork, error) {
+	objects = append(objects, fakePod("kube-apiserver", []string{"kube-apiserver", "--service-cluster-ip-range=" + testServiceCIDR}, []v1.EnvVar{}))
+	return discoverTKENetwork(newTestClient(objects...))
+}
+
+var _ = Describe("discoverTKENetwork", func() {
+	When("no TKE network can be found", func() {
+		It("Should return nil cluster network", func() {
+			clusterNet, err := testTKEDiscoveryWith(fakeNode("node1", "10.244.1.0/24"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).To(BeNil())
+		})
+	})
+
+	When("the cluster info ConfigMap describes a Global Router cluster", func() {
+		It("Should return its CIDRs", func() {
+			clusterNet, err := testTKEDiscoveryWith(tkeFakeConfigMap("tke-cluster-info", map[string]string{
+				"clusterCIDR": "172.16.0.0/16",
+				"serviceCIDR": "172.17.252.0/22",
+				"networkType": "GR",
+			}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet).NotTo(BeNil())
+			Expect(clusterNet.NetworkPlugin).To(Equal("tke"))
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"172.16.0.0/16"}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{"172.17.252.0/22"}))
+			Expect(clusterNet.PluginSettings).To(Equal(map[string]string{
+				constants.TKENetworkMode: constants.TKENetworkModeGlobalRouter,
+			}))
+		})
+	})
+
+	When("the tke-bridge-agent DaemonSet is deployed without cluster info", func() {
+		It("Should detect the Global Router and the CIDRs with the generic functions", func() {
+			clusterNet, err := testTKEDiscoveryWith(tkeFakeDaemonSet("tke-bridge-agent"), fakeNode("node1", "172.16.0.0/26"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet.PluginSettings[constants.TKENetworkMode]).To(Equal(constants.TKENetworkModeGlobalRouter))
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"172.16.0.0/26"}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{testServiceCIDR}))
+		})
+	})
+
+	When("VPC-CNI is enabled", func() {
+		It("Should return the ENI subnets of the nodes and flag the likely overlap", func() {
+			clusterNet, err := testTKEDiscoveryWith(
+				tkeFakeConfigMap("tke-cluster-info", map[string]string{"serviceCIDR": "172.17.252.0/22"}),
+				tkeFakeDaemonSet("tke-eni-agent"),
+				tkeFakeNode("node1", "", "10.0.1.0/24,10.0.2.0/24"),
+				tkeFakeNode("node2", "", "10.0.2.0/24"),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.0.1.0/24", "10.0.2.0/24"}))
+			Expect(clusterNet.ServiceCIDRs).To(Equal([]string{"172.17.252.0/22"}))
+			Expect(clusterNet.PluginSettings).To(Equal(map[string]string{
+				constants.TKENetworkMode:   constants.TKENetworkModeVPCCNI,
+				constants.TKEOverlapLikely: "true",
+			}))
+		})
+	})
+
+	When("Galaxy is deployed", func() {
+		It("Should return the subnets of the floating IP pools", func() {
+			clusterNet, err := testTKEDiscoveryWith(
+				tkeFakeConfigMap("galaxy-etc", map[string]string{"galaxy.json": "{}"}),
+				tkeFakeConfigMap("floatingip-config", map[string]string{
+					"floatingips": `[{"routableSubnet":"10.49.27.0/24","ips":["10.0.70.2~10.0.70.241"],"subnet":"10.0.70.0/24","gateway":"10.0.70.1"}]`,
+				}),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterNet.PluginSettings[constants.TKENetworkMode]).To(Equal(constants.TKENetworkModeGalaxy))
+			Expect(clusterNet.PodCIDRs).To(Equal([]string{"10.0.70.0/24"}))
+		})
+	})
+})
@@ -47,9 +47,10 @@ func discoverWeaveNetwork(c client.Client) (*ClusterNetwork, error) {
 		return nil, nil
 	}
 
-	clusterIPRange, err := findClusterIPRange(c)
+	clusterIPRange, source, err := findClusterIPRange(c)
 	if err == nil && clusterIPRange != "" {
-		clusterNetwork.ServiceCIDRs = []string{clusterIPRange}
+		clusterNetwork.ServiceCIDRs = splitCIDRs(clusterIPRange)
+		clusterNetwork.ServiceCIDRSource = source
 	}
 
 	return clusterNetwork, nil
@@ -18,11 +18,11 @@ package broker
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -42,19 +42,21 @@ import (
 	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
 )
 
+// brokerInfoKey is the key of the encoded broker info in the broker info secret
+const brokerInfoKey = "brokerInfo"
+
 type BrokerInfo struct {
 	BrokerURL                   string     `json:"brokerURL"`
 	ClientToken                 *v1.Secret `json:"clientToken,omitempty"`
 	IPSecPSK                    *v1.Secret `json:"ipsecPSK,omitempty"`
+	IPSecPSKGeneration          int64      `json:"ipsecPSKGeneration,omitempty"`
+	TokenExpirationSeconds      int64      `json:"tokenExpirationSeconds,omitempty"`
 	Components                  []string   `json:",omitempty"`
 	CustomDomains               *[]string  `json:"customDomains,omitempty"`
 	GlobalnetCIDRRange          string     `json:"globalnetCIDRRange,omitempty"`
 	DefaultGlobalnetClusterSize uint       `json:"defaultGlobalnetClusterSize,omitempty"`
 }
 
-const ipsecPSKSecretName = "submariner-ipsec-psk"
-const ipsecSecretLength = 48
-
 func (data *BrokerInfo) SetComponents(componentSet stringset.Interface) {
 	data.Components = componentSet.Elements()
 }
@@ -92,8 +94,10 @@ func NewFromString(str string) (*BrokerInfo, error) {
 	return data, json.Unmarshal(bytes, data)
 }
 
-func (data *BrokerInfo) WriteConfigMap(c client.Client, instance *operatorv1alpha1.Knitnet) error {
-	cm := &v1.ConfigMap{
+// WriteSecret stores the broker info in the broker info secret, it holds the broker credentials
+// and the IPsec PSK, so it is not kept in a configmap
+func (data *BrokerInfo) WriteSecret(c client.Client, instance *operatorv1alpha1.Knitnet) error {
+	secret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      consts.SubmarinerBrokerInfo,
 			Namespace: consts.SubmarinerBrokerNamespace,
@@ -103,51 +107,69 @@ func (data *BrokerInfo) WriteConfigMap(c client.Client, instance *operatorv1alph
 	labels[consts.KnitnetNameLabel] = instance.GetName()
 	labels[consts.KnitnetNamespaceLabel] = instance.GetNamespace()
 
-	or, err := ctrl.CreateOrUpdate(context.TODO(), c, cm, func() error {
+	or, err := ctrl.CreateOrUpdate(context.TODO(), c, secret, func() error {
 		dataStr, err := data.ToString()
 		if err != nil {
 			return err
 		}
-		cm.ObjectMeta.Labels = labels
-		cm.Data = map[string]string{"brokerInfo": dataStr}
+		secret.ObjectMeta.Labels = labels
+		secret.Type = v1.SecretTypeOpaque
+		secret.Data = map[string][]byte{brokerInfoKey: []byte(dataStr)}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
-	klog.Infof("Configmap %s %s", consts.SubmarinerBrokerInfo, or)
+	klog.Infof("Secret %s %s", consts.SubmarinerBrokerInfo, or)
 	return nil
 }
 
-func NewFromConfigMap(c client.Client) (*BrokerInfo, error) {
-	cm, err := GetBrokerInfoConfigMap(c)
+func NewFromSecret(reader client.Reader) (*BrokerInfo, error) {
+	secret, err := GetBrokerInfoSecret(reader)
 	if err != nil {
 		return nil, err
 	}
-	return NewFromString(cm.Data["brokerInfo"])
+	return NewFromString(string(secret.Data[brokerInfoKey]))
 }
 
-func NewFromCluster(c client.Client, restConfig *rest.Config) (*BrokerInfo, error) {
+// NewFromCluster returns the broker info publishing the persisted IPsec PSK secret ipsecPSK and the
+// long-lived token of the broker admin SA, the joined clusters renew their own tokens with it
+func NewFromCluster(tokens *TokenProvider, ipsecPSK *v1.Secret) (*BrokerInfo, error) {
 	brokerInfo := &BrokerInfo{}
 	var err error
-	brokerInfo.ClientToken, err = GetClientTokenSecret(c, consts.SubmarinerBrokerNamespace, SubmarinerBrokerAdminSA)
+	brokerInfo.ClientToken, err = tokens.TokenSecret(SubmarinerBrokerAdminSA)
 	if err != nil {
 		return nil, err
 	}
-	brokerInfo.IPSecPSK, err = newIPSECPSKSecret()
-	if err != nil {
-		return nil, err
+	// Only the name and the PSK are published, as before the PSK was persisted
+	brokerInfo.IPSecPSK = &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ipsecPSK.GetName(),
+		},
+		Data: ipsecPSK.Data,
 	}
+	brokerInfo.IPSecPSKGeneration = IPSecPSKGeneration(ipsecPSK)
 	return brokerInfo, err
 }
 
-func CreateBrokerInfoConfigMap(c client.Client, restConfig *rest.Config, instance *operatorv1alpha1.Knitnet) error {
-	klog.Info("Create or update broker info configmap")
-	brokerInfo, err := NewFromCluster(c, restConfig)
+// CreateBrokerInfoSecret publishes the broker info for the joining clusters
+func CreateBrokerInfoSecret(c client.Client, reader client.Reader, restConfig *rest.Config, instance *operatorv1alpha1.Knitnet,
+	ipsecPSK *v1.Secret) error {
+	klog.Info("Create or update broker info secret")
+	brokerConfig := instance.Spec.BrokerConfig
+	tokenExpiration := DefaultTokenExpiration
+	if brokerConfig.TokenExpiration != nil {
+		tokenExpiration = brokerConfig.TokenExpiration.Duration
+	}
+	tokens, err := NewTokenProvider(c, reader, restConfig, tokenExpiration)
 	if err != nil {
 		return err
 	}
-	brokerConfig := instance.Spec.BrokerConfig
+	brokerInfo, err := NewFromCluster(tokens, ipsecPSK)
+	if err != nil {
+		return err
+	}
+	brokerInfo.TokenExpirationSeconds = int64(tokenExpiration.Seconds())
 	if brokerConfig.PublicAPIServerURL != "" {
 		brokerInfo.BrokerURL = brokerConfig.PublicAPIServerURL
 	} else {
@@ -170,23 +192,98 @@ func CreateBrokerInfoConfigMap(c client.Client, restConfig *rest.Config, instanc
 		brokerInfo.CustomDomains = &brokerConfig.DefaultCustomDomains
 	}
 
-	if err := brokerInfo.WriteConfigMap(c, instance); err != nil {
+	if err := brokerInfo.WriteSecret(c, instance); err != nil {
 		return err
 	}
-	return nil
+	// The broker info secret supersedes the configmap written by the previous versions
+	return deleteBrokerInfoConfigMap(c)
+}
+
+func GetBrokerInfoSecret(reader client.Reader) (*v1.Secret, error) {
+	klog.Info("Get broker info secret")
+	secret := &v1.Secret{}
+	secretKey := types.NamespacedName{Name: consts.SubmarinerBrokerInfo, Namespace: consts.SubmarinerBrokerNamespace}
+	if err := reader.Get(context.TODO(), secretKey, secret); err != nil {
+		klog.Errorf("Get submariner-broker-info secret failed: %v", err)
+		return nil, err
+	}
+	return secret, nil
 }
 
-func GetBrokerInfoConfigMap(reader client.Reader) (*v1.ConfigMap, error) {
-	klog.Info("Get broker info configmap")
+// GetBrokerInfoData returns the encoded broker info, a broker which still publishes the
+// broker info in a configmap is supported until it is upgraded
+func GetBrokerInfoData(reader client.Reader) (string, error) {
+	secret, err := GetBrokerInfoSecret(reader)
+	if err == nil {
+		return string(secret.Data[brokerInfoKey]), nil
+	}
+	if !errors.IsNotFound(err) {
+		return "", err
+	}
+	cm, cmErr := getBrokerInfoConfigMap(reader)
+	if cmErr != nil {
+		if errors.IsNotFound(cmErr) {
+			return "", err
+		}
+		return "", cmErr
+	}
+	return cm.Data[brokerInfoKey], nil
+}
+
+// MigrateBrokerInfoConfigMap moves the broker info of a configmap written by the previous versions,
+// or applied from their instructions, to the broker info secret
+func MigrateBrokerInfoConfigMap(c client.Client, reader client.Reader) error {
+	cm, err := getBrokerInfoConfigMap(reader)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	secret := &v1.Secret{}
+	secretKey := types.NamespacedName{Name: consts.SubmarinerBrokerInfo, Namespace: consts.SubmarinerBrokerNamespace}
+	if err := reader.Get(context.TODO(), secretKey, secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      consts.SubmarinerBrokerInfo,
+				Namespace: consts.SubmarinerBrokerNamespace,
+				Labels:    cm.GetLabels(),
+			},
+			Type: v1.SecretTypeOpaque,
+			Data: map[string][]byte{brokerInfoKey: []byte(cm.Data[brokerInfoKey])},
+		}
+		klog.Infof("Migrating broker info configmap %s to a secret", consts.SubmarinerBrokerInfo)
+		if err := c.Create(context.TODO(), secret); err != nil {
+			klog.Errorf("Create broker info secret failed: %v", err)
+			return err
+		}
+	}
+	return deleteBrokerInfoConfigMap(c)
+}
+
+func getBrokerInfoConfigMap(reader client.Reader) (*v1.ConfigMap, error) {
 	cm := &v1.ConfigMap{}
 	cmKey := types.NamespacedName{Name: consts.SubmarinerBrokerInfo, Namespace: consts.SubmarinerBrokerNamespace}
 	if err := reader.Get(context.TODO(), cmKey, cm); err != nil {
-		klog.Errorf("Get submariner-broker-info configmap failed: %v", err)
 		return nil, err
 	}
 	return cm, nil
 }
 
+func deleteBrokerInfoConfigMap(c client.Client) error {
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      consts.SubmarinerBrokerInfo,
+			Namespace: consts.SubmarinerBrokerNamespace,
+		},
+	}
+	if err := c.Delete(context.TODO(), cm); err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Delete broker info configmap failed: %v", err)
+		return err
+	}
+	return nil
+}
+
 func (data *BrokerInfo) GetBrokerAdministratorCluster() (cluster.Cluster, error) {
 	config := data.GetBrokerAdministratorConfig()
 	scheme := runtime.NewScheme()
@@ -209,29 +306,3 @@ func (data *BrokerInfo) GetBrokerAdministratorConfig() *rest.Config {
 	}
 	return &restConfig
 }
-
-// generateRandomPSK returns securely generated n-byte array.
-func generateRandomPSK(n int) ([]byte, error) {
-	psk := make([]byte, n)
-	_, err := rand.Read(psk)
-	return psk, err
-}
-
-func newIPSECPSKSecret() (*v1.Secret, error) {
-	psk, err := generateRandomPSK(ipsecSecretLength)
-	if err != nil {
-		return nil, err
-	}
-
-	pskSecretData := make(map[string][]byte)
-	pskSecretData["psk"] = psk
-
-	pskSecret := &v1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: ipsecPSKSecretName,
-		},
-		Data: pskSecretData,
-	}
-
-	return pskSecret, nil
-}
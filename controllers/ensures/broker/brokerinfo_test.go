@@ -21,6 +21,13 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
 )
 
 const (
@@ -62,6 +69,37 @@ var _ = Describe("datafile", func() {
 		})
 	})
 
+	When("Migrating the broker info configmap", func() {
+		var c client.Client
+		BeforeEach(func() {
+			cm := &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      consts.SubmarinerBrokerInfo,
+					Namespace: SubmarinerBrokerNamespace,
+					Labels:    map[string]string{consts.KnitnetNameLabel: "deploy-broker-sample"},
+				},
+				Data: map[string]string{"brokerInfo": "encoded"},
+			}
+			c = fake.NewClientBuilder().WithObjects(cm).Build()
+		})
+
+		It("Should move the broker info to a secret", func() {
+			Expect(MigrateBrokerInfoConfigMap(c, c)).To(Succeed())
+			secret, err := GetBrokerInfoSecret(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(secret.Data["brokerInfo"])).To(Equal("encoded"))
+			Expect(secret.GetLabels()).To(HaveKeyWithValue(consts.KnitnetNameLabel, "deploy-broker-sample"))
+			_, err = getBrokerInfoConfigMap(c)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("Should read the configmap of a broker which is not migrated yet", func() {
+			data, err := GetBrokerInfoData(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal("encoded"))
+		})
+	})
+
 	// When("Getting data from cluster", func() {
 
 	// 	var clientSet *fake.Clientset
@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"fmt"
+	"net"
+)
+
+// CIDROverlapError reports a CIDR of a joining cluster overlapping with a CIDR of a joined cluster
+type CIDROverlapError struct {
+	// ClusterID is the joined cluster the CIDR overlaps with
+	ClusterID string
+	Kind      string
+	CIDR      string
+	OtherKind string
+	OtherCIDR string
+}
+
+func (e *CIDROverlapError) Error() string {
+	return fmt.Sprintf("%s CIDR %s overlaps with %s CIDR %s of cluster %s, globalnet is required to join clusters "+
+		"with overlapping CIDRs: enable globalnet on the broker or change the CIDRs of the cluster",
+		e.Kind, e.CIDR, e.OtherKind, e.OtherCIDR, e.ClusterID)
+}
+
+// CheckCIDROverlap checks the pod and service CIDRs of the cluster against the ones of all the other
+// clusters, it returns a CIDROverlapError for the first overlap found. The clusters which did not
+// report their CIDRs are skipped.
+func CheckCIDROverlap(clusterInfos []ClusterInfo, cluster ClusterInfo) error {
+	for _, other := range clusterInfos {
+		if other.ClusterID == cluster.ClusterID {
+			continue
+		}
+		for _, local := range clusterCIDRs(cluster) {
+			for _, remote := range clusterCIDRs(other) {
+				if cidr, otherCIDR := findOverlap(local.cidrs, remote.cidrs); cidr != "" {
+					return &CIDROverlapError{
+						ClusterID: other.ClusterID,
+						Kind:      local.kind,
+						CIDR:      cidr,
+						OtherKind: remote.kind,
+						OtherCIDR: otherCIDR,
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type kindCIDRs struct {
+	kind  string
+	cidrs []string
+}
+
+func clusterCIDRs(cluster ClusterInfo) []kindCIDRs {
+	return []kindCIDRs{{kind: "pod", cidrs: cluster.PodCIDRs}, {kind: "service", cidrs: cluster.ServiceCIDRs}}
+}
+
+// findOverlap returns the first pair of overlapping CIDRs of the lists, invalid CIDRs are ignored
+func findOverlap(cidrs, otherCIDRs []string) (string, string) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		for _, otherCIDR := range otherCIDRs {
+			_, otherNetwork, err := net.ParseCIDR(otherCIDR)
+			if err != nil {
+				continue
+			}
+			if network.Contains(otherNetwork.IP) || otherNetwork.Contains(network.IP) {
+				return cidr, otherCIDR
+			}
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("CheckCIDROverlap", func() {
+	clusterInfos := []ClusterInfo{
+		{ClusterID: "legacy"},
+		{ClusterID: "cluster-a", PodCIDRs: []string{"10.244.0.0/16"}, ServiceCIDRs: []string{"10.96.0.0/12"}},
+		{ClusterID: "cluster-b", PodCIDRs: []string{"10.32.0.0/12"}, ServiceCIDRs: []string{"172.20.0.0/16"}},
+	}
+
+	It("Should accept a cluster with distinct CIDRs", func() {
+		Expect(CheckCIDROverlap(clusterInfos, ClusterInfo{
+			ClusterID: "cluster-c", PodCIDRs: []string{"10.128.0.0/14"}, ServiceCIDRs: []string{"172.30.0.0/16"},
+		})).To(Succeed())
+	})
+
+	It("Should not check a cluster against itself", func() {
+		Expect(CheckCIDROverlap(clusterInfos, clusterInfos[1])).To(Succeed())
+	})
+
+	It("Should name the cluster with an overlapping pod CIDR", func() {
+		err := CheckCIDROverlap(clusterInfos, ClusterInfo{
+			ClusterID: "cluster-c", PodCIDRs: []string{"10.244.128.0/17"}, ServiceCIDRs: []string{"172.30.0.0/16"},
+		})
+		Expect(err).To(Equal(&CIDROverlapError{
+			ClusterID: "cluster-a", Kind: "pod", CIDR: "10.244.128.0/17", OtherKind: "pod", OtherCIDR: "10.244.0.0/16",
+		}))
+		Expect(err.Error()).To(ContainSubstring("globalnet is required"))
+	})
+
+	It("Should detect a service CIDR overlapping with the pod CIDR of another cluster", func() {
+		err := CheckCIDROverlap(clusterInfos, ClusterInfo{
+			ClusterID: "cluster-c", PodCIDRs: []string{"10.128.0.0/14"}, ServiceCIDRs: []string{"10.40.0.0/16"},
+		})
+		Expect(err).To(Equal(&CIDROverlapError{
+			ClusterID: "cluster-b", Kind: "service", CIDR: "10.40.0.0/16", OtherKind: "pod", OtherCIDR: "10.32.0.0/12",
+		}))
+	})
+})
+
+var _ = Describe("UpdateGlobalnetConfigMap", func() {
+	newConfigMap := func(globalnetEnabled bool) *v1.ConfigMap {
+		cm := &v1.ConfigMap{}
+		cm.Name = GlobalCIDRConfigMapName
+		cm.Namespace = "submariner-k8s-broker"
+		Expect(GeneralGlobalnetConfigMap(cm, globalnetEnabled, "242.0.0.0/8", 65536)).To(Succeed())
+		cm.Data[ClusterInfoKey] = `[{"cluster_id": "cluster-a", "network_plugin": "calico", "global_cidr": null, "pod_cidrs": ["10.244.0.0/16"]}]`
+		return cm
+	}
+	joining := ClusterInfo{ClusterID: "cluster-b", PodCIDRs: []string{"10.244.0.0/16"}, ServiceCIDRs: []string{"10.96.0.0/12"}}
+
+	It("Should refuse a cluster with overlapping CIDRs without globalnet", func() {
+		cm := newConfigMap(false)
+		c := fake.NewClientBuilder().WithObjects(cm).Build()
+		err := UpdateGlobalnetConfigMap(c, cm.Namespace, cm, joining)
+		Expect(err).To(BeAssignableToTypeOf(&CIDROverlapError{}))
+	})
+
+	It("Should register a cluster with overlapping CIDRs with globalnet", func() {
+		cm := newConfigMap(true)
+		c := fake.NewClientBuilder().WithObjects(cm).Build()
+		Expect(UpdateGlobalnetConfigMap(c, cm.Namespace, cm, joining)).To(Succeed())
+
+		clusterInfos, err := GetClusterInfos(c, cm.Namespace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clusterInfos).To(HaveLen(2))
+		Expect(clusterInfos[1].PodCIDRs).To(Equal(joining.PodCIDRs))
+	})
+
+	It("Should keep the global CIDR of a rejoining cluster", func() {
+		cm := newConfigMap(true)
+		c := fake.NewClientBuilder().WithObjects(cm).Build()
+		registered := joining
+		registered.GlobalCidr = []string{"242.1.0.0/16"}
+		Expect(UpdateGlobalnetConfigMap(c, cm.Namespace, cm, registered)).To(Succeed())
+
+		cm, err := GetGlobalnetConfigMap(c, cm.Namespace)
+		Expect(err).NotTo(HaveOccurred())
+		rejoining := joining
+		rejoining.PodCIDRs = []string{"10.245.0.0/16"}
+		Expect(UpdateGlobalnetConfigMap(c, cm.Namespace, cm, rejoining)).To(Succeed())
+
+		clusterInfos, err := GetClusterInfos(c, cm.Namespace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clusterInfos).To(HaveLen(2))
+		Expect(clusterInfos[1].GlobalCidr).To(Equal([]string{"242.1.0.0/16"}))
+		Expect(clusterInfos[1].PodCIDRs).To(Equal(rejoining.PodCIDRs))
+	})
+})
@@ -19,13 +19,11 @@ package broker
 import (
 	"context"
 	"fmt"
-	"time"
 
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -70,11 +68,7 @@ func Ensure(c client.Client, config *rest.Config, serviceDiscoveryEnabled, globa
 	}
 
 	// Create cluster Role, and a default account for backwards compatibility, also bind it
-	if err := createBrokerClusterRoleAndDefaultSA(c); err != nil {
-		return err
-	}
-	_, err = WaitForClientToken(c, SubmarinerBrokerAdminSA)
-	return err
+	return createBrokerClusterRoleAndDefaultSA(c)
 }
 
 func createBrokerClusterRoleAndDefaultSA(c client.Client) error {
@@ -100,8 +94,9 @@ func createBrokerClusterRoleAndDefaultSA(c client.Client) error {
 	return nil
 }
 
-// CreateSAForCluster creates a new SA, and binds it to the submariner cluster role
-func CreateSAForCluster(c client.Client, reader client.Reader, clusterID string) (*v1.Secret, error) {
+// CreateSAForCluster creates a new SA, and binds it to the submariner cluster role. The token of the SA
+// is issued by tokens, current is kept until it has to be renewed.
+func CreateSAForCluster(c client.Client, tokens *TokenProvider, clusterID, current string) (*v1.Secret, error) {
 	saName := fmt.Sprintf(submarinerBrokerClusterSAFmt, clusterID)
 	err := CreateNewBrokerSA(c, saName)
 	if err != nil && !apierrors.IsAlreadyExists(err) {
@@ -113,13 +108,26 @@ func CreateSAForCluster(c client.Client, reader client.Reader, clusterID string)
 		return nil, fmt.Errorf("error binding sa to cluster role: %s", err)
 	}
 
-	clientToken, err := WaitForClientToken(reader, saName)
-	if err != nil && !apierrors.IsAlreadyExists(err) {
+	clientToken, err := tokens.Token(saName, current)
+	if err != nil {
 		return nil, fmt.Errorf("error getting cluster sa token: %s", err)
 	}
 	return clientToken, nil
 }
 
+// DeleteSAForCluster revokes the broker access of a cluster by deleting its SA and role binding
+func DeleteSAForCluster(c client.Client, clusterID string) error {
+	saName := fmt.Sprintf(submarinerBrokerClusterSAFmt, clusterID)
+	if err := c.Delete(context.TODO(), NewBrokerRoleBinding(saName, submarinerBrokerClusterRole)); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting cluster rolebinding: %s", err)
+	}
+	if err := c.Delete(context.TODO(), NewBrokerSA(saName)); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting cluster sa: %s", err)
+	}
+	klog.Infof("ServiceAccount %s deleted", saName)
+	return nil
+}
+
 func createBrokerAdministratorRoleAndSA(c client.Client) error {
 	// Create the SA we need for the managing the broker
 	err := CreateNewBrokerSA(c, SubmarinerBrokerAdminSA)
@@ -143,33 +151,6 @@ func createBrokerAdministratorRoleAndSA(c client.Client) error {
 	return nil
 }
 
-func WaitForClientToken(reader client.Reader, submarinerBrokerSA string) (secret *v1.Secret, err error) {
-	// wait for the client token to be ready, while implementing
-	// exponential backoff pattern, it will wait a total of:
-	// sum(n=0..9, 1.2^n * 5) seconds, = 130 seconds
-
-	backoff := wait.Backoff{
-		Steps:    10,
-		Duration: 5 * time.Second,
-		Factor:   1.2,
-		Jitter:   1,
-	}
-
-	var lastErr error
-	err = wait.ExponentialBackoff(backoff, func() (bool, error) {
-		secret, lastErr = GetClientTokenSecret(reader, consts.SubmarinerBrokerNamespace, submarinerBrokerSA)
-		if lastErr != nil {
-			return false, nil
-		}
-		return true, nil
-	})
-	if err == wait.ErrWaitTimeout {
-		return nil, lastErr
-	}
-
-	return secret, err
-}
-
 func CreateNewBrokerNamespace(c client.Client) error {
 	return c.Create(context.TODO(), NewBrokerNamespace())
 }
@@ -243,6 +224,12 @@ func NewBrokerAdminRole(role *rbacv1.Role) error {
 			APIGroups: []string{""},
 			Resources: []string{"serviceaccounts", "secrets", "configmaps"},
 		},
+		{
+			// The joining clusters request the tokens of their SA
+			Verbs:     []string{"create"},
+			APIGroups: []string{""},
+			Resources: []string{"serviceaccounts/token"},
+		},
 		{
 			Verbs:     []string{"create", "get", "list", "delete"},
 			APIGroups: []string{"rbac.authorization.k8s.io"},
@@ -23,6 +23,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -40,6 +41,12 @@ type ClusterInfo struct {
 	ClusterID     string   `json:"cluster_id"`
 	NetworkPlugin string   `json:"network_plugin"`
 	GlobalCidr    []string `json:"global_cidr"`
+	// PodCIDRs and ServiceCIDRs are the IPv4 CIDRs the cluster joined with, they must not overlap with the
+	// CIDRs of the other clusters unless globalnet is enabled
+	PodCIDRs     []string `json:"pod_cidrs,omitempty"`
+	ServiceCIDRs []string `json:"service_cidrs,omitempty"`
+	// IPSecPSKGeneration is the generation of the IPsec PSK the cluster runs with
+	IPSecPSKGeneration int64 `json:"ipsec_psk_generation,omitempty"`
 }
 
 func CreateGlobalnetConfigMap(c client.Client, globalnetEnabled bool, defaultGlobalCidrRange string,
@@ -89,6 +96,9 @@ func GeneralGlobalnetConfigMap(cm *v1.ConfigMap, globalnetEnabled bool, defaultG
 	return nil
 }
 
+// UpdateGlobalnetConfigMap adds or updates the entry of the cluster in the cluster info list. Without globalnet,
+// a cluster whose CIDRs overlap with the CIDRs of another cluster is refused with a CIDROverlapError.
+// An empty global CIDR keeps the global CIDR the cluster was registered with.
 func UpdateGlobalnetConfigMap(c client.Client, namespace string,
 	configMap *v1.ConfigMap, newCluster ClusterInfo) error {
 	var clusterInfos []ClusterInfo
@@ -97,10 +107,21 @@ func UpdateGlobalnetConfigMap(c client.Client, namespace string,
 		return err
 	}
 
+	if configMap.Data[GlobalnetStatusKey] != "true" {
+		if err := CheckCIDROverlap(clusterInfos, newCluster); err != nil {
+			return err
+		}
+	}
+
 	exists := false
 	for k, value := range clusterInfos {
 		if value.ClusterID == newCluster.ClusterID {
-			clusterInfos[k].GlobalCidr = newCluster.GlobalCidr
+			if len(newCluster.GlobalCidr) > 0 {
+				clusterInfos[k].GlobalCidr = newCluster.GlobalCidr
+			}
+			clusterInfos[k].NetworkPlugin = newCluster.NetworkPlugin
+			clusterInfos[k].PodCIDRs = newCluster.PodCIDRs
+			clusterInfos[k].ServiceCIDRs = newCluster.ServiceCIDRs
 			exists = true
 		}
 	}
@@ -122,6 +143,72 @@ func UpdateGlobalnetConfigMap(c client.Client, namespace string,
 	return c.Update(context.TODO(), configMap)
 }
 
+// RemoveClusterInfo deletes the entry of the cluster from the cluster info list, releasing its global CIDR
+func RemoveClusterInfo(c client.Client, reader client.Reader, namespace, clusterID string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := GetGlobalnetConfigMap(reader, namespace)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		var clusterInfos []ClusterInfo
+		if err := json.Unmarshal([]byte(configMap.Data[ClusterInfoKey]), &clusterInfos); err != nil {
+			return err
+		}
+
+		remaining := []ClusterInfo{}
+		for _, value := range clusterInfos {
+			if value.ClusterID != clusterID {
+				remaining = append(remaining, value)
+			}
+		}
+		if len(remaining) == len(clusterInfos) {
+			return nil
+		}
+
+		data, err := json.MarshalIndent(remaining, "", "\t")
+		if err != nil {
+			return err
+		}
+		configMap.Data[ClusterInfoKey] = string(data)
+		klog.Infof("Removing cluster %s from configmap %s", clusterID, GlobalCIDRConfigMapName)
+		return c.Update(context.TODO(), configMap)
+	})
+}
+
+// UpdateClusterIPSecPSKGeneration records the generation of the IPsec PSK the cluster runs with,
+// it is used by the broker to follow the rollout of a rotated PSK
+func UpdateClusterIPSecPSKGeneration(c client.Client, reader client.Reader, namespace, clusterID string, generation int64) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := GetGlobalnetConfigMap(reader, namespace)
+		if err != nil {
+			return err
+		}
+		var clusterInfos []ClusterInfo
+		if err := json.Unmarshal([]byte(configMap.Data[ClusterInfoKey]), &clusterInfos); err != nil {
+			return err
+		}
+
+		updated := false
+		for k, value := range clusterInfos {
+			if value.ClusterID == clusterID && value.IPSecPSKGeneration != generation {
+				clusterInfos[k].IPSecPSKGeneration = generation
+				updated = true
+			}
+		}
+		if !updated {
+			return nil
+		}
+
+		data, err := json.MarshalIndent(clusterInfos, "", "\t")
+		if err != nil {
+			return err
+		}
+		configMap.Data[ClusterInfoKey] = string(data)
+		klog.Infof("Cluster %s runs with IPsec PSK generation %d", clusterID, generation)
+		return c.Update(context.TODO(), configMap)
+	})
+}
+
 func GetGlobalnetConfigMap(reader client.Reader, namespace string) (*v1.ConfigMap, error) {
 	cm := &v1.ConfigMap{}
 	cmKey := types.NamespacedName{Name: GlobalCIDRConfigMapName, Namespace: namespace}
@@ -0,0 +1,187 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+const (
+	ipsecPSKSecretName = "submariner-ipsec-psk"
+	ipsecSecretLength  = 48
+
+	// The generation of the PSK, increased on each rotation
+	IPSecPSKGenerationAnnotation = "operator.tkestack.io/ipsec-psk-generation"
+	// The time the PSK was generated, in RFC3339 format
+	IPSecPSKRotatedAtAnnotation = "operator.tkestack.io/ipsec-psk-rotated-at"
+	// The rotation request the PSK was generated for, so each request rotates the PSK only once
+	IPSecPSKRotationRequestAnnotation = "operator.tkestack.io/ipsec-psk-rotation-request"
+)
+
+// EnsureIPSecPSKSecret returns the IPsec PSK secret persisted in the broker namespace, the secret
+// is generated on first use and kept afterwards, so the joined clusters keep a valid PSK. A pending
+// rotation request is recorded on creation, the new PSK fulfills it already. A broker upgraded from
+// a version which did not persist the PSK adopts the PSK published in the broker info instead, the
+// joined clusters run with it.
+func EnsureIPSecPSKSecret(c client.Client, reader client.Reader, request string) (*v1.Secret, error) {
+	secret := &v1.Secret{}
+	secretKey := types.NamespacedName{Name: ipsecPSKSecretName, Namespace: consts.SubmarinerBrokerNamespace}
+	err := reader.Get(context.TODO(), secretKey, secret)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.IsNotFound(err) {
+		klog.Errorf("Get IPsec PSK secret failed: %v", err)
+		return nil, err
+	}
+
+	published, err := publishedIPSecPSK(reader)
+	if err != nil {
+		return nil, err
+	}
+	if published != nil {
+		klog.Infof("Adopting the IPsec PSK published in the broker info")
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: ipsecPSKSecretName},
+			Data:       map[string][]byte{"psk": published},
+		}
+	} else {
+		secret, err = newIPSECPSKSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+	secret.Namespace = consts.SubmarinerBrokerNamespace
+	secret.Annotations = map[string]string{
+		IPSecPSKGenerationAnnotation: "1",
+		IPSecPSKRotatedAtAnnotation:  time.Now().UTC().Format(time.RFC3339),
+	}
+	// An adopted PSK does not fulfill the rotation request
+	if request != "" && published == nil {
+		secret.Annotations[IPSecPSKRotationRequestAnnotation] = request
+	}
+	klog.Infof("Creating IPsec PSK secret %s", ipsecPSKSecretName)
+	if err := c.Create(context.TODO(), secret); err != nil {
+		klog.Errorf("Create IPsec PSK secret failed: %v", err)
+		return nil, err
+	}
+	return secret, nil
+}
+
+// publishedIPSecPSK returns the PSK published in the broker info, or nil when no broker info is published
+func publishedIPSecPSK(reader client.Reader) ([]byte, error) {
+	data, err := GetBrokerInfoData(reader)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		klog.Errorf("Get broker info failed: %v", err)
+		return nil, err
+	}
+	brokerInfo, err := NewFromString(data)
+	if err != nil {
+		klog.Errorf("Parse broker info failed: %v", err)
+		return nil, err
+	}
+	if brokerInfo.IPSecPSK == nil || len(brokerInfo.IPSecPSK.Data["psk"]) == 0 {
+		return nil, nil
+	}
+	return brokerInfo.IPSecPSK.Data["psk"], nil
+}
+
+// RotateIPSecPSKSecret replaces the PSK with a newly generated one and increases its generation,
+// request is recorded in the secret and may be empty for a scheduled rotation
+func RotateIPSecPSKSecret(c client.Client, secret *v1.Secret, request string) error {
+	psk, err := generateRandomPSK(ipsecSecretLength)
+	if err != nil {
+		return err
+	}
+	generation := IPSecPSKGeneration(secret) + 1
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[IPSecPSKGenerationAnnotation] = strconv.FormatInt(generation, 10)
+	secret.Annotations[IPSecPSKRotatedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if request != "" {
+		secret.Annotations[IPSecPSKRotationRequestAnnotation] = request
+	}
+	secret.Data = map[string][]byte{"psk": psk}
+	klog.Infof("Rotating IPsec PSK to generation %d", generation)
+	return c.Update(context.TODO(), secret)
+}
+
+// IPSecPSKGeneration returns the generation of the PSK, the secrets generated before the PSK was
+// persisted have no generation and are handled as the first one
+func IPSecPSKGeneration(secret *v1.Secret) int64 {
+	generation, err := strconv.ParseInt(secret.GetAnnotations()[IPSecPSKGenerationAnnotation], 10, 64)
+	if err != nil || generation < 1 {
+		return 1
+	}
+	return generation
+}
+
+// IPSecPSKRotationTime returns the time the PSK was generated, falling back to the creation time of the secret
+func IPSecPSKRotationTime(secret *v1.Secret) metav1.Time {
+	rotatedAt, err := time.Parse(time.RFC3339, secret.GetAnnotations()[IPSecPSKRotatedAtAnnotation])
+	if err != nil {
+		return secret.GetCreationTimestamp()
+	}
+	return metav1.NewTime(rotatedAt)
+}
+
+// IPSecPSKRotationRequest returns the last rotation request handled for the PSK
+func IPSecPSKRotationRequest(secret *v1.Secret) string {
+	return secret.GetAnnotations()[IPSecPSKRotationRequestAnnotation]
+}
+
+// generateRandomPSK returns securely generated n-byte array.
+func generateRandomPSK(n int) ([]byte, error) {
+	psk := make([]byte, n)
+	_, err := rand.Read(psk)
+	return psk, err
+}
+
+func newIPSECPSKSecret() (*v1.Secret, error) {
+	psk, err := generateRandomPSK(ipsecSecretLength)
+	if err != nil {
+		return nil, err
+	}
+
+	pskSecretData := make(map[string][]byte)
+	pskSecretData["psk"] = psk
+
+	pskSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ipsecPSKSecretName,
+		},
+		Data: pskSecretData,
+	}
+
+	return pskSecret, nil
+}
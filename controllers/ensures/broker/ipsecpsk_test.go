@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+var _ = Describe("IPsec PSK", func() {
+	var c client.Client
+	BeforeEach(func() {
+		c = fake.NewClientBuilder().Build()
+	})
+
+	When("Ensuring the PSK secret", func() {
+		It("Should keep the persisted PSK", func() {
+			secret, err := EnsureIPSecPSKSecret(c, c, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret.Data["psk"]).To(HaveLen(ipsecSecretLength))
+			Expect(IPSecPSKGeneration(secret)).To(Equal(int64(1)))
+
+			again, err := EnsureIPSecPSKSecret(c, c, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again.Data["psk"]).To(Equal(secret.Data["psk"]))
+		})
+
+		It("Should record the pending rotation request on creation", func() {
+			secret, err := EnsureIPSecPSKSecret(c, c, "2021-09-01")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(IPSecPSKRotationRequest(secret)).To(Equal("2021-09-01"))
+		})
+
+		It("Should adopt the PSK published by an upgraded broker", func() {
+			published := &BrokerInfo{IPSecPSK: &v1.Secret{Data: map[string][]byte{"psk": []byte("published-psk")}}}
+			data, err := published.ToString()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Create(context.TODO(), &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: consts.SubmarinerBrokerInfo, Namespace: consts.SubmarinerBrokerNamespace},
+				Data:       map[string][]byte{brokerInfoKey: []byte(data)},
+			})).To(Succeed())
+
+			secret, err := EnsureIPSecPSKSecret(c, c, "2021-09-01")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret.Data["psk"]).To(Equal([]byte("published-psk")))
+			Expect(IPSecPSKGeneration(secret)).To(Equal(int64(1)))
+			// The adopted PSK is still rotated for the pending request
+			Expect(IPSecPSKRotationRequest(secret)).To(BeEmpty())
+		})
+	})
+
+	When("Rotating the PSK", func() {
+		It("Should replace the PSK and increase the generation", func() {
+			secret, err := EnsureIPSecPSKSecret(c, c, "")
+			Expect(err).NotTo(HaveOccurred())
+			oldPSK := secret.Data["psk"]
+
+			Expect(RotateIPSecPSKSecret(c, secret, "2021-09-01")).To(Succeed())
+			rotated, err := EnsureIPSecPSKSecret(c, c, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotated.Data["psk"]).NotTo(Equal(oldPSK))
+			Expect(IPSecPSKGeneration(rotated)).To(Equal(int64(2)))
+			Expect(IPSecPSKRotationRequest(rotated)).To(Equal("2021-09-01"))
+		})
+
+		It("Should handle a secret without generation as the first one", func() {
+			Expect(IPSecPSKGeneration(&v1.Secret{})).To(Equal(int64(1)))
+		})
+	})
+})
@@ -0,0 +1,250 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+const (
+	// DefaultTokenExpiration is the lifetime of the broker tokens when it is not configured
+	DefaultTokenExpiration = 24 * time.Hour
+
+	// rootCAConfigMapName is the configmap published in each namespace with the CA of the API server
+	rootCAConfigMapName = "kube-root-ca.crt"
+)
+
+// TokenProvider issues the tokens of the service accounts in the broker namespace. The tokens of the
+// clusters are requested through the TokenRequest API, the clusters which do not serve it get a token
+// secret. The broker admin token is always a token secret, see TokenSecret.
+type TokenProvider struct {
+	client     client.Client
+	reader     client.Reader
+	clientset  kubernetes.Interface
+	config     *rest.Config
+	expiration time.Duration
+}
+
+// NewTokenProvider returns a token provider for the broker cluster config points to, c and reader
+// must access the same cluster
+func NewTokenProvider(c client.Client, reader client.Reader, config *rest.Config, expiration time.Duration) (*TokenProvider, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if expiration <= 0 {
+		expiration = DefaultTokenExpiration
+	}
+	return &TokenProvider{
+		client:     c,
+		reader:     reader,
+		clientset:  clientset,
+		config:     config,
+		expiration: expiration,
+	}, nil
+}
+
+// Token returns the token of the service account in the format of a service account token secret.
+// The current token is kept until it has to be renewed, so the clients are not updated on each reconcile.
+func (p *TokenProvider) Token(saName, current string) (*v1.Secret, error) {
+	subject := fmt.Sprintf("system:serviceaccount:%s:%s", consts.SubmarinerBrokerNamespace, saName)
+	if current != "" && tokenSubject(current) == subject && !NeedsRenewal(current, time.Now()) {
+		return p.newTokenSecret(saName, current)
+	}
+
+	expirationSeconds := int64(p.expiration.Seconds())
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}
+	tokenRequest, err := p.clientset.CoreV1().ServiceAccounts(consts.SubmarinerBrokerNamespace).
+		CreateToken(context.TODO(), saName, tokenRequest, metav1.CreateOptions{})
+	if err == nil {
+		klog.Infof("Issued token for ServiceAccount %s, expires at %s", saName, tokenRequest.Status.ExpirationTimestamp)
+		return p.newTokenSecret(saName, tokenRequest.Status.Token)
+	}
+	if !apierrors.IsNotFound(err) && !apierrors.IsMethodNotSupported(err) {
+		klog.Errorf("Request token for ServiceAccount %s failed: %v", saName, err)
+		return nil, err
+	}
+	klog.Warningf("TokenRequest API is not served, falling back to a token secret for ServiceAccount %s", saName)
+	return p.TokenSecret(saName)
+}
+
+// TokenSecret returns the token secret of the service account, whose token does not expire. It is
+// used for the broker admin token published in the broker info: a joined cluster can't renew it once
+// it has expired, and the broker info exported for new clusters has to stay valid. The secret is
+// created explicitly when the token controller has not created one.
+func (p *TokenProvider) TokenSecret(saName string) (*v1.Secret, error) {
+	secret := &v1.Secret{}
+	secretKey := types.NamespacedName{Name: fmt.Sprintf("%s-token", saName), Namespace: consts.SubmarinerBrokerNamespace}
+	if err := p.reader.Get(context.TODO(), secretKey, secret); err == nil && len(secret.Data[v1.ServiceAccountTokenKey]) > 0 {
+		return secret, nil
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	// The token controller creates the token secrets before Kubernetes 1.24
+	if legacy, err := GetClientTokenSecret(p.reader, consts.SubmarinerBrokerNamespace, saName); err == nil {
+		return legacy, nil
+	}
+
+	secret = &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-token", saName),
+			Namespace:   consts.SubmarinerBrokerNamespace,
+			Annotations: map[string]string{v1.ServiceAccountNameKey: saName},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+	}
+	if err := p.client.Create(context.TODO(), secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		klog.Errorf("Create token secret for ServiceAccount %s failed: %v", saName, err)
+		return nil, err
+	}
+
+	// The token controller fills the token in, wait for it a total of sum(n=0..9, 1.2^n * 1) seconds, = 26 seconds
+	backoff := wait.Backoff{
+		Steps:    10,
+		Duration: 1 * time.Second,
+		Factor:   1.2,
+	}
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		if err := p.reader.Get(context.TODO(), secretKey, secret); err != nil {
+			return false, err
+		}
+		return len(secret.Data[v1.ServiceAccountTokenKey]) > 0, nil
+	})
+	if err != nil {
+		klog.Errorf("Wait for token secret %s failed: %v", secret.GetName(), err)
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (p *TokenProvider) newTokenSecret(saName, token string) (*v1.Secret, error) {
+	caData, err := p.rootCA()
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-token", saName),
+			Namespace:   consts.SubmarinerBrokerNamespace,
+			Annotations: map[string]string{v1.ServiceAccountNameKey: saName},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+		Data: map[string][]byte{
+			v1.ServiceAccountTokenKey:     []byte(token),
+			v1.ServiceAccountRootCAKey:    caData,
+			v1.ServiceAccountNamespaceKey: []byte(consts.SubmarinerBrokerNamespace),
+		},
+	}, nil
+}
+
+// rootCA returns the CA of the broker API server from the root CA configmap of the broker namespace,
+// falling back to the CA of the rest config
+func (p *TokenProvider) rootCA() ([]byte, error) {
+	cm := &v1.ConfigMap{}
+	cmKey := types.NamespacedName{Name: rootCAConfigMapName, Namespace: consts.SubmarinerBrokerNamespace}
+	if err := p.reader.Get(context.TODO(), cmKey, cm); err == nil {
+		return []byte(cm.Data[v1.ServiceAccountRootCAKey]), nil
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if len(p.config.CAData) > 0 {
+		return p.config.CAData, nil
+	}
+	if p.config.CAFile != "" {
+		return ioutil.ReadFile(p.config.CAFile)
+	}
+	return nil, nil
+}
+
+// tokenClaims are the registered JWT claims used to check a token before it is reused, the
+// signature is not verified, the API server does it on use
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	Expiry    int64  `json:"exp"`
+}
+
+func parseTokenClaims(token string) (*tokenClaims, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	claims := &tokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+func tokenSubject(token string) string {
+	claims, ok := parseTokenClaims(token)
+	if !ok {
+		return ""
+	}
+	return claims.Subject
+}
+
+// RenewalTime returns when the token has to be renewed, which is after two thirds of its lifetime.
+// The tokens without expiry, like the legacy token secrets, are never renewed.
+func RenewalTime(token string) (time.Time, bool) {
+	claims, ok := parseTokenClaims(token)
+	if !ok || claims.Expiry == 0 {
+		return time.Time{}, false
+	}
+	issuedAt := claims.IssuedAt
+	if issuedAt == 0 {
+		issuedAt = claims.NotBefore
+	}
+	if issuedAt == 0 || issuedAt >= claims.Expiry {
+		return time.Unix(claims.Expiry, 0), true
+	}
+	return time.Unix(issuedAt+(claims.Expiry-issuedAt)*2/3, 0), true
+}
+
+// NeedsRenewal reports whether the token is due to be renewed at now
+func NeedsRenewal(token string, now time.Time) bool {
+	renewAt, expires := RenewalTime(token)
+	return expires && !now.Before(renewAt)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestToken(claims string) string {
+	return "header." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+}
+
+var _ = Describe("Broker tokens", func() {
+	When("Scheduling the renewal", func() {
+		It("Should renew after two thirds of the lifetime", func() {
+			token := newTestToken(`{"sub":"system:serviceaccount:ns:sa","iat":1000,"exp":4000}`)
+			renewAt, expires := RenewalTime(token)
+			Expect(expires).To(BeTrue())
+			Expect(renewAt).To(Equal(time.Unix(3000, 0)))
+			Expect(NeedsRenewal(token, time.Unix(2999, 0))).To(BeFalse())
+			Expect(NeedsRenewal(token, time.Unix(3000, 0))).To(BeTrue())
+		})
+
+		It("Should never renew a token without expiry", func() {
+			token := newTestToken(`{"sub":"system:serviceaccount:ns:sa"}`)
+			_, expires := RenewalTime(token)
+			Expect(expires).To(BeFalse())
+			Expect(NeedsRenewal(token, time.Now())).To(BeFalse())
+		})
+
+		It("Should not fail on a malformed token", func() {
+			_, expires := RenewalTime("not-a-jwt")
+			Expect(expires).To(BeFalse())
+		})
+	})
+
+	When("Reusing the current token", func() {
+		var provider *TokenProvider
+		BeforeEach(func() {
+			provider = &TokenProvider{
+				reader:     fake.NewClientBuilder().Build(),
+				config:     &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: []byte("i-am-a-ca")}},
+				expiration: DefaultTokenExpiration,
+			}
+		})
+
+		It("Should keep a valid token of the service account", func() {
+			now := time.Now().Unix()
+			token := newTestToken(fmt.Sprintf(`{"sub":"system:serviceaccount:%s:%s","iat":%d,"exp":%d}`,
+				SubmarinerBrokerNamespace, SubmarinerBrokerAdminSA, now, now+3600))
+			secret, err := provider.Token(SubmarinerBrokerAdminSA, token)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(secret.Data[v1.ServiceAccountTokenKey])).To(Equal(token))
+			Expect(string(secret.Data[v1.ServiceAccountRootCAKey])).To(Equal("i-am-a-ca"))
+			Expect(string(secret.Data[v1.ServiceAccountNamespaceKey])).To(Equal(SubmarinerBrokerNamespace))
+		})
+	})
+
+	When("Publishing the broker admin token", func() {
+		It("Should return the token secret, which does not expire", func() {
+			tokenSecret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: SubmarinerBrokerAdminSA + "-token", Namespace: SubmarinerBrokerNamespace},
+				Type:       v1.SecretTypeServiceAccountToken,
+				Data:       map[string][]byte{v1.ServiceAccountTokenKey: []byte("i-am-a-token")},
+			}
+			provider := &TokenProvider{reader: fake.NewClientBuilder().WithObjects(tokenSecret).Build()}
+			secret, err := provider.TokenSecret(SubmarinerBrokerAdminSA)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(secret.Data[v1.ServiceAccountTokenKey])).To(Equal("i-am-a-token"))
+			_, expires := RenewalTime(string(secret.Data[v1.ServiceAccountTokenKey]))
+			Expect(expires).To(BeFalse())
+		})
+
+		It("Should return the token secret created by the token controller", func() {
+			sa := &v1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: SubmarinerBrokerAdminSA, Namespace: SubmarinerBrokerNamespace},
+				Secrets:    []v1.ObjectReference{{Name: SubmarinerBrokerAdminSA + "-token-abcde"}},
+			}
+			tokenSecret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: SubmarinerBrokerAdminSA + "-token-abcde", Namespace: SubmarinerBrokerNamespace},
+				Type:       v1.SecretTypeServiceAccountToken,
+				Data:       map[string][]byte{v1.ServiceAccountTokenKey: []byte("i-am-a-legacy-token")},
+			}
+			provider := &TokenProvider{reader: fake.NewClientBuilder().WithObjects(sa, tokenSecret).Build()}
+			secret, err := provider.TokenSecret(SubmarinerBrokerAdminSA)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(secret.Data[v1.ServiceAccountTokenKey])).To(Equal("i-am-a-legacy-token"))
+		})
+	})
+})
@@ -23,7 +23,7 @@ const (
 	SubmarinerBrokerName      = "submariner-broker"
 	SubmarinerBrokerNamespace = "submariner-k8s-broker"
 
-	// SubmarinerBrokerInfo represents the broker info configmap name
+	// SubmarinerBrokerInfo represents the broker info secret name
 	SubmarinerBrokerInfo = "submariner-broker-info"
 
 	//KnitnetNameLabel is the label used to label the resource managed by knitnet
@@ -33,4 +33,25 @@ const (
 	KnitnetNamespaceLabel = "operator.tkestack.io/knitnet-namespace"
 
 	KnitnetOperatorNamespace = "knitnet-operator-system"
+
+	// KnitnetFinalizer is the finalizer used to clean up the resources created for a knitnet
+	KnitnetFinalizer = "operator.tkestack.io/finalizer"
+
+	// KnitnetForceDeleteAnnotation allows deleting a broker knitnet while clusters are still joined,
+	// a joined knitnet whose cluster can't be removed from the broker, and leaving the cloud resources
+	// behind when the cloud credentials secret is gone
+	KnitnetForceDeleteAnnotation = "operator.tkestack.io/force-delete"
+
+	// KnitnetConvertAnnotation converts a v1alpha1 knitnet to the v1beta1 APIs when set to v1beta1
+	KnitnetConvertAnnotation = "operator.tkestack.io/convert-to"
+
+	// SubmarinerGatewayLabel is the label of the nodes selected as Submariner gateways
+	SubmarinerGatewayLabel = "submariner.io/gateway"
+
+	// KnitnetGatewayLabel is the label used to mark the gateway nodes labeled by knitnet
+	KnitnetGatewayLabel = "operator.tkestack.io/knitnet-gateway"
+
+	// KnitnetManagedByLabel is the label used to mark the cluster scoped resources created by knitnet
+	KnitnetManagedByLabel = "app.kubernetes.io/managed-by"
+	KnitnetManagedByValue = "knitnet-operator"
 )
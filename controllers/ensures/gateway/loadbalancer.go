@@ -0,0 +1,196 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+const (
+	// LoadBalancerServiceName is the name of the LoadBalancer service in front of the gateway pods
+	LoadBalancerServiceName = "submariner-gateway"
+
+	// gatewayAppLabel is the app label the submariner operator sets on the gateway pods
+	gatewayAppLabel = "submariner-gateway"
+
+	// awsLoadBalancerTypeAnnotation selects the NLB on AWS, the classic load balancer does not serve UDP
+	awsLoadBalancerTypeAnnotation = "service.beta.kubernetes.io/aws-load-balancer-type"
+
+	// PublicIPLabel tells the submariner gateway on a node where its public IP comes from, the gateway
+	// advertises the address of the LoadBalancer service when it is lb:<service name>
+	PublicIPLabel = "gateway.submariner.io/public-ip"
+)
+
+// publicIPFromLoadBalancer is the public IP label value of the gateway nodes behind the LoadBalancer service
+var publicIPFromLoadBalancer = "lb:" + LoadBalancerServiceName
+
+// EnsureLoadBalancer creates or updates the LoadBalancer service in front of the gateway pods, it serves
+// the IKE and NAT-T ports of the join config. The service is labeled for the knitnet owning it, and the
+// gateway nodes are labeled to advertise its address. The NLB is requested when the cluster runs on AWS.
+// The returned address is empty until the load balancer is provisioned.
+func EnsureLoadBalancer(c client.Client, namespace string, instance *operatorv1alpha1.Knitnet, provider operatorv1alpha1.CloudProvider) (string, error) {
+	joinConfig := instance.Spec.JoinConfig
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      LoadBalancerServiceName,
+			Namespace: namespace,
+		},
+	}
+	or, err := controllerutil.CreateOrUpdate(context.TODO(), c, svc, func() error {
+		if svc.Labels == nil {
+			svc.Labels = map[string]string{}
+		}
+		svc.Labels[consts.KnitnetNameLabel] = instance.GetName()
+		svc.Labels[consts.KnitnetNamespaceLabel] = instance.GetNamespace()
+		if provider == operatorv1alpha1.CloudProviderAWS {
+			if svc.Annotations == nil {
+				svc.Annotations = map[string]string{}
+			}
+			svc.Annotations[awsLoadBalancerTypeAnnotation] = "nlb"
+		}
+
+		svc.Spec.Type = v1.ServiceTypeLoadBalancer
+		svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+		svc.Spec.Selector = map[string]string{"app": gatewayAppLabel}
+		svc.Spec.Ports = mergeServicePorts(svc.Spec.Ports, []v1.ServicePort{
+			newUDPPort("ipsec-ike", joinConfig.IkePort),
+			newUDPPort("ipsec-natt", joinConfig.NattPort),
+		})
+		return nil
+	})
+	if err != nil {
+		klog.Errorf("Failed to %s gateway LoadBalancer service: %v", or, err)
+		return "", err
+	}
+	klog.Infof("Gateway LoadBalancer service %s %s", svc.GetName(), or)
+
+	if err := setGatewayPublicIPLabels(c, true); err != nil {
+		klog.Errorf("Failed to label the gateway nodes with %s: %v", PublicIPLabel, err)
+		return "", err
+	}
+	return LoadBalancerAddress(svc), nil
+}
+
+// DeleteLoadBalancer deletes the gateway LoadBalancer service and unlabels the gateway nodes, a service
+// of the same name which was not created by knitnet is left alone
+func DeleteLoadBalancer(c client.Client, namespace string) error {
+	if err := setGatewayPublicIPLabels(c, false); err != nil {
+		klog.Errorf("Failed to remove the %s label of the gateway nodes: %v", PublicIPLabel, err)
+		return err
+	}
+
+	svc := &v1.Service{}
+	svcKey := types.NamespacedName{Name: LoadBalancerServiceName, Namespace: namespace}
+	if err := c.Get(context.TODO(), svcKey, svc); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if _, ok := svc.GetLabels()[consts.KnitnetNameLabel]; !ok {
+		klog.Warningf("Service %s/%s is not managed by knitnet, skip deleting it", namespace, LoadBalancerServiceName)
+		return nil
+	}
+	if err := c.Delete(context.TODO(), svc); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	klog.Infof("Gateway LoadBalancer service %s deleted", svc.GetName())
+	return nil
+}
+
+// setGatewayPublicIPLabels points the public IP of the gateway nodes to the LoadBalancer service, or removes
+// the label again. A public IP label which does not point to the service is left alone.
+func setGatewayPublicIPLabels(c client.Client, enabled bool) error {
+	nodes := &v1.NodeList{}
+	if err := c.List(context.TODO(), nodes, client.MatchingLabels{consts.SubmarinerGatewayLabel: "true"}); err != nil {
+		return err
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		value, labeled := node.GetLabels()[PublicIPLabel]
+		if enabled == labeled || (labeled && value != publicIPFromLoadBalancer) {
+			continue
+		}
+		patch := client.MergeFrom(node.DeepCopy())
+		labels := node.GetLabels()
+		if enabled {
+			labels[PublicIPLabel] = publicIPFromLoadBalancer
+		} else {
+			delete(labels, PublicIPLabel)
+		}
+		node.SetLabels(labels)
+		if err := c.Patch(context.TODO(), node, patch); err != nil {
+			return err
+		}
+		if enabled {
+			klog.Infof("Gateway node %s labeled %s=%s", node.GetName(), PublicIPLabel, publicIPFromLoadBalancer)
+		} else {
+			klog.Infof("Gateway node %s unlabeled %s", node.GetName(), PublicIPLabel)
+		}
+	}
+	return nil
+}
+
+// LoadBalancerAddress returns the first address assigned to the LoadBalancer service, the IP is
+// preferred over the hostname some cloud providers assign
+func LoadBalancerAddress(svc *v1.Service) string {
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+	}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.Hostname != "" {
+			return ingress.Hostname
+		}
+	}
+	return ""
+}
+
+func newUDPPort(name string, port int) v1.ServicePort {
+	return v1.ServicePort{
+		Name:       name,
+		Protocol:   v1.ProtocolUDP,
+		Port:       int32(port),
+		TargetPort: intstr.FromInt(port),
+	}
+}
+
+// mergeServicePorts returns the desired ports keeping the node ports allocated to the current ones,
+// so an update does not move the load balancer to new node ports
+func mergeServicePorts(current, desired []v1.ServicePort) []v1.ServicePort {
+	nodePorts := map[string]int32{}
+	for _, port := range current {
+		nodePorts[port.Name] = port.NodePort
+	}
+	for i := range desired {
+		desired[i].NodePort = nodePorts[desired[i].Name]
+	}
+	return desired
+}
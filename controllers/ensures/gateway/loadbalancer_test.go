@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+var _ = Describe("Gateway LoadBalancer", func() {
+	var c client.Client
+	var instance *operatorv1alpha1.Knitnet
+	svcKey := types.NamespacedName{Name: LoadBalancerServiceName, Namespace: consts.SubmarinerOperatorNamespace}
+	BeforeEach(func() {
+		c = fake.NewClientBuilder().Build()
+		instance = &operatorv1alpha1.Knitnet{
+			ObjectMeta: metav1.ObjectMeta{Name: "join", Namespace: "default"},
+			Spec: operatorv1alpha1.KnitnetSpec{
+				JoinConfig: operatorv1alpha1.JoinConfig{IkePort: 500, NattPort: 4500, LoadBalancerEnabled: true},
+			},
+		}
+	})
+
+	It("Should serve the IPsec ports and keep the allocated node ports", func() {
+		address, err := EnsureLoadBalancer(c, consts.SubmarinerOperatorNamespace, instance, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(address).To(BeEmpty())
+
+		svc := &v1.Service{}
+		Expect(c.Get(context.TODO(), svcKey, svc)).To(Succeed())
+		Expect(svc.Spec.Type).To(Equal(v1.ServiceTypeLoadBalancer))
+		Expect(svc.Spec.Selector).To(HaveKeyWithValue("app", gatewayAppLabel))
+		Expect(svc.Spec.Ports).To(HaveLen(2))
+		Expect(svc.Labels).To(HaveKeyWithValue(consts.KnitnetNameLabel, "join"))
+
+		svc.Spec.Ports[1].NodePort = 31500
+		svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: "gw.example.com"}}
+		Expect(c.Update(context.TODO(), svc)).To(Succeed())
+
+		address, err = EnsureLoadBalancer(c, consts.SubmarinerOperatorNamespace, instance, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(address).To(Equal("gw.example.com"))
+		Expect(c.Get(context.TODO(), svcKey, svc)).To(Succeed())
+		Expect(svc.Spec.Ports[1].NodePort).To(Equal(int32(31500)))
+	})
+
+	It("Should only delete the service it created", func() {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: svcKey.Name, Namespace: svcKey.Namespace}}
+		Expect(c.Create(context.TODO(), svc)).To(Succeed())
+		Expect(DeleteLoadBalancer(c, consts.SubmarinerOperatorNamespace)).To(Succeed())
+		Expect(c.Get(context.TODO(), svcKey, svc)).To(Succeed())
+
+		Expect(c.Delete(context.TODO(), svc)).To(Succeed())
+		_, err := EnsureLoadBalancer(c, consts.SubmarinerOperatorNamespace, instance, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(DeleteLoadBalancer(c, consts.SubmarinerOperatorNamespace)).To(Succeed())
+		Expect(c.Get(context.TODO(), svcKey, svc)).NotTo(Succeed())
+	})
+
+	It("Should only request the NLB on AWS", func() {
+		_, err := EnsureLoadBalancer(c, consts.SubmarinerOperatorNamespace, instance, operatorv1alpha1.CloudProviderTencent)
+		Expect(err).NotTo(HaveOccurred())
+		svc := &v1.Service{}
+		Expect(c.Get(context.TODO(), svcKey, svc)).To(Succeed())
+		Expect(svc.Annotations).NotTo(HaveKey(awsLoadBalancerTypeAnnotation))
+
+		_, err = EnsureLoadBalancer(c, consts.SubmarinerOperatorNamespace, instance, operatorv1alpha1.CloudProviderAWS)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Get(context.TODO(), svcKey, svc)).To(Succeed())
+		Expect(svc.Annotations).To(HaveKeyWithValue(awsLoadBalancerTypeAnnotation, "nlb"))
+	})
+
+	It("Should point the public IP of the gateway nodes to the service", func() {
+		newNode := func(name string, labels map[string]string) *v1.Node {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+			Expect(c.Create(context.TODO(), node)).To(Succeed())
+			return node
+		}
+		getLabels := func(node *v1.Node) map[string]string {
+			current := &v1.Node{}
+			Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(node), current)).To(Succeed())
+			return current.GetLabels()
+		}
+		gw := newNode("gw", map[string]string{consts.SubmarinerGatewayLabel: "true"})
+		pinned := newNode("pinned", map[string]string{consts.SubmarinerGatewayLabel: "true", PublicIPLabel: "ipv4:1.2.3.4"})
+		worker := newNode("worker", nil)
+
+		_, err := EnsureLoadBalancer(c, consts.SubmarinerOperatorNamespace, instance, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getLabels(gw)).To(HaveKeyWithValue(PublicIPLabel, "lb:"+LoadBalancerServiceName))
+		Expect(getLabels(pinned)).To(HaveKeyWithValue(PublicIPLabel, "ipv4:1.2.3.4"))
+		Expect(getLabels(worker)).NotTo(HaveKey(PublicIPLabel))
+
+		Expect(DeleteLoadBalancer(c, consts.SubmarinerOperatorNamespace)).To(Succeed())
+		Expect(getLabels(gw)).NotTo(HaveKey(PublicIPLabel))
+		Expect(getLabels(pinned)).To(HaveKeyWithValue(PublicIPLabel, "ipv4:1.2.3.4"))
+	})
+
+	It("Should prefer the IP address", func() {
+		svc := &v1.Service{}
+		svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: "gw.example.com"}, {IP: "1.2.3.4"}}
+		Expect(LoadBalancerAddress(svc)).To(Equal("1.2.3.4"))
+	})
+})
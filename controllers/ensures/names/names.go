@@ -43,5 +43,3 @@ var (
 	ImagePrefix  = ""
 	ImagePostfix = ""
 )
-var ValidImageNames = []string{NetworkPluginSyncerImage, RouteAgentImage, GatewayImage, GlobalnetImage,
-	ServiceDiscoveryImage, LighthouseCoreDNSImage, OperatorImage}
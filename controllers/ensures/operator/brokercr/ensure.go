@@ -41,3 +41,12 @@ func Ensure(c client.Client, brokerSpec submariner.BrokerSpec) error {
 	klog.Infof("Broker %s %s", brokerCR.GetName(), or)
 	return nil
 }
+
+func Delete(c client.Client) error {
+	brokerCR := &submariner.Broker{ObjectMeta: metav1.ObjectMeta{Name: consts.SubmarinerBrokerName, Namespace: consts.SubmarinerOperatorNamespace}}
+	if err := c.Delete(context.TODO(), brokerCR); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	klog.Infof("Broker %s deleted", brokerCR.GetName())
+	return nil
+}
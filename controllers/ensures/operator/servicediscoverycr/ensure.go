@@ -18,27 +18,100 @@ package servicediscoverycr
 
 import (
 	"context"
+	"encoding/json"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	submariner "github.com/submariner-io/submariner-operator/apis/submariner/v1alpha1"
-	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/tkestack/knitnet-operator/controllers/ensures/names"
+	"github.com/tkestack/knitnet-operator/controllers/utils"
 )
 
-func Ensure(c client.Client, namespace string, serviceDiscoverySpec *submariner.ServiceDiscoverySpec) error {
-	sd := &submariner.ServiceDiscovery{ObjectMeta: metav1.ObjectMeta{Name: names.ServiceDiscoveryCrName, Namespace: namespace}}
-	or, err := ctrl.CreateOrUpdate(context.TODO(), c, sd, func() error {
-		sd.Spec = *serviceDiscoverySpec
-		return nil
-	})
+// recreateFields are the fields the submariner operator can not apply to the running lighthouse
+// deployment, changing any of them requires the CR to be recreated
+var recreateFields = []string{"clusterID", "namespace"}
+
+// Ensure creates the ServiceDiscovery CR or brings it to serviceDiscoverySpec. Only the changed fields are
+// patched, the CR is recreated when a field which can not be changed live differs.
+func Ensure(c client.Client, namespace string, serviceDiscoverySpec *submariner.ServiceDiscoverySpec) (controllerutil.OperationResult, error) {
+	sd := &submariner.ServiceDiscovery{}
+	sdKey := types.NamespacedName{Name: names.ServiceDiscoveryCrName, Namespace: namespace}
+	if err := c.Get(context.TODO(), sdKey, sd); err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Get ServiceDiscovery %s failed: %v", names.ServiceDiscoveryCrName, err)
+			return controllerutil.OperationResultNone, err
+		}
+		return create(c, namespace, serviceDiscoverySpec)
+	}
+
+	desired, err := withDefaults(serviceDiscoverySpec)
 	if err != nil {
-		klog.Errorf("Failed to %s ServiceDiscovery %s: %v", or, sd.GetName(), err)
-		return err
+		return controllerutil.OperationResultNone, err
+	}
+	changed := utils.ChangedFields(&sd.Spec, desired)
+	if len(changed) == 0 {
+		return controllerutil.OperationResultNone, nil
+	}
+	if utils.ContainsAny(changed, recreateFields...) {
+		klog.Infof("Recreating ServiceDiscovery %s, changed fields %v can not be updated in place", sd.GetName(), changed)
+		if err := Delete(c, namespace); err != nil {
+			klog.Errorf("Delete ServiceDiscovery %s failed: %v", sd.GetName(), err)
+			return controllerutil.OperationResultNone, err
+		}
+		if _, err := create(c, namespace, serviceDiscoverySpec); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		return utils.OperationResultRecreated, nil
+	}
+
+	klog.Infof("Updating ServiceDiscovery %s in place, changed fields %v", sd.GetName(), changed)
+	patch := client.MergeFrom(sd.DeepCopy())
+	sd.Spec = *desired
+	if err := c.Patch(context.TODO(), sd, patch); err != nil {
+		klog.Errorf("Patch ServiceDiscovery %s failed: %v", sd.GetName(), err)
+		return controllerutil.OperationResultNone, err
+	}
+	return controllerutil.OperationResultUpdated, nil
+}
+
+func create(c client.Client, namespace string, serviceDiscoverySpec *submariner.ServiceDiscoverySpec) (controllerutil.OperationResult, error) {
+	sd := &submariner.ServiceDiscovery{
+		ObjectMeta: metav1.ObjectMeta{Name: names.ServiceDiscoveryCrName, Namespace: namespace},
+		Spec:       *serviceDiscoverySpec,
 	}
-	klog.Infof("ServiceDiscovery %s %s", sd.GetName(), or)
+	if err := c.Create(context.TODO(), sd); err != nil {
+		klog.Errorf("Failed to create ServiceDiscovery %s: %v", sd.GetName(), err)
+		return controllerutil.OperationResultNone, err
+	}
+	klog.Infof("ServiceDiscovery %s created", sd.GetName())
+	return controllerutil.OperationResultCreated, nil
+}
+
+func Delete(c client.Client, namespace string) error {
+	sd := &submariner.ServiceDiscovery{ObjectMeta: metav1.ObjectMeta{Name: names.ServiceDiscoveryCrName, Namespace: namespace}}
+	if err := c.Delete(context.TODO(), sd); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	klog.Infof("ServiceDiscovery %s deleted", sd.GetName())
 	return nil
 }
+
+// withDefaults returns the spec as it is read back from the API server, the ServiceDiscovery type fills in
+// the defaults of the image settings when it is unmarshalled
+func withDefaults(spec *submariner.ServiceDiscoverySpec) (*submariner.ServiceDiscoverySpec, error) {
+	data, err := json.Marshal(&submariner.ServiceDiscovery{Spec: *spec})
+	if err != nil {
+		return nil, err
+	}
+	cr := &submariner.ServiceDiscovery{}
+	if err := json.Unmarshal(data, cr); err != nil {
+		return nil, err
+	}
+	return &cr.Spec, nil
+}
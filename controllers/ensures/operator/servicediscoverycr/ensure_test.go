@@ -0,0 +1,96 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package servicediscoverycr
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	submariner "github.com/submariner-io/submariner-operator/apis/submariner/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/tkestack/knitnet-operator/controllers/ensures/names"
+	"github.com/tkestack/knitnet-operator/controllers/utils"
+)
+
+const testNamespace = "submariner-operator"
+
+var _ = Describe("ServiceDiscovery CR", func() {
+	var c client.Client
+	var spec *submariner.ServiceDiscoverySpec
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(submariner.AddToScheme(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+		spec = &submariner.ServiceDiscoverySpec{
+			ClusterID:                "cluster-a",
+			Namespace:                testNamespace,
+			BrokerK8sApiServer:       "https://broker:6443",
+			BrokerK8sApiServerToken:  "token-1",
+			BrokerK8sRemoteNamespace: "submariner-k8s-broker",
+		}
+	})
+
+	getServiceDiscoveryCR := func() *submariner.ServiceDiscovery {
+		sd := &submariner.ServiceDiscovery{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: names.ServiceDiscoveryCrName, Namespace: testNamespace}, sd)).To(Succeed())
+		return sd
+	}
+
+	It("Should create a missing CR and leave an unchanged one alone", func() {
+		result, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(controllerutil.OperationResultCreated))
+
+		result, err = Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(controllerutil.OperationResultNone))
+	})
+
+	It("Should patch the fields which can be changed live", func() {
+		_, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		uid := getServiceDiscoveryCR().GetUID()
+
+		spec.BrokerK8sApiServerToken = "token-2"
+		spec.Debug = true
+		result, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(controllerutil.OperationResultUpdated))
+
+		sd := getServiceDiscoveryCR()
+		Expect(sd.GetUID()).To(Equal(uid))
+		Expect(sd.Spec.BrokerK8sApiServerToken).To(Equal("token-2"))
+		Expect(sd.Spec.Debug).To(BeTrue())
+	})
+
+	It("Should recreate the CR when the cluster ID changes", func() {
+		_, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+
+		spec.ClusterID = "cluster-b"
+		result, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(utils.OperationResultRecreated))
+		Expect(getServiceDiscoveryCR().Spec.ClusterID).To(Equal("cluster-b"))
+	})
+})
@@ -18,6 +18,7 @@ package submarinercr
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -26,8 +27,11 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	submariner "github.com/submariner-io/submariner-operator/apis/submariner/v1alpha1"
+
+	"github.com/tkestack/knitnet-operator/controllers/utils"
 )
 
 const (
@@ -41,33 +45,91 @@ var backOff wait.Backoff = wait.Backoff{
 	Cap:      60 * time.Second,
 }
 
-func Ensure(c client.Client, namespace string, submarinerSpec *submariner.SubmarinerSpec) error {
-	newSubmarinerCR := &submariner.Submariner{
+// recreateFields are the fields the submariner operator can not apply to the running deployment,
+// changing any of them requires the CR to be recreated
+var recreateFields = []string{"clusterID", "clusterCIDR", "serviceCIDR", "globalCIDR", "cableDriver", "namespace"}
+
+// Ensure creates the submariner CR or brings it to submarinerSpec. Only the changed fields are patched,
+// the CR is recreated when a field which can not be changed live differs. The result reports the path taken.
+func Ensure(c client.Client, namespace string, submarinerSpec *submariner.SubmarinerSpec) (controllerutil.OperationResult, error) {
+	submarinerCR := &submariner.Submariner{}
+	submarinerCRKey := types.NamespacedName{Name: SubmarinerName, Namespace: namespace}
+	if err := c.Get(context.TODO(), submarinerCRKey, submarinerCR); err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Get submarinerCR failed: %v", err)
+			return controllerutil.OperationResultNone, err
+		}
+		return create(c, namespace, submarinerSpec)
+	}
+
+	if !submarinerCR.ObjectMeta.DeletionTimestamp.IsZero() {
+		if err := Delete(c, namespace); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		return create(c, namespace, submarinerSpec)
+	}
+
+	desired, err := withDefaults(submarinerSpec)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	changed := utils.ChangedFields(&submarinerCR.Spec, desired)
+	if len(changed) == 0 {
+		return controllerutil.OperationResultNone, nil
+	}
+	if utils.ContainsAny(changed, recreateFields...) {
+		klog.Infof("Recreating submarinerCR, changed fields %v can not be updated in place", changed)
+		if err := Delete(c, namespace); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		if _, err := create(c, namespace, submarinerSpec); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		return utils.OperationResultRecreated, nil
+	}
+
+	klog.Infof("Updating submarinerCR in place, changed fields %v", changed)
+	patch := client.MergeFrom(submarinerCR.DeepCopy())
+	submarinerCR.Spec = *desired
+	if err := c.Patch(context.TODO(), submarinerCR, patch); err != nil {
+		klog.Errorf("Patch submarinerCR failed: %v", err)
+		return controllerutil.OperationResultNone, err
+	}
+	return controllerutil.OperationResultUpdated, nil
+}
+
+func create(c client.Client, namespace string, submarinerSpec *submariner.SubmarinerSpec) (controllerutil.OperationResult, error) {
+	submarinerCR := &submariner.Submariner{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      SubmarinerName,
 			Namespace: namespace,
 		},
 		Spec: *submarinerSpec,
 	}
+	klog.Info("Creating new submarinerCR")
+	if err := c.Create(context.TODO(), submarinerCR); err != nil {
+		klog.Errorf("Create submarinerCR failed: %v", err)
+		return controllerutil.OperationResultNone, err
+	}
+	return controllerutil.OperationResultCreated, nil
+}
+
+// Delete removes the submariner CR and waits until its dependents are gone
+func Delete(c client.Client, namespace string) error {
 	submarinerCR := &submariner.Submariner{}
 	submarinerCRKey := types.NamespacedName{Name: SubmarinerName, Namespace: namespace}
 	return wait.ExponentialBackoff(backOff, func() (bool, error) {
 		if err := c.Get(context.TODO(), submarinerCRKey, submarinerCR); err != nil {
 			if errors.IsNotFound(err) {
-				klog.Info("Creating new submerinerCR")
-				if err := c.Create(context.TODO(), newSubmarinerCR); err != nil {
-					return false, err
-				}
+				klog.Info("SubmerinerCR is deleted")
 				return true, nil
 			}
 			return false, err
 		}
-
 		if !submarinerCR.ObjectMeta.DeletionTimestamp.IsZero() {
 			klog.Info("SubmerinerCR is deleted, waiting for the delete complete...")
 			return false, nil
 		}
-
 		klog.Info("Try to delete existing submerinerCR")
 		fg := metav1.DeletePropagationForeground
 		delOpts := &client.DeleteOptions{PropagationPolicy: &fg}
@@ -75,3 +137,17 @@ func Ensure(c client.Client, namespace string, submarinerSpec *submariner.Submar
 		return false, client.IgnoreNotFound(err)
 	})
 }
+
+// withDefaults returns the spec as it is read back from the API server, the Submariner type fills in
+// the defaults of the image settings when it is unmarshalled
+func withDefaults(spec *submariner.SubmarinerSpec) (*submariner.SubmarinerSpec, error) {
+	data, err := json.Marshal(&submariner.Submariner{Spec: *spec})
+	if err != nil {
+		return nil, err
+	}
+	cr := &submariner.Submariner{}
+	if err := json.Unmarshal(data, cr); err != nil {
+		return nil, err
+	}
+	return &cr.Spec, nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submarinercr
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	submariner "github.com/submariner-io/submariner-operator/apis/submariner/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/tkestack/knitnet-operator/controllers/utils"
+)
+
+const testNamespace = "submariner-operator"
+
+var _ = Describe("Submariner CR", func() {
+	var c client.Client
+	var spec *submariner.SubmarinerSpec
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(submariner.AddToScheme(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+		spec = &submariner.SubmarinerSpec{
+			ClusterID:               "cluster-a",
+			ClusterCIDR:             "10.244.0.0/16",
+			ServiceCIDR:             "10.96.0.0/12",
+			CableDriver:             "libreswan",
+			Namespace:               testNamespace,
+			BrokerK8sApiServerToken: "token-1",
+		}
+	})
+
+	getSubmarinerCR := func() *submariner.Submariner {
+		submarinerCR := &submariner.Submariner{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: SubmarinerName, Namespace: testNamespace}, submarinerCR)).To(Succeed())
+		return submarinerCR
+	}
+
+	It("Should create a missing CR and leave an unchanged one alone", func() {
+		result, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(controllerutil.OperationResultCreated))
+
+		result, err = Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(controllerutil.OperationResultNone))
+	})
+
+	It("Should patch the fields which can be changed live", func() {
+		_, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		uid := getSubmarinerCR().GetUID()
+
+		spec.BrokerK8sApiServerToken = "token-2"
+		spec.Debug = true
+		result, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(controllerutil.OperationResultUpdated))
+
+		submarinerCR := getSubmarinerCR()
+		Expect(submarinerCR.GetUID()).To(Equal(uid))
+		Expect(submarinerCR.Spec.BrokerK8sApiServerToken).To(Equal("token-2"))
+		Expect(submarinerCR.Spec.Debug).To(BeTrue())
+	})
+
+	It("Should recreate the CR when the cluster CIDR changes", func() {
+		_, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+
+		spec.ClusterCIDR = "10.245.0.0/16"
+		result, err := Ensure(c, testNamespace, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(utils.OperationResultRecreated))
+		Expect(getSubmarinerCR().Spec.ClusterCIDR).To(Equal("10.245.0.0/16"))
+	})
+
+	It("Should report the json names of the changed fields", func() {
+		desired := spec.DeepCopy()
+		desired.ServiceCIDR = "10.97.0.0/16"
+		desired.NatEnabled = true
+		Expect(utils.ChangedFields(spec, desired)).To(ConsistOf("serviceCIDR", "natEnabled"))
+	})
+})
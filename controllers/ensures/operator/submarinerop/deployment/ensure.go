@@ -17,6 +17,11 @@ limitations under the License.
 package deployment
 
 import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/tkestack/knitnet-operator/controllers/ensures/common/operatorpod"
@@ -27,3 +32,13 @@ import (
 func Ensure(c client.Client, namespace, image string, debug bool) error {
 	return operatorpod.Ensure(c, namespace, names.OperatorComponent, image, debug)
 }
+
+// Delete removes the operator deployment
+func Delete(c client.Client, namespace string) error {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: names.OperatorComponent, Namespace: namespace}}
+	if err := c.Delete(context.TODO(), deployment); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	klog.Infof("Deployment %s deleted", deployment.GetName())
+	return nil
+}
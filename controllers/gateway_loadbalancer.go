@@ -0,0 +1,55 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/klog/v2"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/gateway"
+)
+
+// ensureGatewayLoadBalancer puts a LoadBalancer service in front of the gateway pods when it is enabled
+// in the join config and records its address in the status, otherwise the service is removed
+func (r *KnitnetReconciler) ensureGatewayLoadBalancer(instance *operatorv1alpha1.Knitnet, connectivityEnabled bool) error {
+	if !connectivityEnabled || !instance.Spec.JoinConfig.LoadBalancerEnabled {
+		instance.Status.GatewayLoadBalancerAddress = ""
+		if err := gateway.DeleteLoadBalancer(r.Client, consts.SubmarinerOperatorNamespace); err != nil {
+			klog.Errorf("Error deleting the gateway LoadBalancer: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	// The load balancer type depends on the cloud provider, which is detected unless it is configured
+	if instance.Spec.CloudPrepareConfig.Provider == "" {
+		r.detectCloudPlatform(instance)
+	}
+	address, err := gateway.EnsureLoadBalancer(r.Client, consts.SubmarinerOperatorNamespace, instance,
+		instance.Spec.CloudPrepareConfig.Provider)
+	if err != nil {
+		klog.Errorf("Error deploying the gateway LoadBalancer: %v", err)
+		return err
+	}
+	if address == "" {
+		// The service watch requeues the knitnet once the address is assigned
+		klog.Info("Waiting for the gateway LoadBalancer address to be assigned")
+	}
+	instance.Status.GatewayLoadBalancerAddress = address
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/broker"
+)
+
+// pskRolloutRequeueAfter is the interval to recheck the joined clusters during the rollout of a rotated IPsec PSK
+const pskRolloutRequeueAfter = 30 * time.Second
+
+// ensureIPSecPSK returns the persisted IPsec PSK of the broker, it is rotated first when a new rotation
+// is requested through the rotate annotation or when the rotation interval has elapsed
+func (r *KnitnetReconciler) ensureIPSecPSK(instance *operatorv1alpha1.Knitnet) (*corev1.Secret, error) {
+	request := instance.GetAnnotations()[operatorv1alpha1.IPSecPSKRotateAnnotation]
+	secret, err := broker.EnsureIPSecPSKSecret(r.Client, r.Reader, request)
+	if err != nil {
+		return nil, err
+	}
+
+	rotate := request != "" && request != broker.IPSecPSKRotationRequest(secret)
+	if interval := instance.Spec.BrokerConfig.IPSecPSKRotationInterval; interval != nil {
+		rotatedAt := broker.IPSecPSKRotationTime(secret)
+		if !time.Now().Before(rotatedAt.Add(interval.Duration)) {
+			rotate = true
+		}
+	}
+	if rotate {
+		if err := broker.RotateIPSecPSKSecret(r.Client, secret, request); err != nil {
+			klog.Errorf("Rotate IPsec PSK failed: %v", err)
+			return nil, err
+		}
+	}
+	return secret, nil
+}
+
+// updateIPSecPSKStatus reports the generation of the published PSK and the joined clusters which
+// have not picked it up yet
+func (r *KnitnetReconciler) updateIPSecPSKStatus(instance *operatorv1alpha1.Knitnet, secret *corev1.Secret) error {
+	clusterInfos, err := broker.GetClusterInfos(r.Reader, consts.SubmarinerBrokerNamespace)
+	if err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Get cluster infos failed: %v", err)
+		return err
+	}
+
+	generation := broker.IPSecPSKGeneration(secret)
+	rotatedAt := broker.IPSecPSKRotationTime(secret)
+	pskStatus := &operatorv1alpha1.IPSecPSKStatus{
+		Generation:       generation,
+		LastRotationTime: &rotatedAt,
+		RotationRequest:  broker.IPSecPSKRotationRequest(secret),
+	}
+	for _, clusterInfo := range clusterInfos {
+		if clusterInfo.IPSecPSKGeneration == generation {
+			pskStatus.UpdatedClusters = append(pskStatus.UpdatedClusters, clusterInfo.ClusterID)
+		} else {
+			pskStatus.PendingClusters = append(pskStatus.PendingClusters, clusterInfo.ClusterID)
+		}
+	}
+	instance.Status.IPSecPSK = pskStatus
+
+	if len(pskStatus.PendingClusters) > 0 {
+		setCondition(instance, operatorv1alpha1.ConditionIPSecPSKRolledOut, metav1.ConditionFalse, operatorv1alpha1.ReasonRolloutInProgress,
+			fmt.Sprintf("Clusters %s have not picked up IPsec PSK generation %d yet", strings.Join(pskStatus.PendingClusters, ","), generation))
+		return nil
+	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionIPSecPSKRolledOut, operatorv1alpha1.ReasonSucceeded,
+		"%d joined clusters run with IPsec PSK generation %d", len(pskStatus.UpdatedClusters), generation)
+	return nil
+}
+
+// ipsecPSKRequeueAfter returns when the broker has to be reconciled again to follow the PSK rollout
+// or to rotate the PSK on schedule, zero means no requeue is needed
+func ipsecPSKRequeueAfter(instance *operatorv1alpha1.Knitnet) time.Duration {
+	pskStatus := instance.Status.IPSecPSK
+	if pskStatus == nil {
+		return 0
+	}
+	if len(pskStatus.PendingClusters) > 0 {
+		return pskRolloutRequeueAfter
+	}
+	interval := instance.Spec.BrokerConfig.IPSecPSKRotationInterval
+	if interval == nil || pskStatus.LastRotationTime == nil {
+		return 0
+	}
+	requeueAfter := time.Until(pskStatus.LastRotationTime.Add(interval.Duration))
+	if requeueAfter < time.Second {
+		requeueAfter = time.Second
+	}
+	return requeueAfter
+}
@@ -19,8 +19,8 @@ package controllers
 import (
 	"context"
 	"encoding/base64"
+	goerrors "errors"
 	"fmt"
-	"regexp"
 	"strings"
 	"time"
 
@@ -36,16 +36,15 @@ import (
 	"github.com/tkestack/knitnet-operator/controllers/ensures/operator/servicediscoverycr"
 	"github.com/tkestack/knitnet-operator/controllers/ensures/operator/submarinercr"
 	"github.com/tkestack/knitnet-operator/controllers/ensures/operator/submarinerop"
-	"github.com/tkestack/knitnet-operator/controllers/utils"
 	"github.com/tkestack/knitnet-operator/controllers/versions"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -53,8 +52,6 @@ import (
 	netconsts "github.com/tkestack/knitnet-operator/controllers/discovery"
 )
 
-var clienttoken *v1.Secret
-
 var nodeLabelBackoff wait.Backoff = wait.Backoff{
 	Steps:    10,
 	Duration: 1 * time.Second,
@@ -75,23 +72,41 @@ func (r *KnitnetReconciler) JoinSubmarinerCluster(instance *operatorv1alpha1.Kni
 
 	brokerInfo, err := SyncBrokerInfo(r.Client, r.Reader)
 	if err != nil {
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerConnected, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
 	joinConfig := instance.Spec.JoinConfig
 
-	if err := isValidCustomCoreDNSConfig(instance); err != nil {
+	// The spec is validated by the webhook as well, check it again in case the webhook is disabled
+	if err := operatorv1alpha1.ValidateCustomCoreDNSConfig(joinConfig.CorednsCustomConfigMap); err != nil {
 		klog.Errorf("Invalid Custom CoreDNS configuration: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonInvalidConfiguration, err)
 		return err
 	}
 
-	if joinConfig.ClusterID == "" {
-		joinConfig.ClusterID = utils.RandStringRunes(5)
+	clusterID, err := r.resolveClusterID(instance)
+	if err != nil {
+		klog.Errorf("Unable to determine the cluster ID: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonFailed, err)
+		return err
 	}
-
-	if valid, err := isValidClusterID(joinConfig.ClusterID); !valid {
+	if err := operatorv1alpha1.ValidateClusterID(clusterID); err != nil {
 		klog.Errorf("Cluster ID invalid: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonInvalidConfiguration, err)
 		return err
 	}
+	if previous := instance.Status.ClusterID; previous != "" && previous != clusterID {
+		// The webhook only accepts a changed cluster ID when the rename is requested explicitly
+		klog.Infof("Renaming cluster %s to %s", previous, clusterID)
+		if err := r.removeClusterFromBroker(previous); err != nil {
+			markConditionFalse(instance, operatorv1alpha1.ConditionBrokerConnected, operatorv1alpha1.ReasonFailed, err)
+			return err
+		}
+	}
+	// The resolved cluster ID is used by all the following steps
+	instance.Spec.JoinConfig.ClusterID = clusterID
+	instance.Status.ClusterID = clusterID
+	joinConfig.ClusterID = clusterID
 
 	if brokerInfo.IsConnectivityEnabled() && joinConfig.LabelGateway {
 		if err := r.HandleNodeLabels(); err != nil {
@@ -101,94 +116,283 @@ func (r *KnitnetReconciler) JoinSubmarinerCluster(instance *operatorv1alpha1.Kni
 	}
 
 	klog.Info("Discovering network details")
-	networkDetails, err := r.GetNetworkDetails()
+	networkDetails, networkReport, err := r.GetNetworkDetails(instance)
 	if err != nil {
 		klog.Errorf("Error get network details: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionNetworkDiscovered, operatorv1alpha1.ReasonFailed, err)
+		return err
+	}
+	serviceCIDRs, serviceCIDRautoDetected, err := getServiceCIDRs(joinConfig.ServiceCIDR, networkDetails)
+	if err != nil {
+		klog.Errorf("Error determining the service CIDR: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionNetworkDiscovered, operatorv1alpha1.ReasonFailed, err)
+		return err
+	}
+	clusterCIDRs, clusterCIDRautoDetected, err := getPodCIDRs(joinConfig.ClusterCIDR, networkDetails)
+	if err != nil {
+		klog.Errorf("Error determining the pod CIDR: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionNetworkDiscovered, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
-	serviceCIDR, serviceCIDRautoDetected, err := getServiceCIDR(joinConfig.ServiceCIDR, networkDetails)
+	serviceCIDRSource := networkDetails.ServiceCIDRSource
+	if !serviceCIDRautoDetected {
+		serviceCIDRSource = netconsts.ServiceCIDRSourceJoinConfig
+	}
+	setNetworkDiscoveryStatus(instance, networkDetails, clusterCIDRs, serviceCIDRs, serviceCIDRSource, networkReport)
+	// Submariner only connects the IPv4 CIDRs, an IPv6-only cluster can not join
+	serviceCIDRv4, serviceCIDRv6, err := splitClusterCIDRs("service", serviceCIDRs)
 	if err != nil {
 		klog.Errorf("Error determining the service CIDR: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionNetworkDiscovered, operatorv1alpha1.ReasonInvalidConfiguration, err)
 		return err
 	}
-	clusterCIDR, clusterCIDRautoDetected, err := getPodCIDR(joinConfig.ClusterCIDR, networkDetails)
+	clusterCIDRv4, clusterCIDRv6, err := splitClusterCIDRs("pod", clusterCIDRs)
 	if err != nil {
 		klog.Errorf("Error determining the pod CIDR: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionNetworkDiscovered, operatorv1alpha1.ReasonInvalidConfiguration, err)
 		return err
 	}
+	serviceCIDR := strings.Join(serviceCIDRv4, ",")
+	clusterCIDR := strings.Join(clusterCIDRv4, ",")
+	networkMessage := fmt.Sprintf("Network plugin %s, service CIDR %s, cluster CIDR %s",
+		networkDetails.NetworkPlugin, serviceCIDR, clusterCIDR)
+	if len(serviceCIDRv6) > 0 || len(clusterCIDRv6) > 0 {
+		klog.Warningf("Cluster %s is dual-stack, Submariner only connects the IPv4 CIDRs", joinConfig.ClusterID)
+		networkMessage += fmt.Sprintf(", dual-stack IPv6 service CIDR %s and cluster CIDR %s are not connected",
+			strings.Join(serviceCIDRv6, ","), strings.Join(clusterCIDRv6, ","))
+	}
+	if networkDetails.PluginSettings[netconsts.TKEOverlapLikely] == "true" && !brokerInfo.IsGlobalnetEnabled() {
+		// The pods of VPC-CNI use the VPC subnets, which the other clusters of the VPC likely use as well
+		klog.Warningf("The pod CIDRs of cluster %s are VPC subnets, enable globalnet if they overlap with other clusters",
+			joinConfig.ClusterID)
+		networkMessage += ", the pod CIDRs are VPC subnets which likely overlap with other clusters, globalnet is recommended"
+	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionNetworkDiscovered, operatorv1alpha1.ReasonSucceeded, "%s", networkMessage)
+	serviceCIDRInferred := serviceCIDRautoDetected && networkDetails.ServiceCIDRSource == netconsts.ServiceCIDRSourceKubernetesService
+	if serviceCIDRInferred {
+		klog.Warningf("The service CIDR %s of cluster %s is inferred from the kubernetes service, pin it in the join config",
+			serviceCIDR, joinConfig.ClusterID)
+		markConditionTrue(instance, operatorv1alpha1.ConditionServiceCIDRInferred, operatorv1alpha1.ReasonInferred,
+			"Service CIDR %s is inferred from the kubernetes service and may be larger than the actual one, it is not checked "+
+				"for overlaps with the other clusters: set serviceCIDR in the join config", serviceCIDR)
+	} else {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, operatorv1alpha1.ConditionServiceCIDRInferred)
+	}
 
 	brokerCluster, err := brokerInfo.GetBrokerAdministratorCluster()
 	if err != nil {
 		klog.Errorf("unable to get broker cluster client: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerConnected, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
 	brokerNamespace := string(brokerInfo.ClientToken.Data["namespace"])
 
+	// Submariner detecting the CIDRs of a dual-stack cluster itself could pick the IPv6 ones
 	netconfig := globalnet.Config{
 		NetworkPlugin:           networkDetails.NetworkPlugin,
 		ClusterID:               joinConfig.ClusterID,
 		ServiceCIDR:             serviceCIDR,
-		ServiceCIDRAutoDetected: serviceCIDRautoDetected,
+		IPv6ServiceCIDRs:        serviceCIDRv6,
 		ClusterCIDR:             clusterCIDR,
-		ClusterCIDRAutoDetected: clusterCIDRautoDetected,
+		IPv6ClusterCIDRs:        clusterCIDRv6,
+		ServiceCIDRAutoDetected: serviceCIDRautoDetected && len(serviceCIDRv6) == 0,
+		ServiceCIDRInferred:     serviceCIDRInferred,
+		ClusterCIDRAutoDetected: clusterCIDRautoDetected && len(clusterCIDRv6) == 0,
 		GlobalnetCIDR:           joinConfig.GlobalnetCIDR,
 		GlobalnetClusterSize:    joinConfig.GlobalnetClusterSize,
 	}
 
 	if err = r.AllocateAndUpdateGlobalCIDRConfigMap(brokerCluster.GetClient(), brokerCluster.GetAPIReader(), instance, brokerNamespace, &netconfig); err != nil {
 		klog.Errorf("Error Discovering multi cluster details: %v", err)
+		reason := operatorv1alpha1.ReasonFailed
+		var overlapErr *broker.CIDROverlapError
+		if goerrors.As(err, &overlapErr) {
+			reason = operatorv1alpha1.ReasonCIDROverlap
+		}
+		markConditionFalse(instance, operatorv1alpha1.ConditionGlobalCIDRAllocated, reason, err)
 		return err
 	}
+	if netconfig.GlobalnetCIDR != "" {
+		markConditionTrue(instance, operatorv1alpha1.ConditionGlobalCIDRAllocated, operatorv1alpha1.ReasonSucceeded,
+			"Cluster %s is registered with global CIDR %s", joinConfig.ClusterID, netconfig.GlobalnetCIDR)
+	} else {
+		markConditionTrue(instance, operatorv1alpha1.ConditionGlobalCIDRAllocated, operatorv1alpha1.ReasonNotRequired,
+			"Cluster %s is registered, globalnet is not enabled", joinConfig.ClusterID)
+	}
 
 	klog.Info("Deploying the Submariner operator")
 	if err = submarinerop.Ensure(r.Client, r.Config, true); err != nil {
 		klog.Errorf("Error deploying the operator: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerOperatorReady, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionSubmarinerOperatorReady, operatorv1alpha1.ReasonSucceeded,
+		"Submariner operator is deployed in namespace %s", consts.SubmarinerOperatorNamespace)
+
 	klog.Info("Creating SA for cluster")
-	clienttoken, err = broker.CreateSAForCluster(brokerCluster.GetClient(), brokerCluster.GetAPIReader(), joinConfig.ClusterID)
+	tokens, err := broker.NewTokenProvider(brokerCluster.GetClient(), brokerCluster.GetAPIReader(), brokerInfo.GetBrokerAdministratorConfig(),
+		time.Duration(brokerInfo.TokenExpirationSeconds)*time.Second)
+	if err != nil {
+		klog.Errorf("Error creating the broker token provider: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerConnected, operatorv1alpha1.ReasonFailed, err)
+		return err
+	}
+	currentToken, err := r.getClusterToken(joinConfig.ClusterID)
+	if err != nil {
+		klog.Errorf("Error getting the current cluster token: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerConnected, operatorv1alpha1.ReasonFailed, err)
+		return err
+	}
+	clientToken, err := broker.CreateSAForCluster(brokerCluster.GetClient(), tokens, joinConfig.ClusterID, currentToken)
 	if err != nil {
 		klog.Errorf("Error creating SA for cluster: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerConnected, operatorv1alpha1.ReasonFailed, err)
 		return err
 	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionBrokerConnected, operatorv1alpha1.ReasonSucceeded,
+		"Connected to broker %s", brokerInfo.BrokerURL)
+
 	if brokerInfo.IsConnectivityEnabled() {
 		klog.Info("Deploying Submariner")
-		submarinerSpec, err := populateSubmarinerSpec(instance, brokerInfo, netconfig)
+		submarinerSpec, err := populateSubmarinerSpec(instance, brokerInfo, clientToken, netconfig)
 		if err != nil {
+			markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonInvalidConfiguration, err)
 			return err
 		}
-		if err = submarinercr.Ensure(r.Client, consts.SubmarinerOperatorNamespace, submarinerSpec); err != nil {
+		result, err := submarinercr.Ensure(r.Client, consts.SubmarinerOperatorNamespace, submarinerSpec)
+		if err != nil {
 			klog.Errorf("Submariner deployment failed: %v", err)
+			markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonFailed, err)
 			return err
 		}
-		klog.Info("Submariner is up and running")
+		klog.Infof("Submariner is up and running, submarinerCR %s", result)
+		markConditionTrue(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonSucceeded,
+			"Submariner is deployed with cluster ID %s, the Submariner CR was %s", joinConfig.ClusterID, result)
 	} else if brokerInfo.IsServiceDiscoveryEnabled() {
 		klog.Info("Deploying service discovery only")
-		serviceDiscoverySpec, err := populateServiceDiscoverySpec(instance, brokerInfo)
+		serviceDiscoverySpec, err := populateServiceDiscoverySpec(instance, brokerInfo, clientToken)
 		if err != nil {
+			markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonInvalidConfiguration, err)
 			return err
 		}
-		if err = servicediscoverycr.Ensure(r.Client, consts.SubmarinerOperatorNamespace, serviceDiscoverySpec); err != nil {
+		result, err := servicediscoverycr.Ensure(r.Client, consts.SubmarinerOperatorNamespace, serviceDiscoverySpec)
+		if err != nil {
 			klog.Errorf("Service discovery deployment failed: %v", err)
+			markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonFailed, err)
 			return err
 		}
-		klog.Info("Service discovery is up and running")
+		klog.Infof("Service discovery is up and running, ServiceDiscovery CR %s", result)
+		markConditionTrue(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonServiceDiscoveryOnly,
+			"Service discovery is deployed with cluster ID %s, the ServiceDiscovery CR was %s", joinConfig.ClusterID, result)
+	}
+
+	if err := r.ensureGatewayLoadBalancer(instance, brokerInfo.IsConnectivityEnabled()); err != nil {
+		markConditionFalse(instance, operatorv1alpha1.ConditionSubmarinerDeployed, operatorv1alpha1.ReasonFailed, err)
+		return err
+	}
+
+	// The broker follows the rollout of a rotated IPsec PSK through the reported generation
+	if err := broker.UpdateClusterIPSecPSKGeneration(brokerCluster.GetClient(), brokerCluster.GetAPIReader(), brokerNamespace,
+		joinConfig.ClusterID, brokerInfo.IPSecPSKGeneration); err != nil {
+		klog.Errorf("Error reporting the IPsec PSK generation: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionBrokerConnected, operatorv1alpha1.ReasonFailed, err)
+		return err
 	}
 
 	// Handle calico network plugin case
-	if networkDetails.NetworkPlugin == netconsts.NetworkPluginCalico {
-		clusterInfos, err := broker.GetClusterInfos(brokerCluster.GetAPIReader(), brokerNamespace)
-		if err != nil {
-			klog.Errorf("Unable to get cluster infos: %v", err)
-			return err
-		}
-		if err := checker.EnsureCalico(r.Client, joinConfig.ClusterID, &clusterInfos); err != nil {
-			return err
+	if networkDetails.NetworkPlugin != netconsts.NetworkPluginCalico {
+		markConditionTrue(instance, operatorv1alpha1.ConditionCalicoIPPoolsReady, operatorv1alpha1.ReasonNotRequired,
+			"Network plugin is %s", networkDetails.NetworkPlugin)
+		return nil
+	}
+	clusterInfos, err := broker.GetClusterInfos(brokerCluster.GetAPIReader(), brokerNamespace)
+	if err != nil {
+		klog.Errorf("Unable to get cluster infos: %v", err)
+		markConditionFalse(instance, operatorv1alpha1.ConditionCalicoIPPoolsReady, operatorv1alpha1.ReasonFailed, err)
+		return err
+	}
+	if err := checker.EnsureCalico(r.Client, joinConfig.ClusterID, &clusterInfos); err != nil {
+		markConditionFalse(instance, operatorv1alpha1.ConditionCalicoIPPoolsReady, operatorv1alpha1.ReasonFailed, err)
+		return err
+	}
+	markConditionTrue(instance, operatorv1alpha1.ConditionCalicoIPPoolsReady, operatorv1alpha1.ReasonSucceeded,
+		"IPPools for %d remote clusters are ready", len(clusterInfos)-1)
+	return nil
+}
+
+// resolveClusterID returns the cluster ID specified in the spec, or the one the cluster has already joined
+// with. A new cluster ID is derived from the kube-system namespace UID, so it is stable across reconciles.
+func (r *KnitnetReconciler) resolveClusterID(instance *operatorv1alpha1.Knitnet) (string, error) {
+	joinConfig := instance.Spec.JoinConfig
+	if joinConfig.ClusterID != "" {
+		return joinConfig.ClusterID, nil
+	}
+	if instance.GetAnnotations()[operatorv1alpha1.ClusterIDRenameAnnotation] != "true" {
+		clusterID, err := r.getJoinedClusterID(instance)
+		if err != nil || clusterID != "" {
+			return clusterID, err
 		}
 	}
+	kubeSystem := &v1.Namespace{}
+	if err := r.Client.Get(context.TODO(), types.NamespacedName{Name: metav1.NamespaceSystem}, kubeSystem); err != nil {
+		klog.Errorf("Get namespace %s failed: %v", metav1.NamespaceSystem, err)
+		return "", err
+	}
+	return deriveClusterID(joinConfig.ClusterIDPrefix, string(kubeSystem.GetUID())), nil
+}
+
+// finishClusterIDRename removes the ClusterIDRenameAnnotation once the cluster ID recorded in the status is the
+// resolved one, so that the cluster ID of the joined cluster is protected again
+func (r *KnitnetReconciler) finishClusterIDRename(ctx context.Context, obj client.Object, recordedClusterID, clusterID string) error {
+	if obj.GetAnnotations()[operatorv1alpha1.ClusterIDRenameAnnotation] != "true" || recordedClusterID != clusterID {
+		return nil
+	}
+	klog.Infof("Cluster has joined with cluster ID %s, removing annotation %s", clusterID, operatorv1alpha1.ClusterIDRenameAnnotation)
+	patch := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, operatorv1alpha1.ClusterIDRenameAnnotation))
+	// Patch a copy, the patch response would replace the status of the reconciled object
+	if err := r.Client.Patch(ctx, obj.DeepCopyObject().(client.Object), client.RawPatch(types.MergePatchType, patch)); err != nil {
+		klog.Errorf("Remove annotation %s failed: %v", operatorv1alpha1.ClusterIDRenameAnnotation, err)
+		return err
+	}
 	return nil
 }
 
+// getClusterToken returns the broker token the Submariner or ServiceDiscovery CR of the cluster runs with,
+// it is kept as long as it does not have to be renewed
+func (r *KnitnetReconciler) getClusterToken(clusterID string) (string, error) {
+	submarinerCR := &submariner.Submariner{}
+	submarinerCRKey := types.NamespacedName{Name: submarinercr.SubmarinerName, Namespace: consts.SubmarinerOperatorNamespace}
+	if err := r.Client.Get(context.TODO(), submarinerCRKey, submarinerCR); err == nil {
+		if submarinerCR.Spec.ClusterID == clusterID {
+			return submarinerCR.Spec.BrokerK8sApiServerToken, nil
+		}
+	} else if !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return "", err
+	}
+	sd := &submariner.ServiceDiscovery{}
+	sdKey := types.NamespacedName{Name: names.ServiceDiscoveryCrName, Namespace: consts.SubmarinerOperatorNamespace}
+	if err := r.Client.Get(context.TODO(), sdKey, sd); err == nil {
+		if sd.Spec.ClusterID == clusterID {
+			return sd.Spec.BrokerK8sApiServerToken, nil
+		}
+	} else if !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return "", err
+	}
+	return "", nil
+}
+
+// deriveClusterID returns <prefix>-<uid>, where uid is cut to its first 8 characters
+func deriveClusterID(prefix, uid string) string {
+	clusterID := strings.ReplaceAll(uid, "-", "")
+	if len(clusterID) > 8 {
+		clusterID = clusterID[:8]
+	}
+	if prefix != "" {
+		clusterID = prefix + "-" + clusterID
+	}
+	return clusterID
+}
+
 func (r *KnitnetReconciler) AllocateAndUpdateGlobalCIDRConfigMap(c client.Client, reader client.Reader, instance *operatorv1alpha1.Knitnet, brokerNamespace string,
 	netconfig *globalnet.Config) error {
 	joinConfig := instance.Spec.JoinConfig
@@ -208,32 +412,39 @@ func (r *KnitnetReconciler) AllocateAndUpdateGlobalCIDRConfigMap(c client.Client
 		var newClusterInfo broker.ClusterInfo
 		newClusterInfo.ClusterID = joinConfig.ClusterID
 		newClusterInfo.NetworkPlugin = netconfig.NetworkPlugin
+		newClusterInfo.PodCIDRs = splitJoinedCIDRs(netconfig.ClusterCIDR)
+		// An inferred service CIDR can be much larger than the actual one, it would overlap with other clusters
+		if !netconfig.ServiceCIDRInferred {
+			newClusterInfo.ServiceCIDRs = splitJoinedCIDRs(netconfig.ServiceCIDR)
+		}
 		if globalnetInfo.GlobalnetEnabled {
 			netconfig.GlobalnetCIDR, err = globalnet.AssignGlobalnetIPs(globalnetInfo, *netconfig)
 			if err != nil {
 				klog.Errorf("error assigning Globalnet IPs: %v", err)
 				return err
 			}
-
-			if globalnetInfo.GlobalCidrInfo[joinConfig.ClusterID] == nil ||
-				globalnetInfo.GlobalCidrInfo[joinConfig.ClusterID].GlobalCIDRs[0] != netconfig.GlobalnetCIDR {
-				newClusterInfo.GlobalCidr = []string{netconfig.GlobalnetCIDR}
-			}
+			newClusterInfo.GlobalCidr = []string{netconfig.GlobalnetCIDR}
 		}
 		return broker.UpdateGlobalnetConfigMap(c, brokerNamespace, globalnetConfigMap, newClusterInfo)
 	})
 	return retryErr
 }
 
+// SyncBrokerInfo returns the broker info of the broker cluster, the local broker info secret is
+// updated when the broker info has changed, e.g. after the IPsec PSK was rotated
 func SyncBrokerInfo(c client.Client, reader client.Reader) (*broker.BrokerInfo, error) {
-	localConfigmap, err := broker.GetBrokerInfoConfigMap(reader)
+	if err := broker.MigrateBrokerInfoConfigMap(c, reader); err != nil {
+		klog.Errorf("Migrate local cluster broker info configmap failed: %v", err)
+		return nil, err
+	}
+	localSecret, err := broker.GetBrokerInfoSecret(reader)
 	if err != nil {
-		klog.Errorf("Get local cluster broker info configmap failed: %v", err)
+		klog.Errorf("Get local cluster broker info secret failed: %v", err)
 		return nil, err
 	}
-	brokerInfo, err := broker.NewFromString(localConfigmap.Data["brokerInfo"])
+	brokerInfo, err := broker.NewFromString(string(localSecret.Data["brokerInfo"]))
 	if err != nil {
-		klog.Errorf("New broker info configmap from string failed: %v", err)
+		klog.Errorf("New broker info from string failed: %v", err)
 		return nil, err
 	}
 	brokerCluster, err := brokerInfo.GetBrokerAdministratorCluster()
@@ -241,76 +452,74 @@ func SyncBrokerInfo(c client.Client, reader client.Reader) (*broker.BrokerInfo,
 		klog.Errorf("Get broker cluster administrator failed: %v", err)
 		return nil, err
 	}
-	brokerClusterConfigmap, err := broker.GetBrokerInfoConfigMap(brokerCluster.GetAPIReader())
+	brokerClusterData, err := broker.GetBrokerInfoData(brokerCluster.GetAPIReader())
 	if err != nil {
-		klog.Errorf("Get broker cluster broker info configmap failed: %v", err)
+		klog.Errorf("Get broker cluster broker info failed: %v", err)
 		return nil, err
 	}
 
-	if localConfigmap.Data["brokerInfo"] != brokerClusterConfigmap.Data["brokerInfo"] {
-		localConfigmap.Data["brokerInfo"] = brokerClusterConfigmap.Data["brokerInfo"]
-		if err := c.Update(context.TODO(), localConfigmap); err != nil {
-			klog.Errorf("Update local broker info configmap failed: %v", err)
+	if string(localSecret.Data["brokerInfo"]) != brokerClusterData {
+		localSecret.Data["brokerInfo"] = []byte(brokerClusterData)
+		if err := c.Update(context.TODO(), localSecret); err != nil {
+			klog.Errorf("Update local broker info secret failed: %v", err)
 			return nil, err
 		}
-		return broker.NewFromString(localConfigmap.Data["brokerInfo"])
+		return broker.NewFromString(brokerClusterData)
 	}
 
 	return brokerInfo, nil
 }
 
-func (r *KnitnetReconciler) GetNetworkDetails() (*network.ClusterNetwork, error) {
-	dynClient, err := dynamic.NewForConfig(r.Config)
-	if err != nil {
-		return nil, err
-	}
-
-	networkDetails, err := network.Discover(dynClient, r.Client, consts.SubmarinerOperatorNamespace)
-	if err != nil {
-		klog.Errorf("Error trying to discover network details: %v", err)
-	} else if networkDetails != nil {
-		networkDetails.Show()
+// splitJoinedCIDRs splits the CIDRs joined by a comma, an empty string has no CIDRs
+func splitJoinedCIDRs(cidrs string) []string {
+	if cidrs == "" {
+		return nil
 	}
-	return networkDetails, nil
+	return strings.Split(cidrs, ",")
 }
 
-func getPodCIDR(clusterCIDR string, nd *network.ClusterNetwork) (cidrType string, autodetected bool, err error) {
+// getPodCIDRs returns the pod CIDRs of the join config, the CIDRs of a dual-stack cluster are separated
+// by a comma, falling back to the discovered ones
+func getPodCIDRs(clusterCIDR string, nd *network.ClusterNetwork) (cidrs []string, autodetected bool, err error) {
 	if clusterCIDR != "" {
-		if nd != nil && len(nd.PodCIDRs) > 0 && nd.PodCIDRs[0] != clusterCIDR {
+		cidrs = strings.Split(clusterCIDR, ",")
+		if nd != nil && len(nd.PodCIDRs) > 0 && strings.Join(nd.PodCIDRs, ",") != clusterCIDR {
 			klog.Warningf("Your provided cluster CIDR for the pods (%s) does not match discovered (%s)",
-				clusterCIDR, nd.PodCIDRs[0])
+				clusterCIDR, strings.Join(nd.PodCIDRs, ","))
 		}
-		return clusterCIDR, false, nil
+		return cidrs, false, nil
 	} else if nd != nil && len(nd.PodCIDRs) > 0 {
-		return nd.PodCIDRs[0], true, nil
+		return nd.PodCIDRs, true, nil
 	}
-	return "", true, fmt.Errorf("not found invalidate cluster CIDR")
+	return nil, true, fmt.Errorf("not found invalidate cluster CIDR")
 }
 
-func getServiceCIDR(serviceCIDR string, nd *network.ClusterNetwork) (cidrType string, autodetected bool, err error) {
+// getServiceCIDRs returns the service CIDRs of the join config, the CIDRs of a dual-stack cluster are
+// separated by a comma, falling back to the discovered ones
+func getServiceCIDRs(serviceCIDR string, nd *network.ClusterNetwork) (cidrs []string, autodetected bool, err error) {
 	if serviceCIDR != "" {
-		if nd != nil && len(nd.ServiceCIDRs) > 0 && nd.ServiceCIDRs[0] != serviceCIDR {
+		cidrs = strings.Split(serviceCIDR, ",")
+		if nd != nil && len(nd.ServiceCIDRs) > 0 && strings.Join(nd.ServiceCIDRs, ",") != serviceCIDR {
 			klog.Warningf("Your provided service CIDR (%s) does not match discovered (%s)",
-				serviceCIDR, nd.ServiceCIDRs[0])
+				serviceCIDR, strings.Join(nd.ServiceCIDRs, ","))
 		}
-		return serviceCIDR, false, nil
+		return cidrs, false, nil
 	} else if nd != nil && len(nd.ServiceCIDRs) > 0 {
-		return nd.ServiceCIDRs[0], true, nil
+		return nd.ServiceCIDRs, true, nil
 	}
-	return "", true, fmt.Errorf("not found invalidate service CIDR")
+	return nil, true, fmt.Errorf("not found invalidate service CIDR")
 }
 
-func isValidClusterID(clusterID string) (bool, error) {
-	// Make sure the clusterid is a valid DNS-1123 string
-	if match, _ := regexp.MatchString("^[a-z0-9][a-z0-9.-]*[a-z0-9]$", clusterID); !match {
-		return false, fmt.Errorf("cluster IDs must be valid DNS-1123 names, with only lowercase alphanumerics,\n"+
-			"'.' or '-' (and the first and last characters must be alphanumerics).\n"+
-			"%s doesn't meet these requirements", clusterID)
+// splitClusterCIDRs splits the pod or service CIDRs into the IPv4 and the IPv6 ones, it fails when
+// there is no IPv4 CIDR for Submariner to connect
+func splitClusterCIDRs(kind string, cidrs []string) (ipv4, ipv6 []string, err error) {
+	if err := operatorv1alpha1.ValidateClusterCIDRs(cidrs); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s CIDRs: %v", kind, err)
 	}
-	return true, nil
+	return operatorv1alpha1.SplitIPFamilies(cidrs)
 }
 
-func populateSubmarinerSpec(instance *operatorv1alpha1.Knitnet, brokerInfo *broker.BrokerInfo, netconfig globalnet.Config) (*submariner.SubmarinerSpec, error) {
+func populateSubmarinerSpec(instance *operatorv1alpha1.Knitnet, brokerInfo *broker.BrokerInfo, clientToken *v1.Secret, netconfig globalnet.Config) (*submariner.SubmarinerSpec, error) {
 	joinConfig := instance.Spec.JoinConfig
 	brokerURL := brokerInfo.BrokerURL
 	if idx := strings.Index(brokerURL, "://"); idx >= 0 {
@@ -348,7 +557,7 @@ func populateSubmarinerSpec(instance *operatorv1alpha1.Knitnet, brokerInfo *brok
 		CeIPSecPSK:               base64.StdEncoding.EncodeToString(brokerInfo.IPSecPSK.Data["psk"]),
 		BrokerK8sCA:              base64.StdEncoding.EncodeToString(brokerInfo.ClientToken.Data["ca.crt"]),
 		BrokerK8sRemoteNamespace: string(brokerInfo.ClientToken.Data["namespace"]),
-		BrokerK8sApiServerToken:  string(clienttoken.Data["token"]),
+		BrokerK8sApiServerToken:  string(clientToken.Data["token"]),
 		BrokerK8sApiServer:       brokerURL,
 		Broker:                   "k8s",
 		NatEnabled:               joinConfig.NatTraversal,
@@ -360,6 +569,11 @@ func populateSubmarinerSpec(instance *operatorv1alpha1.Knitnet, brokerInfo *brok
 		CableDriver:              joinConfig.CableDriver,
 		ServiceDiscoveryEnabled:  brokerInfo.IsServiceDiscoveryEnabled(),
 		ImageOverrides:           imageOverrides,
+		ConnectionHealthCheck: &submariner.HealthCheckSpec{
+			Enabled:            joinConfig.HealthCheckEnable,
+			IntervalSeconds:    joinConfig.HealthCheckInterval,
+			MaxPacketLossCount: joinConfig.HealthCheckMaxPacketLossCount,
+		},
 	}
 	if netconfig.GlobalnetCIDR != "" {
 		submarinerSpec.GlobalCIDR = netconfig.GlobalnetCIDR
@@ -406,7 +620,7 @@ func removeSchemaPrefix(brokerURL string) string {
 	return brokerURL
 }
 
-func populateServiceDiscoverySpec(instance *operatorv1alpha1.Knitnet, brokerInfo *broker.BrokerInfo) (*submariner.ServiceDiscoverySpec, error) {
+func populateServiceDiscoverySpec(instance *operatorv1alpha1.Knitnet, brokerInfo *broker.BrokerInfo, clientToken *v1.Secret) (*submariner.ServiceDiscoverySpec, error) {
 	brokerURL := removeSchemaPrefix(brokerInfo.BrokerURL)
 	joinConfig := instance.Spec.JoinConfig
 	var customDomains []string
@@ -422,7 +636,7 @@ func populateServiceDiscoverySpec(instance *operatorv1alpha1.Knitnet, brokerInfo
 		Version:                  joinConfig.ImageVersion,
 		BrokerK8sCA:              base64.StdEncoding.EncodeToString(brokerInfo.ClientToken.Data["ca.crt"]),
 		BrokerK8sRemoteNamespace: string(brokerInfo.ClientToken.Data["namespace"]),
-		BrokerK8sApiServerToken:  string(clienttoken.Data["token"]),
+		BrokerK8sApiServerToken:  string(clientToken.Data["token"]),
 		BrokerK8sApiServer:       brokerURL,
 		Debug:                    joinConfig.SubmarinerDebug,
 		ClusterID:                joinConfig.ClusterID,
@@ -450,12 +664,11 @@ func getImageOverrides(instance *operatorv1alpha1.Knitnet) (map[string]string, e
 	if len(joinConfig.ImageOverrideArr) > 0 {
 		imageOverrides := make(map[string]string)
 		for _, s := range joinConfig.ImageOverrideArr {
-			key := strings.Split(s, "=")[0]
-			if invalidImageName(key) {
-				klog.Errorf("Invalid image name %s provided. Please choose from %q", key, names.ValidImageNames)
-				return nil, fmt.Errorf("invalid image name %s provided. Please choose from %q", key, names.ValidImageNames)
+			key, value, err := operatorv1alpha1.ParseImageOverride(s)
+			if err != nil {
+				klog.Errorf("Invalid image override: %v", err)
+				return nil, err
 			}
-			value := strings.Split(s, "=")[1]
 			imageOverrides[key] = value
 		}
 		return imageOverrides, nil
@@ -463,24 +676,6 @@ func getImageOverrides(instance *operatorv1alpha1.Knitnet) (map[string]string, e
 	return nil, nil
 }
 
-func invalidImageName(key string) bool {
-	for _, name := range names.ValidImageNames {
-		if key == name {
-			return false
-		}
-	}
-	return true
-}
-
-func isValidCustomCoreDNSConfig(instance *operatorv1alpha1.Knitnet) error {
-	corednsCustomConfigMap := instance.Spec.JoinConfig.CorednsCustomConfigMap
-	if corednsCustomConfigMap != "" && strings.Count(corednsCustomConfigMap, "/") > 1 {
-		klog.Error("coredns-custom-configmap should be in <namespace>/<name> format, namespace is optional")
-		return fmt.Errorf("coredns-custom-configmap should be in <namespace>/<name> format, namespace is optional")
-	}
-	return nil
-}
-
 func getCustomCoreDNSParams(instance *operatorv1alpha1.Knitnet) (namespace, name string) {
 	corednsCustomConfigMap := instance.Spec.JoinConfig.CorednsCustomConfigMap
 	if corednsCustomConfigMap != "" {
@@ -495,9 +690,8 @@ func getCustomCoreDNSParams(instance *operatorv1alpha1.Knitnet) (namespace, name
 }
 
 func (r *KnitnetReconciler) HandleNodeLabels() error {
-	const submarinerGatewayLabel = "submariner.io/gateway"
 	const trueLabel = "true"
-	selector, err := labels.Parse("submariner.io/gateway=true")
+	selector, err := labels.Parse(consts.SubmarinerGatewayLabel + "=" + trueLabel)
 	if err != nil {
 		return err
 	}
@@ -521,7 +715,9 @@ func (r *KnitnetReconciler) HandleNodeLabels() error {
 		if node == nil {
 			klog.Info("* No worker node found to label as the gateway")
 		} else {
-			if err = r.addLabelsToNode(node.GetName(), map[string]string{submarinerGatewayLabel: trueLabel}); err != nil {
+			// The knitnet gateway label marks the nodes labeled by us, so that they can be unlabeled on leave
+			gatewayLabels := map[string]string{consts.SubmarinerGatewayLabel: trueLabel, consts.KnitnetGatewayLabel: trueLabel}
+			if err = r.addLabelsToNode(node.GetName(), gatewayLabels); err != nil {
 				klog.Errorf("Error labeling the gateway node: %v", err)
 				return err
 			}
@@ -598,3 +794,18 @@ func (r *KnitnetReconciler) addLabelsToNode(nodeName string, labelsToAdd map[str
 	}
 	return err
 }
+
+func (r *KnitnetReconciler) removeLabelsFromNode(nodeName string, labelsToRemove []string) error {
+	var tokens = make([]string, 0, len(labelsToRemove))
+	for _, k := range labelsToRemove {
+		tokens = append(tokens, fmt.Sprintf("\"%s\":null", k))
+	}
+
+	labelString := "{" + strings.Join(tokens, ",") + "}"
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":%v}}`, labelString))
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Client.Patch(context.TODO(), node, client.RawPatch(types.MergePatchType, patch))
+	})
+}
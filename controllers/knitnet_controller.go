@@ -18,17 +18,23 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -36,7 +42,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	operatorv1beta1 "github.com/tkestack/knitnet-operator/api/v1beta1"
 	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/gateway"
 )
 
 // KnitnetReconciler reconciles a Knitnet object
@@ -45,6 +53,10 @@ type KnitnetReconciler struct {
 	client.Reader
 	*rest.Config
 	Scheme *runtime.Scheme
+	// DynamicClient reads the resources without Go types, like the OpenShift configs
+	DynamicClient dynamic.Interface
+	// MaxConcurrentReconciles is the number of objects reconciled in parallel by each controller
+	MaxConcurrentReconciles int
 }
 
 const (
@@ -53,6 +65,13 @@ const (
 	AllAction    = "all"
 )
 
+// deletionBlockedRequeueAfter is the interval to recheck the joined clusters of a broker being deleted
+const deletionBlockedRequeueAfter = 30 * time.Second
+
+// brokerInfoResyncInterval is the interval for a joined cluster to pick up the changes of the broker info,
+// like a rotated IPsec PSK
+const brokerInfoResyncInterval = 5 * time.Minute
+
 // +kubebuilder:rbac:groups=apps,resources=*,verbs=*
 // +kubebuilder:rbac:groups=core,resources=*,verbs=*
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings;clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;delete
@@ -60,10 +79,12 @@ const (
 
 // Only for join broker
 // +kubebuilder:rbac:groups=config.openshift.io,resources=networks,verbs=get;list
+// +kubebuilder:rbac:groups=config.openshift.io,resources=infrastructures,verbs=get
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=servicecidrs,verbs=get
 // +kubebuilder:rbac:groups=operator.openshift.io,resources=dnses,verbs=get;list;watch;update
 
 // Only for calico network plugin enabled
-// +kubebuilder:rbac:groups=crd.projectcalico.org,resources=ippools,verbs=get;create;update
+// +kubebuilder:rbac:groups=crd.projectcalico.org,resources=ippools,verbs=get;list;create;update;delete
 
 // +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=get
 // +kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;create
@@ -97,28 +118,89 @@ func (r *KnitnetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_
 		return ctrl.Result{}, err
 	}
 
+	if !instance.GetDeletionTimestamp().IsZero() {
+		return r.reconcileDelete(ctx, instance)
+	}
+
+	if instance.GetAnnotations()[consts.KnitnetConvertAnnotation] == operatorv1beta1.GroupVersion.Version {
+		return ctrl.Result{}, r.convertToV1beta1(ctx, instance)
+	}
+
+	if !controllerutil.ContainsFinalizer(instance, consts.KnitnetFinalizer) {
+		controllerutil.AddFinalizer(instance, consts.KnitnetFinalizer)
+		if err := r.Client.Update(ctx, instance); err != nil {
+			klog.Errorf("Add finalizer failed, err: %v", err)
+			return ctrl.Result{}, err
+		}
+	}
+
 	originalInstance := instance.DeepCopy()
+	// pending tells why the spec is not fully applied yet by a successful reconcile
+	pending := ""
 	// Always attempt to patch the status after each reconciliation.
 	defer func() {
-		if err != nil {
-			instance.Status.Phase = operatorv1alpha1.PhaseFailed
+		if err == nil && pending != "" {
+			markReconcilePending(instance, "%s", pending)
 		} else {
-			instance.Status.Phase = operatorv1alpha1.PhaseRunning
+			markReconcileResult(instance, err)
 		}
 		if reflect.DeepEqual(originalInstance.Status, instance.Status) {
 			return
 		}
-		if updateErr := r.Status().Update(ctx, instance, &client.UpdateOptions{}); updateErr != nil {
+		if updateErr := r.updateStatus(ctx, instance); updateErr != nil {
 			klog.Errorf("Update status failed, err: %v", updateErr)
+			if err == nil {
+				err = updateErr
+			}
 		}
 	}()
 
+	result := ctrl.Result{}
 	// Deploy submeriner broker
 	if instance.Spec.Action == BrokerAction || instance.Spec.Action == AllAction {
 		klog.Info("Deploy submeriner broker")
 		if err := r.DeploySubmerinerBroker(instance); err != nil {
 			return ctrl.Result{}, err
 		}
+		result.RequeueAfter = ipsecPSKRequeueAfter(instance)
+	}
+
+	// Detect the cloud platform, it fills the omitted cloud prepare settings
+	if isCloudPrepareRequired(instance) || instance.Spec.Action == JoinAction || instance.Spec.Action == AllAction {
+		r.detectCloudPlatform(instance)
+	}
+
+	// Remove the cloud resources which are no longer wanted, before preparing another cloud environment
+	if needsCloudCleanup(instance) {
+		klog.Info("Clean up the cloud environment")
+		done, err := r.CleanupCloud(instance)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !done {
+			pending = "Removing the cloud resources which are no longer wanted"
+			result.RequeueAfter = shorterRequeue(result.RequeueAfter, cloudPrepareRequeueAfter)
+			if isCloudPrepareRequired(instance) {
+				return result, nil
+			}
+		}
+	}
+
+	// Prepare the cloud environment, the cluster joins once its gateways are ready
+	if isCloudPrepareRequired(instance) {
+		klog.Info("Prepare the cloud environment")
+		ready, err := r.PrepareCloud(instance, func() error {
+			return r.persistStatus(ctx, instance)
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !ready {
+			klog.Info("Waiting for the cloud gateways to be ready")
+			pending = "Waiting for the cloud gateways to be ready"
+			result.RequeueAfter = shorterRequeue(result.RequeueAfter, cloudPrepareRequeueAfter)
+			return result, nil
+		}
 	}
 
 	// Join managed cluster to submeriner borker
@@ -127,49 +209,194 @@ func (r *KnitnetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_
 		if err := r.JoinSubmarinerCluster(instance); err != nil {
 			return ctrl.Result{}, err
 		}
+		if err := r.finishClusterIDRename(ctx, instance, originalInstance.Status.ClusterID, instance.Status.ClusterID); err != nil {
+			return ctrl.Result{}, err
+		}
+		result.RequeueAfter = shorterRequeue(result.RequeueAfter, brokerInfoResyncInterval)
 	}
 	klog.Infof("Finished reconciling Knitnet: %s", req.NamespacedName)
-	return ctrl.Result{}, nil
+	return result, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *KnitnetReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	cmPredicates := predicate.Funcs{
-		CreateFunc: func(e event.CreateEvent) bool {
-			return false
-		},
-		UpdateFunc: func(e event.UpdateEvent) bool {
-			return false
-		},
-		DeleteFunc: func(e event.DeleteEvent) bool {
-			labels := e.Object.GetLabels()
-			for labelKey := range labels {
-				if labelKey == consts.KnitnetNameLabel {
-					return true
+// shorterRequeue returns the shorter of two requeue intervals, zero stands for no requeue
+func shorterRequeue(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// updateStatus updates the status of the object. The status is only written by its reconciler, a
+// conflict caused by another change of the object is retried with the latest resource version.
+func (r *KnitnetReconciler) updateStatus(ctx context.Context, obj client.Object) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := r.Status().Update(ctx, obj)
+		if errors.IsConflict(err) {
+			latest := obj.DeepCopyObject().(client.Object)
+			if getErr := r.Reader.Get(ctx, client.ObjectKeyFromObject(obj), latest); getErr != nil {
+				return getErr
+			}
+			obj.SetResourceVersion(latest.GetResourceVersion())
+		}
+		return err
+	})
+}
+
+// persistStatus updates the status of the knitnet in the middle of a reconcile. A copy is updated, the
+// spec of the instance keeps the settings filled by the detection.
+func (r *KnitnetReconciler) persistStatus(ctx context.Context, instance *operatorv1alpha1.Knitnet) error {
+	persisted := instance.DeepCopy()
+	if err := r.updateStatus(ctx, persisted); err != nil {
+		klog.Errorf("Update status failed, err: %v", err)
+		return err
+	}
+	instance.SetResourceVersion(persisted.GetResourceVersion())
+	return nil
+}
+
+// reconcileDelete cleans up the resources created for the knitnet before removing the finalizer.
+// A broker is kept as long as clusters are joined, unless the force delete annotation is set.
+func (r *KnitnetReconciler) reconcileDelete(ctx context.Context, instance *operatorv1alpha1.Knitnet) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(instance, consts.KnitnetFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	klog.Infof("Cleaning up Knitnet: %s/%s", instance.GetNamespace(), instance.GetName())
+
+	if instance.Spec.Action == JoinAction || instance.Spec.Action == AllAction {
+		klog.Info("Leave managed cluster from submeriner broker")
+		if err := r.LeaveSubmarinerCluster(instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if len(instance.Status.CloudResources) > 0 {
+		klog.Info("Clean up the cloud environment")
+		if result, err := r.cleanupCloudOnDelete(ctx, instance); err != nil || !result.IsZero() {
+			return result, err
+		}
+	}
+
+	if instance.Spec.Action == BrokerAction || instance.Spec.Action == AllAction {
+		original := instance.DeepCopy()
+		blocked, err := r.isBrokerDeletionBlocked(instance)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if blocked {
+			instance.Status.Phase = operatorv1alpha1.PhaseFailed
+			if !reflect.DeepEqual(original.Status, instance.Status) {
+				if err := r.Status().Update(ctx, instance); err != nil {
+					klog.Errorf("Update status failed, err: %v", err)
+					return ctrl.Result{}, err
 				}
 			}
-			return false
-		},
+			return ctrl.Result{RequeueAfter: deletionBlockedRequeueAfter}, nil
+		}
+		klog.Info("Undeploy submeriner broker")
+		if err := r.UndeploySubmarinerBroker(instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(instance, consts.KnitnetFinalizer)
+	if err := r.Client.Update(ctx, instance); err != nil {
+		return ctrl.Result{}, fmt.Errorf("remove finalizer failed: %v", err)
+	}
+	klog.Infof("Finished cleaning up Knitnet: %s/%s", instance.GetNamespace(), instance.GetName())
+	return ctrl.Result{}, nil
+}
+
+// cleanupCloudOnDelete removes the cloud resources of a deleted knitnet and persists the resources left,
+// it requeues until all of them are removed
+func (r *KnitnetReconciler) cleanupCloudOnDelete(ctx context.Context, instance *operatorv1alpha1.Knitnet) (ctrl.Result, error) {
+	original := instance.DeepCopy()
+	done, err := r.CleanupCloud(instance)
+	if !reflect.DeepEqual(original.Status, instance.Status) {
+		if updateErr := r.updateStatus(ctx, instance); updateErr != nil {
+			klog.Errorf("Update status failed, err: %v", updateErr)
+			if err == nil {
+				err = updateErr
+			}
+		}
+	}
+	if err != nil {
+		return ctrl.Result{}, err
 	}
+	if !done {
+		return ctrl.Result{RequeueAfter: cloudPrepareRequeueAfter}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KnitnetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&operatorv1alpha1.Knitnet{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Watches(
-			&source.Kind{Type: &corev1.ConfigMap{}},
-			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
-				lables := obj.GetLabels()
-				name, nameOk := lables[consts.KnitnetNameLabel]
-				ns, namespaceOK := lables[consts.KnitnetNamespaceLabel]
-				if nameOk && namespaceOK {
-					return []reconcile.Request{
-						{NamespacedName: types.NamespacedName{
-							Name:      name,
-							Namespace: ns,
-						}},
-					}
-				}
-				return nil
-			}),
-			builder.WithPredicates(cmPredicates),
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(knitnetLabelsToRequests),
+			builder.WithPredicates(knitnetSecretPredicates),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Service{}},
+			handler.EnqueueRequestsFromMapFunc(knitnetLabelsToRequests),
+			builder.WithPredicates(knitnetServicePredicates),
 		).
 		Complete(r)
 }
+
+// knitnetSecretPredicates filters the delete events of the secrets created for a knitnet
+var knitnetSecretPredicates = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return false
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return false
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		labels := e.Object.GetLabels()
+		for labelKey := range labels {
+			if labelKey == consts.KnitnetNameLabel {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// knitnetServicePredicates filters the events of the gateway LoadBalancer services created for a knitnet,
+// they are deleted or their assigned address changes
+var knitnetServicePredicates = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return false
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if _, ok := e.ObjectNew.GetLabels()[consts.KnitnetNameLabel]; !ok {
+			return false
+		}
+		oldSvc, oldOk := e.ObjectOld.(*corev1.Service)
+		newSvc, newOk := e.ObjectNew.(*corev1.Service)
+		return oldOk && newOk && gateway.LoadBalancerAddress(oldSvc) != gateway.LoadBalancerAddress(newSvc)
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		_, ok := e.Object.GetLabels()[consts.KnitnetNameLabel]
+		return ok
+	},
+}
+
+// knitnetLabelsToRequests maps a resource created for a knitnet to the knitnet
+func knitnetLabelsToRequests(obj client.Object) []reconcile.Request {
+	lables := obj.GetLabels()
+	name, nameOk := lables[consts.KnitnetNameLabel]
+	ns, namespaceOK := lables[consts.KnitnetNamespaceLabel]
+	if nameOk && namespaceOK {
+		return []reconcile.Request{
+			{NamespacedName: types.NamespacedName{
+				Name:      name,
+				Namespace: ns,
+			}},
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	operatorv1beta1 "github.com/tkestack/knitnet-operator/api/v1beta1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+// KnitnetBrokerReconciler reconciles a KnitnetBroker object
+type KnitnetBrokerReconciler struct {
+	KnitnetReconciler
+}
+
+// +kubebuilder:rbac:groups=operator.tkestack.io,resources=knitnetbrokers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=operator.tkestack.io,resources=knitnetbrokers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=operator.tkestack.io,resources=knitnetbrokers/finalizers,verbs=update
+
+// Reconcile deploys the broker described by a KnitnetBroker, the deployment itself is shared
+// with the v1alpha1 Knitnet broker action.
+func (r *KnitnetBrokerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	klog.Infof("Start reconciling KnitnetBroker: %s", req.NamespacedName)
+	instance := &operatorv1beta1.KnitnetBroker{}
+
+	if err := r.Client.Get(context.TODO(), req.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !instance.GetDeletionTimestamp().IsZero() {
+		return r.reconcileBrokerDelete(ctx, instance)
+	}
+
+	if !controllerutil.ContainsFinalizer(instance, consts.KnitnetFinalizer) {
+		controllerutil.AddFinalizer(instance, consts.KnitnetFinalizer)
+		if err := r.Client.Update(ctx, instance); err != nil {
+			klog.Errorf("Add finalizer failed, err: %v", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	knitnet := instance.ToKnitnet()
+	// Always attempt to patch the status after each reconciliation.
+	defer func() {
+		markReconcileResult(knitnet, err)
+		r.updateBrokerStatus(ctx, instance, knitnet)
+	}()
+
+	klog.Info("Deploy submeriner broker")
+	if err := r.DeploySubmerinerBroker(knitnet); err != nil {
+		return ctrl.Result{}, err
+	}
+	klog.Infof("Finished reconciling KnitnetBroker: %s", req.NamespacedName)
+	return ctrl.Result{RequeueAfter: ipsecPSKRequeueAfter(knitnet)}, nil
+}
+
+// reconcileBrokerDelete undeploys the broker once all clusters have left, unless the force delete annotation is set
+func (r *KnitnetBrokerReconciler) reconcileBrokerDelete(ctx context.Context, instance *operatorv1beta1.KnitnetBroker) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(instance, consts.KnitnetFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	klog.Infof("Cleaning up KnitnetBroker: %s/%s", instance.GetNamespace(), instance.GetName())
+
+	knitnet := instance.ToKnitnet()
+	blocked, err := r.isBrokerDeletionBlocked(knitnet)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if blocked {
+		r.updateBrokerStatus(ctx, instance, knitnet)
+		return ctrl.Result{RequeueAfter: deletionBlockedRequeueAfter}, nil
+	}
+	klog.Info("Undeploy submeriner broker")
+	if err := r.UndeploySubmarinerBroker(knitnet); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	controllerutil.RemoveFinalizer(instance, consts.KnitnetFinalizer)
+	if err := r.Client.Update(ctx, instance); err != nil {
+		return ctrl.Result{}, fmt.Errorf("remove finalizer failed: %v", err)
+	}
+	klog.Infof("Finished cleaning up KnitnetBroker: %s/%s", instance.GetNamespace(), instance.GetName())
+	return ctrl.Result{}, nil
+}
+
+func (r *KnitnetBrokerReconciler) updateBrokerStatus(ctx context.Context, instance *operatorv1beta1.KnitnetBroker, knitnet *operatorv1alpha1.Knitnet) {
+	status := operatorv1beta1.KnitnetBrokerStatus{
+		ObservedGeneration: knitnet.Status.ObservedGeneration,
+		Conditions:         knitnet.Status.Conditions,
+		JoinedClusters:     instance.Status.JoinedClusters,
+		IPSecPSK:           knitnet.Status.IPSecPSK,
+	}
+	if clusterIDs, err := r.getJoinedClusters(); err == nil {
+		status.JoinedClusters = clusterIDs
+	}
+	if reflect.DeepEqual(instance.Status, status) {
+		return
+	}
+	instance.Status = status
+	if err := r.Status().Update(ctx, instance); err != nil {
+		klog.Errorf("Update status failed, err: %v", err)
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KnitnetBrokerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&operatorv1beta1.KnitnetBroker{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(knitnetLabelsToRequests),
+			builder.WithPredicates(knitnetSecretPredicates),
+		).
+		Complete(r)
+}
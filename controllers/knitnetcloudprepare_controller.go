@@ -0,0 +1,169 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	operatorv1beta1 "github.com/tkestack/knitnet-operator/api/v1beta1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+// KnitnetCloudPrepareReconciler reconciles a KnitnetCloudPrepare object
+type KnitnetCloudPrepareReconciler struct {
+	KnitnetReconciler
+}
+
+// +kubebuilder:rbac:groups=operator.tkestack.io,resources=knitnetcloudprepares,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=operator.tkestack.io,resources=knitnetcloudprepares/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=operator.tkestack.io,resources=knitnetcloudprepares/finalizers,verbs=update
+
+// Reconcile prepares the cloud environment described by a KnitnetCloudPrepare, the preparation itself
+// is shared with the v1alpha1 Knitnet.
+func (r *KnitnetCloudPrepareReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	klog.Infof("Start reconciling KnitnetCloudPrepare: %s", req.NamespacedName)
+	instance := &operatorv1beta1.KnitnetCloudPrepare{}
+
+	if err := r.Client.Get(context.TODO(), req.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !instance.GetDeletionTimestamp().IsZero() {
+		return r.reconcileCloudPrepareDelete(ctx, instance)
+	}
+
+	if !controllerutil.ContainsFinalizer(instance, consts.KnitnetFinalizer) {
+		controllerutil.AddFinalizer(instance, consts.KnitnetFinalizer)
+		if err := r.Client.Update(ctx, instance); err != nil {
+			klog.Errorf("Add finalizer failed, err: %v", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	knitnet := instance.ToKnitnet()
+	// pending tells why the spec is not fully applied yet by a successful reconcile
+	pending := ""
+	// Always attempt to patch the status after each reconciliation.
+	defer func() {
+		if err == nil && pending != "" {
+			markReconcilePending(knitnet, "%s", pending)
+		} else {
+			markReconcileResult(knitnet, err)
+		}
+		if updateErr := r.updateCloudPrepareStatus(ctx, instance, knitnet); updateErr != nil && err == nil {
+			err = updateErr
+		}
+	}()
+
+	r.detectCloudPlatform(knitnet)
+	if needsCloudCleanup(knitnet) {
+		klog.Info("Clean up the cloud environment")
+		done, err := r.CleanupCloud(knitnet)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !done {
+			pending = "Removing the cloud resources which are no longer wanted"
+			return ctrl.Result{RequeueAfter: cloudPrepareRequeueAfter}, nil
+		}
+	}
+
+	klog.Info("Prepare the cloud environment")
+	ready, err := r.PrepareCloud(knitnet, func() error {
+		return r.updateCloudPrepareStatus(ctx, instance, knitnet)
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	klog.Infof("Finished reconciling KnitnetCloudPrepare: %s", req.NamespacedName)
+	if !ready {
+		pending = "Waiting for the cloud gateways to be ready"
+		return ctrl.Result{RequeueAfter: cloudPrepareRequeueAfter}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// reconcileCloudPrepareDelete removes the prepared cloud resources before removing the finalizer
+func (r *KnitnetCloudPrepareReconciler) reconcileCloudPrepareDelete(ctx context.Context, instance *operatorv1beta1.KnitnetCloudPrepare) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(instance, consts.KnitnetFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	klog.Infof("Cleaning up KnitnetCloudPrepare: %s/%s", instance.GetNamespace(), instance.GetName())
+
+	knitnet := instance.ToKnitnet()
+	done, err := r.CleanupCloud(knitnet)
+	if updateErr := r.updateCloudPrepareStatus(ctx, instance, knitnet); updateErr != nil && err == nil {
+		err = updateErr
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !done {
+		return ctrl.Result{RequeueAfter: cloudPrepareRequeueAfter}, nil
+	}
+
+	controllerutil.RemoveFinalizer(instance, consts.KnitnetFinalizer)
+	if err := r.Client.Update(ctx, instance); err != nil {
+		return ctrl.Result{}, fmt.Errorf("remove finalizer failed: %v", err)
+	}
+	klog.Infof("Finished cleaning up KnitnetCloudPrepare: %s/%s", instance.GetNamespace(), instance.GetName())
+	return ctrl.Result{}, nil
+}
+
+// updateCloudPrepareStatus updates the status of the KnitnetCloudPrepare with the status of the knitnet
+// it is prepared as
+func (r *KnitnetCloudPrepareReconciler) updateCloudPrepareStatus(ctx context.Context, instance *operatorv1beta1.KnitnetCloudPrepare,
+	knitnet *operatorv1alpha1.Knitnet) error {
+	status := operatorv1beta1.KnitnetCloudPrepareStatus{
+		ObservedGeneration:        knitnet.Status.ObservedGeneration,
+		CloudResources:            knitnet.Status.CloudResources,
+		AppliedCloudPrepareConfig: knitnet.Status.AppliedCloudPrepareConfig,
+		Conditions:                knitnet.Status.Conditions,
+	}
+	if knitnet.Status.Discovery != nil {
+		status.CloudPlatform = knitnet.Status.Discovery.CloudPlatform
+	}
+	if reflect.DeepEqual(instance.Status, status) {
+		return nil
+	}
+	instance.Status = status
+	if err := r.updateStatus(ctx, instance); err != nil {
+		klog.Errorf("Update status failed, err: %v", err)
+		return err
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KnitnetCloudPrepareReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&operatorv1beta1.KnitnetCloudPrepare{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(r)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	operatorv1beta1 "github.com/tkestack/knitnet-operator/api/v1beta1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+// KnitnetJoinReconciler reconciles a KnitnetJoin object
+type KnitnetJoinReconciler struct {
+	KnitnetReconciler
+}
+
+// +kubebuilder:rbac:groups=operator.tkestack.io,resources=knitnetjoins,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=operator.tkestack.io,resources=knitnetjoins/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=operator.tkestack.io,resources=knitnetjoins/finalizers,verbs=update
+
+// Reconcile joins the cluster to the broker as described by a KnitnetJoin, the join itself is shared
+// with the v1alpha1 Knitnet join action.
+func (r *KnitnetJoinReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	klog.Infof("Start reconciling KnitnetJoin: %s", req.NamespacedName)
+	instance := &operatorv1beta1.KnitnetJoin{}
+
+	if err := r.Client.Get(context.TODO(), req.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !instance.GetDeletionTimestamp().IsZero() {
+		return r.reconcileJoinDelete(ctx, instance)
+	}
+
+	if !controllerutil.ContainsFinalizer(instance, consts.KnitnetFinalizer) {
+		controllerutil.AddFinalizer(instance, consts.KnitnetFinalizer)
+		if err := r.Client.Update(ctx, instance); err != nil {
+			klog.Errorf("Add finalizer failed, err: %v", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	knitnet := instance.ToKnitnet()
+	// Always attempt to patch the status after each reconciliation.
+	defer func() {
+		markReconcileResult(knitnet, err)
+		r.updateJoinStatus(ctx, instance, knitnet)
+	}()
+
+	klog.Info("Join managed cluster to submeriner broker")
+	if err := r.JoinSubmarinerCluster(knitnet); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.finishClusterIDRename(ctx, instance, instance.Status.ClusterID, knitnet.Status.ClusterID); err != nil {
+		return ctrl.Result{}, err
+	}
+	klog.Infof("Finished reconciling KnitnetJoin: %s", req.NamespacedName)
+	return ctrl.Result{RequeueAfter: brokerInfoResyncInterval}, nil
+}
+
+// reconcileJoinDelete makes the cluster leave the broker before removing the finalizer
+func (r *KnitnetJoinReconciler) reconcileJoinDelete(ctx context.Context, instance *operatorv1beta1.KnitnetJoin) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(instance, consts.KnitnetFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	klog.Infof("Cleaning up KnitnetJoin: %s/%s", instance.GetNamespace(), instance.GetName())
+
+	klog.Info("Leave managed cluster from submeriner broker")
+	if err := r.LeaveSubmarinerCluster(instance.ToKnitnet()); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	controllerutil.RemoveFinalizer(instance, consts.KnitnetFinalizer)
+	if err := r.Client.Update(ctx, instance); err != nil {
+		return ctrl.Result{}, fmt.Errorf("remove finalizer failed: %v", err)
+	}
+	klog.Infof("Finished cleaning up KnitnetJoin: %s/%s", instance.GetNamespace(), instance.GetName())
+	return ctrl.Result{}, nil
+}
+
+func (r *KnitnetJoinReconciler) updateJoinStatus(ctx context.Context, instance *operatorv1beta1.KnitnetJoin, knitnet *operatorv1alpha1.Knitnet) {
+	status := operatorv1beta1.KnitnetJoinStatus{
+		ObservedGeneration:         knitnet.Status.ObservedGeneration,
+		ClusterID:                  knitnet.Status.ClusterID,
+		GatewayLoadBalancerAddress: knitnet.Status.GatewayLoadBalancerAddress,
+		Conditions:                 knitnet.Status.Conditions,
+	}
+	if knitnet.Status.Discovery != nil {
+		status.Network = knitnet.Status.Discovery.Network
+	}
+	if reflect.DeepEqual(instance.Status, status) {
+		return
+	}
+	instance.Status = status
+	if err := r.Status().Update(ctx, instance); err != nil {
+		klog.Errorf("Update status failed, err: %v", err)
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KnitnetJoinReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&operatorv1beta1.KnitnetJoin{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(
+			&source.Kind{Type: &corev1.Service{}},
+			handler.EnqueueRequestsFromMapFunc(knitnetLabelsToRequests),
+			builder.WithPredicates(knitnetServicePredicates),
+		).
+		Complete(r)
+}
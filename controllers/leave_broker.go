@@ -0,0 +1,190 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	submariner "github.com/submariner-io/submariner-operator/apis/submariner/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	operatorv1beta1 "github.com/tkestack/knitnet-operator/api/v1beta1"
+	"github.com/tkestack/knitnet-operator/controllers/checker"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/broker"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/gateway"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/operator/servicediscoverycr"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/operator/submarinercr"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/operator/submarinerop/deployment"
+)
+
+// LeaveSubmarinerCluster reverts JoinSubmarinerCluster, the cluster is removed from the broker
+// and everything deployed for the join is deleted
+func (r *KnitnetReconciler) LeaveSubmarinerCluster(instance *operatorv1alpha1.Knitnet) error {
+	clusterID, err := r.getJoinedClusterID(instance)
+	if err != nil {
+		return err
+	}
+
+	klog.Info("Deleting Submariner")
+	if err := submarinercr.Delete(r.Client, consts.SubmarinerOperatorNamespace); err != nil {
+		klog.Errorf("Error deleting Submariner: %v", err)
+		return err
+	}
+	if err := servicediscoverycr.Delete(r.Client, consts.SubmarinerOperatorNamespace); err != nil {
+		klog.Errorf("Error deleting service discovery: %v", err)
+		return err
+	}
+
+	if err := gateway.DeleteLoadBalancer(r.Client, consts.SubmarinerOperatorNamespace); err != nil {
+		klog.Errorf("Error deleting the gateway LoadBalancer: %v", err)
+		return err
+	}
+
+	if err := r.removeGatewayNodeLabels(); err != nil {
+		klog.Errorf("Error removing the gateway node labels: %v", err)
+		return err
+	}
+
+	if err := checker.DeleteCalicoIPPools(r.Client); err != nil {
+		klog.Errorf("Error deleting IPPools: %v", err)
+		return err
+	}
+
+	if clusterID != "" {
+		if err := r.removeClusterFromBroker(clusterID); err != nil {
+			// The broker may be unreachable or already deleted, the cleanup of the broker is skipped when forced
+			if instance.GetAnnotations()[consts.KnitnetForceDeleteAnnotation] != "true" {
+				klog.Warningf("Cluster %s can't be removed from the broker, annotate %s=true to force delete", clusterID, consts.KnitnetForceDeleteAnnotation)
+				return err
+			}
+			klog.Warningf("Force deleting, skip removing cluster %s from the broker: %v", clusterID, err)
+		}
+	}
+
+	return r.deleteSubmarinerOperator(instance)
+}
+
+// getJoinedClusterID returns the cluster ID used to join the broker, the clusters joined before the
+// cluster ID was recorded in the status are looked up in the Submariner or ServiceDiscovery CR
+func (r *KnitnetReconciler) getJoinedClusterID(instance *operatorv1alpha1.Knitnet) (string, error) {
+	if instance.Status.ClusterID != "" {
+		return instance.Status.ClusterID, nil
+	}
+	if instance.Spec.JoinConfig.ClusterID != "" {
+		return instance.Spec.JoinConfig.ClusterID, nil
+	}
+	submarinerCR := &submariner.Submariner{}
+	submarinerCRKey := types.NamespacedName{Name: submarinercr.SubmarinerName, Namespace: consts.SubmarinerOperatorNamespace}
+	// The CRDs are missing as long as the submariner operator is not deployed
+	if err := r.Client.Get(context.TODO(), submarinerCRKey, submarinerCR); err == nil {
+		return submarinerCR.Spec.ClusterID, nil
+	} else if !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return "", err
+	}
+	sdList := &submariner.ServiceDiscoveryList{}
+	if err := r.Client.List(context.TODO(), sdList, client.InNamespace(consts.SubmarinerOperatorNamespace)); err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(sdList.Items) > 0 {
+		return sdList.Items[0].Spec.ClusterID, nil
+	}
+	return "", nil
+}
+
+func (r *KnitnetReconciler) removeClusterFromBroker(clusterID string) error {
+	brokerInfo, err := broker.NewFromSecret(r.Reader)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			klog.Warningf("Broker info not found, skip removing cluster %s from broker", clusterID)
+			return nil
+		}
+		klog.Errorf("Get broker info failed: %v", err)
+		return err
+	}
+	brokerCluster, err := brokerInfo.GetBrokerAdministratorCluster()
+	if err != nil {
+		klog.Errorf("unable to get broker cluster client: %v", err)
+		return err
+	}
+	brokerNamespace := string(brokerInfo.ClientToken.Data["namespace"])
+
+	klog.Infof("Removing cluster %s from broker", clusterID)
+	if err := broker.RemoveClusterInfo(brokerCluster.GetClient(), brokerCluster.GetAPIReader(), brokerNamespace, clusterID); err != nil {
+		klog.Errorf("Error removing cluster info: %v", err)
+		return err
+	}
+	if err := broker.DeleteSAForCluster(brokerCluster.GetClient(), clusterID); err != nil {
+		klog.Errorf("Error deleting SA for cluster: %v", err)
+		return err
+	}
+	return nil
+}
+
+// removeGatewayNodeLabels unlabels the gateway nodes which were labeled by HandleNodeLabels
+func (r *KnitnetReconciler) removeGatewayNodeLabels() error {
+	nodes := &v1.NodeList{}
+	if err := r.Client.List(context.TODO(), nodes, client.HasLabels{consts.KnitnetGatewayLabel}); err != nil {
+		return err
+	}
+	for _, node := range nodes.Items {
+		klog.Infof("Removing gateway label from node %s", node.GetName())
+		if err := r.removeLabelsFromNode(node.GetName(), []string{consts.SubmarinerGatewayLabel, consts.KnitnetGatewayLabel}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSubmarinerOperator deletes the operator deployment unless it is still used by another knitnet
+func (r *KnitnetReconciler) deleteSubmarinerOperator(instance *operatorv1alpha1.Knitnet) error {
+	knitnetLists := []client.ObjectList{
+		&operatorv1alpha1.KnitnetList{},
+		&operatorv1beta1.KnitnetBrokerList{},
+		&operatorv1beta1.KnitnetJoinList{},
+	}
+	for _, knitnetList := range knitnetLists {
+		if err := r.Client.List(context.TODO(), knitnetList); err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+		items, err := meta.ExtractList(knitnetList)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			knitnet := item.(client.Object)
+			if knitnet.GetUID() != instance.GetUID() && knitnet.GetDeletionTimestamp().IsZero() {
+				klog.Infof("Submariner operator is still used by %T %s/%s", knitnet, knitnet.GetNamespace(), knitnet.GetName())
+				return nil
+			}
+		}
+	}
+	klog.Info("Deleting the Submariner operator")
+	return deployment.Delete(r.Client, consts.SubmarinerOperatorNamespace)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	netconsts "github.com/tkestack/knitnet-operator/controllers/discovery"
+	"github.com/tkestack/knitnet-operator/controllers/discovery/network"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+)
+
+// GetNetworkDetails returns the network of the cluster with the values pinned in the join config. The network
+// persisted in the status is used unless it has to be discovered again, the report of the detections is only
+// returned by a new discovery.
+func (r *KnitnetReconciler) GetNetworkDetails(instance *operatorv1alpha1.Knitnet) (*network.ClusterNetwork, *network.Report, error) {
+	joinConfig := instance.Spec.JoinConfig
+	// The DaemonSets of Submariner are not network plugin workloads
+	fingerprint, err := network.Fingerprint(r.Client, consts.SubmarinerOperatorNamespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var networkDetails *network.ClusterNetwork
+	var report *network.Report
+	reason := networkRediscoveryReason(instance, fingerprint)
+	if reason == "" {
+		klog.Infof("Using the network details discovered at %s", instance.Status.Discovery.Network.LastDiscoveryTime)
+		networkDetails = networkFromStatus(instance.Status.Discovery.Network)
+	} else {
+		klog.Infof("Discovering network details, %s", reason)
+		networkDetails, report, err = network.Discover(r.DynamicClient, r.Client, consts.SubmarinerOperatorNamespace, joinConfig.ServiceCIDRProbe)
+		if err != nil {
+			// The network is discovered again by the next reconcile
+			klog.Errorf("Error trying to discover network details: %v", err)
+		} else {
+			recordNetworkDiscovery(instance, fingerprint)
+		}
+		if networkDetails == nil {
+			networkDetails = &network.ClusterNetwork{NetworkPlugin: netconsts.NetworkPluginGeneric}
+		}
+	}
+
+	applyNetworkPins(joinConfig, networkDetails)
+	networkDetails.Show()
+	return networkDetails, report, nil
+}
+
+// networkRediscoveryReason returns why the network has to be discovered again, an empty reason means the
+// network persisted in the status is up to date
+func networkRediscoveryReason(instance *operatorv1alpha1.Knitnet, fingerprint string) string {
+	if instance.Status.Discovery == nil || instance.Status.Discovery.Network == nil ||
+		instance.Status.Discovery.Network.LastDiscoveryTime == nil {
+		return "the network was not discovered yet"
+	}
+	status := instance.Status.Discovery.Network
+
+	if request := instance.GetAnnotations()[operatorv1alpha1.NetworkRediscoverAnnotation]; request != "" && request != status.RediscoveryRequest {
+		return fmt.Sprintf("requested through the annotation %s=%s", operatorv1alpha1.NetworkRediscoverAnnotation, request)
+	}
+	if fingerprint != status.Fingerprint {
+		return "the network plugin workloads changed"
+	}
+	// The status has the pinned values in place of the discovered ones
+	if strings.Join(pinnedNetworkFields(instance.Spec.JoinConfig), ",") != strings.Join(status.PinnedFields, ",") {
+		return "the pinned network fields changed"
+	}
+	if len(status.PodCIDRs) == 0 || len(status.ServiceCIDRs) == 0 {
+		return "the last discovery is incomplete"
+	}
+	return ""
+}
+
+// recordNetworkDiscovery records when and why the network was discovered
+func recordNetworkDiscovery(instance *operatorv1alpha1.Knitnet, fingerprint string) {
+	status := networkDiscoveryStatus(instance)
+	now := metav1.Now()
+	status.LastDiscoveryTime = &now
+	status.Fingerprint = fingerprint
+	status.RediscoveryRequest = instance.GetAnnotations()[operatorv1alpha1.NetworkRediscoverAnnotation]
+}
+
+// networkFromStatus returns the network persisted in the status
+func networkFromStatus(status *operatorv1alpha1.NetworkDiscovery) *network.ClusterNetwork {
+	networkDetails := &network.ClusterNetwork{
+		NetworkPlugin:     status.NetworkPlugin,
+		PodCIDRs:          append([]string{}, status.PodCIDRs...),
+		ServiceCIDRs:      append([]string{}, status.ServiceCIDRs...),
+		GlobalCIDR:        status.GlobalCIDR,
+		ServiceCIDRSource: status.ServiceCIDRSource,
+	}
+	if len(status.PluginSettings) > 0 {
+		networkDetails.PluginSettings = map[string]string{}
+		for key, value := range status.PluginSettings {
+			networkDetails.PluginSettings[key] = value
+		}
+	}
+	return networkDetails
+}
+
+// applyNetworkPins overrides the discovered network plugin, plugin settings and global CIDR with the values
+// pinned in the join config, the pinned CIDRs are handled by getPodCIDRs and getServiceCIDRs
+func applyNetworkPins(joinConfig operatorv1alpha1.JoinConfig, networkDetails *network.ClusterNetwork) {
+	if joinConfig.NetworkPlugin != "" {
+		networkDetails.NetworkPlugin = joinConfig.NetworkPlugin
+	}
+	if len(joinConfig.NetworkPluginSettings) > 0 && networkDetails.PluginSettings == nil {
+		networkDetails.PluginSettings = map[string]string{}
+	}
+	for key, value := range joinConfig.NetworkPluginSettings {
+		networkDetails.PluginSettings[key] = value
+	}
+	if joinConfig.GlobalnetCIDR != "" {
+		networkDetails.GlobalCIDR = joinConfig.GlobalnetCIDR
+	}
+}
+
+// pinnedNetworkFields returns the fields of the join config overriding the discovered network, each pinned
+// plugin setting is a field of its own
+func pinnedNetworkFields(joinConfig operatorv1alpha1.JoinConfig) []string {
+	pinned := []string{}
+	if joinConfig.NetworkPlugin != "" {
+		pinned = append(pinned, "networkPlugin")
+	}
+	settings := []string{}
+	for key := range joinConfig.NetworkPluginSettings {
+		settings = append(settings, "networkPluginSettings."+key)
+	}
+	sort.Strings(settings)
+	pinned = append(pinned, settings...)
+	if joinConfig.ClusterCIDR != "" {
+		pinned = append(pinned, "clusterCIDR")
+	}
+	if joinConfig.ServiceCIDR != "" {
+		pinned = append(pinned, "serviceCIDR")
+	}
+	if joinConfig.GlobalnetCIDR != "" {
+		pinned = append(pinned, "globalnetCIDR")
+	}
+	return pinned
+}
+
+// setNetworkDiscoveryStatus reports the network of the cluster with the CIDRs of all the IP families, and
+// the detections of the network plugins when the network was discovered again
+func setNetworkDiscoveryStatus(instance *operatorv1alpha1.Knitnet, networkDetails *network.ClusterNetwork, podCIDRs, serviceCIDRs []string,
+	serviceCIDRSource string, report *network.Report) {
+	status := networkDiscoveryStatus(instance)
+	status.NetworkPlugin = networkDetails.NetworkPlugin
+	status.PodCIDRs = podCIDRs
+	status.ServiceCIDRs = serviceCIDRs
+	status.ServiceCIDRSource = serviceCIDRSource
+	status.IPFamilies = operatorv1alpha1.IPFamilies(append(append([]string{}, podCIDRs...), serviceCIDRs...))
+	status.GlobalCIDR = networkDetails.GlobalCIDR
+	status.PluginSettings = networkDetails.PluginSettings
+	status.PinnedFields = pinnedNetworkFields(instance.Spec.JoinConfig)
+	if report == nil {
+		return
+	}
+
+	status.Source = netconsts.NetworkPluginGeneric
+	if best := report.Best(); best != nil {
+		status.Source = best.Detector
+	}
+	status.Detections = nil
+	for _, detection := range report.Detections {
+		status.Detections = append(status.Detections, operatorv1alpha1.NetworkDetection{
+			Detector:      detection.Detector,
+			NetworkPlugin: detection.Network.NetworkPlugin,
+			Confidence:    detection.Confidence,
+			Evidence:      detection.Evidence,
+			PodCIDRs:      detection.Network.PodCIDRs,
+			ServiceCIDRs:  detection.Network.ServiceCIDRs,
+		})
+	}
+	status.DetectorErrors = report.Errors
+}
+
+func networkDiscoveryStatus(instance *operatorv1alpha1.Knitnet) *operatorv1alpha1.NetworkDiscovery {
+	if instance.Status.Discovery == nil {
+		instance.Status.Discovery = &operatorv1alpha1.DiscoveryStatus{}
+	}
+	if instance.Status.Discovery.Network == nil {
+		instance.Status.Discovery.Network = &operatorv1alpha1.NetworkDiscovery{}
+	}
+	return instance.Status.Discovery.Network
+}
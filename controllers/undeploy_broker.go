@@ -0,0 +1,81 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+
+	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	consts "github.com/tkestack/knitnet-operator/controllers/ensures"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/broker"
+	"github.com/tkestack/knitnet-operator/controllers/ensures/operator/brokercr"
+)
+
+// UndeploySubmarinerBroker reverts DeploySubmerinerBroker, the broker namespace holding the
+// broker info, the cluster infos and the cluster service accounts is deleted
+func (r *KnitnetReconciler) UndeploySubmarinerBroker(instance *operatorv1alpha1.Knitnet) error {
+	klog.Info("Deleting the broker")
+	if err := brokercr.Delete(r.Client); err != nil {
+		klog.Errorf("Error deleting the broker: %v", err)
+		return err
+	}
+
+	klog.Infof("Deleting the broker namespace %s", consts.SubmarinerBrokerNamespace)
+	if err := r.Client.Delete(context.TODO(), broker.NewBrokerNamespace()); err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Error deleting the broker namespace: %v", err)
+		return err
+	}
+
+	return r.deleteSubmarinerOperator(instance)
+}
+
+// isBrokerDeletionBlocked reports whether the broker has to be kept because clusters are still joined,
+// the DeletionBlocked condition is set on the instance in that case
+func (r *KnitnetReconciler) isBrokerDeletionBlocked(instance *operatorv1alpha1.Knitnet) (bool, error) {
+	clusterIDs, err := r.getJoinedClusters()
+	if err != nil {
+		klog.Errorf("Get joined clusters failed: %v", err)
+		return false, err
+	}
+	if len(clusterIDs) == 0 || instance.GetAnnotations()[consts.KnitnetForceDeleteAnnotation] == "true" {
+		return false, nil
+	}
+	klog.Warningf("Broker is still used by clusters %v, annotate %s=true to force delete", clusterIDs, consts.KnitnetForceDeleteAnnotation)
+	markConditionTrue(instance, operatorv1alpha1.ConditionDeletionBlocked, operatorv1alpha1.ReasonClustersJoined,
+		"Clusters %s are still joined, leave them first or set annotation %s=true", strings.Join(clusterIDs, ","), consts.KnitnetForceDeleteAnnotation)
+	return true, nil
+}
+
+// getJoinedClusters returns the IDs of the clusters registered in the broker
+func (r *KnitnetReconciler) getJoinedClusters() ([]string, error) {
+	clusterInfos, err := broker.GetClusterInfos(r.Reader, consts.SubmarinerBrokerNamespace)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	clusterIDs := []string{}
+	for _, clusterInfo := range clusterInfos {
+		clusterIDs = append(clusterIDs, clusterInfo.ClusterID)
+	}
+	return clusterIDs, nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// OperationResultRecreated is reported when a resource was deleted and created again, because a field
+// which can not be changed live was changed
+const OperationResultRecreated controllerutil.OperationResult = "recreated"
+
+// ChangedFields returns the json names of the top level fields which differ between the current and the
+// desired spec, both must be structs of the same type
+func ChangedFields(current, desired interface{}) []string {
+	currentValue := reflect.Indirect(reflect.ValueOf(current))
+	desiredValue := reflect.Indirect(reflect.ValueOf(desired))
+	changed := []string{}
+	for i := 0; i < currentValue.NumField(); i++ {
+		if !reflect.DeepEqual(currentValue.Field(i).Interface(), desiredValue.Field(i).Interface()) {
+			changed = append(changed, jsonFieldName(currentValue.Type().Field(i)))
+		}
+	}
+	return changed
+}
+
+// ContainsAny reports whether any of the fields is one of names
+func ContainsAny(fields []string, names ...string) bool {
+	for _, field := range fields {
+		for _, name := range names {
+			if field == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
@@ -3,11 +3,18 @@ module github.com/tkestack/knitnet-operator
 go 1.16
 
 require (
+	github.com/Azure/azure-sdk-for-go v40.6.0+incompatible
+	github.com/Azure/go-autorest/autorest v0.11.18
+	github.com/Azure/go-autorest/autorest/azure/auth v0.5.7
+	github.com/Azure/go-autorest/autorest/to v0.3.1-0.20191028180845-3492b2aff503
+	github.com/aws/aws-sdk-go v1.38.70
 	github.com/onsi/ginkgo v1.16.1
 	github.com/onsi/gomega v1.11.0
 	github.com/pkg/errors v0.9.1
 	github.com/submariner-io/submariner v0.9.1
 	github.com/submariner-io/submariner-operator v0.9.1
+	github.com/tencentcloud/tencentcloud-sdk-go v1.0.162
+	google.golang.org/api v0.29.0
 	k8s.io/api v0.21.0-rc.0
 	k8s.io/apiextensions-apiserver v0.20.1
 	k8s.io/apimachinery v0.21.0-rc.0
@@ -27,6 +27,7 @@ import (
 	apiextensions "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/klog/v2"
@@ -34,6 +35,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 
 	operatorv1alpha1 "github.com/tkestack/knitnet-operator/api/v1alpha1"
+	operatorv1beta1 "github.com/tkestack/knitnet-operator/api/v1beta1"
 	"github.com/tkestack/knitnet-operator/controllers"
 	//+kubebuilder:scaffold:imports
 )
@@ -48,6 +50,7 @@ func init() {
 	utilruntime.Must(submarinerv1.AddToScheme(scheme))
 	utilruntime.Must(submarineropv1alpha1.AddToScheme(scheme))
 	utilruntime.Must(operatorv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(operatorv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -55,12 +58,15 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var maxConcurrentReconciles int
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 4,
+		"The maximum number of objects reconciled in parallel by each controller.")
 
 	klog.InitFlags(nil)
 	defer klog.Flush()
@@ -79,15 +85,51 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.KnitnetReconciler{
-		Client: mgr.GetClient(),
-		Reader: mgr.GetAPIReader(),
-		Config: mgr.GetConfig(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	dynamicClient, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		klog.Errorf("unable to new dynamic client: %v", err)
+		os.Exit(1)
+	}
+
+	knitnetReconciler := controllers.KnitnetReconciler{
+		Client:        mgr.GetClient(),
+		Reader:        mgr.GetAPIReader(),
+		Config:        mgr.GetConfig(),
+		Scheme:        mgr.GetScheme(),
+		DynamicClient: dynamicClient,
+
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+	}
+	if err = (&knitnetReconciler).SetupWithManager(mgr); err != nil {
 		klog.Errorf("unable to create controller Knitnet: %v", err)
 		os.Exit(1)
 	}
+	if err = (&controllers.KnitnetBrokerReconciler{KnitnetReconciler: knitnetReconciler}).SetupWithManager(mgr); err != nil {
+		klog.Errorf("unable to create controller KnitnetBroker: %v", err)
+		os.Exit(1)
+	}
+	if err = (&controllers.KnitnetJoinReconciler{KnitnetReconciler: knitnetReconciler}).SetupWithManager(mgr); err != nil {
+		klog.Errorf("unable to create controller KnitnetJoin: %v", err)
+		os.Exit(1)
+	}
+	if err = (&controllers.KnitnetCloudPrepareReconciler{KnitnetReconciler: knitnetReconciler}).SetupWithManager(mgr); err != nil {
+		klog.Errorf("unable to create controller KnitnetCloudPrepare: %v", err)
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&operatorv1alpha1.Knitnet{}).SetupWebhookWithManager(mgr); err != nil {
+			klog.Errorf("unable to create webhook Knitnet: %v", err)
+			os.Exit(1)
+		}
+		if err = (&operatorv1beta1.KnitnetBroker{}).SetupWebhookWithManager(mgr); err != nil {
+			klog.Errorf("unable to create webhook KnitnetBroker: %v", err)
+			os.Exit(1)
+		}
+		if err = (&operatorv1beta1.KnitnetJoin{}).SetupWebhookWithManager(mgr); err != nil {
+			klog.Errorf("unable to create webhook KnitnetJoin: %v", err)
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {